@@ -4,8 +4,11 @@ go 1.14
 
 require (
 	github.com/gin-gonic/gin v1.6.3
+	github.com/googleapis/gnostic v0.4.1
 	github.com/jonboulle/clockwork v0.1.0 // indirect
 	github.com/pkg/errors v0.9.1
+	github.com/spf13/pflag v1.0.5
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	gopkg.in/igm/sockjs-go.v2 v2.1.0
 	gopkg.in/yaml.v2 v2.2.8 // indirect
 	k8s.io/api v0.19.4
@@ -13,6 +16,7 @@ require (
 	k8s.io/cli-runtime v0.19.4 // indirect
 	k8s.io/client-go v0.19.4
 	k8s.io/klog/v2 v2.2.0 // indirect
+	k8s.io/kube-openapi v0.0.0-20200805222855-6aeccd4b50c6
 	k8s.io/kubectl v0.19.4 // indirect
 	sigs.k8s.io/yaml v1.2.0
 )
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/gokubectl"
+)
+
+// manifestFlags are the flags of the manifest sources
+type manifestFlags struct {
+	filenames []string
+	recursive bool
+}
+
+func (f *manifestFlags) register(fs *pflag.FlagSet) {
+	fs.StringSliceVarP(&f.filenames, "filename", "f", nil, "Files, directories or archives of the manifests, - for stdin")
+	fs.BoolVarP(&f.recursive, "recursive", "R", false, "Read the sub directories of the directories")
+}
+
+func (f *manifestFlags) read() ([]gokubectl.Manifest, error) {
+	return gokubectl.ReadPaths(f.filenames, f.recursive)
+}
+
+var applyFlags struct {
+	manifestFlags
+	chartFlags
+	decryptionFlags
+	releaseFlags
+	fieldManager   string
+	forceConflicts bool
+	validate       string
+	convert        bool
+	atomic         bool
+	snapshotFile   string
+	release        string
+	policy         bool
+	policyFiles    []string
+	policyExempt   []string
+	concurrency    int
+	qps            float32
+	burst          int
+	wait           bool
+	timeout        time.Duration
+}
+
+var applyCommand = &command{
+	name:  "apply",
+	usage: "Apply the manifests.\n\nUsage:\n  gokubectl apply -f FILENAME [flags]",
+	flags: func(fs *pflag.FlagSet) {
+		applyFlags.manifestFlags.register(fs)
+		applyFlags.chartFlags.register(fs, ", its objects are applied after the ones of -f")
+		applyFlags.decryptionFlags.register(fs)
+		fs.StringVar(&applyFlags.release, "release", "", "Record the manifests as a revision of the release, see gokubectl history")
+		applyFlags.releaseFlags.register(fs)
+		fs.StringVar(&applyFlags.fieldManager, "field-manager", "", "Manager name of server-side apply, default kubectl-golang")
+		fs.BoolVar(&applyFlags.forceConflicts, "force-conflicts", false, "Take the ownership of the fields conflicted with other managers")
+		fs.StringVar(&applyFlags.validate, "validate", "none", "Validate the manifests by the OpenAPI schema: none, warn or strict")
+		fs.BoolVar(&applyFlags.policy, "policy", false, "Check the built-in policies: deny privileged, host namespaces and hostPath, warn latest tags, missing resources and probes")
+		fs.StringSliceVar(&applyFlags.policyFiles, "policy-file", nil, "Check the field rules in the policy files")
+		fs.StringArrayVar(&applyFlags.policyExempt, "policy-exempt", nil, "Skip the rules for the object, e.g. DaemonSet/kube-system/agent=host-path,privileged or ClusterRole/admin=*")
+		fs.BoolVar(&applyFlags.convert, "convert-deprecated", false, "Convert the deprecated apiVersions not served by the cluster")
+		fs.IntVar(&applyFlags.concurrency, "concurrency", 1, "Max number of objects applied at the same time, tier by tier in the install order of kinds")
+		fs.Float32Var(&applyFlags.qps, "qps", 0, "Max requests per second to the cluster, default 5")
+		fs.IntVar(&applyFlags.burst, "burst", 0, "Max burst of requests to the cluster, default 10")
+		fs.BoolVar(&applyFlags.wait, "wait", false, "Wait until the objects are healthy, the degraded ones fail at once")
+		fs.DurationVar(&applyFlags.timeout, "timeout", 0, "The max duration of --wait, default 5m")
+		fs.BoolVar(&applyFlags.atomic, "atomic", false, "Stop at the first failure and roll back the applied objects")
+		fs.StringVar(&applyFlags.snapshotFile, "snapshot-file", "", "Save the snapshot taken by --atomic for gokubectl rollback")
+	},
+	run: runApply,
+}
+
+func applyOptions(flags *globalFlags) (gokubectl.ApplyOptions, bool) {
+	opts := gokubectl.ApplyOptions{
+		NamespaceOptions: gokubectl.NamespaceOptions{
+			DefaultNamespace: flags.namespace,
+		},
+		DiscoveryOptions: flags.discoveryOptions(),
+
+		FieldManager: applyFlags.fieldManager,
+		Force:        applyFlags.forceConflicts,
+
+		ConvertDeprecated: applyFlags.convert,
+		Concurrency:       applyFlags.concurrency,
+		QPS:               applyFlags.qps,
+		Burst:             applyFlags.burst,
+		Wait:              applyFlags.wait,
+		WaitTimeout:       applyFlags.timeout,
+	}
+	switch strings.ToLower(applyFlags.validate) {
+	case "none", "":
+	case "warn":
+		opts.Validation = gokubectl.ValidationWarn
+	case "strict":
+		opts.Validation = gokubectl.ValidationStrict
+	default:
+		return opts, false
+	}
+	return opts, true
+}
+
+// policyExemptions parses KIND/[NAMESPACE/]NAME=RULE,RULE of --policy-exempt.
+func policyExemptions(values []string) (gokubectl.PolicyExemptions, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	exemptions := gokubectl.PolicyExemptions{}
+	for _, value := range values {
+		i := strings.Index(value, "=")
+		if i < 0 || i == len(value)-1 {
+			return nil, fmt.Errorf("invalid --policy-exempt %q, should be KIND/[NAMESPACE/]NAME=RULE,RULE", value)
+		}
+		var object gokubectl.PolicyObject
+		switch parts := strings.Split(value[:i], "/"); len(parts) {
+		case 2:
+			object = gokubectl.PolicyObject{Kind: parts[0], Name: parts[1]}
+		case 3:
+			object = gokubectl.PolicyObject{Kind: parts[0], Namespace: parts[1], Name: parts[2]}
+		default:
+			return nil, fmt.Errorf("invalid --policy-exempt %q, should be KIND/[NAMESPACE/]NAME=RULE,RULE", value)
+		}
+		exemptions[object] = append(exemptions[object], strings.Split(value[i+1:], ",")...)
+	}
+	return exemptions, nil
+}
+
+func runApply(ctx context.Context, flags *globalFlags, args []string) int {
+	if (len(applyFlags.filenames) == 0 && applyFlags.chart == "") || len(args) != 0 {
+		return usageError("apply requires -f or --chart and no arguments")
+	}
+	opts, ok := applyOptions(flags)
+	if !ok {
+		return usageError("unknown --validate %q, should be one of none, warn or strict", applyFlags.validate)
+	}
+	identities, err := applyFlags.identities()
+	if err != nil {
+		return fail(err)
+	}
+	opts.AgeIdentities = identities
+	if applyFlags.policy {
+		opts.Policies = gokubectl.DefaultPolicies()
+	}
+	for _, file := range applyFlags.policyFiles {
+		rules, err := gokubectl.ReadPolicyFile(file)
+		if err != nil {
+			return fail(err)
+		}
+		opts.Policies = append(opts.Policies, rules...)
+	}
+	if opts.PolicyExemptions, err = policyExemptions(applyFlags.policyExempt); err != nil {
+		return usageError("%s", err)
+	}
+	manifests, err := applyFlags.read()
+	if err != nil {
+		return fail(err)
+	}
+	clusters, set, err := flags.clusters()
+	if err != nil {
+		return fail(err)
+	}
+	// The chart is rendered by the capabilities of the first cluster
+	rendered, err := applyFlags.render(ctx, flags, clusters[0].Base64KubeConfig, applyFlags.release)
+	if err != nil {
+		return fail(err)
+	}
+	manifests = append(manifests, rendered...)
+	if applyFlags.release != "" {
+		if set != nil || applyFlags.atomic {
+			return usageError("--release doesn't support --cluster-set or --atomic")
+		}
+		releaseOpts, err := applyFlags.releaseFlags.options()
+		if err != nil {
+			return usageError("%s", err)
+		}
+		releaseOpts.Apply = opts
+		release, applied, err := gokubectl.ApplyRelease(ctx, clusters[0].Base64KubeConfig, applyFlags.release, manifests, releaseOpts)
+		if release != nil {
+			fmt.Fprintf(os.Stderr, "release %s revision %d %s\n", release.Name, release.Revision, release.Status)
+		}
+		if err != nil {
+			return fail(err)
+		}
+		return printResults(os.Stdout, flags.output, releaseResults(applied))
+	}
+	if applyFlags.atomic {
+		if set != nil {
+			return usageError("--atomic doesn't support --cluster-set")
+		}
+		return runApplyAtomic(ctx, flags, clusters[0].Base64KubeConfig, manifests, opts)
+	}
+	if applyFlags.snapshotFile != "" {
+		return usageError("--snapshot-file requires --atomic")
+	}
+
+	var results []objectResult
+	if set == nil {
+		applied, err := gokubectl.ApplyManifests(ctx, clusters[0].Base64KubeConfig, manifests, opts)
+		if err != nil {
+			return fail(err)
+		}
+		for _, r := range applied {
+			results = append(results, newObjectResult("", r))
+		}
+		return printResults(os.Stdout, flags.output, results)
+	}
+
+	clusterResults, err := gokubectl.MultiApplyManifests(ctx, clusters, manifests, gokubectl.MultiApplyOptions{
+		Apply:         opts,
+		Workers:       set.Workers,
+		Strategy:      set.Strategy,
+		StopOnFailure: set.StopOnFailure,
+	})
+	if err != nil {
+		return fail(err)
+	}
+	for _, cr := range clusterResults {
+		switch {
+		case cr.Skipped:
+			results = append(results, objectResult{Cluster: cr.Cluster, Error: "skipped"})
+		case cr.Err != nil:
+			results = append(results, clusterError(cr.Cluster, cr.Err))
+		}
+		for _, r := range cr.Results {
+			results = append(results, newObjectResult(cr.Cluster, r))
+		}
+	}
+	return printResults(os.Stdout, flags.output, results)
+}
+
+func runApplyAtomic(ctx context.Context, flags *globalFlags, base64KubeConfig string, manifests []gokubectl.Manifest, opts gokubectl.ApplyOptions) int {
+	atomic, err := gokubectl.ApplyAtomic(ctx, base64KubeConfig, manifests, opts)
+	if err != nil {
+		return fail(err)
+	}
+	if atomic.Snapshot != nil && applyFlags.snapshotFile != "" {
+		if err = writeSnapshot(applyFlags.snapshotFile, atomic.Snapshot); err != nil {
+			return fail(err)
+		}
+	}
+
+	var results []objectResult
+	for _, r := range atomic.Results {
+		results = append(results, newObjectResult("", r))
+	}
+	for _, r := range atomic.Rollback {
+		result := newObjectResult("", r)
+		result.Message = "rollback: " + result.Message
+		if result.Error != "" {
+			result.Error = "rollback: " + result.Error
+		}
+		results = append(results, result)
+	}
+	return printResults(os.Stdout, flags.output, results)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/gokubectl"
+)
+
+func TestPolicyExemptions(t *testing.T) {
+	tests := []struct {
+		values  []string
+		want    gokubectl.PolicyExemptions
+		wantErr bool
+	}{
+		{values: nil},
+		{
+			values: []string{"DaemonSet/kube-system/agent=host-path,privileged", "ClusterRole/admin=*"},
+			want: gokubectl.PolicyExemptions{
+				{Kind: "DaemonSet", Namespace: "kube-system", Name: "agent"}: {"host-path", "privileged"},
+				{Kind: "ClusterRole", Name: "admin"}:                         {"*"},
+			},
+		},
+		{
+			// The rules of the same object are merged
+			values: []string{"Pod/web=latest-tag", "Pod/web=resources"},
+			want:   gokubectl.PolicyExemptions{{Kind: "Pod", Name: "web"}: {"latest-tag", "resources"}},
+		},
+		{values: []string{"Pod/web"}, wantErr: true},
+		{values: []string{"Pod/web="}, wantErr: true},
+		{values: []string{"web=*"}, wantErr: true},
+		{values: []string{"Pod/a/b/c=*"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := policyExemptions(tt.values)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("policyExemptions(%q) = %v, want error", tt.values, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("policyExemptions(%q) = %v, %v, want %v", tt.values, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/gokubectl"
+)
+
+// chartFlags are the flags of rendering a chart as the manifest source
+type chartFlags struct {
+	chart       string
+	valuesFiles []string
+	set         []string
+	releaseName string
+	kubeVersion string
+}
+
+// register registers the flags, usage tells what is done with the rendered objects.
+func (f *chartFlags) register(fs *pflag.FlagSet, usage string) {
+	fs.StringVar(&f.chart, "chart", "", "Chart directory or .tgz to render"+usage)
+	fs.StringSliceVar(&f.valuesFiles, "values", nil, "Values files of the chart, the later ones win")
+	fs.StringArrayVar(&f.set, "set", nil, "Values of the chart like a.b=1,c=true, applied after --values")
+	fs.StringVar(&f.releaseName, "release-name", "", "Name of .Release.Name, default --release or the chart name")
+	fs.StringVar(&f.kubeVersion, "kube-version", "", "Version of .Capabilities.KubeVersion, default the version of cluster")
+}
+
+// render renders the chart with the capabilities of cluster, nil without --chart.
+func (f *chartFlags) render(ctx context.Context, flags *globalFlags, base64KubeConfig, releaseName string) ([]gokubectl.Manifest, error) {
+	if f.chart == "" {
+		return nil, nil
+	}
+	opts, err := f.options(flags, releaseName)
+	if err != nil {
+		return nil, err
+	}
+	return gokubectl.RenderChart(ctx, base64KubeConfig, expandHome(f.chart), opts)
+}
+
+func (f *chartFlags) options(flags *globalFlags, releaseName string) (gokubectl.ChartOptions, error) {
+	values, err := parseSetValues(f.set)
+	if err != nil {
+		return gokubectl.ChartOptions{}, err
+	}
+	if f.releaseName != "" {
+		releaseName = f.releaseName
+	}
+	return gokubectl.ChartOptions{
+		DiscoveryOptions: flags.discoveryOptions(),
+
+		ReleaseName: releaseName,
+		Namespace:   flags.namespace,
+		ValuesFiles: f.valuesFiles,
+		Values:      values,
+		KubeVersion: f.kubeVersion,
+	}, nil
+}
+
+// parseSetValues parses a.b=1,c=true like helm --set, the values are typed by YAML.
+func parseSetValues(items []string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	for _, item := range items {
+		for _, pair := range strings.Split(item, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return nil, fmt.Errorf("invalid --set %q, should be key=value", pair)
+			}
+			var value interface{}
+			if err := yaml.Unmarshal([]byte(kv[1]), &value); err != nil {
+				value = kv[1]
+			}
+
+			current := values
+			keys := strings.Split(kv[0], ".")
+			for _, key := range keys[:len(keys)-1] {
+				next, ok := current[key].(map[string]interface{})
+				if !ok {
+					next = map[string]interface{}{}
+					current[key] = next
+				}
+				current = next
+			}
+			current[keys[len(keys)-1]] = value
+		}
+	}
+	return values, nil
+}
+
+var templateFlags struct {
+	chartFlags
+	apiVersions []string
+}
+
+var templateCommand = &command{
+	name: "template",
+	usage: "Render the chart without cluster and print the manifests, like helm template.\n\n" +
+		"Usage:\n  gokubectl template --chart CHART [flags]",
+	flags: func(fs *pflag.FlagSet) {
+		templateFlags.chartFlags.register(fs, "")
+		fs.StringSliceVar(&templateFlags.apiVersions, "api-versions", nil, "API versions of .Capabilities.APIVersions like apps/v1")
+	},
+	run: runTemplate,
+}
+
+func runTemplate(ctx context.Context, flags *globalFlags, args []string) int {
+	if templateFlags.chart == "" || len(args) != 0 {
+		return usageError("template requires --chart and no arguments")
+	}
+	values, err := parseSetValues(templateFlags.set)
+	if err != nil {
+		return usageError("%s", err)
+	}
+	manifests, err := gokubectl.RenderChart(ctx, "", expandHome(templateFlags.chart), gokubectl.ChartOptions{
+		ReleaseName: templateFlags.releaseName,
+		Namespace:   flags.namespace,
+		ValuesFiles: templateFlags.valuesFiles,
+		Values:      values,
+		KubeVersion: templateFlags.kubeVersion,
+		APIVersions: templateFlags.apiVersions,
+	})
+	if err != nil {
+		return fail(err)
+	}
+	for _, m := range manifests {
+		fmt.Printf("---\n# Source: %s\n", m.Source.File)
+		os.Stdout.Write(m.Data)
+	}
+	return exitOK
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSetValues(t *testing.T) {
+	tests := []struct {
+		items   []string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{items: nil, want: map[string]interface{}{}},
+		{
+			items: []string{"replicas=3,image.tag=1.0", "enabled=true"},
+			want: map[string]interface{}{
+				"replicas": float64(3),
+				"image":    map[string]interface{}{"tag": 1.0},
+				"enabled":  true,
+			},
+		},
+		{
+			// The later ones win, the value may contain "="
+			items: []string{"a.b=1", "a.c=x=y", "a.b=2"},
+			want:  map[string]interface{}{"a": map[string]interface{}{"b": float64(2), "c": "x=y"}},
+		},
+		{
+			// The scalar is replaced by the map
+			items: []string{"a=1,a.b=2"},
+			want:  map[string]interface{}{"a": map[string]interface{}{"b": float64(2)}},
+		},
+		{items: []string{"name="}, want: map[string]interface{}{"name": nil}},
+		{items: []string{"version='1.0'"}, want: map[string]interface{}{"version": "1.0"}},
+		{items: []string{"replicas"}, wantErr: true},
+		{items: []string{"=1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSetValues(tt.items)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSetValues(%q) = %v, want error", tt.items, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSetValues(%q) = %v, %v, want %v", tt.items, got, err, tt.want)
+		}
+	}
+}
+
+func TestChartFlagsOptions(t *testing.T) {
+	flags := &globalFlags{namespace: "shop"}
+	f := &chartFlags{chart: "web", valuesFiles: []string{"prod.yaml"}, set: []string{"replicas=2"}, kubeVersion: "v1.18.0"}
+	opts, err := f.options(flags, "web-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.ReleaseName != "web-release" || opts.Namespace != "shop" || opts.KubeVersion != "v1.18.0" ||
+		!reflect.DeepEqual(opts.ValuesFiles, []string{"prod.yaml"}) || opts.Values["replicas"] != float64(2) {
+		t.Errorf("options() = %+v, want the flags", opts)
+	}
+
+	// --release-name wins
+	f.releaseName = "other"
+	if opts, _ = f.options(flags, "web-release"); opts.ReleaseName != "other" {
+		t.Errorf("ReleaseName = %q, want other", opts.ReleaseName)
+	}
+	f.set = []string{"replicas"}
+	if _, err = f.options(flags, ""); err == nil {
+		t.Error("options() with the invalid --set succeeds")
+	}
+}
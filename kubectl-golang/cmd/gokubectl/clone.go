@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/spf13/pflag"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/gokubectl"
+)
+
+var cloneFlags struct {
+	targetKubeconfig string
+	targetContext    string
+	targetNamespace  string
+	selector         string
+	kinds            []string
+	storageClasses   map[string]string
+	ingressHosts     map[string]string
+}
+
+var cloneCommand = &command{
+	name: "clone",
+	usage: "Copy the objects of the namespace to the target cluster, the source is --kubeconfig and --context.\n" +
+		"The objects generated by the source cluster or controlled by others are skipped.\n\n" +
+		"Usage:\n  gokubectl clone -n NAMESPACE --target-context CONTEXT [flags]",
+	flags: func(fs *pflag.FlagSet) {
+		fs.StringVar(&cloneFlags.targetKubeconfig, "target-kubeconfig", "", "Path to the kubeconfig file of the target, default the same as the source")
+		fs.StringVar(&cloneFlags.targetContext, "target-context", "", "The kubeconfig context of the target, default the current context")
+		fs.StringVar(&cloneFlags.targetNamespace, "target-namespace", "", "Rename the namespace in the target, default the same")
+		fs.StringVarP(&cloneFlags.selector, "selector", "l", "", "Label selector to filter the objects")
+		fs.StringSliceVar(&cloneFlags.kinds, "kind", nil, "Clone only the kinds, e.g. Deployment,ConfigMap")
+		fs.StringToStringVar(&cloneFlags.storageClasses, "storage-class", nil, "Map the storage classes, e.g. gp2=standard")
+		fs.StringToStringVar(&cloneFlags.ingressHosts, "ingress-host", nil, "Map the ingress hosts, e.g. .old.example.com=.new.example.com")
+	},
+	run: runClone,
+}
+
+func runClone(ctx context.Context, flags *globalFlags, args []string) int {
+	if len(args) != 0 {
+		return usageError("clone requires no arguments")
+	}
+	if flags.clusterSet != "" {
+		return usageError("clone doesn't support --cluster-set")
+	}
+	if cloneFlags.targetKubeconfig == "" && cloneFlags.targetContext == "" && cloneFlags.targetNamespace == "" {
+		return usageError("clone requires --target-kubeconfig, --target-context or --target-namespace")
+	}
+	source, err := loadKubeConfig(flags.kubeconfig, flags.context)
+	if err != nil {
+		return fail(err)
+	}
+	targetKubeconfig := cloneFlags.targetKubeconfig
+	if targetKubeconfig == "" {
+		targetKubeconfig = flags.kubeconfig
+	}
+	target, err := loadKubeConfig(targetKubeconfig, cloneFlags.targetContext)
+	if err != nil {
+		return fail(err)
+	}
+
+	result, err := gokubectl.CloneNamespace(ctx, source, target, gokubectl.CloneOptions{
+		Apply:           gokubectl.ApplyOptions{DiscoveryOptions: flags.discoveryOptions()},
+		Namespace:       flags.namespace,
+		TargetNamespace: cloneFlags.targetNamespace,
+		LabelSelector:   cloneFlags.selector,
+		Kinds:           cloneFlags.kinds,
+		StorageClasses:  cloneFlags.storageClasses,
+		IngressHosts:    cloneFlags.ingressHosts,
+	})
+	if err != nil {
+		return fail(err)
+	}
+	printWarnings("", result.Warnings)
+	for _, s := range result.Skipped {
+		printWarnings("", []string{"skip " + s.String()})
+	}
+	var results []objectResult
+	for _, r := range result.Results {
+		results = append(results, newObjectResult("", r))
+	}
+	return printResults(os.Stdout, flags.output, results)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/pflag"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/gokubectl"
+)
+
+var convertFlags struct {
+	manifestFlags
+	write bool
+}
+
+var convertCommand = &command{
+	name: "convert",
+	usage: "Convert the deprecated apiVersions of the manifests to the latest ones, no cluster is required.\n" +
+		"The converted manifests are printed, or written back to the files with --write.\n\n" +
+		"Usage:\n  gokubectl convert -f FILENAME [flags]",
+	flags: func(fs *pflag.FlagSet) {
+		convertFlags.manifestFlags.register(fs)
+		fs.BoolVarP(&convertFlags.write, "write", "w", false, "Write the converted manifests back to the files, the comments are not kept")
+	},
+	run: runConvert,
+}
+
+func runConvert(_ context.Context, _ *globalFlags, args []string) int {
+	if len(convertFlags.filenames) == 0 || len(args) != 0 {
+		return usageError("convert requires -f and no arguments")
+	}
+
+	if convertFlags.write {
+		for _, filename := range convertFlags.filenames {
+			if filename == gokubectl.Stdin {
+				return usageError("--write can't write to stdin")
+			}
+			info, err := os.Stat(filename)
+			if err != nil {
+				return fail(err)
+			}
+			if info.IsDir() {
+				return usageError("--write requires files, %s is a directory", filename)
+			}
+		}
+		for _, filename := range convertFlags.filenames {
+			warnings, err := gokubectl.ConvertFile(filename, filename)
+			if err != nil {
+				return fail(err)
+			}
+			printWarnings(filename, warnings)
+		}
+		return exitOK
+	}
+
+	manifests, err := convertFlags.read()
+	if err != nil {
+		return fail(err)
+	}
+	converted, warnings, err := gokubectl.ConvertManifests(manifests)
+	if err != nil {
+		return fail(err)
+	}
+	printWarnings("", warnings)
+	for i, m := range converted {
+		if i != 0 {
+			fmt.Println("---")
+		}
+		os.Stdout.Write(m.Data)
+	}
+	return exitOK
+}
+
+func printWarnings(filename string, warnings []string) {
+	for _, w := range warnings {
+		if filename != "" {
+			fmt.Fprintf(os.Stderr, "warning: %s: %s\n", filename, w)
+		} else {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/gokubectl"
+)
+
+var deleteFlags struct {
+	manifestFlags
+	chartFlags
+	cascade        string
+	ignoreNotFound bool
+	wait           bool
+	timeout        time.Duration
+	gracePeriod    int64
+}
+
+var deleteCommand = &command{
+	name:  "delete",
+	usage: "Delete the objects of the manifests.\n\nUsage:\n  gokubectl delete -f FILENAME [flags]",
+	flags: func(fs *pflag.FlagSet) {
+		deleteFlags.manifestFlags.register(fs)
+		deleteFlags.chartFlags.register(fs, ", its objects except the CRDs of crds/ are deleted")
+		fs.StringVar(&deleteFlags.cascade, "cascade", "background", "How the dependents are deleted: background, foreground or orphan")
+		fs.BoolVar(&deleteFlags.ignoreNotFound, "ignore-not-found", false, "Treat the objects which are not found as deleted")
+		fs.BoolVar(&deleteFlags.wait, "wait", false, "Wait until the objects are removed")
+		fs.DurationVar(&deleteFlags.timeout, "timeout", 0, "The max duration of --wait, default 60s")
+		fs.Int64Var(&deleteFlags.gracePeriod, "grace-period", -1, "Grace period in seconds, -1 means the default of the kind")
+	},
+	run: runDelete,
+}
+
+func runDelete(ctx context.Context, flags *globalFlags, args []string) int {
+	if (len(deleteFlags.filenames) == 0 && deleteFlags.chart == "") || len(args) != 0 {
+		return usageError("delete requires -f or --chart and no arguments")
+	}
+	opts := gokubectl.DeleteOptions{
+		NamespaceOptions: gokubectl.NamespaceOptions{
+			DefaultNamespace: flags.namespace,
+		},
+		DiscoveryOptions: flags.discoveryOptions(),
+
+		IgnoreNotFound: deleteFlags.ignoreNotFound,
+		Wait:           deleteFlags.wait,
+		Timeout:        deleteFlags.timeout,
+	}
+	switch deleteFlags.cascade {
+	case "background":
+		opts.PropagationPolicy = metav1.DeletePropagationBackground
+	case "foreground":
+		opts.PropagationPolicy = metav1.DeletePropagationForeground
+	case "orphan":
+		opts.PropagationPolicy = metav1.DeletePropagationOrphan
+	default:
+		return usageError("unknown --cascade %q, should be one of background, foreground or orphan", deleteFlags.cascade)
+	}
+	if deleteFlags.gracePeriod >= 0 {
+		opts.GracePeriodSeconds = &deleteFlags.gracePeriod
+	}
+
+	manifests, err := deleteFlags.read()
+	if err != nil {
+		return fail(err)
+	}
+	clusters, _, err := flags.clusters()
+	if err != nil {
+		return fail(err)
+	}
+	if deleteFlags.chart != "" {
+		// The CRDs are kept like helm uninstall, deleting them removes all the custom resources
+		chartOpts, err := deleteFlags.options(flags, "")
+		if err != nil {
+			return fail(err)
+		}
+		chartOpts.SkipCRDs = true
+		rendered, err := gokubectl.RenderChart(ctx, clusters[0].Base64KubeConfig, expandHome(deleteFlags.chart), chartOpts)
+		if err != nil {
+			return fail(err)
+		}
+		manifests = append(manifests, gokubectl.UninstallOrder(rendered)...)
+	}
+
+	var results []objectResult
+	for _, cluster := range clusters {
+		clusterManifests, err := gokubectl.ApplyClusterOverrides(cluster.Base64KubeConfig, manifests, cluster.Overrides, opts.DiscoveryOptions)
+		if err != nil {
+			results = append(results, clusterError(cluster.Name, err))
+			continue
+		}
+		deleted, err := gokubectl.DeleteManifests(ctx, cluster.Base64KubeConfig, clusterManifests, opts)
+		if err != nil {
+			results = append(results, clusterError(cluster.Name, err))
+			continue
+		}
+		for _, r := range deleted {
+			results = append(results, newObjectResult(cluster.Name, r))
+		}
+	}
+	return printResults(os.Stdout, flags.output, results)
+}
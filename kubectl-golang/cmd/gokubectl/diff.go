@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/pflag"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/gokubectl"
+)
+
+var diffFlags struct {
+	manifestFlags
+	chartFlags
+	decryptionFlags
+	fieldManager   string
+	forceConflicts bool
+	convert        bool
+}
+
+var diffCommand = &command{
+	name:  "diff",
+	usage: "Show the changes apply would make, exit with 3 if any.\n\nUsage:\n  gokubectl diff -f FILENAME [flags]",
+	flags: func(fs *pflag.FlagSet) {
+		diffFlags.manifestFlags.register(fs)
+		diffFlags.chartFlags.register(fs, ", its objects are diffed after the ones of -f")
+		diffFlags.decryptionFlags.register(fs)
+		fs.StringVar(&diffFlags.fieldManager, "field-manager", "", "Manager name of server-side apply, default kubectl-golang")
+		fs.BoolVar(&diffFlags.forceConflicts, "force-conflicts", false, "Take the ownership of the fields conflicted with other managers")
+		fs.BoolVar(&diffFlags.convert, "convert-deprecated", false, "Convert the deprecated apiVersions not served by the cluster")
+	},
+	run: runDiff,
+}
+
+func runDiff(ctx context.Context, flags *globalFlags, args []string) int {
+	if (len(diffFlags.filenames) == 0 && diffFlags.chart == "") || len(args) != 0 {
+		return usageError("diff requires -f or --chart and no arguments")
+	}
+	opts := gokubectl.ApplyOptions{
+		NamespaceOptions: gokubectl.NamespaceOptions{
+			DefaultNamespace: flags.namespace,
+		},
+		DiscoveryOptions: flags.discoveryOptions(),
+
+		FieldManager: diffFlags.fieldManager,
+		Force:        diffFlags.forceConflicts,
+
+		ConvertDeprecated: diffFlags.convert,
+	}
+
+	identities, err := diffFlags.identities()
+	if err != nil {
+		return fail(err)
+	}
+	opts.AgeIdentities = identities
+	manifests, err := diffFlags.read()
+	if err != nil {
+		return fail(err)
+	}
+	clusters, _, err := flags.clusters()
+	if err != nil {
+		return fail(err)
+	}
+	rendered, err := diffFlags.render(ctx, flags, clusters[0].Base64KubeConfig, "")
+	if err != nil {
+		return fail(err)
+	}
+	manifests = append(manifests, rendered...)
+
+	var results []objectResult
+	for _, cluster := range clusters {
+		clusterManifests, err := gokubectl.ApplyClusterOverrides(cluster.Base64KubeConfig, manifests, cluster.Overrides, opts.DiscoveryOptions)
+		if err != nil {
+			results = append(results, clusterError(cluster.Name, err))
+			continue
+		}
+		diffs, err := gokubectl.DiffManifests(ctx, cluster.Base64KubeConfig, clusterManifests, opts)
+		if err != nil {
+			results = append(results, clusterError(cluster.Name, err))
+			continue
+		}
+		for _, d := range diffs {
+			r := objectResult{
+				Cluster:   cluster.Name,
+				Kind:      d.Kind,
+				Namespace: d.Namespace,
+				Name:      d.Name,
+				Diff:      d.Diff,
+			}
+			if d.Source.File != "" {
+				r.Source = d.Source.String()
+			}
+			if d.Err != nil {
+				r.Error = d.Err.Error()
+			}
+			results = append(results, r)
+		}
+	}
+
+	code := exitOK
+	for _, r := range results {
+		if r.Diff != "" && code == exitOK {
+			code = exitDiffFound
+		}
+		if r.Error != "" {
+			code = exitFailed
+		}
+	}
+
+	if flags.output == outputJSON || flags.output == outputYAML {
+		if err := printData(os.Stdout, flags.output, results); err != nil {
+			return fail(err)
+		}
+		return code
+	}
+	for _, r := range results {
+		switch {
+		case r.Error != "" && r.Source != "":
+			fmt.Fprintf(os.Stderr, "error: %s: %s\n", r.Source, r.Error)
+		case r.Error != "":
+			fmt.Fprintf(os.Stderr, "error: %s\n", r.Error)
+		case r.Diff != "":
+			if r.Cluster != "" {
+				fmt.Printf("# cluster %s\n", r.Cluster)
+			}
+			fmt.Print(r.Diff)
+		}
+	}
+	return code
+}
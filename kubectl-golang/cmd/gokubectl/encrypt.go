@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/gokubectl"
+)
+
+// decryptionFlags is the age key file of the manifests encrypted by SOPS or age
+type decryptionFlags struct {
+	keyFile string
+}
+
+func (f *decryptionFlags) register(fs *pflag.FlagSet) {
+	fs.StringVar(&f.keyFile, "decryption-key-file", "",
+		"age key file to decrypt the manifests encrypted by SOPS or age, default $SOPS_AGE_KEY_FILE")
+}
+
+func (f *decryptionFlags) identities() ([]*gokubectl.AgeIdentity, error) {
+	keyFile := f.keyFile
+	if keyFile == "" {
+		keyFile = os.Getenv("SOPS_AGE_KEY_FILE")
+	}
+	if keyFile == "" {
+		return nil, nil
+	}
+	return gokubectl.ReadAgeIdentities(expandHome(keyFile))
+}
+
+var encryptFlags struct {
+	filename    string
+	recipients  []string
+	write       bool
+	generateKey bool
+}
+
+var encryptCommand = &command{
+	name: "encrypt",
+	usage: "Encrypt the data and stringData of a Secret manifest by SOPS with age recipients, the other fields are kept in plain text.\n\n" +
+		"Usage:\n  gokubectl encrypt --generate-key > key.txt\n  gokubectl encrypt -f secret.yaml --age age1... [--write]",
+	flags: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&encryptFlags.filename, "filename", "f", "", "File of the Secret manifest, - for stdin")
+		fs.StringSliceVar(&encryptFlags.recipients, "age", nil, "age recipients to encrypt for, comma separated or repeated")
+		fs.BoolVarP(&encryptFlags.write, "write", "w", false, "Write the encrypted manifest back to the file")
+		fs.BoolVar(&encryptFlags.generateKey, "generate-key", false, "Print a random age identity like age-keygen")
+	},
+	run: runEncrypt,
+}
+
+func runEncrypt(_ context.Context, _ *globalFlags, args []string) int {
+	if len(args) != 0 {
+		return usageError("encrypt requires no arguments")
+	}
+	if encryptFlags.generateKey {
+		identity, err := gokubectl.GenerateAgeIdentity()
+		if err != nil {
+			return fail(err)
+		}
+		fmt.Printf("# created: %s\n# public key: %s\n%s\n",
+			time.Now().Format(time.RFC3339), identity.Recipient(), identity)
+		return exitOK
+	}
+	if encryptFlags.filename == "" || len(encryptFlags.recipients) == 0 {
+		return usageError("encrypt requires -f and --age")
+	}
+	if encryptFlags.write && encryptFlags.filename == gokubectl.Stdin {
+		return usageError("--write can't write to stdin")
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	if encryptFlags.filename == gokubectl.Stdin {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(encryptFlags.filename)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	encrypted, err := gokubectl.EncryptSecret(data, encryptFlags.recipients)
+	if err != nil {
+		return fail(err)
+	}
+
+	if encryptFlags.write {
+		if err = ioutil.WriteFile(encryptFlags.filename, encrypted, 0600); err != nil {
+			return fail(err)
+		}
+		return exitOK
+	}
+	os.Stdout.Write(encrypted)
+	return exitOK
+}
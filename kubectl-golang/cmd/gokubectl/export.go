@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/gokubectl"
+)
+
+var exportFlags struct {
+	dir           string
+	perObject     bool
+	clusterScoped bool
+	selector      string
+	kinds         []string
+	keepNodePorts bool
+}
+
+var exportCommand = &command{
+	name: "export",
+	usage: "Export the live objects of the namespace as manifests which can be applied again.\n" +
+		"The manifests are printed, or written to the dir with --dir.\n\n" +
+		"Usage:\n  gokubectl export [-n NAMESPACE] [flags]",
+	flags: func(fs *pflag.FlagSet) {
+		fs.StringVar(&exportFlags.dir, "dir", "", "Write one file per kind into the dir, e.g. 27-deployment.apps.yaml")
+		fs.BoolVar(&exportFlags.perObject, "per-object", false, "Write one file per object with --dir, e.g. 27-deployment.apps/web.yaml")
+		fs.BoolVar(&exportFlags.clusterScoped, "cluster-scoped", false, "Export the cluster-scoped objects as well")
+		fs.StringVarP(&exportFlags.selector, "selector", "l", "", "Label selector to filter the objects")
+		fs.StringSliceVar(&exportFlags.kinds, "kind", nil, "Export only the kinds, e.g. Deployment,ConfigMap")
+		fs.BoolVar(&exportFlags.keepNodePorts, "keep-node-ports", false, "Keep the node ports of the Services, removed by default")
+	},
+	run: runExport,
+}
+
+func runExport(ctx context.Context, flags *globalFlags, args []string) int {
+	if len(args) != 0 {
+		return usageError("export requires no arguments")
+	}
+	if exportFlags.perObject && exportFlags.dir == "" {
+		return usageError("--per-object requires --dir")
+	}
+	clusters, set, err := flags.clusters()
+	if err != nil {
+		return fail(err)
+	}
+	if set != nil {
+		return usageError("export doesn't support --cluster-set")
+	}
+
+	objects, warnings, err := gokubectl.Export(ctx, clusters[0].Base64KubeConfig, gokubectl.ExportOptions{
+		DiscoveryOptions: flags.discoveryOptions(),
+
+		Namespace:     flags.namespace,
+		ClusterScoped: exportFlags.clusterScoped,
+		LabelSelector: exportFlags.selector,
+		Kinds:         exportFlags.kinds,
+		KeepNodePorts: exportFlags.keepNodePorts,
+	})
+	if err != nil {
+		return fail(err)
+	}
+	printWarnings("", warnings)
+
+	if exportFlags.dir != "" {
+		layout := gokubectl.ExportPerKind
+		if exportFlags.perObject {
+			layout = gokubectl.ExportPerObject
+		}
+		files, err := gokubectl.WriteExport(exportFlags.dir, objects, layout)
+		if err != nil {
+			return fail(err)
+		}
+		fmt.Fprintf(os.Stderr, "%d objects exported to %d files\n", len(objects), len(files))
+		return exitOK
+	}
+	for i := range objects {
+		out, err := yaml.Marshal(objects[i].Object)
+		if err != nil {
+			return fail(err)
+		}
+		if i != 0 {
+			fmt.Println("---")
+		}
+		os.Stdout.Write(out)
+	}
+	return exitOK
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/gokubectl"
+)
+
+var getFlags struct {
+	allNamespaces bool
+	selector      string
+	fieldSelector string
+}
+
+var getCommand = &command{
+	name:  "get",
+	usage: "Get the objects of a resource.\n\nUsage:\n  gokubectl get RESOURCE [NAME] [flags]",
+	flags: func(fs *pflag.FlagSet) {
+		fs.BoolVarP(&getFlags.allNamespaces, "all-namespaces", "A", false, "List the objects in all the namespaces")
+		fs.StringVarP(&getFlags.selector, "selector", "l", "", "Label selector to filter the objects")
+		fs.StringVar(&getFlags.fieldSelector, "field-selector", "", "Field selector to filter the objects, e.g. status.phase=Running")
+	},
+	run: runGet,
+}
+
+func runGet(ctx context.Context, flags *globalFlags, args []string) int {
+	if len(args) == 0 || len(args) > 2 {
+		return usageError("get requires RESOURCE and an optional NAME")
+	}
+	resource, name := args[0], ""
+	if len(args) == 2 {
+		name = args[1]
+	}
+	opts := gokubectl.GetOptions{
+		DiscoveryOptions: flags.discoveryOptions(),
+
+		Namespace:     flags.namespace,
+		AllNamespaces: getFlags.allNamespaces,
+		LabelSelector: getFlags.selector,
+		FieldSelector: getFlags.fieldSelector,
+	}
+
+	clusters, _, err := flags.clusters()
+	if err != nil {
+		return fail(err)
+	}
+	if flags.output == outputTable || flags.output == outputWide {
+		return runGetTable(ctx, flags, clusters, resource, name, opts)
+	}
+
+	code := exitOK
+	var objects []unstructured.Unstructured
+	for _, cluster := range clusters {
+		items, err := gokubectl.Get(ctx, cluster.Base64KubeConfig, resource, name, opts)
+		if err != nil {
+			if cluster.Name != "" {
+				err = fmt.Errorf("cluster %s: %v", cluster.Name, err)
+			}
+			fail(err)
+			code = exitFailed
+			continue
+		}
+		objects = append(objects, items...)
+	}
+
+	var data interface{}
+	if name != "" && len(objects) == 1 {
+		data = objects[0].Object
+	} else {
+		items := make([]interface{}, 0, len(objects))
+		for _, o := range objects {
+			items = append(items, o.Object)
+		}
+		data = map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "List",
+			"items":      items,
+		}
+	}
+	if err := printData(os.Stdout, flags.output, data); err != nil {
+		return fail(err)
+	}
+	return code
+}
+
+// runGetTable prints the columns defined by the server, the tables of the
+// cluster set are merged with the CLUSTER column.
+func runGetTable(ctx context.Context, flags *globalFlags, clusters []gokubectl.ClusterConfig,
+	resource, name string, opts gokubectl.GetOptions) int {
+	code := exitOK
+	var merged *metav1.Table
+	for _, cluster := range clusters {
+		table, err := gokubectl.GetTable(ctx, cluster.Base64KubeConfig, resource, name, opts)
+		if err != nil {
+			if cluster.Name != "" {
+				err = fmt.Errorf("cluster %s: %v", cluster.Name, err)
+			}
+			code = fail(err)
+			continue
+		}
+		if flags.clusterSet != "" {
+			addClusterColumn(table, cluster.Name)
+		}
+		if merged == nil {
+			merged = table
+		} else {
+			merged.Rows = append(merged.Rows, table.Rows...)
+		}
+	}
+
+	if merged == nil || len(merged.Rows) == 0 {
+		if code == exitOK {
+			fmt.Fprintln(os.Stderr, "No resources found.")
+		}
+		return code
+	}
+	printOpts := gokubectl.TablePrintOptions{
+		WithNamespace: opts.AllNamespaces,
+	}
+	if flags.output == outputWide {
+		printOpts.Format = gokubectl.TableWide
+	}
+	if err := gokubectl.PrintTable(os.Stdout, merged, printOpts); err != nil {
+		return fail(err)
+	}
+	return code
+}
+
+func addClusterColumn(table *metav1.Table, cluster string) {
+	table.ColumnDefinitions = append([]metav1.TableColumnDefinition{{Name: "Cluster", Type: "string"}},
+		table.ColumnDefinitions...)
+	for i := range table.Rows {
+		table.Rows[i].Cells = append([]interface{}{cluster}, table.Rows[i].Cells...)
+	}
+}
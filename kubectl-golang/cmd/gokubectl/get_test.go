@@ -0,0 +1,24 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAddClusterColumn(t *testing.T) {
+	table := &metav1.Table{
+		ColumnDefinitions: []metav1.TableColumnDefinition{{Name: "Name", Type: "string"}},
+		Rows:              []metav1.TableRow{{Cells: []interface{}{"web"}}, {Cells: []interface{}{"api"}}},
+	}
+	addClusterColumn(table, "prod")
+	if table.ColumnDefinitions[0].Name != "Cluster" || len(table.ColumnDefinitions) != 2 {
+		t.Errorf("columns = %v, want Cluster first", table.ColumnDefinitions)
+	}
+	for _, row := range table.Rows {
+		if !reflect.DeepEqual(row.Cells[0], "prod") || len(row.Cells) != 2 {
+			t.Errorf("cells = %v, want prod first", row.Cells)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/spf13/pflag"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/gokubectl"
+)
+
+var healthFlags struct {
+	manifestFlags
+}
+
+var healthCommand = &command{
+	name: "health",
+	usage: "Show the health of the live objects of the manifests: Healthy, Progressing, Degraded or Unknown.\n" +
+		"It exits 1 if any object is not healthy.\n\n" +
+		"Usage:\n  gokubectl health -f FILENAME [flags]",
+	flags: func(fs *pflag.FlagSet) {
+		healthFlags.manifestFlags.register(fs)
+	},
+	run: runHealth,
+}
+
+func runHealth(ctx context.Context, flags *globalFlags, args []string) int {
+	if len(healthFlags.filenames) == 0 || len(args) != 0 {
+		return usageError("health requires -f and no arguments")
+	}
+	manifests, err := healthFlags.read()
+	if err != nil {
+		return fail(err)
+	}
+	clusters, _, err := flags.clusters()
+	if err != nil {
+		return fail(err)
+	}
+
+	opts := gokubectl.HealthOptions{
+		NamespaceOptions: gokubectl.NamespaceOptions{DefaultNamespace: flags.namespace},
+		DiscoveryOptions: flags.discoveryOptions(),
+	}
+	var results []objectResult
+	for _, cluster := range clusters {
+		checked, err := gokubectl.CheckHealth(ctx, cluster.Base64KubeConfig, manifests, opts)
+		if err != nil {
+			results = append(results, clusterError(cluster.Name, err))
+			continue
+		}
+		for _, r := range checked {
+			result := newObjectResult(cluster.Name, r)
+			if r.Health != nil && r.Health.Status != gokubectl.HealthHealthy {
+				result.Error = "not healthy"
+			}
+			results = append(results, result)
+		}
+	}
+	return printResults(os.Stdout, flags.output, results)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	clientcmdlatest "k8s.io/client-go/tools/clientcmd/api/latest"
+	clientcmdapiv1 "k8s.io/client-go/tools/clientcmd/api/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/gokubectl"
+)
+
+const (
+	outputTable = "table"
+	outputWide  = "wide"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+// globalFlags are shared by all the commands
+type globalFlags struct {
+	kubeconfig string
+	context    string
+	clusterSet string
+	namespace  string
+	output     string
+
+	discoveryCacheDir string
+	discoveryCacheTTL time.Duration
+}
+
+func (f *globalFlags) register(fs *pflag.FlagSet) {
+	fs.StringVar(&f.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file, default $KUBECONFIG or ~/.kube/config")
+	fs.StringVar(&f.context, "context", "", "The kubeconfig context to use, default the current context")
+	fs.StringVar(&f.clusterSet, "cluster-set", "", "Path to the cluster set file, run the command on all the clusters of it")
+	fs.StringVarP(&f.namespace, "namespace", "n", "", "The namespace of the objects without namespace")
+	fs.StringVarP(&f.output, "output", "o", outputTable, "Output format: table, wide, json or yaml")
+	fs.StringVar(&f.discoveryCacheDir, "discovery-cache-dir", "~/.kube/cache/discovery", "Dir of the discovery cache, empty to disable it")
+	fs.DurationVar(&f.discoveryCacheTTL, "discovery-cache-ttl", 0, "Max age of the discovery cache, default 10m")
+}
+
+// discoveryOptions returns the disk cache of discovery shared by all the commands.
+func (f *globalFlags) discoveryOptions() gokubectl.DiscoveryOptions {
+	return gokubectl.DiscoveryOptions{
+		DiscoveryCacheDir: expandHome(f.discoveryCacheDir),
+		DiscoveryCacheTTL: f.discoveryCacheTTL,
+	}
+}
+
+func (f *globalFlags) validate() error {
+	switch f.output {
+	case outputTable, outputWide, outputJSON, outputYAML:
+	default:
+		return errors.Errorf("unknown output format %q, should be one of table, wide, json or yaml", f.output)
+	}
+	if f.clusterSet != "" && (f.kubeconfig != "" || f.context != "") {
+		return errors.New("--cluster-set can't be used with --kubeconfig or --context")
+	}
+	return nil
+}
+
+// clusterSet is the file of --cluster-set, e.g.
+//
+//	strategy: Canary
+//	clusters:
+//	- name: prod-a
+//	  kubeconfig: ~/.kube/prod-a
+//	  context: admin@prod-a
+//	  overrides:
+//	    namespace: app
+//	    imageTags: {web: v2}
+//	    replicas: {web: 3}
+type clusterSet struct {
+	Strategy      gokubectl.RolloutStrategy `json:"strategy"`
+	Workers       int                       `json:"workers"`
+	StopOnFailure bool                      `json:"stopOnFailure"`
+	Clusters      []clusterSetEntry         `json:"clusters"`
+}
+
+type clusterSetEntry struct {
+	Name string `json:"name"`
+	// Kubeconfig is relative to the cluster set file
+	Kubeconfig string `json:"kubeconfig"`
+	Context    string `json:"context"`
+	Overrides  struct {
+		Namespace string            `json:"namespace"`
+		ImageTags map[string]string `json:"imageTags"`
+		Replicas  map[string]int64  `json:"replicas"`
+	} `json:"overrides"`
+}
+
+// clusters returns the clusters to run the command on, the single cluster of
+// --kubeconfig and --context has no name.
+func (f *globalFlags) clusters() ([]gokubectl.ClusterConfig, *clusterSet, error) {
+	if f.clusterSet == "" {
+		config, err := loadKubeConfig(f.kubeconfig, f.context)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []gokubectl.ClusterConfig{{Base64KubeConfig: config}}, nil, nil
+	}
+
+	data, err := ioutil.ReadFile(f.clusterSet)
+	if err != nil {
+		return nil, nil, err
+	}
+	set := &clusterSet{}
+	if err = yaml.UnmarshalStrict(data, set); err != nil {
+		return nil, nil, errors.Wrapf(err, "decode %s failed", f.clusterSet)
+	}
+	if len(set.Clusters) == 0 {
+		return nil, nil, errors.Errorf("no cluster in %s", f.clusterSet)
+	}
+
+	clusters := make([]gokubectl.ClusterConfig, 0, len(set.Clusters))
+	for _, entry := range set.Clusters {
+		if entry.Name == "" {
+			return nil, nil, errors.Errorf("cluster name is required in %s", f.clusterSet)
+		}
+		path := expandHome(entry.Kubeconfig)
+		if path != "" && !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(f.clusterSet), path)
+		}
+		config, err := loadKubeConfig(path, entry.Context)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "load kubeconfig of cluster %s failed", entry.Name)
+		}
+		clusters = append(clusters, gokubectl.ClusterConfig{
+			Name:             entry.Name,
+			Base64KubeConfig: config,
+			Overrides: gokubectl.Overrides{
+				Namespace: entry.Overrides.Namespace,
+				ImageTags: entry.Overrides.ImageTags,
+				Replicas:  entry.Overrides.Replicas,
+			},
+		})
+	}
+	return clusters, set, nil
+}
+
+// loadKubeConfig returns the base64 kubeconfig which has only the context, the
+// files referenced by the kubeconfig are embedded.
+func loadKubeConfig(path, context string) (string, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = expandHome(path)
+	config, err := rules.Load()
+	if err != nil {
+		return "", errors.Wrap(err, "load kubeconfig failed")
+	}
+
+	if context != "" {
+		if _, ok := config.Contexts[context]; !ok {
+			return "", errors.Errorf("context %q not found in kubeconfig", context)
+		}
+		config.CurrentContext = context
+	}
+	if config.CurrentContext == "" {
+		return "", errors.New("no context is set in kubeconfig, use --context")
+	}
+	if err = clientcmdapi.MinifyConfig(config); err != nil {
+		return "", errors.Wrap(err, "select context failed")
+	}
+	if err = clientcmdapi.FlattenConfig(config); err != nil {
+		return "", errors.Wrap(err, "embed kubeconfig files failed")
+	}
+	// clientcmd.Write is not used, json-iterator fails on the maps with new go versions
+	v1Config := &clientcmdapiv1.Config{}
+	if err = clientcmdlatest.Scheme.Convert(config, v1Config, nil); err != nil {
+		return "", errors.Wrap(err, "convert kubeconfig failed")
+	}
+	v1Config.APIVersion, v1Config.Kind = "v1", "Config"
+	data, err := yaml.Marshal(v1Config)
+	if err != nil {
+		return "", errors.Wrap(err, "encode kubeconfig failed")
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
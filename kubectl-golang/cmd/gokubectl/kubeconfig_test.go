@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/gokubectl"
+)
+
+const testKubeConfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com
+    certificate-authority: ca.crt
+- name: prod
+  cluster:
+    server: https://prod.example.com
+users:
+- name: admin
+  user:
+    token: admin-token
+contexts:
+- name: admin@dev
+  context: {cluster: dev, user: admin, namespace: app}
+- name: admin@prod
+  context: {cluster: prod, user: admin}
+current-context: admin@dev
+`
+
+// writeTestKubeConfig writes the kubeconfig and the CA file it references
+func writeTestKubeConfig(t *testing.T, dir string) string {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, "ca.crt"), []byte("test ca"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(path, []byte(testKubeConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func decodeTestKubeConfig(t *testing.T, b64 string) *clientcmdapi.Config {
+	t.Helper()
+	data, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestLoadKubeConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeTestKubeConfig(t, dir)
+
+	tests := []struct {
+		context    string
+		wantServer string
+		wantCA     string
+	}{
+		{"", "https://dev.example.com", "test ca"},
+		{"admin@prod", "https://prod.example.com", ""},
+	}
+	for _, tt := range tests {
+		b64, err := loadKubeConfig(path, tt.context)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config := decodeTestKubeConfig(t, b64)
+		// Only the context is kept, and the files are embedded
+		if len(config.Contexts) != 1 || len(config.Clusters) != 1 {
+			t.Errorf("loadKubeConfig(%q) = %d contexts and %d clusters, want 1", tt.context, len(config.Contexts), len(config.Clusters))
+			continue
+		}
+		cluster := config.Clusters[config.Contexts[config.CurrentContext].Cluster]
+		if cluster.Server != tt.wantServer || string(cluster.CertificateAuthorityData) != tt.wantCA {
+			t.Errorf("loadKubeConfig(%q) = %s with CA %q, want %s with CA %q", tt.context,
+				cluster.Server, cluster.CertificateAuthorityData, tt.wantServer, tt.wantCA)
+		}
+		if user := config.AuthInfos[config.Contexts[config.CurrentContext].AuthInfo]; user.Token != "admin-token" {
+			t.Errorf("token = %q, want admin-token", user.Token)
+		}
+	}
+
+	if _, err = loadKubeConfig(path, "admin@staging"); err == nil || !strings.Contains(err.Error(), `context "admin@staging" not found`) {
+		t.Errorf("loadKubeConfig() of a missing context err = %v", err)
+	}
+	if _, err = loadKubeConfig(filepath.Join(dir, "missing"), ""); err == nil {
+		t.Error("loadKubeConfig() of a missing file succeeds")
+	}
+}
+
+func TestClusterSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clusterset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestKubeConfig(t, dir)
+	setFile := filepath.Join(dir, "clusters.yaml")
+	// The kubeconfig is relative to the cluster set file
+	set := `strategy: Canary
+stopOnFailure: true
+clusters:
+- name: dev
+  kubeconfig: config
+- name: prod
+  kubeconfig: config
+  context: admin@prod
+  overrides:
+    namespace: shop
+    imageTags: {web: v2}
+    replicas: {web: 3}
+`
+	if err = ioutil.WriteFile(setFile, []byte(set), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	flags := &globalFlags{clusterSet: setFile}
+	clusters, parsed, err := flags.clusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Strategy != gokubectl.RolloutCanary || !parsed.StopOnFailure {
+		t.Errorf("cluster set = %+v, want the canary strategy stopping on failure", parsed)
+	}
+	if len(clusters) != 2 || clusters[0].Name != "dev" || clusters[1].Name != "prod" {
+		t.Fatalf("clusters() = %v, want dev and prod", clusters)
+	}
+	want := gokubectl.Overrides{Namespace: "shop", ImageTags: map[string]string{"web": "v2"}, Replicas: map[string]int64{"web": 3}}
+	if !reflect.DeepEqual(clusters[1].Overrides, want) {
+		t.Errorf("overrides = %+v, want %+v", clusters[1].Overrides, want)
+	}
+	if config := decodeTestKubeConfig(t, clusters[1].Base64KubeConfig); config.CurrentContext != "admin@prod" {
+		t.Errorf("context of prod = %q, want admin@prod", config.CurrentContext)
+	}
+
+	for name, data := range map[string]string{
+		"empty":        "clusters: []\n",
+		"unknown":      "clusters:\n- name: dev\n  kubeconfig: config\n  server: https://dev.example.com\n",
+		"without name": "clusters:\n- kubeconfig: config\n",
+	} {
+		if err = ioutil.WriteFile(setFile, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err = flags.clusters(); err == nil {
+			t.Errorf("clusters() of the %s cluster set succeeds", name)
+		}
+	}
+}
+
+func TestGlobalFlagsValidate(t *testing.T) {
+	tests := []struct {
+		flags   globalFlags
+		wantErr bool
+	}{
+		{globalFlags{output: outputTable}, false},
+		{globalFlags{output: outputYAML, clusterSet: "clusters.yaml"}, false},
+		{globalFlags{output: "xml"}, true},
+		{globalFlags{output: outputTable, clusterSet: "clusters.yaml", context: "admin@dev"}, true},
+		{globalFlags{output: outputTable, clusterSet: "clusters.yaml", kubeconfig: "config"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.flags.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("validate(%+v) err = %v, wantErr %v", tt.flags, err, tt.wantErr)
+		}
+	}
+}
+
+func TestExpandHome(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"~", home},
+		{"~/.kube/config", filepath.Join(home, ".kube", "config")},
+		{"~other/config", "~other/config"},
+		{"/etc/kubeconfig", "/etc/kubeconfig"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := expandHome(tt.path); got != tt.want {
+			t.Errorf("expandHome(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// gokubectl applies, deletes, diffs and gets the objects of one cluster or
+// a set of clusters, it's a small kubectl built on the gokubectl package.
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/pflag"
+)
+
+// Exit codes of the commands
+const (
+	exitOK = 0
+	// exitFailed means the command or any object failed
+	exitFailed = 1
+	// exitUsage means the arguments or flags are invalid
+	exitUsage = 2
+	// exitDiffFound means diff found differences, like `diff` does
+	exitDiffFound = 3
+)
+
+const usage = `gokubectl applies manifests to one or a set of Kubernetes clusters.
+
+Usage:
+  gokubectl <command> [flags]
+
+Commands:
+  apply      Apply the manifests
+  clone      Copy the objects of a namespace to another cluster
+  convert    Convert the deprecated apiVersions of the manifests
+  delete     Delete the objects of the manifests
+  diff       Show the changes apply would make
+  encrypt    Encrypt the values of a Secret manifest
+  export     Export the live objects of a namespace as manifests
+  get        Get the objects of a resource
+  health     Show the health of the live objects of the manifests
+  history    Show the revisions of a release
+  patch      Patch the objects of a resource by name or label selector
+  rollback   Restore a snapshot saved by apply --atomic, or a release revision
+  reconcile  Keep the objects of the manifests applied until interrupted
+  template   Render a chart without cluster
+
+Exit codes:
+  0  succeeded
+  1  the command or any object failed
+  2  invalid arguments or flags
+  3  diff found differences
+
+Use "gokubectl <command> --help" for the flags of a command.
+`
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, flags *globalFlags, args []string) int
+	flags func(fs *pflag.FlagSet)
+}
+
+var commands = []*command{
+	applyCommand,
+	cloneCommand,
+	convertCommand,
+	deleteCommand,
+	diffCommand,
+	encryptCommand,
+	exportCommand,
+	getCommand,
+	healthCommand,
+	historyCommand,
+	patchCommand,
+	rollbackCommand,
+	reconcileCommand,
+	templateCommand,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		fmt.Fprint(os.Stderr, usage)
+		if len(args) == 0 {
+			return exitUsage
+		}
+		return exitOK
+	}
+
+	var cmd *command
+	for _, c := range commands {
+		if c.name == args[0] {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+
+	fs := pflag.NewFlagSet("gokubectl "+cmd.name, pflag.ContinueOnError)
+	fs.SortFlags = false
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "%s\nFlags:\n%s", cmd.usage, fs.FlagUsages())
+	}
+	flags := &globalFlags{}
+	cmd.flags(fs)
+	flags.register(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		if err == pflag.ErrHelp {
+			return exitOK
+		}
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if err := flags.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+	return cmd.run(ctx, flags, fs.Args())
+}
+
+// fail prints the error and returns the exit code of failure.
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	return exitFailed
+}
+
+// usageError prints the message and returns the exit code of invalid usage.
+func usageError(format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "error: "+format+"\n", args...)
+	return exitUsage
+}
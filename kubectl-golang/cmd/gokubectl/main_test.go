@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestRunUsage(t *testing.T) {
+	tests := []struct {
+		args []string
+		want int
+	}{
+		{nil, exitUsage},
+		{[]string{"--help"}, exitOK},
+		{[]string{"help"}, exitOK},
+		{[]string{"upgrade"}, exitUsage},
+		{[]string{"apply", "--help"}, exitOK},
+		{[]string{"apply", "--unknown"}, exitUsage},
+		{[]string{"apply", "-o", "xml"}, exitUsage},
+		{[]string{"get", "--cluster-set", "clusters.yaml", "--context", "admin@dev", "pods"}, exitUsage},
+	}
+	for _, tt := range tests {
+		if got := run(tt.args); got != tt.want {
+			t.Errorf("run(%q) = %d, want %d", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestCommandsRegistered(t *testing.T) {
+	names := make(map[string]bool)
+	for _, cmd := range commands {
+		if names[cmd.name] {
+			t.Errorf("command %s is registered twice", cmd.name)
+		}
+		names[cmd.name] = true
+		if cmd.run == nil || cmd.flags == nil || cmd.usage == "" {
+			t.Errorf("command %s has no run, flags or usage", cmd.name)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/gokubectl"
+)
+
+// objectResult is the output of the result of one object
+type objectResult struct {
+	Cluster   string   `json:"cluster,omitempty"`
+	Kind      string   `json:"kind,omitempty"`
+	Namespace string   `json:"namespace,omitempty"`
+	Name      string   `json:"name,omitempty"`
+	Source    string   `json:"source,omitempty"`
+	Message   string   `json:"message,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+	Health    string   `json:"health,omitempty"`
+	Diff      string   `json:"diff,omitempty"`
+}
+
+func newObjectResult(cluster string, r gokubectl.ApplyResult) objectResult {
+	result := objectResult{
+		Cluster:   cluster,
+		Kind:      r.Kind,
+		Namespace: r.Namespace,
+		Name:      r.Name,
+		Message:   r.Message,
+		Warnings:  r.Warnings,
+	}
+	for _, v := range r.Violations {
+		if v.Severity != gokubectl.PolicyDeny {
+			result.Warnings = append(result.Warnings, v.String())
+		}
+	}
+	if r.Source.File != "" {
+		result.Source = r.Source.String()
+	}
+	if r.Health != nil {
+		result.Health = r.Health.String()
+	}
+	if r.Err != nil {
+		result.Error = r.Err.Error()
+	}
+	return result
+}
+
+// clusterError is the result of the cluster which failed as a whole
+func clusterError(cluster string, err error) objectResult {
+	return objectResult{
+		Cluster: cluster,
+		Error:   err.Error(),
+	}
+}
+
+// printResults prints the results, it returns exitFailed if any of them failed.
+func printResults(w io.Writer, output string, results []objectResult) int {
+	code := exitOK
+	for _, r := range results {
+		if r.Error != "" {
+			code = exitFailed
+		}
+	}
+
+	if output == outputJSON || output == outputYAML {
+		if err := printData(w, output, results); err != nil {
+			return fail(err)
+		}
+		return code
+	}
+
+	var withCluster, withHealth bool
+	for _, r := range results {
+		withCluster = withCluster || r.Cluster != ""
+		withHealth = withHealth || r.Health != ""
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 3, ' ', 0)
+	header := []string{"KIND", "NAMESPACE", "NAME", "RESULT"}
+	if withCluster {
+		header = append([]string{"CLUSTER"}, header...)
+	}
+	if withHealth {
+		header = append(header, "HEALTH")
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, r := range results {
+		result := r.Message
+		if r.Error != "" {
+			result = "error: " + r.Error
+			if r.Source != "" {
+				result = "error: " + r.Source + ": " + r.Error
+			}
+		}
+		row := []string{r.Kind, r.Namespace, r.Name, result}
+		if withCluster {
+			row = append([]string{r.Cluster}, row...)
+		}
+		if withHealth {
+			row = append(row, r.Health)
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	_ = tw.Flush()
+
+	for _, r := range results {
+		for _, warning := range r.Warnings {
+			fmt.Fprintf(os.Stderr, "warning: %s %s: %s\n", r.Kind, r.Name, warning)
+		}
+	}
+	return code
+}
+
+// printData prints the data in JSON or YAML.
+func printData(w io.Writer, output string, data interface{}) error {
+	var (
+		out []byte
+		err error
+	)
+	if output == outputJSON {
+		out, err = json.MarshalIndent(data, "", "    ")
+		out = append(out, '\n')
+	} else {
+		out, err = yaml.Marshal(data)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/gokubectl"
+)
+
+func TestNewObjectResult(t *testing.T) {
+	r := gokubectl.ApplyResult{
+		Kind:      "Deployment",
+		Namespace: "shop",
+		Name:      "web",
+		Err:       errors.New("invalid"),
+		Warnings:  []string{"deprecated"},
+		Violations: []gokubectl.PolicyViolation{
+			{Rule: "resources", Severity: gokubectl.PolicyWarn, Message: "no limits"},
+			{Rule: "privileged", Severity: gokubectl.PolicyDeny, Message: "privileged"},
+		},
+		Source: gokubectl.Location{File: "app.yaml", Line: 1, Item: -1},
+	}
+	got := newObjectResult("prod", r)
+	want := objectResult{
+		Cluster:   "prod",
+		Kind:      "Deployment",
+		Namespace: "shop",
+		Name:      "web",
+		Source:    "app.yaml:0:1",
+		Error:     "invalid",
+		// The denied violations are in the error
+		Warnings: []string{"deprecated", r.Violations[0].String()},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("newObjectResult() = %+v, want %+v", got, want)
+	}
+}
+
+func TestPrintResults(t *testing.T) {
+	ok := objectResult{Kind: "ConfigMap", Namespace: "default", Name: "web", Message: "web created."}
+	failed := objectResult{Kind: "Secret", Namespace: "default", Name: "db", Source: "app.yaml:1:7", Error: "forbidden"}
+
+	tests := []struct {
+		name     string
+		output   string
+		results  []objectResult
+		want     string
+		wantCode int
+	}{
+		{
+			name:    "table",
+			output:  outputTable,
+			results: []objectResult{ok},
+			want: "KIND        NAMESPACE   NAME   RESULT\n" +
+				"ConfigMap   default     web    web created.\n",
+			wantCode: exitOK,
+		},
+		{
+			name:    "table with clusters and errors",
+			output:  outputWide,
+			results: []objectResult{withCluster(ok, "dev"), withCluster(failed, "prod"), clusterError("staging", errors.New("unreachable"))},
+			want: "CLUSTER   KIND        NAMESPACE   NAME   RESULT\n" +
+				"dev       ConfigMap   default     web    web created.\n" +
+				"prod      Secret      default     db     error: app.yaml:1:7: forbidden\n" +
+				"staging                                  error: unreachable\n",
+			wantCode: exitFailed,
+		},
+		{
+			name:     "table with health",
+			output:   outputTable,
+			results:  []objectResult{{Kind: "Deployment", Name: "web", Message: "web configured.", Health: "Healthy"}},
+			want:     "KIND         NAMESPACE   NAME   RESULT            HEALTH\nDeployment               web    web configured.   Healthy\n",
+			wantCode: exitOK,
+		},
+		{
+			name:     "yaml",
+			output:   outputYAML,
+			results:  []objectResult{failed},
+			want:     "- error: forbidden\n  kind: Secret\n  name: db\n  namespace: default\n  source: app.yaml:1:7\n",
+			wantCode: exitFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if code := printResults(&buf, tt.output, tt.results); code != tt.wantCode {
+				t.Errorf("printResults() = %d, want %d", code, tt.wantCode)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("printResults() printed\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func withCluster(r objectResult, cluster string) objectResult {
+	r.Cluster = cluster
+	return r
+}
+
+func TestPrintResultsJSON(t *testing.T) {
+	var buf bytes.Buffer
+	results := []objectResult{{Kind: "ConfigMap", Name: "web", Warnings: []string{"deprecated"}}}
+	if code := printResults(&buf, outputJSON, results); code != exitOK {
+		t.Errorf("printResults() = %d, want %d", code, exitOK)
+	}
+	var got []objectResult
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, results) {
+		t.Errorf("printResults() = %+v, want %+v", got, results)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/gokubectl"
+)
+
+var patchFlags struct {
+	patch         string
+	patchFile     string
+	patchType     string
+	selector      string
+	allNamespaces bool
+	subresource   string
+	dryRun        bool
+}
+
+var patchTypes = map[string]types.PatchType{
+	"strategic": types.StrategicMergePatchType,
+	"merge":     types.MergePatchType,
+	"json":      types.JSONPatchType,
+}
+
+var patchCommand = &command{
+	name:  "patch",
+	usage: "Patch the object of a resource by name, or the objects selected by -l.\n\nUsage:\n  gokubectl patch RESOURCE [NAME] -p PATCH [flags]",
+	flags: func(fs *pflag.FlagSet) {
+		fs.StringVarP(&patchFlags.patch, "patch", "p", "", "The patch in JSON or YAML")
+		fs.StringVar(&patchFlags.patchFile, "patch-file", "", "Read the patch from the file, - for stdin")
+		fs.StringVar(&patchFlags.patchType, "type", "strategic", "Patch type: strategic, merge or json")
+		fs.StringVarP(&patchFlags.selector, "selector", "l", "", "Label selector of the objects to patch without NAME")
+		fs.BoolVarP(&patchFlags.allNamespaces, "all-namespaces", "A", false, "Patch the selected objects in all the namespaces")
+		fs.StringVar(&patchFlags.subresource, "subresource", "", "Patch the subresource, e.g. status or scale")
+		fs.BoolVar(&patchFlags.dryRun, "dry-run", false, "Send the patch without persisting it")
+	},
+	run: runPatch,
+}
+
+func runPatch(ctx context.Context, flags *globalFlags, args []string) int {
+	if len(args) == 0 || len(args) > 2 {
+		return usageError("patch requires RESOURCE and an optional NAME")
+	}
+	resource, name := args[0], ""
+	if len(args) == 2 {
+		name = args[1]
+	}
+	if (name == "") == (patchFlags.selector == "") {
+		return usageError("patch requires either NAME or -l")
+	}
+	if (patchFlags.patch == "") == (patchFlags.patchFile == "") {
+		return usageError("patch requires either -p or --patch-file")
+	}
+	patchType, ok := patchTypes[strings.ToLower(patchFlags.patchType)]
+	if !ok {
+		return usageError("unknown --type %q, should be one of strategic, merge or json", patchFlags.patchType)
+	}
+
+	patch := []byte(patchFlags.patch)
+	if patchFlags.patchFile != "" {
+		var err error
+		if patchFlags.patchFile == gokubectl.Stdin {
+			patch, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			patch, err = ioutil.ReadFile(patchFlags.patchFile)
+		}
+		if err != nil {
+			return fail(err)
+		}
+	}
+	opts := gokubectl.PatchOptions{
+		DiscoveryOptions: flags.discoveryOptions(),
+
+		Type:          patchType,
+		Namespace:     flags.namespace,
+		AllNamespaces: patchFlags.allNamespaces,
+		LabelSelector: patchFlags.selector,
+		Subresource:   patchFlags.subresource,
+		DryRun:        patchFlags.dryRun,
+	}
+
+	clusters, _, err := flags.clusters()
+	if err != nil {
+		return fail(err)
+	}
+	var results []objectResult
+	for _, cluster := range clusters {
+		patched, err := gokubectl.PatchResource(ctx, cluster.Base64KubeConfig, resource, name, patch, opts)
+		if err != nil {
+			results = append(results, clusterError(cluster.Name, err))
+			continue
+		}
+		for _, r := range patched {
+			results = append(results, newObjectResult(cluster.Name, r))
+		}
+	}
+	return printResults(os.Stdout, flags.output, results)
+}
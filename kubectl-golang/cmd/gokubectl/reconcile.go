@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/gokubectl"
+)
+
+var reconcileFlags struct {
+	manifestFlags
+	chartFlags
+	decryptionFlags
+	fieldManager   string
+	resync         time.Duration
+	leaderElect    bool
+	leaseNamespace string
+	leaseName      string
+	leaderIdentity string
+}
+
+var reconcileCommand = &command{
+	name: "reconcile",
+	usage: "Apply the manifests and re-apply the objects changed or deleted by others, until interrupted.\n\n" +
+		"Usage:\n  gokubectl reconcile -f FILENAME [flags]",
+	flags: func(fs *pflag.FlagSet) {
+		reconcileFlags.manifestFlags.register(fs)
+		reconcileFlags.chartFlags.register(fs, ", its objects are reconciled with the ones of -f")
+		reconcileFlags.decryptionFlags.register(fs)
+		fs.StringVar(&reconcileFlags.fieldManager, "field-manager", "", "Manager name of server-side apply, default kubectl-golang")
+		fs.DurationVar(&reconcileFlags.resync, "resync", 0, "Period of re-applying all the manifests, default 10m")
+		fs.BoolVar(&reconcileFlags.leaderElect, "leader-elect", false, "Reconcile only on the leader of the replicas")
+		fs.StringVar(&reconcileFlags.leaseNamespace, "leader-elect-namespace", "default", "Namespace of the leader election Lease")
+		fs.StringVar(&reconcileFlags.leaseName, "leader-elect-name", "gokubectl-reconcile", "Name of the leader election Lease")
+		fs.StringVar(&reconcileFlags.leaderIdentity, "leader-elect-identity", "", "Identity of this replica, default the hostname")
+	},
+	run: runReconcile,
+}
+
+func runReconcile(ctx context.Context, flags *globalFlags, args []string) int {
+	if (len(reconcileFlags.filenames) == 0 && reconcileFlags.chart == "") || len(args) != 0 {
+		return usageError("reconcile requires -f or --chart and no arguments")
+	}
+	if flags.clusterSet != "" {
+		return usageError("reconcile doesn't support --cluster-set, run one for every cluster")
+	}
+	manifests, err := reconcileFlags.read()
+	if err != nil {
+		return fail(err)
+	}
+	clusters, _, err := flags.clusters()
+	if err != nil {
+		return fail(err)
+	}
+	rendered, err := reconcileFlags.render(ctx, flags, clusters[0].Base64KubeConfig, "")
+	if err != nil {
+		return fail(err)
+	}
+	manifests = append(manifests, rendered...)
+	identities, err := reconcileFlags.identities()
+	if err != nil {
+		return fail(err)
+	}
+
+	opts := gokubectl.ReconcileOptions{
+		Apply: gokubectl.ApplyOptions{
+			NamespaceOptions: gokubectl.NamespaceOptions{
+				DefaultNamespace: flags.namespace,
+			},
+			DiscoveryOptions: flags.discoveryOptions(),
+
+			FieldManager:  reconcileFlags.fieldManager,
+			AgeIdentities: identities,
+			// The reconciler owns the fields of manifest
+			Force: true,
+		},
+		ResyncPeriod: reconcileFlags.resync,
+	}
+	if reconcileFlags.leaderElect {
+		opts.LeaderElection = &gokubectl.LeaderElectionOptions{
+			Namespace: reconcileFlags.leaseNamespace,
+			Name:      reconcileFlags.leaseName,
+			Identity:  reconcileFlags.leaderIdentity,
+		}
+	}
+	if err = gokubectl.Reconcile(ctx, clusters[0].Base64KubeConfig, manifests, opts); err != nil {
+		return fail(err)
+	}
+	return exitOK
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/gokubectl"
+)
+
+// releaseFlags are the flags of the release storage
+type releaseFlags struct {
+	namespace string
+	storage   string
+}
+
+func (f *releaseFlags) register(fs *pflag.FlagSet) {
+	fs.StringVar(&f.namespace, "release-namespace", "default", "Namespace of the release history")
+	fs.StringVar(&f.storage, "release-storage", "Secret", "Kind of the release history: Secret or ConfigMap, which rejects the Secrets not encrypted")
+}
+
+func (f *releaseFlags) options() (gokubectl.ReleaseOptions, error) {
+	opts := gokubectl.ReleaseOptions{Namespace: f.namespace}
+	switch strings.ToLower(f.storage) {
+	case "secret", "":
+		opts.Storage = gokubectl.ReleaseStorageSecret
+	case "configmap":
+		opts.Storage = gokubectl.ReleaseStorageConfigMap
+	default:
+		return opts, fmt.Errorf("unknown --release-storage %q, should be Secret or ConfigMap", f.storage)
+	}
+	return opts, nil
+}
+
+var historyFlags struct {
+	releaseFlags
+	revision int
+	at       string
+}
+
+var historyCommand = &command{
+	name: "history",
+	usage: "Show the revisions of a release applied by apply --release, or the manifests of one revision.\n\n" +
+		"Usage:\n  gokubectl history NAME [flags]\n  gokubectl history NAME --revision 3\n  gokubectl history NAME --at 2021-06-01T15:00:00Z",
+	flags: func(fs *pflag.FlagSet) {
+		historyFlags.releaseFlags.register(fs)
+		fs.IntVar(&historyFlags.revision, "revision", 0, "Print the manifests of the revision")
+		fs.StringVar(&historyFlags.at, "at", "", "Print the manifests deployed at the time, RFC3339 or 2006-01-02")
+	},
+	run: runHistory,
+}
+
+func runHistory(ctx context.Context, flags *globalFlags, args []string) int {
+	if len(args) != 1 {
+		return usageError("history requires the release name")
+	}
+	if flags.clusterSet != "" {
+		return usageError("history doesn't support --cluster-set")
+	}
+	opts, err := historyFlags.options()
+	if err != nil {
+		return usageError("%s", err)
+	}
+	var at time.Time
+	if historyFlags.at != "" {
+		if at, err = parseTime(historyFlags.at); err != nil {
+			return usageError("invalid --at %q, should be RFC3339 or 2006-01-02", historyFlags.at)
+		}
+	}
+	clusters, _, err := flags.clusters()
+	if err != nil {
+		return fail(err)
+	}
+
+	history, err := gokubectl.ReleaseHistory(ctx, clusters[0].Base64KubeConfig, args[0], opts)
+	if err != nil {
+		return fail(err)
+	}
+	if len(history) == 0 {
+		return fail(fmt.Errorf("release %s not found", args[0]))
+	}
+
+	var release *gokubectl.Release
+	switch {
+	case historyFlags.revision != 0:
+		for _, r := range history {
+			if r.Revision == historyFlags.revision {
+				release = r
+			}
+		}
+		if release == nil {
+			return fail(fmt.Errorf("revision %d of release %s not found", historyFlags.revision, args[0]))
+		}
+	case !at.IsZero():
+		if release = gokubectl.ReleaseAt(history, at); release == nil {
+			return fail(fmt.Errorf("release %s was not deployed at %s", args[0], at.Format(time.RFC3339)))
+		}
+	}
+	if release != nil {
+		fmt.Printf("# revision %d, %s by %s\n", release.Revision, release.Time.Format(time.RFC3339), release.User)
+		for i, m := range release.Manifests {
+			if i != 0 {
+				fmt.Println("---")
+			}
+			os.Stdout.Write(m.Data)
+		}
+		return exitOK
+	}
+
+	if flags.output == outputJSON || flags.output == outputYAML {
+		if err = printData(os.Stdout, flags.output, history); err != nil {
+			return fail(err)
+		}
+		return exitOK
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 3, ' ', 0)
+	fmt.Fprintln(tw, "REVISION\tTIME\tUSER\tSTATUS\tOBJECTS\tDESCRIPTION")
+	for _, r := range history {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%s\n", r.Revision, r.Time.Local().Format(time.RFC3339),
+			r.User, r.Status, len(r.Results), r.Description)
+	}
+	_ = tw.Flush()
+	return exitOK
+}
+
+// parseTime parses RFC3339 or a local date
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return t, err
+	}
+	// The end of the day
+	return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+}
+
+func releaseResults(results []gokubectl.ApplyResult) []objectResult {
+	var out []objectResult
+	for _, r := range results {
+		out = append(out, newObjectResult("", r))
+	}
+	return out
+}
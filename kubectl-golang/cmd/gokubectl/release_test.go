@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/gokubectl"
+)
+
+func TestReleaseFlagsOptions(t *testing.T) {
+	tests := []struct {
+		storage string
+		want    gokubectl.ReleaseStorage
+		wantErr bool
+	}{
+		{storage: "", want: gokubectl.ReleaseStorageSecret},
+		{storage: "Secret", want: gokubectl.ReleaseStorageSecret},
+		{storage: "configmap", want: gokubectl.ReleaseStorageConfigMap},
+		{storage: "ConfigMap", want: gokubectl.ReleaseStorageConfigMap},
+		{storage: "etcd", wantErr: true},
+	}
+	for _, tt := range tests {
+		f := &releaseFlags{namespace: "ops", storage: tt.storage}
+		opts, err := f.options()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("options(%q) = %+v, want error", tt.storage, opts)
+			}
+			continue
+		}
+		if err != nil || opts.Storage != tt.want || opts.Namespace != "ops" {
+			t.Errorf("options(%q) = %+v, %v, want %s in ops", tt.storage, opts, err, tt.want)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    time.Time
+		wantErr bool
+	}{
+		{s: "2021-06-01T15:00:00Z", want: time.Date(2021, 6, 1, 15, 0, 0, 0, time.UTC)},
+		{s: "2021-06-01T15:00:00+08:00", want: time.Date(2021, 6, 1, 7, 0, 0, 0, time.UTC)},
+		// The date is the end of the local day
+		{s: "2021-06-01", want: time.Date(2021, 6, 2, 0, 0, 0, 0, time.Local).Add(-time.Nanosecond)},
+		{s: "yesterday", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTime(tt.s)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseTime(%q) = %v, want error", tt.s, got)
+			}
+			continue
+		}
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseTime(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/gokubectl"
+)
+
+var rollbackFlags struct {
+	releaseFlags
+	decryptionFlags
+	snapshotFile string
+	release      string
+	revision     int
+}
+
+var rollbackCommand = &command{
+	name: "rollback",
+	usage: "Restore the objects to the snapshot saved by apply --atomic --snapshot-file,\n" +
+		"or apply a revision of the release again as a new revision.\n\n" +
+		"Usage:\n  gokubectl rollback --snapshot-file FILENAME [flags]\n  gokubectl rollback --release NAME [--revision N] [flags]",
+	flags: func(fs *pflag.FlagSet) {
+		fs.StringVar(&rollbackFlags.snapshotFile, "snapshot-file", "", "Snapshot file saved by apply")
+		fs.StringVar(&rollbackFlags.release, "release", "", "Name of the release")
+		fs.IntVar(&rollbackFlags.revision, "revision", 0, "Revision of the release, default the previous deployed one")
+		rollbackFlags.releaseFlags.register(fs)
+		rollbackFlags.decryptionFlags.register(fs)
+	},
+	run: runRollback,
+}
+
+func runRollback(ctx context.Context, flags *globalFlags, args []string) int {
+	if (rollbackFlags.snapshotFile == "") == (rollbackFlags.release == "") || len(args) != 0 {
+		return usageError("rollback requires one of --snapshot-file or --release and no arguments")
+	}
+	if flags.clusterSet != "" {
+		return usageError("rollback doesn't support --cluster-set")
+	}
+	if rollbackFlags.release != "" {
+		return runRollbackRelease(ctx, flags)
+	}
+	snapshot, err := readSnapshot(rollbackFlags.snapshotFile)
+	if err != nil {
+		return fail(err)
+	}
+	clusters, _, err := flags.clusters()
+	if err != nil {
+		return fail(err)
+	}
+
+	restored, err := gokubectl.Rollback(ctx, clusters[0].Base64KubeConfig, snapshot, flags.discoveryOptions())
+	if err != nil {
+		return fail(err)
+	}
+	var results []objectResult
+	for _, r := range restored {
+		results = append(results, newObjectResult("", r))
+	}
+	return printResults(os.Stdout, flags.output, results)
+}
+
+func runRollbackRelease(ctx context.Context, flags *globalFlags) int {
+	opts, err := rollbackFlags.releaseFlags.options()
+	if err != nil {
+		return usageError("%s", err)
+	}
+	identities, err := rollbackFlags.identities()
+	if err != nil {
+		return fail(err)
+	}
+	opts.Apply = gokubectl.ApplyOptions{
+		NamespaceOptions: gokubectl.NamespaceOptions{
+			DefaultNamespace: flags.namespace,
+		},
+		DiscoveryOptions: flags.discoveryOptions(),
+
+		AgeIdentities: identities,
+	}
+	clusters, _, err := flags.clusters()
+	if err != nil {
+		return fail(err)
+	}
+
+	release, applied, err := gokubectl.RollbackRelease(ctx, clusters[0].Base64KubeConfig, rollbackFlags.release, rollbackFlags.revision, opts)
+	if release != nil {
+		fmt.Fprintf(os.Stderr, "release %s revision %d %s: %s\n", release.Name, release.Revision, release.Status, release.Description)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return printResults(os.Stdout, flags.output, releaseResults(applied))
+}
+
+// writeSnapshot saves the snapshot only readable by the owner, it may contain Secrets.
+func writeSnapshot(path string, snapshot *gokubectl.Snapshot) error {
+	data, err := json.MarshalIndent(snapshot, "", "    ")
+	if err != nil {
+		return errors.Wrap(err, "encode snapshot failed")
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+func readSnapshot(path string) (*gokubectl.Snapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &gokubectl.Snapshot{}
+	if err = json.Unmarshal(data, snapshot); err != nil {
+		return nil, errors.Wrapf(err, "decode snapshot %s failed", path)
+	}
+	return snapshot, nil
+}
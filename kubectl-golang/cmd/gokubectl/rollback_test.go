@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/gokubectl"
+)
+
+func TestSnapshotFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	live := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]interface{}{"name": "db", "namespace": "shop", "resourceVersion": "42"},
+		"data":       map[string]interface{}{"password": "c2VjcmV0"},
+	}}
+	snapshot := &gokubectl.Snapshot{
+		Time: time.Date(2020, 11, 1, 8, 0, 0, 0, time.UTC),
+		Objects: []gokubectl.SnapshotObject{
+			{APIVersion: "v1", Kind: "Secret", Namespace: "shop", Name: "db", Live: live,
+				Source: gokubectl.Location{File: "db.yaml", Line: 1, Item: -1}},
+			{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "shop", Name: "web"},
+		},
+	}
+	path := filepath.Join(dir, "snapshot.json")
+	if err = writeSnapshot(path, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	// The Secrets are in plain text
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("mode of the snapshot = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+
+	got, err := readSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, snapshot) {
+		t.Errorf("readSnapshot() = %+v, want %+v", got, snapshot)
+	}
+
+	if err = ioutil.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = readSnapshot(path); err == nil || !strings.Contains(err.Error(), "decode snapshot") {
+		t.Errorf("readSnapshot() err = %v, want decode failed", err)
+	}
+	if _, err = readSnapshot(filepath.Join(dir, "missing.json")); !os.IsNotExist(err) {
+		t.Errorf("readSnapshot() err = %v, want not exist", err)
+	}
+}
@@ -0,0 +1,512 @@
+package gokubectl
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// The X25519 recipients of age v1, see https://age-encryption.org/v1. SOPS
+// encrypts its data key with them.
+const (
+	ageIntro        = "age-encryption.org/v1"
+	ageX25519Info   = "age-encryption.org/v1/X25519"
+	ageArmorBegin   = "-----BEGIN AGE ENCRYPTED FILE-----"
+	ageArmorEnd     = "-----END AGE ENCRYPTED FILE-----"
+	ageIdentityHRP  = "age-secret-key-"
+	ageRecipientHRP = "age"
+
+	ageFileKeySize  = 16
+	agePayloadNonce = 16
+	ageChunkSize    = 64 * 1024
+	// ageColumns is the width of the stanza body and the armored lines
+	ageColumns = 64
+)
+
+var ageEncoding = base64.RawStdEncoding
+
+// AgeIdentity is an X25519 identity of age, like a line of the key file
+// generated by age-keygen.
+type AgeIdentity struct {
+	secret    []byte
+	recipient []byte
+}
+
+// ParseAgeIdentity parses the identity like AGE-SECRET-KEY-1...
+func ParseAgeIdentity(s string) (*AgeIdentity, error) {
+	hrp, secret, err := bech32Decode(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed age identity")
+	}
+	if hrp != ageIdentityHRP || len(secret) != curve25519.ScalarSize {
+		return nil, errors.New("malformed age identity: not an X25519 identity")
+	}
+	recipient, err := curve25519.X25519(secret, curve25519.Basepoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed age identity")
+	}
+	return &AgeIdentity{secret: secret, recipient: recipient}, nil
+}
+
+// GenerateAgeIdentity returns a random identity, like age-keygen.
+func GenerateAgeIdentity() (*AgeIdentity, error) {
+	secret := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	recipient, err := curve25519.X25519(secret, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	return &AgeIdentity{secret: secret, recipient: recipient}, nil
+}
+
+func (i *AgeIdentity) String() string {
+	s, _ := bech32Encode(ageIdentityHRP, i.secret)
+	return strings.ToUpper(s)
+}
+
+// Recipient returns the public key of the identity, like age1...
+func (i *AgeIdentity) Recipient() string {
+	s, _ := bech32Encode(ageRecipientHRP, i.recipient)
+	return s
+}
+
+// ReadAgeIdentities reads the key file of age-keygen, which is SOPS_AGE_KEY_FILE
+// of SOPS as well. The empty lines and the comments starting with # are ignored.
+func ReadAgeIdentities(path string) ([]*AgeIdentity, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read age key file failed")
+	}
+	var identities []*AgeIdentity
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		identity, err := ParseAgeIdentity(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s:%d", path, n+1)
+		}
+		identities = append(identities, identity)
+	}
+	if len(identities) == 0 {
+		return nil, errors.Errorf("no age identity found in %s", path)
+	}
+	return identities, nil
+}
+
+func parseAgeRecipient(s string) ([]byte, error) {
+	hrp, recipient, err := bech32Decode(s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "malformed age recipient %s", s)
+	}
+	if hrp != ageRecipientHRP || len(recipient) != curve25519.PointSize {
+		return nil, errors.Errorf("malformed age recipient %s: not an X25519 recipient", s)
+	}
+	return recipient, nil
+}
+
+// isAgeEncrypted returns true if the data is an age file, armored or not.
+func isAgeEncrypted(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+	return bytes.HasPrefix(trimmed, []byte(ageArmorBegin)) || bytes.HasPrefix(trimmed, []byte(ageIntro+"\n"))
+}
+
+// ageEncrypt encrypts the plaintext to the recipients, the output is armored.
+func ageEncrypt(plaintext []byte, recipients []string) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("at least one age recipient is required")
+	}
+	fileKey := make([]byte, ageFileKeySize)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, err
+	}
+
+	var header bytes.Buffer
+	header.WriteString(ageIntro + "\n")
+	for _, r := range recipients {
+		recipient, err := parseAgeRecipient(r)
+		if err != nil {
+			return nil, err
+		}
+		ephemeral := make([]byte, curve25519.ScalarSize)
+		if _, err = rand.Read(ephemeral); err != nil {
+			return nil, err
+		}
+		share, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+		if err != nil {
+			return nil, err
+		}
+		shared, err := curve25519.X25519(ephemeral, recipient)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := ageStanzaCipher(shared, share, recipient)
+		if err != nil {
+			return nil, err
+		}
+		body := aead.Seal(nil, make([]byte, chacha20poly1305.NonceSize), fileKey, nil)
+		header.WriteString("-> X25519 " + ageEncoding.EncodeToString(share) + "\n")
+		// The last line of the body is always shorter than the columns, even empty
+		encoded := ageEncoding.EncodeToString(body)
+		for ; len(encoded) >= ageColumns; encoded = encoded[ageColumns:] {
+			header.WriteString(encoded[:ageColumns] + "\n")
+		}
+		header.WriteString(encoded + "\n")
+	}
+	header.WriteString("---")
+	mac, err := ageHeaderMAC(fileKey, header.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	header.WriteString(" " + ageEncoding.EncodeToString(mac) + "\n")
+
+	nonce := make([]byte, agePayloadNonce)
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	aead, err := agePayloadCipher(fileKey, nonce)
+	if err != nil {
+		return nil, err
+	}
+	out := append(header.Bytes(), nonce...)
+	for counter := uint64(0); ; counter++ {
+		chunk := plaintext
+		if len(chunk) > ageChunkSize {
+			chunk = chunk[:ageChunkSize]
+		}
+		plaintext = plaintext[len(chunk):]
+		last := len(plaintext) == 0
+		out = aead.Seal(out, ageChunkNonce(counter, last), chunk, nil)
+		if last {
+			break
+		}
+	}
+	return ageArmor(out), nil
+}
+
+// ageDecrypt decrypts the age file, armored or not, by any of the identities.
+// Only the X25519 recipients are supported, the header MAC is verified.
+func ageDecrypt(data []byte, identities []*AgeIdentity) ([]byte, error) {
+	data, err := ageDearmor(data)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(bytes.NewReader(data))
+	readLine := func() (string, error) {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", errors.New("malformed age header: unexpected end")
+		}
+		return strings.TrimSuffix(line, "\n"), nil
+	}
+
+	var header bytes.Buffer
+	line, err := readLine()
+	if err != nil {
+		return nil, err
+	}
+	if line != ageIntro {
+		return nil, errors.Errorf("unsupported age version %q", line)
+	}
+	header.WriteString(line + "\n")
+
+	var fileKey, mac []byte
+	for {
+		if line, err = readLine(); err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(line, "---") {
+			if mac, err = ageEncoding.DecodeString(strings.TrimPrefix(line, "--- ")); err != nil {
+				return nil, errors.Wrap(err, "malformed age header MAC")
+			}
+			header.WriteString("---")
+			break
+		}
+		if !strings.HasPrefix(line, "-> ") {
+			return nil, errors.Errorf("malformed age header line %q", line)
+		}
+		header.WriteString(line + "\n")
+		args := strings.Fields(strings.TrimPrefix(line, "-> "))
+
+		var encoded string
+		for {
+			bodyLine, err := readLine()
+			if err != nil {
+				return nil, err
+			}
+			header.WriteString(bodyLine + "\n")
+			encoded += bodyLine
+			if len(bodyLine) < ageColumns {
+				break
+			}
+		}
+		if fileKey != nil || len(args) != 2 || args[0] != "X25519" {
+			continue
+		}
+		share, err := ageEncoding.DecodeString(args[1])
+		if err != nil {
+			return nil, errors.Wrap(err, "malformed age X25519 stanza")
+		}
+		body, err := ageEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.Wrap(err, "malformed age X25519 stanza")
+		}
+		for _, identity := range identities {
+			if fileKey = identity.unwrap(share, body); fileKey != nil {
+				break
+			}
+		}
+	}
+	if fileKey == nil {
+		return nil, errors.New("no age identity matches the recipients")
+	}
+	expected, err := ageHeaderMAC(fileKey, header.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, expected) {
+		return nil, errors.New("age header MAC mismatch")
+	}
+
+	payload, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < agePayloadNonce {
+		return nil, errors.New("malformed age payload")
+	}
+	aead, err := agePayloadCipher(fileKey, payload[:agePayloadNonce])
+	if err != nil {
+		return nil, err
+	}
+	payload = payload[agePayloadNonce:]
+	var plaintext []byte
+	for counter := uint64(0); ; counter++ {
+		chunk := payload
+		if len(chunk) > ageChunkSize+aead.Overhead() {
+			chunk = chunk[:ageChunkSize+aead.Overhead()]
+		}
+		payload = payload[len(chunk):]
+		last := len(payload) == 0
+		if plaintext, err = aead.Open(plaintext, ageChunkNonce(counter, last), chunk, nil); err != nil {
+			return nil, errors.New("decrypt age payload failed, it may be truncated or modified")
+		}
+		if last {
+			return plaintext, nil
+		}
+	}
+}
+
+// unwrap returns the file key of the X25519 stanza, nil if it isn't for the identity.
+func (i *AgeIdentity) unwrap(share, body []byte) []byte {
+	if len(share) != curve25519.PointSize {
+		return nil
+	}
+	shared, err := curve25519.X25519(i.secret, share)
+	if err != nil {
+		return nil
+	}
+	aead, err := ageStanzaCipher(shared, share, i.recipient)
+	if err != nil {
+		return nil
+	}
+	fileKey, err := aead.Open(nil, make([]byte, chacha20poly1305.NonceSize), body, nil)
+	if err != nil || len(fileKey) != ageFileKeySize {
+		return nil
+	}
+	return fileKey
+}
+
+func ageStanzaCipher(shared, share, recipient []byte) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, share...), recipient...)
+	key, err := hkdfKey(shared, salt, ageX25519Info)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
+
+func agePayloadCipher(fileKey, nonce []byte) (cipher.AEAD, error) {
+	key, err := hkdfKey(fileKey, nonce, "payload")
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
+
+func ageHeaderMAC(fileKey, header []byte) ([]byte, error) {
+	key, err := hkdfKey(fileKey, nil, "header")
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write(header)
+	return h.Sum(nil), nil
+}
+
+func hkdfKey(secret, salt []byte, info string) ([]byte, error) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ageChunkNonce is the 11 bytes counter in big endian and the last chunk flag.
+func ageChunkNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func ageArmor(data []byte) []byte {
+	var out bytes.Buffer
+	out.WriteString(ageArmorBegin + "\n")
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > ageColumns {
+		out.WriteString(encoded[:ageColumns] + "\n")
+		encoded = encoded[ageColumns:]
+	}
+	out.WriteString(encoded + "\n" + ageArmorEnd + "\n")
+	return out.Bytes()
+}
+
+func ageDearmor(data []byte) ([]byte, error) {
+	trimmed := bytes.TrimSpace(data)
+	if !bytes.HasPrefix(trimmed, []byte(ageArmorBegin)) {
+		return data, nil
+	}
+	if !bytes.HasSuffix(trimmed, []byte(ageArmorEnd)) {
+		return nil, errors.New("malformed age armor: missing end line")
+	}
+	body := trimmed[len(ageArmorBegin) : len(trimmed)-len(ageArmorEnd)]
+	decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+	if err != nil {
+		return nil, errors.Wrap(err, "malformed age armor")
+	}
+	return decoded, nil
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var bech32Generator = []uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+// bech32Encode encodes the data in BIP 173 without the length limit, like age.
+func bech32Encode(hrp string, data []byte) (string, error) {
+	values, err := convertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	checksum := bech32Polymod(append(append(bech32ExpandHRP(hrp), values...), 0, 0, 0, 0, 0, 0)) ^ 1
+	var s strings.Builder
+	s.WriteString(hrp + "1")
+	for _, v := range values {
+		s.WriteByte(bech32Charset[v])
+	}
+	for i := 0; i < 6; i++ {
+		s.WriteByte(bech32Charset[(checksum>>uint(5*(5-i)))&31])
+	}
+	return s.String(), nil
+}
+
+// bech32Decode returns the lower case hrp and the data.
+func bech32Decode(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case")
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndex(s, "1")
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("invalid separator")
+	}
+	hrp := s[:pos]
+	values := make([]byte, 0, len(s)-pos-1)
+	for i := pos + 1; i < len(s); i++ {
+		v := strings.IndexByte(bech32Charset, s[i])
+		if v < 0 {
+			return "", nil, errors.Errorf("invalid character %q", s[i])
+		}
+		values = append(values, byte(v))
+	}
+	if bech32Polymod(append(bech32ExpandHRP(hrp), values...)) != 1 {
+		return "", nil, errors.New("invalid checksum")
+	}
+	data, err := convertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return hrp, data, nil
+}
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i, g := range bech32Generator {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= g
+			}
+		}
+	}
+	return chk
+}
+
+func bech32ExpandHRP(hrp string) []byte {
+	values := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]>>5)
+	}
+	values = append(values, 0)
+	for i := 0; i < len(hrp); i++ {
+		values = append(values, hrp[i]&31)
+	}
+	return values
+}
+
+func convertBits(data []byte, from, to uint, pad bool) ([]byte, error) {
+	var (
+		acc  uint32
+		bits uint
+		out  []byte
+	)
+	max := uint32(1)<<to - 1
+	for _, b := range data {
+		if uint32(b)>>from != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<from | uint32(b)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&max))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(to-bits)&max))
+		}
+	} else if bits >= from || acc<<(to-bits)&max != 0 {
+		return nil, errors.New("invalid padding")
+	}
+	return out, nil
+}
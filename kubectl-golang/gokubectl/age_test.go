@@ -0,0 +1,273 @@
+package gokubectl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The vectors of testdata/age are encrypted by filippo.io/age v1.2.1 with the
+// identity of key.txt, chunks.age is encrypted to another recipient as well.
+const (
+	testAgeRecipient = "age1zdxw05rrkqvqs84gmnjzt6dt0f5wq8gly35wprwnwljdqwwhksgqs6jq2j"
+	// testChunksSHA256 is the hash of the 65537 bytes plaintext of chunks.age
+	testChunksSHA256 = "07e298dc14638c2582d6a5ea6d961c44d2ccb6ecf071a150d7ae4efce9d1e902"
+)
+
+func readTestIdentities(t *testing.T) []*AgeIdentity {
+	t.Helper()
+	identities, err := ReadAgeIdentities(filepath.Join("testdata", "age", "key.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identities
+}
+
+func readTestFile(t *testing.T, elem ...string) []byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join(append([]string{"testdata"}, elem...)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestBech32Checksum(t *testing.T) {
+	// The valid strings of BIP 173
+	valid := []string{
+		"A12UEL5L",
+		"a12uel5l",
+		"an83characterlonghumanreadablepartthatcontainsthenumber1andtheexcludedcharactersbio1tt5tgs",
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxw",
+		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w",
+	}
+	for _, s := range valid {
+		lower := strings.ToLower(s)
+		pos := strings.LastIndex(lower, "1")
+		values := bech32ExpandHRP(lower[:pos])
+		for _, c := range lower[pos+1:] {
+			values = append(values, byte(strings.IndexRune(bech32Charset, c)))
+		}
+		if bech32Polymod(values) != 1 {
+			t.Errorf("checksum of %s is invalid", s)
+		}
+	}
+
+	// The invalid strings of BIP 173
+	invalid := []string{
+		"pzry9x0s0muk",  // no separator
+		"1pzry9x0s0muk", // empty hrp
+		"x1b4n0q5v",     // invalid character
+		"li1dgmt3",      // too short checksum
+		"A1G7SGD8",      // checksum calculated with the upper case hrp
+		"10a06t8",       // empty hrp
+		"1qzzfhee",      // empty hrp
+		"a12UEL5L",      // mixed case
+		"abcdef1qpzry9x8gf2tvdw0s3jn54khce6mua7lmqqqxx", // wrong checksum
+	}
+	for _, s := range invalid {
+		if _, _, err := bech32Decode(s); err == nil {
+			t.Errorf("bech32Decode(%q) succeeds, want error", s)
+		}
+	}
+}
+
+func TestBech32RoundTrip(t *testing.T) {
+	data := []byte{0x00, 0x01, 0x7f, 0x80, 0xff}
+	s, err := bech32Encode("test", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hrp, decoded, err := bech32Decode(strings.ToUpper(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hrp != "test" || !bytes.Equal(decoded, data) {
+		t.Errorf("bech32Decode(bech32Encode()) = %q, %x, want test, %x", hrp, decoded, data)
+	}
+}
+
+func TestParseAgeIdentity(t *testing.T) {
+	// The X25519 vector of RFC 7748 section 6.1
+	secret := mustDecodeHex(t, "77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a")
+	public := mustDecodeHex(t, "8520f0098930a754748b7ddcb43ef75a0dbf3a0d26381af4eba4a98eaa9b4e6a")
+	s, err := bech32Encode(ageIdentityHRP, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := ParseAgeIdentity(strings.ToUpper(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(identity.recipient, public) {
+		t.Errorf("recipient = %x, want %x", identity.recipient, public)
+	}
+	if identity.String() != strings.ToUpper(s) {
+		t.Errorf("String() = %s, want %s", identity.String(), strings.ToUpper(s))
+	}
+
+	// The key file of age-keygen
+	identities := readTestIdentities(t)
+	if len(identities) != 1 || identities[0].Recipient() != testAgeRecipient {
+		t.Errorf("ReadAgeIdentities() = %v, want the recipient %s", identities, testAgeRecipient)
+	}
+
+	for _, s := range []string{
+		testAgeRecipient,
+		"AGE-SECRET-KEY-1",
+		strings.Replace(identities[0].String(), "Q", "P", 1),
+	} {
+		if _, err := ParseAgeIdentity(s); err == nil {
+			t.Errorf("ParseAgeIdentity(%q) succeeds, want error", s)
+		}
+	}
+}
+
+func TestHKDFKey(t *testing.T) {
+	// Test case 1 of RFC 5869, the first 32 bytes of the 42 bytes OKM
+	key, err := hkdfKey(
+		mustDecodeHex(t, "0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b"),
+		mustDecodeHex(t, "000102030405060708090a0b0c"),
+		string(mustDecodeHex(t, "f0f1f2f3f4f5f6f7f8f9")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf"
+	if hex.EncodeToString(key) != want {
+		t.Errorf("hkdfKey() = %x, want %s", key, want)
+	}
+}
+
+func TestAgeChunkNonce(t *testing.T) {
+	tests := []struct {
+		counter uint64
+		last    bool
+		want    string
+	}{
+		{0, false, "000000000000000000000000"},
+		{0, true, "000000000000000000000001"},
+		{1, false, "000000000000000000000100"},
+		{0x0102, true, "000000000000000000010201"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(ageChunkNonce(tt.counter, tt.last)); got != tt.want {
+			t.Errorf("ageChunkNonce(%d, %v) = %s, want %s", tt.counter, tt.last, got, tt.want)
+		}
+	}
+}
+
+func TestAgeDecryptVectors(t *testing.T) {
+	identities := readTestIdentities(t)
+
+	// Armored, one recipient
+	plaintext, err := ageDecrypt(readTestFile(t, "age", "secret.yaml.age"), identities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(plaintext, []byte("password: s3cr3t")) {
+		t.Errorf("ageDecrypt(secret.yaml.age) = %q, want the Secret", plaintext)
+	}
+
+	// Binary, two chunks, the identity matches the second stanza
+	plaintext, err = ageDecrypt(readTestFile(t, "age", "chunks.age"), identities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(plaintext)
+	if len(plaintext) != ageChunkSize+1 || hex.EncodeToString(sum[:]) != testChunksSHA256 {
+		t.Errorf("ageDecrypt(chunks.age) = %d bytes with sha256 %x, want %d bytes with %s",
+			len(plaintext), sum, ageChunkSize+1, testChunksSHA256)
+	}
+}
+
+func TestAgeDecryptTampered(t *testing.T) {
+	identities := readTestIdentities(t)
+	data := readTestFile(t, "age", "chunks.age")
+	headerEnd := bytes.Index(data, []byte("\n---")) + 1
+
+	other, err := GenerateAgeIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// replace changes the byte at i, the base64 of the header is still valid
+	replace := func(i int) []byte {
+		out := append([]byte{}, data...)
+		if out[i] == 'A' {
+			out[i] = 'B'
+		} else {
+			out[i] = 'A'
+		}
+		return out
+	}
+	tests := []struct {
+		name       string
+		data       []byte
+		identities []*AgeIdentity
+		want       string
+	}{
+		{"wrong identity", data, []*AgeIdentity{other}, "no age identity matches"},
+		{"header modified", replace(bytes.Index(data, []byte("X25519")) + 8), identities, "MAC mismatch"},
+		{"mac modified", replace(headerEnd + 5), identities, "MAC mismatch"},
+		{"payload modified", replace(len(data) - 20), identities, "decrypt age payload failed"},
+		// The full first chunk isn't the last one
+		{"last chunk dropped", data[:len(data)-1-16], identities, "decrypt age payload failed"},
+		{"truncated header", data[:headerEnd], identities, "unexpected end"},
+		{"not age", []byte("age-encryption.org/v2\n"), identities, "unsupported age version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ageDecrypt(tt.data, tt.identities)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ageDecrypt() err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAgeEncryptRoundTrip(t *testing.T) {
+	identity, err := GenerateAgeIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := GenerateAgeIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipients := []string{other.Recipient(), identity.Recipient()}
+	for _, size := range []int{0, 1, ageChunkSize, ageChunkSize + 1, 2*ageChunkSize + 100} {
+		plaintext := bytes.Repeat([]byte{'x'}, size)
+		encrypted, err := ageEncrypt(plaintext, recipients)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !isAgeEncrypted(encrypted) {
+			t.Errorf("isAgeEncrypted() of %d bytes = false", size)
+		}
+		for _, id := range []*AgeIdentity{identity, other} {
+			decrypted, err := ageDecrypt(encrypted, []*AgeIdentity{id})
+			if err != nil {
+				t.Fatalf("ageDecrypt() of %d bytes failed: %v", size, err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("ageDecrypt(ageEncrypt()) of %d bytes = %d bytes", size, len(decrypted))
+			}
+		}
+	}
+
+	if _, err = ageEncrypt([]byte("x"), []string{"age1invalid"}); err == nil {
+		t.Error("ageEncrypt() to an invalid recipient succeeds")
+	}
+}
@@ -0,0 +1,274 @@
+package gokubectl
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/version"
+	"sigs.k8s.io/yaml"
+)
+
+// testAPIResources are the resources served by the discovery of testAPIServer
+var testAPIResources = map[string][]metav1.APIResource{
+	"v1": {
+		{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, ShortNames: []string{"cm"}, Verbs: metav1.Verbs{"get", "list", "create", "patch", "delete"}},
+		{Name: "namespaces", Kind: "Namespace", ShortNames: []string{"ns"}, Verbs: metav1.Verbs{"get", "list", "create", "patch", "delete"}},
+		{Name: "pods", Kind: "Pod", Namespaced: true, ShortNames: []string{"po"}, Verbs: metav1.Verbs{"get", "list", "create", "patch", "delete"}},
+		{Name: "secrets", Kind: "Secret", Namespaced: true, Verbs: metav1.Verbs{"get", "list", "create", "patch", "delete"}},
+		{Name: "services", Kind: "Service", Namespaced: true, ShortNames: []string{"svc"}, Verbs: metav1.Verbs{"get", "list", "create", "patch", "delete"}},
+	},
+	"apps/v1": {
+		{Name: "deployments", Kind: "Deployment", Namespaced: true, ShortNames: []string{"deploy"}, Verbs: metav1.Verbs{"get", "list", "create", "patch", "delete"}},
+		{Name: "deployments/scale", Kind: "Scale", Group: "autoscaling", Version: "v1", Namespaced: true, Verbs: metav1.Verbs{"get", "patch"}},
+	},
+}
+
+// testAPIServer serves the discovery of testAPIResources, the other requests
+// are served by the handler. It returns the base64 kubeconfig of the server.
+func testAPIServer(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+	writeJSON := func(w http.ResponseWriter, obj interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(obj)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/version":
+			writeJSON(w, &version.Info{Major: "1", Minor: "18", GitVersion: "v1.18.9"})
+		case "/api":
+			writeJSON(w, &metav1.APIVersions{Versions: []string{"v1"}})
+		case "/apis":
+			writeJSON(w, &metav1.APIGroupList{Groups: []metav1.APIGroup{{
+				Name:             "apps",
+				Versions:         []metav1.GroupVersionForDiscovery{{GroupVersion: "apps/v1", Version: "v1"}},
+				PreferredVersion: metav1.GroupVersionForDiscovery{GroupVersion: "apps/v1", Version: "v1"},
+			}}})
+		case "/api/v1":
+			writeJSON(w, &metav1.APIResourceList{GroupVersion: "v1", APIResources: testAPIResources["v1"]})
+		case "/apis/apps/v1":
+			writeJSON(w, &metav1.APIResourceList{GroupVersion: "apps/v1", APIResources: testAPIResources["apps/v1"]})
+		default:
+			handler(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	kubeConfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster: {server: %q}
+users:
+- name: test
+  user: {token: test}
+contexts:
+- name: test
+  context: {cluster: test, user: test}
+current-context: test
+`, server.URL)
+	return base64.StdEncoding.EncodeToString([]byte(kubeConfig))
+}
+
+// testObjectStore serves the objects in memory by the request paths, e.g.
+// /api/v1/namespaces/shop/configmaps/web, for the handler of testAPIServer.
+// Every request is recorded as "METHOD path". All the patch types are merged
+// like a JSON merge patch, and the apply patch creates the missing objects.
+type testObjectStore struct {
+	mu              sync.Mutex
+	objects         map[string]map[string]interface{}
+	resourceVersion int
+	requests        []string
+	// failures are the status codes of the requests "METHOD path" to fail
+	failures map[string]int
+}
+
+func newTestObjectStore() *testObjectStore {
+	return &testObjectStore{objects: map[string]map[string]interface{}{}, failures: map[string]int{}}
+}
+
+// add stores the object at the path with a new resource version.
+func (s *testObjectStore) add(path string, obj map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.putLocked(path, obj)
+}
+
+func (s *testObjectStore) get(path string) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.objects[path]
+}
+
+func (s *testObjectStore) putLocked(path string, obj map[string]interface{}) {
+	s.resourceVersion++
+	u := &unstructured.Unstructured{Object: obj}
+	u.SetResourceVersion(strconv.Itoa(s.resourceVersion))
+	s.objects[path] = obj
+}
+
+// parseTestPath parses the request path like /apis/apps/v1/namespaces/shop/deployments,
+// collection is false if the path is of an object or a subresource.
+func parseTestPath(path string) (groupVersion, resource string, collection bool) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case segments[0] == "api" && len(segments) > 2:
+		groupVersion, segments = segments[1], segments[2:]
+	case segments[0] == "apis" && len(segments) > 3:
+		groupVersion, segments = segments[1]+"/"+segments[2], segments[3:]
+	default:
+		return "", "", false
+	}
+	if len(segments) >= 3 && segments[0] == "namespaces" {
+		segments = segments[2:]
+	}
+	return groupVersion, segments[0], len(segments) == 1
+}
+
+func (s *testObjectStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+
+	writeStatus := func(code int, reason metav1.StatusReason) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(&metav1.Status{
+			TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+			Status:   metav1.StatusFailure, Code: int32(code), Reason: reason,
+			Message: fmt.Sprintf("%s %s: %s", r.Method, r.URL.Path, reason),
+		})
+	}
+	writeObject := func(obj interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(obj)
+	}
+	readObject := func() map[string]interface{} {
+		var obj map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
+			writeStatus(http.StatusBadRequest, metav1.StatusReasonBadRequest)
+			return nil
+		}
+		return obj
+	}
+
+	path := r.URL.Path
+	groupVersion, resource, collection := parseTestPath(path)
+	if code, ok := s.failures[r.Method+" "+path]; ok {
+		writeStatus(code, metav1.StatusReasonInternalError)
+		return
+	}
+	switch {
+	case r.Method == http.MethodGet && collection:
+		selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+		if err != nil {
+			writeStatus(http.StatusBadRequest, metav1.StatusReasonBadRequest)
+			return
+		}
+		var paths []string
+		for p, obj := range s.objects {
+			if strings.HasPrefix(p, path+"/") && !strings.Contains(p[len(path)+1:], "/") &&
+				selector.Matches(labels.Set((&unstructured.Unstructured{Object: obj}).GetLabels())) {
+				paths = append(paths, p)
+			}
+		}
+		sort.Strings(paths)
+		items := make([]interface{}, 0, len(paths))
+		for _, p := range paths {
+			items = append(items, s.objects[p])
+		}
+		// The typed clients decode the list of the kind only
+		kind := "List"
+		for _, apiResource := range testAPIResources[groupVersion] {
+			if apiResource.Name == resource {
+				kind = apiResource.Kind + "List"
+			}
+		}
+		writeObject(map[string]interface{}{
+			"apiVersion": groupVersion,
+			"kind":       kind,
+			"metadata":   map[string]interface{}{"resourceVersion": strconv.Itoa(s.resourceVersion)},
+			"items":      items,
+		})
+	case r.Method == http.MethodGet:
+		if obj, ok := s.objects[path]; ok {
+			writeObject(obj)
+			return
+		}
+		writeStatus(http.StatusNotFound, metav1.StatusReasonNotFound)
+	case r.Method == http.MethodPost:
+		obj := readObject()
+		if obj == nil {
+			return
+		}
+		path += "/" + (&unstructured.Unstructured{Object: obj}).GetName()
+		if _, ok := s.objects[path]; ok {
+			writeStatus(http.StatusConflict, metav1.StatusReasonAlreadyExists)
+			return
+		}
+		s.putLocked(path, obj)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(obj)
+	case r.Method == http.MethodPut:
+		obj := readObject()
+		if obj == nil {
+			return
+		}
+		current, ok := s.objects[path]
+		if !ok {
+			writeStatus(http.StatusNotFound, metav1.StatusReasonNotFound)
+			return
+		}
+		// The update without the resource version is unconditional
+		if rv := (&unstructured.Unstructured{Object: obj}).GetResourceVersion(); rv != "" && rv != (&unstructured.Unstructured{Object: current}).GetResourceVersion() {
+			writeStatus(http.StatusConflict, metav1.StatusReasonConflict)
+			return
+		}
+		s.putLocked(path, obj)
+		writeObject(obj)
+	case r.Method == http.MethodPatch:
+		body, err := ioutil.ReadAll(r.Body)
+		var patch map[string]interface{}
+		if err == nil {
+			err = yaml.Unmarshal(body, &patch)
+		}
+		if err != nil {
+			writeStatus(http.StatusBadRequest, metav1.StatusReasonBadRequest)
+			return
+		}
+		current, ok := s.objects[path]
+		if !ok && r.Header.Get("Content-Type") != string(types.ApplyPatchType) {
+			writeStatus(http.StatusNotFound, metav1.StatusReasonNotFound)
+			return
+		}
+		obj := mergePatch(current, patch)
+		if !ok {
+			(&unstructured.Unstructured{Object: obj}).SetUID(types.UID("uid-" + path))
+		}
+		s.putLocked(path, obj)
+		writeObject(obj)
+	case r.Method == http.MethodDelete:
+		obj, ok := s.objects[path]
+		if !ok {
+			writeStatus(http.StatusNotFound, metav1.StatusReasonNotFound)
+			return
+		}
+		delete(s.objects, path)
+		writeObject(obj)
+	default:
+		writeStatus(http.StatusMethodNotAllowed, metav1.StatusReasonMethodNotAllowed)
+	}
+}
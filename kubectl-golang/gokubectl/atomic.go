@@ -0,0 +1,259 @@
+package gokubectl
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/k8s"
+)
+
+// SnapshotObject is the state of one target object before apply.
+type SnapshotObject struct {
+	APIVersion string   `json:"apiVersion"`
+	Kind       string   `json:"kind"`
+	Namespace  string   `json:"namespace,omitempty"`
+	Name       string   `json:"name"`
+	Source     Location `json:"source"`
+
+	// Live is the object before apply, nil if it didn't exist
+	Live *unstructured.Unstructured `json:"live,omitempty"`
+}
+
+// Snapshot is the state of the target objects before apply, in the order of
+// the manifests. It can be encoded in JSON and rolled back later by Rollback,
+// keep it safe since the Secrets are in plain text.
+type Snapshot struct {
+	Time    time.Time        `json:"time"`
+	Objects []SnapshotObject `json:"objects"`
+}
+
+type AtomicResult struct {
+	Results []ApplyResult
+	// Snapshot is the state before apply, nil if nothing was applied
+	Snapshot *Snapshot
+	// RolledBack is true if any object failed and the applied ones were rolled back,
+	// Rollback is the result of rolling back every object.
+	RolledBack bool
+	Rollback   []ApplyResult
+}
+
+// Failed returns true if any object failed to apply.
+func (r *AtomicResult) Failed() bool {
+	for _, result := range r.Results {
+		if result.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyAtomic snapshots the target objects and applies the manifests in order. On the
+// first failure the rest are not applied, and the applied ones are rolled back: the
+// created objects are deleted and the updated ones are restored to the snapshot.
+// With ApplyOptions.Wait, the objects not healthy in time are failures as well.
+func ApplyAtomic(ctx context.Context, base64KubeConfig string, manifests []Manifest, opts ApplyOptions) (*AtomicResult, error) {
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  base64KubeConfig,
+		DiscoveryCacheDir: opts.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.DiscoveryCacheTTL,
+		QPS:               opts.QPS,
+		Burst:             opts.Burst,
+	}
+	return applyAtomic(ctx, kubeClient, manifests, opts)
+}
+
+// TakeSnapshot returns the state of the target objects of the manifests, see ApplyAtomic.
+func TakeSnapshot(ctx context.Context, base64KubeConfig string, manifests []Manifest, opts ApplyOptions) (*Snapshot, error) {
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  base64KubeConfig,
+		DiscoveryCacheDir: opts.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.DiscoveryCacheTTL,
+	}
+	prepared, invalid, err := prepareManifests(kubeClient, manifests, opts)
+	if err != nil {
+		return nil, err
+	}
+	if invalid != nil {
+		return nil, invalid[0].Err
+	}
+	return takeSnapshot(ctx, kubeClient, prepared.manifests, opts.NamespaceOptions)
+}
+
+// Rollback restores the objects to the snapshot in the reverse order.
+func Rollback(ctx context.Context, base64KubeConfig string, snapshot *Snapshot, opts DiscoveryOptions) ([]ApplyResult, error) {
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  base64KubeConfig,
+		DiscoveryCacheDir: opts.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.DiscoveryCacheTTL,
+	}
+	return rollback(ctx, kubeClient, snapshot.Objects), nil
+}
+
+func applyAtomic(ctx context.Context, kubeClient *k8s.KubeClient, manifests []Manifest, opts ApplyOptions) (*AtomicResult, error) {
+	opts.complete()
+	prepared, invalid, err := prepareManifests(kubeClient, manifests, opts)
+	if err != nil {
+		return nil, err
+	}
+	if invalid != nil {
+		return &AtomicResult{Results: invalid}, nil
+	}
+
+	snapshot, err := takeSnapshot(ctx, kubeClient, prepared.manifests, opts.NamespaceOptions)
+	if err != nil {
+		return nil, err
+	}
+	result := &AtomicResult{Snapshot: snapshot}
+	results, indexes, err := applyPrepared(ctx, kubeClient, prepared, opts, true)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Wait {
+		waitHealthy(ctx, kubeClient, prepared.manifests, results, indexes, opts)
+	}
+	result.Results = results
+	if !result.Failed() {
+		return result, nil
+	}
+
+	// Roll back the applied ones and the failed ones, which may be partially applied
+	// by client-side apply, in the order of the tiers so they're restored in the
+	// reverse tier order, e.g. the workloads before their Namespace
+	isApplied := make(map[int]bool, len(indexes))
+	for _, i := range indexes {
+		isApplied[i] = true
+	}
+	applied := make([]SnapshotObject, 0, len(indexes))
+	for _, tier := range applyTiers(prepared.manifests, opts.Concurrency) {
+		for _, i := range tier {
+			if isApplied[i] {
+				applied = append(applied, snapshot.Objects[i])
+			}
+		}
+	}
+	result.RolledBack = true
+	result.Rollback = rollback(ctx, kubeClient, applied)
+	return result, nil
+}
+
+// takeSnapshot gets the live objects, any error fails the snapshot so that
+// nothing is applied without a way back.
+func takeSnapshot(ctx context.Context, kubeClient *k8s.KubeClient, manifests []Manifest, nsOpts NamespaceOptions) (*Snapshot, error) {
+	snapshot := &Snapshot{Time: time.Now()}
+	for _, m := range manifests {
+		obj, dr, err := buildDynamicResourceClient(kubeClient, m.Data, nsOpts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s", m.Source)
+		}
+		item := SnapshotObject{
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       obj.GetKind(),
+			Namespace:  obj.GetNamespace(),
+			Name:       obj.GetName(),
+			Source:     m.Source,
+		}
+		live, err := dr.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		switch {
+		case k8sErrors.IsNotFound(err):
+		case err != nil:
+			return nil, errors.Wrapf(err, "snapshot %s %s failed", obj.GetKind(), obj.GetName())
+		default:
+			item.Live = live
+		}
+		snapshot.Objects = append(snapshot.Objects, item)
+	}
+	return snapshot, nil
+}
+
+// rollback restores the objects in the reverse order, it goes on after failures
+// to restore as many as possible.
+func rollback(ctx context.Context, kubeClient *k8s.KubeClient, objects []SnapshotObject) (result []ApplyResult) {
+	for i := len(objects) - 1; i >= 0; i-- {
+		item := objects[i]
+		r := ApplyResult{
+			Kind:      item.Kind,
+			Namespace: item.Namespace,
+			Name:      item.Name,
+			Source:    item.Source,
+		}
+		r.Message, r.Err = restoreObject(ctx, kubeClient, item)
+		if item.Live != nil {
+			redactResult(item.Live, &r)
+		}
+		result = append(result, r)
+	}
+	return result
+}
+
+func restoreObject(ctx context.Context, kubeClient *k8s.KubeClient, item SnapshotObject) (string, error) {
+	dr, err := snapshotResourceClient(kubeClient, item)
+	if err != nil {
+		return "", err
+	}
+
+	// Delete the object created by apply
+	if item.Live == nil {
+		propagationPolicy := metav1.DeletePropagationBackground
+		err = dr.Delete(ctx, item.Name, metav1.DeleteOptions{PropagationPolicy: &propagationPolicy})
+		if k8sErrors.IsNotFound(err) {
+			return item.Name + " absent.", nil
+		}
+		if err != nil {
+			return "", errors.Wrapf(err, "delete %s failed", item.Name)
+		}
+		return item.Name + " deleted.", nil
+	}
+
+	prior := item.Live.DeepCopy()
+	current, err := dr.Get(ctx, item.Name, metav1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		// Deleted by others after the snapshot, create it again
+		for _, field := range []string{"resourceVersion", "uid", "selfLink", "creationTimestamp", "generation", "managedFields"} {
+			unstructured.RemoveNestedField(prior.Object, "metadata", field)
+		}
+		if _, err = dr.Create(ctx, prior, metav1.CreateOptions{}); err != nil {
+			return "", errors.Wrapf(err, "create %s failed", item.Name)
+		}
+		return item.Name + " recreated.", nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "get %s failed", item.Name)
+	}
+	if current.GetResourceVersion() == prior.GetResourceVersion() {
+		return item.Name + " unchanged.", nil
+	}
+
+	// Replace the whole object, so that the fields added by apply are removed
+	prior.SetResourceVersion(current.GetResourceVersion())
+	if _, err = dr.Update(ctx, prior, metav1.UpdateOptions{}); err != nil {
+		return "", errors.Wrapf(err, "restore %s failed", item.Name)
+	}
+	return item.Name + " restored.", nil
+}
+
+func snapshotResourceClient(kubeClient *k8s.KubeClient, item SnapshotObject) (dynamic.ResourceInterface, error) {
+	gv, err := schema.ParseGroupVersion(item.APIVersion)
+	if err != nil {
+		return nil, err
+	}
+	mapping, err := kubeClient.RESTMapping(schema.GroupKind{Group: gv.Group, Kind: item.Kind}, gv.Version)
+	if err != nil {
+		return nil, errors.Wrap(err, "Mapping kind with version failed")
+	}
+	dynamicClient, err := kubeClient.GetDynamicClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "Prepare dynamic client failed.")
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		return dynamicClient.Resource(mapping.Resource).Namespace(item.Namespace), nil
+	}
+	return dynamicClient.Resource(mapping.Resource), nil
+}
@@ -0,0 +1,193 @@
+package gokubectl
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const testConfigMapsPath = "/api/v1/namespaces/shop/configmaps/"
+
+func testStoredConfigMap(name string, data map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "shop", "uid": "uid-" + name},
+		"data":       data,
+	}
+}
+
+func TestAtomicResultFailed(t *testing.T) {
+	r := &AtomicResult{Results: []ApplyResult{{Name: "a"}, {Name: "b"}}}
+	if r.Failed() {
+		t.Error("Failed() = true, want false")
+	}
+	r.Results[1].Err = errors.New("denied")
+	if !r.Failed() {
+		t.Error("Failed() = false, want true")
+	}
+}
+
+func TestTakeSnapshot(t *testing.T) {
+	store := newTestObjectStore()
+	store.add(testConfigMapsPath+"web", testStoredConfigMap("web", map[string]interface{}{"a": "1"}))
+	b64 := testAPIServer(t, store.ServeHTTP)
+
+	manifests := []Manifest{
+		{Data: []byte(testConfigMapManifest("web")), Source: Location{File: "cm.yaml", Line: 1, Item: -1}},
+		{Data: []byte(testConfigMapManifest("api")), Source: Location{File: "cm.yaml", Line: 6, Item: -1}},
+	}
+	snapshot, err := TakeSnapshot(context.Background(), b64, manifests, ApplyOptions{
+		NamespaceOptions: NamespaceOptions{DefaultNamespace: "shop"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Objects) != 2 {
+		t.Fatalf("TakeSnapshot() = %d objects, want 2", len(snapshot.Objects))
+	}
+	web, api := snapshot.Objects[0], snapshot.Objects[1]
+	if web.Kind != "ConfigMap" || web.Namespace != "shop" || web.Name != "web" || web.Source != manifests[0].Source {
+		t.Errorf("Objects[0] = %+v, want the ConfigMap shop/web of cm.yaml:1", web)
+	}
+	if web.Live == nil || web.Live.GetUID() != "uid-web" {
+		t.Errorf("Objects[0].Live = %v, want the live object", web.Live)
+	}
+	// The objects not found are created by apply
+	if api.Name != "api" || api.Live != nil {
+		t.Errorf("Objects[1] = %+v, want api without the live object", api)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	store := newTestObjectStore()
+	prior := func(name string, data map[string]interface{}) *unstructured.Unstructured {
+		obj := testStoredConfigMap(name, data)
+		store.add(testConfigMapsPath+name, obj)
+		return (&unstructured.Unstructured{Object: obj}).DeepCopy()
+	}
+	item := func(name string, live *unstructured.Unstructured) SnapshotObject {
+		return SnapshotObject{APIVersion: "v1", Kind: "ConfigMap", Namespace: "shop", Name: name, Live: live}
+	}
+
+	// Created by apply
+	store.add(testConfigMapsPath+"created", testStoredConfigMap("created", nil))
+	// Updated by apply
+	updated := prior("updated", map[string]interface{}{"a": "1"})
+	store.add(testConfigMapsPath+"updated", testStoredConfigMap("updated", map[string]interface{}{"a": "2", "b": "3"}))
+	// Not changed by apply
+	unchanged := prior("unchanged", map[string]interface{}{"a": "1"})
+	// Deleted by others after the snapshot
+	deleted := prior("deleted", map[string]interface{}{"a": "1"})
+	store.mu.Lock()
+	delete(store.objects, testConfigMapsPath+"deleted")
+	store.mu.Unlock()
+
+	b64 := testAPIServer(t, store.ServeHTTP)
+	results, err := Rollback(context.Background(), b64, &Snapshot{Objects: []SnapshotObject{
+		item("created", nil),
+		item("absent", nil),
+		item("updated", updated),
+		item("unchanged", unchanged),
+		item("deleted", deleted),
+	}}, DiscoveryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Restored in the reverse order
+	var messages []string
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("rollback %s failed: %v", r.Name, r.Err)
+		}
+		messages = append(messages, r.Message)
+	}
+	want := []string{"deleted recreated.", "unchanged unchanged.", "updated restored.", "absent absent.", "created deleted."}
+	if !reflect.DeepEqual(messages, want) {
+		t.Errorf("Rollback() = %q, want %q", messages, want)
+	}
+
+	if store.get(testConfigMapsPath+"created") != nil {
+		t.Error("created isn't deleted")
+	}
+	if data := store.get(testConfigMapsPath + "updated")["data"]; !reflect.DeepEqual(data, map[string]interface{}{"a": "1"}) {
+		t.Errorf("data of updated = %v, want the snapshot", data)
+	}
+	recreated := &unstructured.Unstructured{Object: store.get(testConfigMapsPath + "deleted")}
+	if recreated.Object == nil || recreated.GetUID() != "" {
+		t.Errorf("deleted = %v, want recreated without the uid", recreated.Object)
+	}
+}
+
+func TestRollbackFailure(t *testing.T) {
+	store := newTestObjectStore()
+	b64 := testAPIServer(t, store.ServeHTTP)
+
+	// The unknown kinds fail, the others are still restored
+	store.add(testConfigMapsPath+"created", testStoredConfigMap("created", nil))
+	results, err := Rollback(context.Background(), b64, &Snapshot{Objects: []SnapshotObject{
+		{APIVersion: "v1", Kind: "ConfigMap", Namespace: "shop", Name: "created"},
+		{APIVersion: "example.com/v1", Kind: "Widget", Namespace: "shop", Name: "web"},
+	}}, DiscoveryOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Err == nil || results[1].Err != nil || results[1].Message != "created deleted." {
+		t.Errorf("Rollback() = %v, want Widget failed and created deleted", results)
+	}
+}
+
+func TestApplyAtomic(t *testing.T) {
+	store := newTestObjectStore()
+	store.add(testConfigMapsPath+"web", testStoredConfigMap("web", map[string]interface{}{"a": "1"}))
+	b64 := testAPIServer(t, store.ServeHTTP)
+
+	manifest := func(name, value string) Manifest {
+		return Manifest{Data: []byte(testConfigMapManifest(name) + "data: {a: \"" + value + "\"}\n")}
+	}
+	opts := ApplyOptions{NamespaceOptions: NamespaceOptions{DefaultNamespace: "shop"}}
+	result, err := ApplyAtomic(context.Background(), b64, []Manifest{manifest("web", "2"), manifest("api", "1")}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Failed() || result.RolledBack || result.Snapshot == nil || len(result.Snapshot.Objects) != 2 {
+		t.Fatalf("ApplyAtomic() = %+v, want applied with the snapshot", result)
+	}
+	if data := store.get(testConfigMapsPath + "web")["data"]; !reflect.DeepEqual(data, map[string]interface{}{"a": "2"}) {
+		t.Errorf("data of web = %v, want applied", data)
+	}
+
+	// The rest after the failure aren't applied, the applied ones are rolled back
+	store.failures["PATCH "+testConfigMapsPath+"db"] = 500
+	result, err = ApplyAtomic(context.Background(), b64, []Manifest{
+		manifest("web", "3"), manifest("new", "1"), manifest("db", "1"), manifest("cache", "1"),
+	}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Failed() || !result.RolledBack {
+		t.Fatalf("ApplyAtomic() = %+v, want failed and rolled back", result)
+	}
+	if len(result.Results) != 3 || result.Results[2].Name != "db" || result.Results[2].Err == nil {
+		t.Errorf("Results = %v, want db failed and cache not applied", result.Results)
+	}
+	var rolledBack []string
+	for _, r := range result.Rollback {
+		rolledBack = append(rolledBack, r.Message)
+	}
+	if want := []string{"db absent.", "new deleted.", "web restored."}; !reflect.DeepEqual(rolledBack, want) {
+		t.Errorf("Rollback = %q, want %q", rolledBack, want)
+	}
+	if data := store.get(testConfigMapsPath + "web")["data"]; !reflect.DeepEqual(data, map[string]interface{}{"a": "2"}) {
+		t.Errorf("data of web = %v, want restored to the snapshot", data)
+	}
+	for _, name := range []string{"new", "cache"} {
+		if store.get(testConfigMapsPath+name) != nil {
+			t.Errorf("%s exists, want not applied or deleted", name)
+		}
+	}
+}
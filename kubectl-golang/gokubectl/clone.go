@@ -0,0 +1,234 @@
+package gokubectl
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/k8s"
+)
+
+const (
+	storageClassAnnotation = "volume.beta.kubernetes.io/storage-class"
+)
+
+type CloneOptions struct {
+	// Namespace to clone from the source cluster, default "default"
+	Namespace string
+	// TargetNamespace renames the namespace in the target cluster, default the same.
+	// The namespaces of RoleBinding subjects are renamed as well.
+	TargetNamespace string
+	// LabelSelector filters the objects to clone
+	LabelSelector string
+	// Kinds limits the cloned kinds if not empty, e.g. Deployment
+	Kinds []string
+
+	// StorageClasses maps the storage classes of PersistentVolumeClaims and the
+	// volumeClaimTemplates of StatefulSets, the unmapped ones are kept.
+	StorageClasses map[string]string
+	// IngressHosts maps the hosts of Ingresses. The keys starting with "." map the
+	// suffixes, e.g. ".old.example.com" to ".new.example.com".
+	IngressHosts map[string]string
+
+	// Apply are the options to apply the objects to the target cluster
+	Apply ApplyOptions
+}
+
+type CloneResult struct {
+	// Results are the results of applying to the target cluster
+	Results []ApplyResult
+	// Skipped are the objects generated by the source cluster or controlled by others
+	Skipped []SkippedObject
+	// Warnings are the kinds failed to list in the source cluster
+	Warnings []string
+}
+
+// CloneNamespace copies the objects of the namespace from the source cluster to
+// the target cluster, see Export for the objects copied. The PersistentVolumeClaims
+// are provisioned again in the target cluster, the data is not copied.
+func CloneNamespace(ctx context.Context, sourceBase64KubeConfig, targetBase64KubeConfig string, opts CloneOptions) (*CloneResult, error) {
+	source := &k8s.KubeClient{
+		Base64KubeConfig:  sourceBase64KubeConfig,
+		DiscoveryCacheDir: opts.Apply.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.Apply.DiscoveryCacheTTL,
+	}
+	target := &k8s.KubeClient{
+		Base64KubeConfig:  targetBase64KubeConfig,
+		DiscoveryCacheDir: opts.Apply.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.Apply.DiscoveryCacheTTL,
+		QPS:               opts.Apply.QPS,
+		Burst:             opts.Apply.Burst,
+	}
+	return cloneNamespace(ctx, source, target, opts)
+}
+
+func cloneNamespace(ctx context.Context, source, target *k8s.KubeClient, opts CloneOptions) (*CloneResult, error) {
+	if opts.Namespace == "" {
+		opts.Namespace = defaultNamespace
+	}
+	if opts.TargetNamespace == "" {
+		opts.TargetNamespace = opts.Namespace
+	}
+
+	objects, skipped, warnings, err := export(ctx, source, ExportOptions{
+		Namespace:     opts.Namespace,
+		LabelSelector: opts.LabelSelector,
+		Kinds:         opts.Kinds,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "export source namespace failed")
+	}
+
+	manifests := make([]Manifest, 0, len(objects))
+	for i := range objects {
+		obj := &objects[i]
+		if err = rewriteClone(obj, opts); err != nil {
+			return nil, err
+		}
+		out, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return nil, errors.Wrapf(err, "encode %s failed", obj.GetName())
+		}
+		manifests = append(manifests, Manifest{
+			Data:   out,
+			Source: Location{File: opts.Namespace + "/" + strings.ToLower(obj.GetKind()) + "/" + obj.GetName()},
+		})
+	}
+
+	result := &CloneResult{Skipped: skipped, Warnings: warnings}
+	opts.Apply.DefaultNamespace = opts.TargetNamespace
+	if result.Results, err = applyManifests(ctx, target, manifests, opts.Apply); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// rewriteClone renames the namespace and maps the storage classes and hosts of
+// the exported object.
+func rewriteClone(obj *unstructured.Unstructured, opts CloneOptions) error {
+	if obj.GetKind() == "Namespace" {
+		obj.SetName(opts.TargetNamespace)
+	} else if obj.GetNamespace() != "" {
+		obj.SetNamespace(opts.TargetNamespace)
+	}
+
+	switch obj.GetKind() {
+	case "RoleBinding", "ClusterRoleBinding":
+		return renameSubjects(obj, opts.Namespace, opts.TargetNamespace)
+	case "PersistentVolumeClaim":
+		// Bound to the volume of the source cluster
+		unstructured.RemoveNestedField(obj.Object, "spec", "volumeName")
+		mapStorageClass(obj.Object, opts.StorageClasses)
+		if annotations := obj.GetAnnotations(); annotations[storageClassAnnotation] != "" {
+			if class, ok := opts.StorageClasses[annotations[storageClassAnnotation]]; ok {
+				annotations[storageClassAnnotation] = class
+				obj.SetAnnotations(annotations)
+			}
+		}
+	case "StatefulSet":
+		templates, _, err := unstructured.NestedSlice(obj.Object, "spec", "volumeClaimTemplates")
+		if err != nil {
+			return errors.Wrapf(err, "read volumeClaimTemplates of %s failed", obj.GetName())
+		}
+		for _, t := range templates {
+			if template, ok := t.(map[string]interface{}); ok {
+				mapStorageClass(template, opts.StorageClasses)
+			}
+		}
+		if templates != nil {
+			return unstructured.SetNestedSlice(obj.Object, templates, "spec", "volumeClaimTemplates")
+		}
+	case "Ingress":
+		return mapIngressHosts(obj, opts.IngressHosts)
+	}
+	return nil
+}
+
+func renameSubjects(obj *unstructured.Unstructured, from, to string) error {
+	subjects, found, err := unstructured.NestedSlice(obj.Object, "subjects")
+	if err != nil {
+		return errors.Wrapf(err, "read subjects of %s failed", obj.GetName())
+	}
+	if !found {
+		return nil
+	}
+	for _, s := range subjects {
+		if subject, ok := s.(map[string]interface{}); ok && subject["namespace"] == from {
+			subject["namespace"] = to
+		}
+	}
+	return unstructured.SetNestedSlice(obj.Object, subjects, "subjects")
+}
+
+func mapStorageClass(pvc map[string]interface{}, classes map[string]string) {
+	class, found, _ := unstructured.NestedString(pvc, "spec", "storageClassName")
+	if !found {
+		return
+	}
+	if mapped, ok := classes[class]; ok {
+		_ = unstructured.SetNestedField(pvc, mapped, "spec", "storageClassName")
+	}
+}
+
+func mapIngressHosts(obj *unstructured.Unstructured, hosts map[string]string) error {
+	if len(hosts) == 0 {
+		return nil
+	}
+	rules, _, err := unstructured.NestedSlice(obj.Object, "spec", "rules")
+	if err != nil {
+		return errors.Wrapf(err, "read rules of %s failed", obj.GetName())
+	}
+	for _, r := range rules {
+		if rule, ok := r.(map[string]interface{}); ok {
+			if host, ok := rule["host"].(string); ok {
+				rule["host"] = mapHost(host, hosts)
+			}
+		}
+	}
+	if rules != nil {
+		if err = unstructured.SetNestedSlice(obj.Object, rules, "spec", "rules"); err != nil {
+			return err
+		}
+	}
+
+	tls, _, err := unstructured.NestedSlice(obj.Object, "spec", "tls")
+	if err != nil {
+		return errors.Wrapf(err, "read tls of %s failed", obj.GetName())
+	}
+	for _, t := range tls {
+		item, ok := t.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		tlsHosts, _ := item["hosts"].([]interface{})
+		for i, h := range tlsHosts {
+			if host, ok := h.(string); ok {
+				tlsHosts[i] = mapHost(host, hosts)
+			}
+		}
+	}
+	if tls != nil {
+		return unstructured.SetNestedSlice(obj.Object, tls, "spec", "tls")
+	}
+	return nil
+}
+
+// mapHost maps the host exactly, or by the longest suffix key starting with ".".
+func mapHost(host string, hosts map[string]string) string {
+	if mapped, ok := hosts[host]; ok {
+		return mapped
+	}
+	suffix := ""
+	for from := range hosts {
+		if strings.HasPrefix(from, ".") && strings.HasSuffix(host, from) && len(from) > len(suffix) {
+			suffix = from
+		}
+	}
+	if suffix == "" {
+		return host
+	}
+	return strings.TrimSuffix(host, suffix) + hosts[suffix]
+}
@@ -0,0 +1,215 @@
+package gokubectl
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestMapHost(t *testing.T) {
+	hosts := map[string]string{
+		"shop.example.com":     "shop.example.org",
+		".example.com":         ".example.net",
+		".staging.example.com": ".prod.example.com",
+	}
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"shop.example.com", "shop.example.org"},
+		{"api.example.com", "api.example.net"},
+		// The longest suffix wins
+		{"api.staging.example.com", "api.prod.example.com"},
+		{"example.com", "example.com"},
+		{"api.example.io", "api.example.io"},
+	}
+	for _, tt := range tests {
+		if got := mapHost(tt.host, hosts); got != tt.want {
+			t.Errorf("mapHost(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestRewriteClone(t *testing.T) {
+	opts := CloneOptions{
+		Namespace:       "shop",
+		TargetNamespace: "shop-copy",
+		StorageClasses:  map[string]string{"gp2": "standard"},
+		IngressHosts:    map[string]string{".old.example.com": ".new.example.com"},
+	}
+	tests := []struct {
+		name string
+		obj  string
+		want string
+	}{
+		{
+			name: "Namespace",
+			obj:  "apiVersion: v1\nkind: Namespace\nmetadata: {name: shop}\n",
+			want: "apiVersion: v1\nkind: Namespace\nmetadata: {name: shop-copy}\n",
+		},
+		{
+			name: "cluster-scoped",
+			obj:  "apiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole\nmetadata: {name: reader}\n",
+			want: "apiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole\nmetadata: {name: reader}\n",
+		},
+		{
+			name: "RoleBinding",
+			obj: `
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata: {name: web, namespace: shop}
+subjects:
+- {kind: ServiceAccount, name: web, namespace: shop}
+- {kind: ServiceAccount, name: monitor, namespace: monitoring}
+- {kind: User, name: alice}
+`,
+			want: `
+apiVersion: rbac.authorization.k8s.io/v1
+kind: RoleBinding
+metadata: {name: web, namespace: shop-copy}
+subjects:
+- {kind: ServiceAccount, name: web, namespace: shop-copy}
+- {kind: ServiceAccount, name: monitor, namespace: monitoring}
+- {kind: User, name: alice}
+`,
+		},
+		{
+			name: "PersistentVolumeClaim",
+			obj: `
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data
+  namespace: shop
+  annotations: {volume.beta.kubernetes.io/storage-class: gp2}
+spec:
+  storageClassName: gp2
+  volumeName: pvc-7a9b
+`,
+			want: `
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data
+  namespace: shop-copy
+  annotations: {volume.beta.kubernetes.io/storage-class: standard}
+spec:
+  storageClassName: standard
+`,
+		},
+		{
+			name: "StatefulSet",
+			obj: `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata: {name: db, namespace: shop}
+spec:
+  volumeClaimTemplates:
+  - metadata: {name: data}
+    spec: {storageClassName: gp2}
+  - metadata: {name: logs}
+    spec: {storageClassName: io1}
+  - metadata: {name: cache}
+`,
+			want: `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata: {name: db, namespace: shop-copy}
+spec:
+  volumeClaimTemplates:
+  - metadata: {name: data}
+    spec: {storageClassName: standard}
+  - metadata: {name: logs}
+    spec: {storageClassName: io1}
+  - metadata: {name: cache}
+`,
+		},
+		{
+			name: "Ingress",
+			obj: `
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata: {name: web, namespace: shop}
+spec:
+  rules:
+  - host: shop.old.example.com
+  - http: {}
+  tls:
+  - hosts: [shop.old.example.com, shop.example.io]
+    secretName: web-tls
+`,
+			want: `
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata: {name: web, namespace: shop-copy}
+spec:
+  rules:
+  - host: shop.new.example.com
+  - http: {}
+  tls:
+  - hosts: [shop.new.example.com, shop.example.io]
+    secretName: web-tls
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := decodeTestObject(t, tt.obj)
+			if err := rewriteClone(obj, opts); err != nil {
+				t.Fatal(err)
+			}
+			if want := decodeTestObject(t, tt.want); !reflect.DeepEqual(obj.Object, want.Object) {
+				t.Errorf("rewriteClone() = %v\nwant %v", obj.Object, want.Object)
+			}
+		})
+	}
+}
+
+func TestCloneNamespace(t *testing.T) {
+	source, target := newTestObjectStore(), newTestObjectStore()
+	sourceB64, targetB64 := testAPIServer(t, source.ServeHTTP), testAPIServer(t, target.ServeHTTP)
+
+	source.add("/api/v1/namespaces/shop", decodeTestLive(t, "apiVersion: v1\nkind: Namespace\nmetadata: {name: shop, labels: {team: shop}}\n").Object)
+	source.add("/api/v1/namespaces/shop/configmaps/web", decodeTestLive(t, "apiVersion: v1\nkind: ConfigMap\nmetadata: {name: web, namespace: shop, uid: x}\ndata: {a: '1'}\n").Object)
+	source.add("/api/v1/namespaces/shop/configmaps/kube-root-ca.crt", decodeTestLive(t, "apiVersion: v1\nkind: ConfigMap\nmetadata: {name: kube-root-ca.crt, namespace: shop}\n").Object)
+	source.add("/api/v1/namespaces/shop/secrets/web-token", decodeTestLive(t, "apiVersion: v1\nkind: Secret\nmetadata: {name: web-token, namespace: shop}\ntype: kubernetes.io/service-account-token\n").Object)
+
+	result, err := CloneNamespace(context.Background(), sourceB64, targetB64, CloneOptions{Namespace: "shop", TargetNamespace: "shop-copy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Warnings) != 0 {
+		t.Errorf("Warnings = %v, want none", result.Warnings)
+	}
+	var skipped []string
+	for _, s := range result.Skipped {
+		skipped = append(skipped, s.String())
+	}
+	if want := []string{"Secret web-token: service account token", "ConfigMap kube-root-ca.crt: created by the cluster"}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("Skipped = %q, want %q", skipped, want)
+	}
+	var applied []string
+	for _, r := range result.Results {
+		if r.Err != nil {
+			t.Errorf("clone %s failed: %v", r.Name, r.Err)
+		}
+		applied = append(applied, r.Kind+" "+r.Namespace+"/"+r.Name)
+	}
+	if want := []string{"Namespace /shop-copy", "ConfigMap shop-copy/web"}; !reflect.DeepEqual(applied, want) {
+		t.Errorf("Results = %v, want %v", applied, want)
+	}
+
+	ns := target.get("/api/v1/namespaces/shop-copy")
+	if labels, _, _ := unstructured.NestedStringMap(ns, "metadata", "labels"); labels["team"] != "shop" {
+		t.Errorf("Namespace in target = %v, want the labels copied", ns)
+	}
+	cm := target.get("/api/v1/namespaces/shop-copy/configmaps/web")
+	if !reflect.DeepEqual(cm["data"], map[string]interface{}{"a": "1"}) {
+		t.Errorf("ConfigMap in target = %v, want the data copied", cm)
+	}
+	if source.get("/api/v1/namespaces/shop-copy") != nil {
+		t.Error("the source cluster is changed")
+	}
+}
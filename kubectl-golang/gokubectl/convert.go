@@ -0,0 +1,292 @@
+package gokubectl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/k8s"
+)
+
+// apiMigration converts the object of deprecated version to the target version,
+// the warnings describe the changed fields.
+type apiMigration struct {
+	target  schema.GroupVersion
+	migrate func(obj *unstructured.Unstructured, from schema.GroupVersion) ([]string, error)
+}
+
+var (
+	appsV1         = schema.GroupVersion{Group: "apps", Version: "v1"}
+	networkingV1   = schema.GroupVersion{Group: "networking.k8s.io", Version: "v1"}
+	batchV1        = schema.GroupVersion{Group: "batch", Version: "v1"}
+	rbacV1         = schema.GroupVersion{Group: "rbac.authorization.k8s.io", Version: "v1"}
+	policyV1       = schema.GroupVersion{Group: "policy", Version: "v1"}
+	policyV1beta1  = schema.GroupVersion{Group: "policy", Version: "v1beta1"}
+	storageV1      = schema.GroupVersion{Group: "storage.k8s.io", Version: "v1"}
+	schedulingV1   = schema.GroupVersion{Group: "scheduling.k8s.io", Version: "v1"}
+	coordinationV1 = schema.GroupVersion{Group: "coordination.k8s.io", Version: "v1"}
+
+	// deprecatedAPIs are the removed or deprecated versions with known migrations
+	deprecatedAPIs = map[schema.GroupVersionKind]apiMigration{
+		{Group: "extensions", Version: "v1beta1", Kind: "Deployment"}: {appsV1, migrateWorkload},
+		{Group: "apps", Version: "v1beta1", Kind: "Deployment"}:       {appsV1, migrateWorkload},
+		{Group: "apps", Version: "v1beta2", Kind: "Deployment"}:       {appsV1, migrateWorkload},
+		{Group: "extensions", Version: "v1beta1", Kind: "DaemonSet"}:  {appsV1, migrateWorkload},
+		{Group: "apps", Version: "v1beta2", Kind: "DaemonSet"}:        {appsV1, migrateWorkload},
+		{Group: "extensions", Version: "v1beta1", Kind: "ReplicaSet"}: {appsV1, migrateWorkload},
+		{Group: "apps", Version: "v1beta2", Kind: "ReplicaSet"}:       {appsV1, migrateWorkload},
+		{Group: "apps", Version: "v1beta1", Kind: "StatefulSet"}:      {appsV1, migrateWorkload},
+		{Group: "apps", Version: "v1beta2", Kind: "StatefulSet"}:      {appsV1, migrateWorkload},
+
+		{Group: "extensions", Version: "v1beta1", Kind: "Ingress"}:        {networkingV1, migrateIngress},
+		{Group: "networking.k8s.io", Version: "v1beta1", Kind: "Ingress"}: {networkingV1, migrateIngress},
+		{Group: "extensions", Version: "v1beta1", Kind: "NetworkPolicy"}:  {networkingV1, nil},
+
+		{Group: "batch", Version: "v1beta1", Kind: "CronJob"}:  {batchV1, nil},
+		{Group: "batch", Version: "v2alpha1", Kind: "CronJob"}: {batchV1, nil},
+
+		{Group: "extensions", Version: "v1beta1", Kind: "PodSecurityPolicy"}: {policyV1beta1, nil},
+		{Group: "policy", Version: "v1beta1", Kind: "PodDisruptionBudget"}:   {policyV1, nil},
+
+		{Group: "rbac.authorization.k8s.io", Version: "v1beta1", Kind: "Role"}:                {rbacV1, nil},
+		{Group: "rbac.authorization.k8s.io", Version: "v1beta1", Kind: "ClusterRole"}:         {rbacV1, nil},
+		{Group: "rbac.authorization.k8s.io", Version: "v1beta1", Kind: "RoleBinding"}:         {rbacV1, nil},
+		{Group: "rbac.authorization.k8s.io", Version: "v1beta1", Kind: "ClusterRoleBinding"}:  {rbacV1, nil},
+		{Group: "rbac.authorization.k8s.io", Version: "v1alpha1", Kind: "Role"}:               {rbacV1, nil},
+		{Group: "rbac.authorization.k8s.io", Version: "v1alpha1", Kind: "ClusterRole"}:        {rbacV1, nil},
+		{Group: "rbac.authorization.k8s.io", Version: "v1alpha1", Kind: "RoleBinding"}:        {rbacV1, nil},
+		{Group: "rbac.authorization.k8s.io", Version: "v1alpha1", Kind: "ClusterRoleBinding"}: {rbacV1, nil},
+
+		{Group: "storage.k8s.io", Version: "v1beta1", Kind: "StorageClass"}:     {storageV1, nil},
+		{Group: "scheduling.k8s.io", Version: "v1beta1", Kind: "PriorityClass"}: {schedulingV1, nil},
+		{Group: "coordination.k8s.io", Version: "v1beta1", Kind: "Lease"}:       {coordinationV1, nil},
+	}
+)
+
+// ConvertManifests converts all the deprecated apiVersions to the latest ones
+// without a cluster, the warnings describe the converted objects.
+func ConvertManifests(manifests []Manifest) ([]Manifest, []string, error) {
+	var warnings []string
+	converted, err := transformManifests(manifests, func(obj *unstructured.Unstructured) error {
+		w, err := convertObject(obj)
+		warnings = append(warnings, w...)
+		return err
+	})
+	return converted, warnings, err
+}
+
+// ConvertFile converts the deprecated apiVersions of the manifest file and writes
+// it to dst, dst may be the same file. The comments are not kept.
+func ConvertFile(src, dst string) ([]string, error) {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+	manifests, err := ReadBytes(src, data)
+	if err != nil {
+		return nil, err
+	}
+	converted, warnings, err := ConvertManifests(manifests)
+	if err != nil {
+		return nil, err
+	}
+	return warnings, ioutil.WriteFile(dst, joinManifests(converted), 0644)
+}
+
+// convertUnserved converts the manifests whose apiVersions are not served by the
+// cluster, the deprecated ones still served are kept with warnings. It fails if
+// the target version isn't served either.
+func convertUnserved(kubeClient *k8s.KubeClient, manifests []Manifest) ([]Manifest, [][]string, error) {
+	warnings := make([][]string, len(manifests))
+	result := make([]Manifest, 0, len(manifests))
+	for i, m := range manifests {
+		obj := &unstructured.Unstructured{}
+		if _, _, err := decUnstructured.Decode(m.Data, nil, obj); err != nil {
+			// Decode error is reported when applying
+			result = append(result, m)
+			continue
+		}
+		gvk := obj.GroupVersionKind()
+		migration, ok := deprecatedAPIs[gvk]
+		if !ok {
+			result = append(result, m)
+			continue
+		}
+
+		_, err := kubeClient.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err == nil {
+			warnings[i] = append(warnings[i], fmt.Sprintf("%s %s is deprecated, use %s",
+				gvk.GroupVersion(), gvk.Kind, migration.target))
+			result = append(result, m)
+			continue
+		}
+		if !meta.IsNoMatchError(err) {
+			return nil, nil, errors.Wrap(err, "Mapping kind with version failed")
+		}
+		// The target may be not served yet by the old clusters, or removed, e.g.
+		// PodSecurityPolicy
+		target := migration.target.WithKind(gvk.Kind)
+		if _, err = kubeClient.RESTMapping(target.GroupKind(), target.Version); err != nil {
+			if meta.IsNoMatchError(err) {
+				return nil, nil, errors.Errorf("%s: neither %s nor the converted %s of %s is served by the cluster",
+					m.Source, gvk.GroupVersion(), target.GroupVersion(), gvk.Kind)
+			}
+			return nil, nil, errors.Wrap(err, "Mapping kind with version failed")
+		}
+
+		w, err := convertObject(obj)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "%s", m.Source)
+		}
+		data, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "encode %s failed", obj.GetName())
+		}
+		warnings[i] = append(warnings[i], w...)
+		result = append(result, Manifest{Data: data, Source: m.Source})
+	}
+	return result, warnings, nil
+}
+
+// convertObject converts the object in place if its version is deprecated.
+func convertObject(obj *unstructured.Unstructured) ([]string, error) {
+	from := obj.GroupVersionKind()
+	migration, ok := deprecatedAPIs[from]
+	if !ok {
+		return nil, nil
+	}
+
+	warnings := []string{fmt.Sprintf("%s %s %s is converted to %s",
+		from.GroupVersion(), from.Kind, obj.GetName(), migration.target)}
+	obj.SetAPIVersion(migration.target.String())
+	if migration.migrate == nil {
+		return warnings, nil
+	}
+	w, err := migration.migrate(obj, from.GroupVersion())
+	if err != nil {
+		return nil, errors.Wrapf(err, "convert %s %s failed", from.Kind, obj.GetName())
+	}
+	return append(warnings, w...), nil
+}
+
+// migrateWorkload defaults the selector which is required by apps/v1, and drops
+// the fields removed in apps/v1.
+func migrateWorkload(obj *unstructured.Unstructured, from schema.GroupVersion) ([]string, error) {
+	var warnings []string
+	if _, ok, _ := unstructured.NestedFieldNoCopy(obj.Object, "spec", "selector"); !ok {
+		labels, _, err := unstructured.NestedStringMap(obj.Object, "spec", "template", "metadata", "labels")
+		if err != nil {
+			return nil, err
+		}
+		if len(labels) == 0 {
+			return nil, errors.New("spec.selector is required by apps/v1, but spec.template.metadata.labels is empty")
+		}
+		selector := make(map[string]interface{}, len(labels))
+		for k, v := range labels {
+			selector[k] = v
+		}
+		if err = unstructured.SetNestedMap(obj.Object, selector, "spec", "selector", "matchLabels"); err != nil {
+			return nil, err
+		}
+		warnings = append(warnings, "spec.selector.matchLabels is defaulted to spec.template.metadata.labels")
+	}
+
+	for _, field := range []string{"rollbackTo", "templateGeneration"} {
+		if _, ok, _ := unstructured.NestedFieldNoCopy(obj.Object, "spec", field); ok {
+			unstructured.RemoveNestedField(obj.Object, "spec", field)
+			warnings = append(warnings, fmt.Sprintf("spec.%s is removed in apps/v1", field))
+		}
+	}
+
+	// The default update strategy of extensions/v1beta1 DaemonSet is OnDelete
+	if from.Group == "extensions" && obj.GetKind() == "DaemonSet" {
+		if _, ok, _ := unstructured.NestedFieldNoCopy(obj.Object, "spec", "updateStrategy"); !ok {
+			if err := unstructured.SetNestedField(obj.Object, "OnDelete", "spec", "updateStrategy", "type"); err != nil {
+				return nil, err
+			}
+			warnings = append(warnings, "spec.updateStrategy.type is set to OnDelete, the default of extensions/v1beta1")
+		}
+	}
+	return warnings, nil
+}
+
+// migrateIngress converts the backends to networking.k8s.io/v1, e.g.
+// backend.serviceName to backend.service.name, and defaults the pathType.
+func migrateIngress(obj *unstructured.Unstructured, _ schema.GroupVersion) ([]string, error) {
+	var warnings []string
+	spec, ok, err := unstructured.NestedMap(obj.Object, "spec")
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	if backend, ok := spec["backend"].(map[string]interface{}); ok {
+		spec["defaultBackend"] = convertIngressBackend(backend)
+		delete(spec, "backend")
+		warnings = append(warnings, "spec.backend is moved to spec.defaultBackend")
+	}
+
+	rules, _ := spec["rules"].([]interface{})
+	for i, rule := range rules {
+		ruleMap, _ := rule.(map[string]interface{})
+		http, _ := ruleMap["http"].(map[string]interface{})
+		paths, _ := http["paths"].([]interface{})
+		for j, path := range paths {
+			pathMap, ok := path.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if backend, ok := pathMap["backend"].(map[string]interface{}); ok {
+				pathMap["backend"] = convertIngressBackend(backend)
+			}
+			if _, ok := pathMap["pathType"]; !ok {
+				pathMap["pathType"] = "ImplementationSpecific"
+				warnings = append(warnings, fmt.Sprintf("spec.rules[%d].http.paths[%d].pathType is defaulted to ImplementationSpecific", i, j))
+			}
+		}
+	}
+	if len(rules) != 0 {
+		warnings = append(warnings, "backend.serviceName and backend.servicePort are moved to backend.service")
+	}
+	return warnings, unstructured.SetNestedMap(obj.Object, spec, "spec")
+}
+
+func convertIngressBackend(backend map[string]interface{}) map[string]interface{} {
+	serviceName, ok := backend["serviceName"]
+	if !ok {
+		// Resource backend is not changed
+		return backend
+	}
+
+	port := map[string]interface{}{}
+	switch servicePort := backend["servicePort"].(type) {
+	case int64:
+		port["number"] = servicePort
+	case float64:
+		port["number"] = int64(servicePort)
+	case string:
+		// servicePort is IntOrString, the quoted numbers like "80" are numbers
+		if number, err := strconv.Atoi(servicePort); err == nil {
+			port["number"] = int64(number)
+		} else {
+			port["name"] = servicePort
+		}
+	}
+	converted := map[string]interface{}{
+		"service": map[string]interface{}{
+			"name": serviceName,
+			"port": port,
+		},
+	}
+	for k, v := range backend {
+		if k != "serviceName" && k != "servicePort" {
+			converted[k] = v
+		}
+	}
+	return converted
+}
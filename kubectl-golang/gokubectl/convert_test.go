@@ -0,0 +1,150 @@
+package gokubectl
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestConvertIngressBackend(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend map[string]interface{}
+		want    map[string]interface{}
+	}{
+		{
+			name:    "int port",
+			backend: map[string]interface{}{"serviceName": "web", "servicePort": int64(80)},
+			want:    map[string]interface{}{"service": map[string]interface{}{"name": "web", "port": map[string]interface{}{"number": int64(80)}}},
+		},
+		{
+			name:    "float port",
+			backend: map[string]interface{}{"serviceName": "web", "servicePort": float64(8080)},
+			want:    map[string]interface{}{"service": map[string]interface{}{"name": "web", "port": map[string]interface{}{"number": int64(8080)}}},
+		},
+		{
+			name:    "numeric string port",
+			backend: map[string]interface{}{"serviceName": "web", "servicePort": "80"},
+			want:    map[string]interface{}{"service": map[string]interface{}{"name": "web", "port": map[string]interface{}{"number": int64(80)}}},
+		},
+		{
+			name:    "named port",
+			backend: map[string]interface{}{"serviceName": "web", "servicePort": "http"},
+			want:    map[string]interface{}{"service": map[string]interface{}{"name": "web", "port": map[string]interface{}{"name": "http"}}},
+		},
+		{
+			name:    "resource backend",
+			backend: map[string]interface{}{"resource": map[string]interface{}{"kind": "StorageBucket", "name": "static"}},
+			want:    map[string]interface{}{"resource": map[string]interface{}{"kind": "StorageBucket", "name": "static"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertIngressBackend(tt.backend); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("convertIngressBackend() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+const deprecatedIngress = `
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: web
+spec:
+  backend:
+    serviceName: default
+    servicePort: "8080"
+  rules:
+  - host: web.example.com
+    http:
+      paths:
+      - path: /
+        backend:
+          serviceName: web
+          servicePort: http
+`
+
+func TestConvertManifestsIngress(t *testing.T) {
+	converted, warnings, err := ConvertManifests([]Manifest{{Data: []byte(deprecatedIngress)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) == 0 {
+		t.Error("ConvertManifests() returns no warnings")
+	}
+	obj := decodeTestObject(t, string(converted[0].Data))
+	if obj.GetAPIVersion() != "networking.k8s.io/v1" {
+		t.Errorf("apiVersion = %q, want networking.k8s.io/v1", obj.GetAPIVersion())
+	}
+	if port, _, _ := unstructured.NestedFloat64(obj.Object, "spec", "defaultBackend", "service", "port", "number"); port != 8080 {
+		t.Errorf("spec.defaultBackend.service.port.number = %v, want 8080", port)
+	}
+	paths, _, _ := unstructured.NestedSlice(obj.Object, "spec", "rules")
+	path := paths[0].(map[string]interface{})["http"].(map[string]interface{})["paths"].([]interface{})[0].(map[string]interface{})
+	if path["pathType"] != "ImplementationSpecific" {
+		t.Errorf("pathType = %v, want ImplementationSpecific", path["pathType"])
+	}
+	if name, _, _ := unstructured.NestedString(path, "backend", "service", "port", "name"); name != "http" {
+		t.Errorf("backend.service.port.name = %q, want http", name)
+	}
+}
+
+func TestConvertObjectWorkload(t *testing.T) {
+	obj := decodeTestObject(t, `
+apiVersion: extensions/v1beta1
+kind: DaemonSet
+metadata:
+  name: agent
+spec:
+  templateGeneration: 2
+  template:
+    metadata:
+      labels: {app: agent}
+`)
+	if _, err := convertObject(obj); err != nil {
+		t.Fatal(err)
+	}
+	if obj.GetAPIVersion() != "apps/v1" {
+		t.Errorf("apiVersion = %q, want apps/v1", obj.GetAPIVersion())
+	}
+	selector, _, _ := unstructured.NestedStringMap(obj.Object, "spec", "selector", "matchLabels")
+	if !reflect.DeepEqual(selector, map[string]string{"app": "agent"}) {
+		t.Errorf("spec.selector.matchLabels = %v, want the template labels", selector)
+	}
+	if _, ok, _ := unstructured.NestedFieldNoCopy(obj.Object, "spec", "templateGeneration"); ok {
+		t.Error("spec.templateGeneration is not removed")
+	}
+	if strategy, _, _ := unstructured.NestedString(obj.Object, "spec", "updateStrategy", "type"); strategy != "OnDelete" {
+		t.Errorf("spec.updateStrategy.type = %q, want OnDelete", strategy)
+	}
+}
+
+func TestConvertObjectWithoutLabels(t *testing.T) {
+	obj := decodeTestObject(t, `
+apiVersion: apps/v1beta2
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template: {}
+`)
+	if _, err := convertObject(obj); err == nil {
+		t.Error("convertObject() succeeds without the selector and labels")
+	}
+}
+
+func TestConvertObjectLatest(t *testing.T) {
+	obj := decodeTestObject(t, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+`)
+	warnings, err := convertObject(obj)
+	if err != nil || len(warnings) != 0 {
+		t.Errorf("convertObject() = %v, %v, want no warnings", warnings, err)
+	}
+}
@@ -0,0 +1,138 @@
+package gokubectl
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	defaultDeleteTimeout      = 60 * time.Second
+	defaultDeletePollInterval = time.Second
+)
+
+type DeleteOptions struct {
+	NamespaceOptions
+	DiscoveryOptions
+
+	// PropagationPolicy decides how the dependents are garbage collected,
+	// default is metav1.DeletePropagationBackground.
+	PropagationPolicy metav1.DeletionPropagation
+	// GracePeriodSeconds overrides the grace period of the objects,
+	// nil means the default of the kind.
+	GracePeriodSeconds *int64
+	// IgnoreNotFound treats the objects which are already gone as deleted.
+	IgnoreNotFound bool
+
+	// Wait blocks until the objects are removed from the cluster. Use it with
+	// metav1.DeletePropagationForeground to wait for the dependents too.
+	Wait bool
+	// Timeout is the max duration of waiting, default 60s.
+	Timeout time.Duration
+}
+
+type deletedObject struct {
+	obj *unstructured.Unstructured
+	// uid is the UID of the deleted object, the manifest has no UID
+	uid    types.UID
+	dr     dynamic.ResourceInterface
+	source Location
+	// index is the position of the result
+	index int
+}
+
+func (opts *DeleteOptions) complete() {
+	if opts.PropagationPolicy == "" {
+		opts.PropagationPolicy = metav1.DeletePropagationBackground
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultDeleteTimeout
+	}
+}
+
+// toDeleteOptions returns the options to delete the object of uid, any object if
+// the uid is empty.
+func (opts *DeleteOptions) toDeleteOptions(uid types.UID) metav1.DeleteOptions {
+	propagationPolicy := opts.PropagationPolicy
+	deleteOpts := metav1.DeleteOptions{
+		PropagationPolicy:  &propagationPolicy,
+		GracePeriodSeconds: opts.GracePeriodSeconds,
+	}
+	if uid != "" {
+		deleteOpts.Preconditions = &metav1.Preconditions{UID: &uid}
+	}
+	return deleteOpts
+}
+
+// waitDeleted polls the deleted objects until all of them are gone or timeout,
+// the results are set at the indexes of the objects. Objects still alive after
+// timeout are reported with their pending finalizers.
+func waitDeleted(ctx context.Context, deleted []deletedObject, result []ApplyResult, opts DeleteOptions) {
+	if len(deleted) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(defaultDeletePollInterval)
+	defer ticker.Stop()
+
+	pending := deleted
+	for {
+		var remain []deletedObject
+		for _, item := range pending {
+			r := newApplyResult(item.obj, item.source)
+			live, err := item.dr.Get(ctx, item.obj.GetName(), metav1.GetOptions{})
+			switch {
+			case err == nil:
+				// The name may be reused by a new object after deleted
+				if live.GetUID() != item.uid {
+					r.Message = item.obj.GetName() + " deleted."
+					result[item.index] = r
+					continue
+				}
+				item.obj = live
+				remain = append(remain, item)
+			case k8sErrors.IsNotFound(err):
+				r.Message = item.obj.GetName() + " deleted."
+				result[item.index] = r
+			case ctx.Err() != nil:
+				remain = append(remain, item)
+			default:
+				r.Err = err
+				result[item.index] = r
+			}
+		}
+		pending = remain
+		if len(pending) == 0 {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			for _, item := range pending {
+				r := newApplyResult(item.obj, item.source)
+				r.Err = errors.New(deleteTimeoutMessage(item.obj))
+				result[item.index] = r
+			}
+			return
+		}
+	}
+}
+
+func deleteTimeoutMessage(obj *unstructured.Unstructured) string {
+	if obj.GetDeletionTimestamp() != nil && len(obj.GetFinalizers()) != 0 {
+		return fmt.Sprintf("%s is stuck on finalizers %v since %s.", obj.GetName(), obj.GetFinalizers(),
+			obj.GetDeletionTimestamp().Format(time.RFC3339))
+	}
+	return obj.GetName() + " deletion timed out."
+}
@@ -0,0 +1,78 @@
+package gokubectl
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// liveResource returns the live object of Get, not found if it's nil
+type liveResource struct {
+	dynamic.ResourceInterface
+	live *unstructured.Unstructured
+}
+
+func (r *liveResource) Get(ctx context.Context, name string, options metav1.GetOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	if r.live == nil {
+		return nil, k8sErrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, name)
+	}
+	return r.live.DeepCopy(), nil
+}
+
+func testConfigMap(uid types.UID) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("ConfigMap")
+	obj.SetNamespace("default")
+	obj.SetName("web")
+	obj.SetUID(uid)
+	return obj
+}
+
+func TestWaitDeleted(t *testing.T) {
+	tests := []struct {
+		name    string
+		live    *unstructured.Unstructured
+		wantErr bool
+	}{
+		{"gone", nil, false},
+		// The manifest has no UID, the recorded one tells the new object from the deleted one
+		{"name reused", testConfigMap("new"), false},
+		{"still alive", testConfigMap("old"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deleted := []deletedObject{{obj: testConfigMap(""), uid: "old", dr: &liveResource{live: tt.live}}}
+			result := make([]ApplyResult, 1)
+			waitDeleted(context.Background(), deleted, result, DeleteOptions{Timeout: 10 * time.Millisecond})
+			if (result[0].Err != nil) != tt.wantErr {
+				t.Errorf("waitDeleted() err = %v, wantErr %v", result[0].Err, tt.wantErr)
+			}
+			if !tt.wantErr && result[0].Message != "web deleted." {
+				t.Errorf("waitDeleted() message = %q, want %q", result[0].Message, "web deleted.")
+			}
+		})
+	}
+}
+
+func TestToDeleteOptions(t *testing.T) {
+	opts := DeleteOptions{}
+	opts.complete()
+	if got := opts.toDeleteOptions(""); got.Preconditions != nil {
+		t.Errorf("toDeleteOptions(\"\").Preconditions = %v, want nil", got.Preconditions)
+	}
+	got := opts.toDeleteOptions("old")
+	if got.Preconditions == nil || got.Preconditions.UID == nil || *got.Preconditions.UID != "old" {
+		t.Errorf("toDeleteOptions(%q).Preconditions = %v, want the UID", "old", got.Preconditions)
+	}
+	if got.PropagationPolicy == nil || *got.PropagationPolicy != metav1.DeletePropagationBackground {
+		t.Errorf("toDeleteOptions().PropagationPolicy = %v, want Background", got.PropagationPolicy)
+	}
+}
@@ -0,0 +1,321 @@
+package gokubectl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/k8s"
+)
+
+const (
+	diffContextLines = 3
+)
+
+type DiffResult struct {
+	Kind      string
+	Namespace string
+	Name      string
+
+	// Diff is the unified diff between the live and the applied object,
+	// empty if nothing will be changed.
+	Diff   string
+	Err    error
+	Source Location
+}
+
+// Changed returns true if the object will be created or changed by apply.
+func (r DiffResult) Changed() bool {
+	return r.Diff != ""
+}
+
+// Diff shows the changes that Apply would make, the objects are applied
+// by dry-run, nothing is persisted.
+func Diff(ctx context.Context, base64KubeConfig string, data []byte, opts ApplyOptions) ([]DiffResult, error) {
+	manifests, err := ReadBytes("", data)
+	if err != nil {
+		return nil, err
+	}
+	return DiffManifests(ctx, base64KubeConfig, manifests, opts)
+}
+
+// DiffManifests is Diff of the manifests read from the sources, see ReadPaths.
+func DiffManifests(ctx context.Context, base64KubeConfig string, manifests []Manifest, opts ApplyOptions) ([]DiffResult, error) {
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  base64KubeConfig,
+		DiscoveryCacheDir: opts.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.DiscoveryCacheTTL,
+	}
+	return diffManifests(ctx, kubeClient, manifests, opts)
+}
+
+func diffManifests(ctx context.Context, kubeClient *k8s.KubeClient, manifests []Manifest, opts ApplyOptions) (result []DiffResult, err error) {
+	opts.complete()
+	if manifests, err = decryptManifests(manifests, opts.AgeIdentities); err != nil {
+		return nil, err
+	}
+	if opts.Render != nil {
+		if manifests, err = renderManifests(kubeClient, manifests, *opts.Render); err != nil {
+			return nil, errors.Wrap(err, "render manifest failed")
+		}
+	}
+	capabilities, err := kubeClient.GetCapabilities()
+	if err != nil {
+		return nil, err
+	}
+	if !capabilities.DryRun {
+		return nil, errors.Errorf("server %s doesn't support dry-run", capabilities.GitVersion)
+	}
+	clientSide := !capabilities.ServerSideApply
+	if opts.ConvertDeprecated {
+		if manifests, _, err = convertUnserved(kubeClient, manifests); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, m := range manifests {
+		obj, dr, err := buildDynamicResourceClient(kubeClient, m.Data, opts.NamespaceOptions)
+		r := DiffResult{
+			Kind:      obj.GetKind(),
+			Namespace: obj.GetNamespace(),
+			Name:      obj.GetName(),
+			Source:    m.Source,
+		}
+		if err != nil {
+			r.Err = err
+			result = append(result, r)
+			continue
+		}
+
+		live, err := dr.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		if err != nil {
+			if !k8sErrors.IsNotFound(err) {
+				r.Err = err
+				result = append(result, r)
+				continue
+			}
+			live = nil
+		}
+
+		var merged *unstructured.Unstructured
+		if clientSide {
+			low := &lowVersion{
+				ctx:        ctx,
+				kubeClient: kubeClient,
+				nsOpts:     opts.NamespaceOptions,
+				dryRun:     true,
+			}
+			merged, err = low.apply(m.Data)
+		} else {
+			merged, err = dr.Patch(ctx, obj.GetName(), types.ApplyPatchType, m.Data, metav1.PatchOptions{
+				FieldManager: opts.FieldManager,
+				Force:        &opts.Force,
+				DryRun:       []string{metav1.DryRunAll},
+			})
+			err = newConflictError(obj, err)
+		}
+		if err != nil {
+			r.Err = err
+			if values := secretValues(obj); len(values) != 0 {
+				r.Err = &redactedError{err: err, msg: redactText(err.Error(), values)}
+			}
+			result = append(result, r)
+			continue
+		}
+
+		r.Diff, r.Err = diffObjects(live, merged)
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+// diffObjects returns the unified diff of the objects in YAML, nil object is empty.
+// The values of Secrets are redacted.
+func diffObjects(live, merged *unstructured.Unstructured) (string, error) {
+	if live != nil {
+		live = live.DeepCopy()
+	}
+	if merged != nil {
+		merged = merged.DeepCopy()
+	}
+	redactSecretDiff(live, merged)
+
+	from, err := diffYAML(live)
+	if err != nil {
+		return "", err
+	}
+	to, err := diffYAML(merged)
+	if err != nil {
+		return "", err
+	}
+
+	obj := merged
+	if obj == nil {
+		obj = live
+	}
+	name := strings.ToLower(obj.GetKind()) + "/" + obj.GetName()
+	if obj.GetNamespace() != "" {
+		name = obj.GetNamespace() + "/" + name
+	}
+	return unifiedDiff(from, to, "live/"+name, "merged/"+name), nil
+}
+
+// diffYAML encodes the object without the fields changed by every request.
+func diffYAML(obj *unstructured.Unstructured) (string, error) {
+	if obj == nil {
+		return "", nil
+	}
+	unstructured.RemoveNestedField(obj.Object, "metadata", "managedFields")
+	unstructured.RemoveNestedField(obj.Object, "metadata", "resourceVersion")
+	unstructured.RemoveNestedField(obj.Object, "metadata", "generation")
+	out, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return "", errors.Wrapf(err, "encode %s failed", obj.GetName())
+	}
+	return string(out), nil
+}
+
+type diffLine struct {
+	// op is ' ', '-' or '+'
+	op   byte
+	text string
+}
+
+// unifiedDiff returns the diff of the texts line by line like `diff -u`,
+// empty if they are the same.
+func unifiedDiff(from, to, fromName, toName string) string {
+	if from == to {
+		return ""
+	}
+	lines := diffLines(splitLines(from), splitLines(to))
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", fromName, toName)
+	for i := 0; i < len(lines); {
+		for i < len(lines) && lines[i].op == ' ' {
+			i++
+		}
+		if i == len(lines) {
+			break
+		}
+
+		// Merge the changes which are close enough into one hunk
+		start, end := i-diffContextLines, i
+		if start < 0 {
+			start = 0
+		}
+		for {
+			for end < len(lines) && lines[end].op != ' ' {
+				end++
+			}
+			next := end
+			for next < len(lines) && lines[next].op == ' ' && next-end < 2*diffContextLines {
+				next++
+			}
+			if next == len(lines) || lines[next].op == ' ' {
+				break
+			}
+			end = next
+		}
+		end += diffContextLines
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		writeHunk(&buf, lines, start, end)
+		i = end
+	}
+	return buf.String()
+}
+
+func writeHunk(buf *strings.Builder, lines []diffLine, start, end int) {
+	var fromLine, toLine, fromCount, toCount int
+	for _, line := range lines[:start] {
+		if line.op != '+' {
+			fromLine++
+		}
+		if line.op != '-' {
+			toLine++
+		}
+	}
+	for _, line := range lines[start:end] {
+		if line.op != '+' {
+			fromCount++
+		}
+		if line.op != '-' {
+			toCount++
+		}
+	}
+	// The start line is the one before the hunk if the hunk is empty
+	if fromCount != 0 {
+		fromLine++
+	}
+	if toCount != 0 {
+		toLine++
+	}
+
+	fmt.Fprintf(buf, "@@ -%d,%d +%d,%d @@\n", fromLine, fromCount, toLine, toCount)
+	for _, line := range lines[start:end] {
+		buf.WriteByte(line.op)
+		buf.WriteString(line.text)
+		buf.WriteByte('\n')
+	}
+}
+
+// diffLines returns the edit script by the longest common subsequence.
+func diffLines(from, to []string) []diffLine {
+	// lcs[i][j] is the LCS length of from[i:] and to[j:]
+	lcs := make([][]int, len(from)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(from) && j < len(to) {
+		switch {
+		case from[i] == to[j]:
+			lines = append(lines, diffLine{op: ' ', text: from[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, diffLine{op: '-', text: from[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{op: '+', text: to[j]})
+			j++
+		}
+	}
+	for ; i < len(from); i++ {
+		lines = append(lines, diffLine{op: '-', text: from[i]})
+	}
+	for ; j < len(to); j++ {
+		lines = append(lines, diffLine{op: '+', text: to[j]})
+	}
+	return lines
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,604 @@
+package gokubectl
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/k8s"
+)
+
+type ExportLayout string
+
+const (
+	// ExportPerKind writes the objects of every kind into one file, it's the default.
+	ExportPerKind ExportLayout = "kind"
+	// ExportPerObject writes every object into a file in the dir of its kind.
+	ExportPerObject ExportLayout = "object"
+)
+
+type ExportOptions struct {
+	DiscoveryOptions
+
+	// Namespace to export, default "default"
+	Namespace string
+	// ClusterScoped exports the cluster-scoped objects as well, e.g. ClusterRoles
+	// and PersistentVolumes. The Namespace object is always exported.
+	ClusterScoped bool
+	// LabelSelector filters the objects when listing
+	LabelSelector string
+	// Kinds limits the exported kinds if not empty, e.g. Deployment
+	Kinds []string
+	// KeepNodePorts keeps the node ports of the Services, they're removed by
+	// default since applying them again fails if the ports are still allocated.
+	KeepNodePorts bool
+}
+
+var (
+	// exportSkipped are the kinds created and updated by the cluster itself.
+	exportSkipped = map[schema.GroupKind]bool{
+		{Kind: "Event"}:                                                   true,
+		{Group: "events.k8s.io", Kind: "Event"}:                           true,
+		{Kind: "Endpoints"}:                                               true,
+		{Group: "discovery.k8s.io", Kind: "EndpointSlice"}:                true,
+		{Group: "coordination.k8s.io", Kind: "Lease"}:                     true,
+		{Kind: "Node"}:                                                    true,
+		{Group: "storage.k8s.io", Kind: "CSINode"}:                        true,
+		{Group: "storage.k8s.io", Kind: "VolumeAttachment"}:               true,
+		{Group: "certificates.k8s.io", Kind: "CertificateSigningRequest"}: true,
+	}
+
+	// exportMetadata are the metadata fields set by the server
+	exportMetadata = []string{
+		"uid", "resourceVersion", "selfLink", "creationTimestamp", "generation", "managedFields",
+		"deletionTimestamp", "deletionGracePeriodSeconds", "ownerReferences",
+	}
+	// exportAnnotations are the annotations set by the server and kubectl
+	exportAnnotations = []string{
+		lastAppliedConfigAnnotation,
+		"deployment.kubernetes.io/revision",
+		"pv.kubernetes.io/bind-completed",
+		"pv.kubernetes.io/bound-by-controller",
+		"pv.kubernetes.io/provisioned-by",
+		"volume.beta.kubernetes.io/storage-provisioner",
+		"volume.kubernetes.io/storage-provisioner",
+		"control-plane.alpha.kubernetes.io/leader",
+	}
+
+	// exportDefaults are the fields defaulted by the server, they are removed if
+	// the values are the defaults. "[]" matches every item of the list.
+	exportDefaults = map[string][]fieldDefault{
+		"Deployment": {
+			{"spec.progressDeadlineSeconds", int64(600)},
+			{"spec.revisionHistoryLimit", int64(10)},
+			{"spec.strategy", map[string]interface{}{
+				"type":          "RollingUpdate",
+				"rollingUpdate": map[string]interface{}{"maxSurge": "25%", "maxUnavailable": "25%"},
+			}},
+		},
+		"StatefulSet": {
+			{"spec.podManagementPolicy", "OrderedReady"},
+			{"spec.revisionHistoryLimit", int64(10)},
+			{"spec.updateStrategy", map[string]interface{}{
+				"type":          "RollingUpdate",
+				"rollingUpdate": map[string]interface{}{"partition": int64(0)},
+			}},
+		},
+		"DaemonSet": {
+			{"spec.revisionHistoryLimit", int64(10)},
+			{"spec.updateStrategy", map[string]interface{}{
+				"type":          "RollingUpdate",
+				"rollingUpdate": map[string]interface{}{"maxUnavailable": int64(1)},
+			}},
+			{"spec.updateStrategy", map[string]interface{}{
+				"type":          "RollingUpdate",
+				"rollingUpdate": map[string]interface{}{"maxUnavailable": int64(1), "maxSurge": int64(0)},
+			}},
+		},
+		"Job": {
+			{"spec.backoffLimit", int64(6)},
+			{"spec.completions", int64(1)},
+			{"spec.parallelism", int64(1)},
+		},
+		"CronJob": {
+			{"spec.concurrencyPolicy", "Allow"},
+			{"spec.failedJobsHistoryLimit", int64(1)},
+			{"spec.successfulJobsHistoryLimit", int64(3)},
+			{"spec.suspend", false},
+		},
+		"Service": {
+			{"spec.sessionAffinity", "None"},
+			{"spec.type", "ClusterIP"},
+			{"spec.ports[].protocol", "TCP"},
+			{"spec.ipFamilyPolicy", "SingleStack"},
+		},
+		"PersistentVolumeClaim": {
+			{"spec.volumeMode", "Filesystem"},
+		},
+		"PersistentVolume": {
+			{"spec.volumeMode", "Filesystem"},
+		},
+	}
+	// podDefaults are the defaults in the pod spec, see exportDefaults.
+	podDefaults = []fieldDefault{
+		{"dnsPolicy", "ClusterFirst"},
+		{"restartPolicy", "Always"},
+		{"schedulerName", "default-scheduler"},
+		{"securityContext", map[string]interface{}{}},
+		{"terminationGracePeriodSeconds", int64(30)},
+		{"enableServiceLinks", true},
+	}
+	// containerDefaults are the defaults in every container, see exportDefaults.
+	containerDefaults = []fieldDefault{
+		{"terminationMessagePath", "/dev/termination-log"},
+		{"terminationMessagePolicy", "File"},
+		{"resources", map[string]interface{}{}},
+		{"ports[].protocol", "TCP"},
+		{"env[].valueFrom.fieldRef.apiVersion", "v1"},
+	}
+	// probeDefaults are the defaults in every probe of the containers.
+	probeDefaults = []fieldDefault{
+		{"timeoutSeconds", int64(1)},
+		{"periodSeconds", int64(10)},
+		{"successThreshold", int64(1)},
+		{"failureThreshold", int64(3)},
+	}
+)
+
+type fieldDefault struct {
+	path  string
+	value interface{}
+}
+
+// Export lists the objects in the namespace by discovery and cleans them to be
+// applied again, see ExportObject. The objects controlled by others, e.g. the
+// Pods of ReplicaSets, and the ones created by the cluster are skipped. The
+// kinds failed to list are skipped and returned as warnings.
+func Export(ctx context.Context, base64KubeConfig string, opts ExportOptions) ([]unstructured.Unstructured, []string, error) {
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  base64KubeConfig,
+		DiscoveryCacheDir: opts.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.DiscoveryCacheTTL,
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = defaultNamespace
+	}
+	result, _, warnings, err := export(ctx, kubeClient, opts)
+	return result, warnings, err
+}
+
+// export returns the exported objects, and the skipped ones with the reasons.
+func export(ctx context.Context, kubeClient *k8s.KubeClient, opts ExportOptions) (result []unstructured.Unstructured, skipped []SkippedObject, warnings []string, err error) {
+	resources, skipped, err := exportResources(kubeClient, opts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	dynamicClient, err := kubeClient.GetDynamicClient()
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "Prepare dynamic client failed.")
+	}
+
+	for _, r := range resources {
+		gvr := r.gv.WithResource(r.Name)
+		if r.Kind == "Namespace" {
+			ns, err := dynamicClient.Resource(gvr).Get(ctx, opts.Namespace, metav1.GetOptions{})
+			if err != nil {
+				return nil, nil, nil, errors.Wrapf(err, "get namespace %s failed", opts.Namespace)
+			}
+			result = append(result, *exportObject(ns, opts.KeepNodePorts))
+			continue
+		}
+
+		listOpts := metav1.ListOptions{LabelSelector: opts.LabelSelector}
+		var list *unstructured.UnstructuredList
+		if r.Namespaced {
+			list, err = dynamicClient.Resource(gvr).Namespace(opts.Namespace).List(ctx, listOpts)
+		} else {
+			list, err = dynamicClient.Resource(gvr).List(ctx, listOpts)
+		}
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("skip %s: %v", gvr.GroupResource(), err))
+			continue
+		}
+		items := list.Items
+		sort.Slice(items, func(i, j int) bool { return items[i].GetName() < items[j].GetName() })
+		for i := range items {
+			obj := &items[i]
+			// List doesn't set apiVersion and kind of the items for some servers
+			obj.SetAPIVersion(r.gv.String())
+			obj.SetKind(r.Kind)
+			if reason := exportSkipReason(obj); reason != "" {
+				skipped = append(skipped, SkippedObject{
+					Kind:      obj.GetKind(),
+					Namespace: obj.GetNamespace(),
+					Name:      obj.GetName(),
+					Reason:    reason,
+				})
+				continue
+			}
+			result = append(result, *exportObject(obj, opts.KeepNodePorts))
+		}
+	}
+	return result, skipped, warnings, nil
+}
+
+// exportResource is a listable resource found by discovery
+type exportResource struct {
+	metav1.APIResource
+	gv schema.GroupVersion
+}
+
+// exportResources returns the preferred version of every kind to export in the
+// install order, see installOrder. The kinds generated by the cluster are skipped.
+func exportResources(kubeClient *k8s.KubeClient, opts ExportOptions) (_ []exportResource, skipped []SkippedObject, err error) {
+	dc, err := kubeClient.GetDiscoveryClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	// The partial result is used if some groups are unavailable
+	lists, err := dc.ServerPreferredResources()
+	if err != nil && len(lists) == 0 {
+		return nil, nil, errors.Wrap(err, "discover resources failed")
+	}
+
+	kinds := map[string]bool{}
+	for _, kind := range opts.Kinds {
+		kinds[kind] = true
+	}
+	var (
+		result       []exportResource
+		served       = map[string]bool{}
+		skippedKinds = map[string]bool{}
+	)
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, r := range list.APIResources {
+			switch {
+			case strings.Contains(r.Name, "/"):
+				// Subresources
+				continue
+			case len(kinds) != 0 && !kinds[r.Kind]:
+				continue
+			case !r.Namespaced && !opts.ClusterScoped && r.Kind != "Namespace":
+				continue
+			case exportSkipped[gv.WithKind(r.Kind).GroupKind()]:
+				if !skippedKinds[r.Kind] {
+					skipped = append(skipped, SkippedObject{Kind: r.Kind, Reason: "generated by the cluster"})
+					skippedKinds[r.Kind] = true
+				}
+				continue
+			case !hasVerbs(r.Verbs, "list", "create"):
+				continue
+			}
+			result = append(result, exportResource{APIResource: r, gv: gv})
+			if gv.Group != "extensions" {
+				served[r.Kind] = true
+			}
+		}
+	}
+
+	// The kinds in extensions are served by the other groups as well
+	filtered := result[:0]
+	for _, r := range result {
+		if r.gv.Group != "extensions" || !served[r.Kind] {
+			filtered = append(filtered, r)
+		}
+	}
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return installRank(filtered[i].Kind) < installRank(filtered[j].Kind)
+	})
+	return filtered, skipped, nil
+}
+
+func hasVerbs(verbs metav1.Verbs, required ...string) bool {
+	for _, verb := range required {
+		found := false
+		for _, v := range verbs {
+			if v == verb {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// SkippedObject is an object not exported, Name is empty if the whole kind
+// is skipped.
+type SkippedObject struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Reason    string
+}
+
+func (s SkippedObject) String() string {
+	name := s.Kind
+	if s.Name != "" {
+		name += " " + s.Name
+	}
+	return name + ": " + s.Reason
+}
+
+// exportSkipReason returns why the object is skipped, empty if it's exported.
+// The objects created by the cluster or controlled by other objects are skipped.
+func exportSkipReason(obj *unstructured.Unstructured) string {
+	if owner := metav1.GetControllerOf(obj); owner != nil {
+		return fmt.Sprintf("controlled by %s %s", owner.Kind, owner.Name)
+	}
+	if strings.HasPrefix(obj.GetName(), "system:") || obj.GetLabels()["kubernetes.io/bootstrapping"] == "rbac-defaults" {
+		return "system object"
+	}
+	switch obj.GetKind() {
+	case "Secret":
+		if secretType, _, _ := unstructured.NestedString(obj.Object, "type"); secretType == "kubernetes.io/service-account-token" {
+			return "service account token"
+		}
+	case "ServiceAccount":
+		if obj.GetName() == "default" {
+			return "created by the cluster"
+		}
+	case "ConfigMap":
+		if obj.GetName() == "kube-root-ca.crt" {
+			return "created by the cluster"
+		}
+	case "APIService":
+		// The local APIServices are registered by the apiserver
+		if _, found, _ := unstructured.NestedMap(obj.Object, "spec", "service"); !found {
+			return "local APIService"
+		}
+	case "PriorityClass":
+		if strings.HasPrefix(obj.GetName(), "system-") {
+			return "system object"
+		}
+	}
+	return ""
+}
+
+// ExportObject returns a copy of the object without status, the metadata set by
+// the server and the fields defaulted by the server, so that it can be applied again.
+// The node ports of Services are removed as well.
+func ExportObject(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	return exportObject(obj, false)
+}
+
+func exportObject(obj *unstructured.Unstructured, keepNodePorts bool) *unstructured.Unstructured {
+	obj = obj.DeepCopy()
+	delete(obj.Object, "status")
+	for _, field := range exportMetadata {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+	if annotations := obj.GetAnnotations(); annotations != nil {
+		for _, key := range exportAnnotations {
+			delete(annotations, key)
+		}
+		if len(annotations) == 0 {
+			unstructured.RemoveNestedField(obj.Object, "metadata", "annotations")
+		} else {
+			obj.SetAnnotations(annotations)
+		}
+	}
+
+	for _, d := range exportDefaults[obj.GetKind()] {
+		removeDefault(obj.Object, strings.Split(d.path, "."), d.value)
+	}
+	if path, ok := podSpecPaths[obj.GetKind()]; ok {
+		exportPodSpec(obj, path)
+	}
+
+	switch obj.GetKind() {
+	case "Pod":
+		unstructured.RemoveNestedField(obj.Object, "spec", "nodeName")
+	case "Service":
+		exportService(obj, keepNodePorts)
+	case "Job":
+		exportJob(obj)
+	case "ServiceAccount":
+		// The token Secrets are created by the cluster
+		delete(obj.Object, "secrets")
+	case "Namespace":
+		delete(obj.Object, "spec")
+	case "PersistentVolume":
+		for _, field := range []string{"uid", "resourceVersion"} {
+			unstructured.RemoveNestedField(obj.Object, "spec", "claimRef", field)
+		}
+	}
+	return obj
+}
+
+func exportPodSpec(obj *unstructured.Unstructured, path []string) {
+	if len(path) > 1 {
+		// The template of workloads
+		unstructured.RemoveNestedField(obj.Object, append(path[:len(path)-1:len(path)-1], "metadata", "creationTimestamp")...)
+	}
+	spec, found, _ := unstructured.NestedMap(obj.Object, path...)
+	if !found {
+		return
+	}
+	for _, d := range podDefaults {
+		removeDefault(spec, strings.Split(d.path, "."), d.value)
+	}
+	// serviceAccount is the deprecated alias of serviceAccountName
+	delete(spec, "serviceAccount")
+	for _, field := range []string{"initContainers", "containers"} {
+		containers, _ := spec[field].([]interface{})
+		for _, c := range containers {
+			container, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for _, d := range containerDefaults {
+				removeDefault(container, strings.Split(d.path, "."), d.value)
+			}
+			for _, probe := range []string{"livenessProbe", "readinessProbe", "startupProbe"} {
+				for _, d := range probeDefaults {
+					removeDefault(container, []string{probe, d.path}, d.value)
+				}
+			}
+			image, _ := container["image"].(string)
+			removeDefault(container, []string{"imagePullPolicy"}, defaultPullPolicy(image))
+		}
+	}
+	_ = unstructured.SetNestedMap(obj.Object, spec, path...)
+}
+
+// defaultPullPolicy is Always for the latest tag, IfNotPresent for the others.
+func defaultPullPolicy(image string) string {
+	if strings.Contains(image, "@") {
+		return "IfNotPresent"
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") && image[i+1:] != "latest" {
+		return "IfNotPresent"
+	}
+	return "Always"
+}
+
+func exportService(obj *unstructured.Unstructured, keepNodePorts bool) {
+	// The cluster IPs are allocated unless headless
+	if clusterIP, _, _ := unstructured.NestedString(obj.Object, "spec", "clusterIP"); clusterIP != "None" {
+		unstructured.RemoveNestedField(obj.Object, "spec", "clusterIP")
+		unstructured.RemoveNestedField(obj.Object, "spec", "clusterIPs")
+		unstructured.RemoveNestedField(obj.Object, "spec", "ipFamilies")
+	}
+	// The node ports are allocated as well, the same ports conflict with the
+	// source object when applied to the same cluster
+	if !keepNodePorts {
+		unstructured.RemoveNestedField(obj.Object, "spec", "healthCheckNodePort")
+	}
+	ports, _, _ := unstructured.NestedSlice(obj.Object, "spec", "ports")
+	for _, p := range ports {
+		port, _ := p.(map[string]interface{})
+		if reflect.DeepEqual(port["targetPort"], port["port"]) {
+			delete(port, "targetPort")
+		}
+		if !keepNodePorts {
+			delete(port, "nodePort")
+		}
+	}
+	if ports != nil {
+		_ = unstructured.SetNestedSlice(obj.Object, ports, "spec", "ports")
+	}
+}
+
+func exportJob(obj *unstructured.Unstructured) {
+	if manual, _, _ := unstructured.NestedBool(obj.Object, "spec", "manualSelector"); manual {
+		return
+	}
+	// The selector and labels are generated by the uid of the job
+	unstructured.RemoveNestedField(obj.Object, "spec", "selector")
+	labels, _, _ := unstructured.NestedStringMap(obj.Object, "spec", "template", "metadata", "labels")
+	for _, key := range []string{"controller-uid", "job-name", "batch.kubernetes.io/controller-uid", "batch.kubernetes.io/job-name"} {
+		delete(labels, key)
+	}
+	if len(labels) == 0 {
+		unstructured.RemoveNestedField(obj.Object, "spec", "template", "metadata", "labels")
+	} else {
+		_ = unstructured.SetNestedStringMap(obj.Object, labels, "spec", "template", "metadata", "labels")
+	}
+	if metadata, found, _ := unstructured.NestedMap(obj.Object, "spec", "template", "metadata"); found && len(metadata) == 0 {
+		unstructured.RemoveNestedField(obj.Object, "spec", "template", "metadata")
+	}
+}
+
+// removeDefault removes the field at the path if it equals the default value,
+// "[]" in the path matches every item of the list.
+func removeDefault(value interface{}, segments []string, defaultValue interface{}) {
+	m, ok := value.(map[string]interface{})
+	if !ok || len(segments) == 0 {
+		return
+	}
+	name := strings.TrimSuffix(segments[0], "[]")
+	child, ok := m[name]
+	if !ok {
+		return
+	}
+	switch {
+	case name != segments[0]:
+		items, _ := child.([]interface{})
+		for _, item := range items {
+			removeDefault(item, segments[1:], defaultValue)
+		}
+	case len(segments) == 1:
+		if reflect.DeepEqual(child, defaultValue) {
+			delete(m, name)
+		}
+	default:
+		removeDefault(child, segments[1:], defaultValue)
+	}
+}
+
+// WriteExport writes the objects into the dir as multi-document YAML files, the
+// names are prefixed by the install order, so that ReadDir reads them in order.
+// It returns the files written.
+func WriteExport(dir string, objects []unstructured.Unstructured, layout ExportLayout) ([]string, error) {
+	var (
+		files []string
+		docs  = map[string][][]byte{}
+	)
+	for i := range objects {
+		obj := &objects[i]
+		out, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return nil, errors.Wrapf(err, "encode %s failed", obj.GetName())
+		}
+		gvk := obj.GroupVersionKind()
+		name := fmt.Sprintf("%02d-%s", installRank(gvk.Kind), strings.ToLower(gvk.Kind))
+		if gvk.Group != "" {
+			name += "." + gvk.Group
+		}
+		switch layout {
+		case ExportPerKind, "":
+			name += ".yaml"
+		case ExportPerObject:
+			name = filepath.Join(name, obj.GetName()+".yaml")
+		default:
+			return nil, errors.Errorf("unknown export layout: %s", layout)
+		}
+		if _, ok := docs[name]; !ok {
+			files = append(files, name)
+		}
+		docs[name] = append(docs[name], out)
+	}
+
+	for i, name := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, errors.Wrap(err, "create export dir failed")
+		}
+		data := joinDocuments(docs[name])
+		// The Secrets are in plain text
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			return nil, errors.Wrapf(err, "write %s failed", path)
+		}
+		files[i] = path
+	}
+	return files, nil
+}
+
+func joinDocuments(docs [][]byte) []byte {
+	var buf strings.Builder
+	for i, doc := range docs {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(doc)
+	}
+	return []byte(buf.String())
+}
@@ -0,0 +1,407 @@
+package gokubectl
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// decodeTestLive decodes the object like the dynamic client, i.e. the numbers are int64
+func decodeTestLive(t *testing.T, data string) *unstructured.Unstructured {
+	t.Helper()
+	obj := &unstructured.Unstructured{}
+	if _, _, err := decUnstructured.Decode([]byte(data), nil, obj); err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func TestExportObject(t *testing.T) {
+	tests := []struct {
+		name string
+		live string
+		want string
+	}{
+		{
+			name: "Deployment",
+			live: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: shop
+  uid: 7a9b
+  resourceVersion: "42"
+  generation: 3
+  creationTimestamp: "2020-11-01T08:00:00Z"
+  managedFields: [{manager: gokubectl}]
+  annotations:
+    deployment.kubernetes.io/revision: "3"
+    kubectl.kubernetes.io/last-applied-configuration: "{}"
+  labels: {app: web}
+spec:
+  replicas: 2
+  progressDeadlineSeconds: 600
+  revisionHistoryLimit: 5
+  strategy:
+    type: RollingUpdate
+    rollingUpdate: {maxSurge: 25%, maxUnavailable: 25%}
+  selector:
+    matchLabels: {app: web}
+  template:
+    metadata:
+      creationTimestamp: null
+      labels: {app: web}
+    spec:
+      dnsPolicy: ClusterFirst
+      restartPolicy: Always
+      schedulerName: default-scheduler
+      securityContext: {}
+      serviceAccount: web
+      serviceAccountName: web
+      terminationGracePeriodSeconds: 60
+      containers:
+      - name: web
+        image: web:1.0
+        imagePullPolicy: IfNotPresent
+        terminationMessagePath: /dev/termination-log
+        terminationMessagePolicy: File
+        resources: {}
+        ports:
+        - {containerPort: 80, protocol: TCP}
+        - {containerPort: 53, protocol: UDP}
+        readinessProbe:
+          httpGet: {path: /, port: 80}
+          timeoutSeconds: 1
+          periodSeconds: 5
+          successThreshold: 1
+          failureThreshold: 3
+      - name: proxy
+        image: proxy
+        imagePullPolicy: Always
+status:
+  replicas: 2
+`,
+			want: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: shop
+  labels: {app: web}
+spec:
+  replicas: 2
+  revisionHistoryLimit: 5
+  selector:
+    matchLabels: {app: web}
+  template:
+    metadata:
+      labels: {app: web}
+    spec:
+      serviceAccountName: web
+      terminationGracePeriodSeconds: 60
+      containers:
+      - name: web
+        image: web:1.0
+        ports:
+        - {containerPort: 80}
+        - {containerPort: 53, protocol: UDP}
+        readinessProbe:
+          httpGet: {path: /, port: 80}
+          periodSeconds: 5
+      - name: proxy
+        image: proxy
+`,
+		},
+		{
+			name: "Service",
+			live: `
+apiVersion: v1
+kind: Service
+metadata: {name: web, annotations: {owner: ops}}
+spec:
+  type: NodePort
+  clusterIP: 10.0.0.10
+  clusterIPs: [10.0.0.10]
+  ipFamilies: [IPv4]
+  ipFamilyPolicy: SingleStack
+  sessionAffinity: None
+  ports:
+  - {port: 80, targetPort: 80, protocol: TCP, nodePort: 30080}
+  - {port: 443, targetPort: 8443, protocol: TCP, nodePort: 30443}
+`,
+			want: `
+apiVersion: v1
+kind: Service
+metadata: {name: web, annotations: {owner: ops}}
+spec:
+  type: NodePort
+  ports:
+  - {port: 80}
+  - {port: 443, targetPort: 8443}
+`,
+		},
+		{
+			name: "headless Service",
+			live: "apiVersion: v1\nkind: Service\nmetadata: {name: db}\nspec: {clusterIP: None, type: ClusterIP}\n",
+			want: "apiVersion: v1\nkind: Service\nmetadata: {name: db}\nspec: {clusterIP: None}\n",
+		},
+		{
+			name: "Job",
+			live: `
+apiVersion: batch/v1
+kind: Job
+metadata: {name: migrate}
+spec:
+  backoffLimit: 6
+  completions: 1
+  parallelism: 1
+  selector:
+    matchLabels: {controller-uid: 7a9b}
+  template:
+    metadata:
+      labels: {controller-uid: 7a9b, job-name: migrate}
+    spec:
+      restartPolicy: Never
+      containers:
+      - {name: migrate, image: "migrate:1.0"}
+`,
+			want: `
+apiVersion: batch/v1
+kind: Job
+metadata: {name: migrate}
+spec:
+  template:
+    spec:
+      restartPolicy: Never
+      containers:
+      - {name: migrate, image: "migrate:1.0"}
+`,
+		},
+		{
+			name: "ServiceAccount",
+			live: "apiVersion: v1\nkind: ServiceAccount\nmetadata: {name: web, ownerReferences: [{kind: Foo, name: bar}]}\nsecrets: [{name: web-token-x}]\n",
+			want: "apiVersion: v1\nkind: ServiceAccount\nmetadata: {name: web}\n",
+		},
+		{
+			name: "Namespace",
+			live: "apiVersion: v1\nkind: Namespace\nmetadata: {name: shop}\nspec: {finalizers: [kubernetes]}\nstatus: {phase: Active}\n",
+			want: "apiVersion: v1\nkind: Namespace\nmetadata: {name: shop}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			live := decodeTestLive(t, tt.live)
+			before := live.DeepCopy()
+			got := ExportObject(live)
+			if want := decodeTestLive(t, tt.want); !reflect.DeepEqual(got.Object, want.Object) {
+				t.Errorf("ExportObject() = %v\nwant %v", got.Object, want.Object)
+			}
+			if !reflect.DeepEqual(live, before) {
+				t.Error("ExportObject() modifies the live object")
+			}
+		})
+	}
+}
+
+func TestExportObjectKeepNodePorts(t *testing.T) {
+	live := decodeTestLive(t, `
+apiVersion: v1
+kind: Service
+metadata: {name: web}
+spec:
+  type: LoadBalancer
+  healthCheckNodePort: 31000
+  ports:
+  - {port: 80, nodePort: 30080}
+`)
+	got := exportObject(live, true)
+	if port, _, _ := unstructured.NestedInt64(got.Object, "spec", "healthCheckNodePort"); port != 31000 {
+		t.Errorf("healthCheckNodePort = %d, want kept", port)
+	}
+	ports, _, _ := unstructured.NestedSlice(got.Object, "spec", "ports")
+	if len(ports) != 1 || ports[0].(map[string]interface{})["nodePort"] != int64(30080) {
+		t.Errorf("ports = %v, want the node port kept", ports)
+	}
+}
+
+func TestExportSkipReason(t *testing.T) {
+	tests := []struct {
+		obj  string
+		want string
+	}{
+		{obj: "kind: ConfigMap\nmetadata: {name: web}", want: ""},
+		{obj: "kind: ConfigMap\nmetadata: {name: kube-root-ca.crt}", want: "created by the cluster"},
+		{obj: "kind: ServiceAccount\nmetadata: {name: default}", want: "created by the cluster"},
+		{obj: "kind: Secret\nmetadata: {name: web-token}\ntype: kubernetes.io/service-account-token", want: "service account token"},
+		{obj: "kind: Secret\nmetadata: {name: db}\ntype: Opaque", want: ""},
+		{
+			obj:  "kind: Pod\nmetadata:\n  name: web-1\n  ownerReferences: [{apiVersion: apps/v1, kind: ReplicaSet, name: web, uid: x, controller: true}]",
+			want: "controlled by ReplicaSet web",
+		},
+		// The owners which aren't the controller don't skip
+		{obj: "kind: ConfigMap\nmetadata:\n  name: web\n  ownerReferences: [{apiVersion: v1, kind: Foo, name: bar, uid: x}]", want: ""},
+		{obj: "kind: ClusterRole\nmetadata: {name: 'system:node'}", want: "system object"},
+		{obj: "kind: ClusterRole\nmetadata: {name: admin, labels: {kubernetes.io/bootstrapping: rbac-defaults}}", want: "system object"},
+		{obj: "kind: PriorityClass\nmetadata: {name: system-node-critical}", want: "system object"},
+		{obj: "kind: APIService\nmetadata: {name: v1.apps}\nspec: {group: apps}", want: "local APIService"},
+		{obj: "kind: APIService\nmetadata: {name: v1beta1.metrics.k8s.io}\nspec: {service: {name: metrics-server}}", want: ""},
+	}
+	for _, tt := range tests {
+		if got := exportSkipReason(decodeTestObject(t, tt.obj)); got != tt.want {
+			t.Errorf("exportSkipReason(%q) = %q, want %q", tt.obj, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultPullPolicy(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"web", "Always"},
+		{"web:latest", "Always"},
+		{"web:1.0", "IfNotPresent"},
+		{"registry.example.com:5000/web", "Always"},
+		{"registry.example.com:5000/web:1.0", "IfNotPresent"},
+		{"web@sha256:0123", "IfNotPresent"},
+	}
+	for _, tt := range tests {
+		if got := defaultPullPolicy(tt.image); got != tt.want {
+			t.Errorf("defaultPullPolicy(%q) = %q, want %q", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestWriteExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	objects := []unstructured.Unstructured{
+		*decodeTestLive(t, "apiVersion: v1\nkind: Namespace\nmetadata: {name: shop}\n"),
+		*decodeTestLive(t, "apiVersion: v1\nkind: ConfigMap\nmetadata: {name: a, namespace: shop}\n"),
+		*decodeTestLive(t, "apiVersion: v1\nkind: ConfigMap\nmetadata: {name: b, namespace: shop}\n"),
+		*decodeTestLive(t, "apiVersion: apps/v1\nkind: Deployment\nmetadata: {name: web, namespace: shop}\n"),
+		*decodeTestLive(t, "apiVersion: example.com/v1\nkind: Widget\nmetadata: {name: w, namespace: shop}\n"),
+	}
+	tests := []struct {
+		layout ExportLayout
+		want   []string
+	}{
+		{layout: ExportPerKind, want: []string{"00-namespace.yaml", "09-configmap.yaml", "27-deployment.apps.yaml", "34-widget.example.com.yaml"}},
+		{
+			layout: ExportPerObject,
+			want: []string{
+				filepath.Join("00-namespace", "shop.yaml"),
+				filepath.Join("09-configmap", "a.yaml"),
+				filepath.Join("09-configmap", "b.yaml"),
+				filepath.Join("27-deployment.apps", "web.yaml"),
+				filepath.Join("34-widget.example.com", "w.yaml"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		layoutDir := filepath.Join(dir, string(tt.layout))
+		files, err := WriteExport(layoutDir, objects, tt.layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, file := range files {
+			name, _ := filepath.Rel(layoutDir, file)
+			names = append(names, name)
+		}
+		if !reflect.DeepEqual(names, tt.want) {
+			t.Errorf("WriteExport(%s) = %v, want %v", tt.layout, names, tt.want)
+		}
+
+		// The files are read in the install order
+		manifests, err := ReadDir(layoutDir, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := manifestNames(t, manifests); !reflect.DeepEqual(got, []string{"shop", "a", "b", "web", "w"}) {
+			t.Errorf("ReadDir(%s) = %v, want the objects in order", tt.layout, got)
+		}
+		// The Secrets are in plain text
+		if info, err := os.Stat(files[0]); err != nil || info.Mode().Perm() != 0600 {
+			t.Errorf("mode of %s = %v, %v, want 0600", files[0], info.Mode().Perm(), err)
+		}
+	}
+
+	if _, err = WriteExport(dir, objects, "tree"); err == nil || !strings.Contains(err.Error(), "unknown export layout") {
+		t.Errorf("WriteExport(tree) err = %v, want unknown layout", err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	store := newTestObjectStore()
+	b64 := testAPIServer(t, store.ServeHTTP)
+
+	store.add("/api/v1/namespaces/shop", decodeTestLive(t, "apiVersion: v1\nkind: Namespace\nmetadata: {name: shop, uid: ns}\nstatus: {phase: Active}\n").Object)
+	store.add("/api/v1/namespaces/other", decodeTestLive(t, "apiVersion: v1\nkind: Namespace\nmetadata: {name: other}\n").Object)
+	for _, obj := range []string{
+		"apiVersion: v1\nkind: ConfigMap\nmetadata: {name: web, namespace: shop, labels: {app: web}}\ndata: {a: '1'}\n",
+		"apiVersion: v1\nkind: ConfigMap\nmetadata: {name: api, namespace: shop}\n",
+		"apiVersion: v1\nkind: ConfigMap\nmetadata: {name: kube-root-ca.crt, namespace: shop}\n",
+		"apiVersion: v1\nkind: ConfigMap\nmetadata: {name: web, namespace: other}\n",
+		"apiVersion: v1\nkind: Pod\nmetadata:\n  name: web-1\n  namespace: shop\n  ownerReferences: [{apiVersion: apps/v1, kind: ReplicaSet, name: web-1, uid: x, controller: true}]\n",
+		"apiVersion: apps/v1\nkind: Deployment\nmetadata: {name: web, namespace: shop, labels: {app: web}}\nspec: {revisionHistoryLimit: 10, replicas: 2}\n",
+	} {
+		live := decodeTestLive(t, obj)
+		path := "/api/v1/namespaces/" + live.GetNamespace() + "/configmaps/" + live.GetName()
+		switch live.GetKind() {
+		case "Pod":
+			path = "/api/v1/namespaces/shop/pods/" + live.GetName()
+		case "Deployment":
+			path = "/apis/apps/v1/namespaces/shop/deployments/" + live.GetName()
+		}
+		store.add(path, live.Object)
+	}
+
+	objects, warnings, err := Export(context.Background(), b64, ExportOptions{Namespace: "shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("warnings = %v, want none", warnings)
+	}
+	// In the install order, the skipped objects aren't exported
+	var got []string
+	for _, obj := range objects {
+		got = append(got, obj.GetKind()+"/"+obj.GetName())
+		if obj.GetResourceVersion() != "" || obj.Object["status"] != nil {
+			t.Errorf("%s %s = %v, want the server fields removed", obj.GetKind(), obj.GetName(), obj.Object)
+		}
+	}
+	if want := []string{"Namespace/shop", "ConfigMap/api", "ConfigMap/web", "Deployment/web"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Export() = %v, want %v", got, want)
+	}
+	if spec := objects[3].Object["spec"]; !reflect.DeepEqual(spec, map[string]interface{}{"replicas": int64(2)}) {
+		t.Errorf("spec of Deployment = %v, want the defaults removed", spec)
+	}
+
+	// The kinds and the selector
+	objects, _, err = Export(context.Background(), b64, ExportOptions{Namespace: "shop", Kinds: []string{"ConfigMap"}, LabelSelector: "app=web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].GetName() != "web" || objects[0].GetKind() != "ConfigMap" {
+		t.Errorf("Export(ConfigMap, app=web) = %v, want the ConfigMap web", objects)
+	}
+
+	// The kinds failed to list are warned
+	store.failures["GET /api/v1/namespaces/shop/secrets"] = 403
+	if _, warnings, err = Export(context.Background(), b64, ExportOptions{Namespace: "shop"}); err != nil || len(warnings) != 1 || !strings.HasPrefix(warnings[0], "skip secrets:") {
+		t.Errorf("Export() = %v, %v, want the secrets skipped", warnings, err)
+	}
+}
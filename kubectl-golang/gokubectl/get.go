@@ -0,0 +1,77 @@
+package gokubectl
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/k8s"
+)
+
+type GetOptions struct {
+	DiscoveryOptions
+
+	// Namespace of the namespaced objects, default "default"
+	Namespace string
+	// AllNamespaces lists the objects in all the namespaces, Namespace is ignored.
+	AllNamespaces bool
+	// LabelSelector filters the objects when listing
+	LabelSelector string
+	// FieldSelector filters the objects by fields when listing, e.g. status.phase=Running
+	FieldSelector string
+}
+
+// Get returns the object of resource by name, or lists the objects if name is empty.
+// The resource is the same as kubectl get, e.g. "deploy", "deployments.apps".
+func Get(ctx context.Context, base64KubeConfig string, resource, name string, opts GetOptions) ([]unstructured.Unstructured, error) {
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  base64KubeConfig,
+		DiscoveryCacheDir: opts.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.DiscoveryCacheTTL,
+	}
+	mapping, err := kubeClient.ResourceMapping(resource)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Mapping resource %s failed", resource)
+	}
+	dynamicClient, err := kubeClient.GetDynamicClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "Prepare dynamic client failed.")
+	}
+
+	var dr dynamic.ResourceInterface = dynamicClient.Resource(mapping.Resource)
+	if namespace := opts.namespace(mapping, name); namespace != "" {
+		dr = dynamicClient.Resource(mapping.Resource).Namespace(namespace)
+	}
+
+	if name != "" {
+		obj, err := dr.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return []unstructured.Unstructured{*obj}, nil
+	}
+	list, err := dr.List(ctx, metav1.ListOptions{
+		LabelSelector: opts.LabelSelector,
+		FieldSelector: opts.FieldSelector,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// namespace returns the namespace to get from, empty if the resource is
+// cluster-scoped or listed in all the namespaces.
+func (opts GetOptions) namespace(mapping *meta.RESTMapping, name string) string {
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace || (opts.AllNamespaces && name == "") {
+		return ""
+	}
+	if opts.Namespace == "" {
+		return defaultNamespace
+	}
+	return opts.Namespace
+}
@@ -0,0 +1,428 @@
+package gokubectl
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/k8s"
+)
+
+const (
+	defaultWaitTimeout      = 5 * time.Minute
+	defaultWaitPollInterval = 2 * time.Second
+	// statusGracePeriod is how long the objects without status are progressing
+	// after changed, the controller is expected to report the status by then.
+	statusGracePeriod = 30 * time.Second
+)
+
+type HealthStatus string
+
+const (
+	HealthHealthy     HealthStatus = "Healthy"
+	HealthProgressing HealthStatus = "Progressing"
+	HealthDegraded    HealthStatus = "Degraded"
+	HealthUnknown     HealthStatus = "Unknown"
+)
+
+type HealthResult struct {
+	Status  HealthStatus
+	Message string
+}
+
+func (h HealthResult) String() string {
+	if h.Message == "" {
+		return string(h.Status)
+	}
+	return string(h.Status) + ": " + h.Message
+}
+
+var (
+	// podWaitingDegraded are the waiting reasons of the containers which won't
+	// recover without changes.
+	podWaitingDegraded = map[string]bool{
+		"CrashLoopBackOff":           true,
+		"ImagePullBackOff":           true,
+		"ErrImagePull":               true,
+		"InvalidImageName":           true,
+		"CreateContainerConfigError": true,
+		"CreateContainerError":       true,
+	}
+)
+
+// Health assesses the live object by the kind, the other kinds are assessed by
+// status.observedGeneration and the standard conditions: Ready, Available,
+// Reconciling and Stalled. The objects with metadata.generation but without
+// status are progressing within statusGracePeriod after changed, they're healthy
+// after that as well as the objects without generation, which have no controller.
+func Health(obj *unstructured.Unstructured) HealthResult {
+	if obj.GetDeletionTimestamp() != nil {
+		return HealthResult{Status: HealthProgressing, Message: "being deleted"}
+	}
+	if h, ok := observedGeneration(obj); !ok {
+		return h
+	}
+
+	switch obj.GetKind() {
+	case "Deployment":
+		return deploymentHealth(obj)
+	case "StatefulSet":
+		return statefulSetHealth(obj)
+	case "DaemonSet":
+		return daemonSetHealth(obj)
+	case "Job":
+		return jobHealth(obj)
+	case "Pod":
+		return podHealth(obj)
+	case "PersistentVolumeClaim":
+		return pvcHealth(obj)
+	case "Service":
+		if serviceType, _, _ := unstructured.NestedString(obj.Object, "spec", "type"); serviceType != "LoadBalancer" {
+			return HealthResult{Status: HealthHealthy}
+		}
+		return loadBalancerHealth(obj)
+	case "Ingress":
+		return loadBalancerHealth(obj)
+	case "CustomResourceDefinition":
+		if c := findCondition(obj, "NamesAccepted"); c != nil && c.Status == metav1.ConditionFalse {
+			return HealthResult{Status: HealthDegraded, Message: c.Message}
+		}
+		if c := findCondition(obj, "Established"); c == nil || c.Status != metav1.ConditionTrue {
+			return HealthResult{Status: HealthProgressing, Message: "not established"}
+		}
+		return HealthResult{Status: HealthHealthy}
+	}
+	return conditionsHealth(obj)
+}
+
+// observedGeneration returns false with the result if the controller hasn't
+// observed the latest spec.
+func observedGeneration(obj *unstructured.Unstructured) (HealthResult, bool) {
+	observed, found, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	if found && obj.GetGeneration() > observed {
+		return HealthResult{
+			Status:  HealthProgressing,
+			Message: fmt.Sprintf("waiting for the controller to observe generation %d", obj.GetGeneration()),
+		}, false
+	}
+	return HealthResult{}, true
+}
+
+func findCondition(obj *unstructured.Unstructured, conditionType string) *metav1.Condition {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != conditionType {
+			continue
+		}
+		status, _ := condition["status"].(string)
+		reason, _ := condition["reason"].(string)
+		message, _ := condition["message"].(string)
+		return &metav1.Condition{
+			Type:    conditionType,
+			Status:  metav1.ConditionStatus(status),
+			Reason:  reason,
+			Message: message,
+		}
+	}
+	return nil
+}
+
+// conditionMessage returns the message of the condition, or the reason if empty.
+func conditionMessage(c *metav1.Condition) string {
+	if c.Message != "" {
+		return c.Message
+	}
+	return c.Reason
+}
+
+func conditionsHealth(obj *unstructured.Unstructured) HealthResult {
+	if c := findCondition(obj, "Stalled"); c != nil && c.Status == metav1.ConditionTrue {
+		return HealthResult{Status: HealthDegraded, Message: conditionMessage(c)}
+	}
+	if c := findCondition(obj, "Failed"); c != nil && c.Status == metav1.ConditionTrue {
+		return HealthResult{Status: HealthDegraded, Message: conditionMessage(c)}
+	}
+	if c := findCondition(obj, "Reconciling"); c != nil && c.Status == metav1.ConditionTrue {
+		return HealthResult{Status: HealthProgressing, Message: conditionMessage(c)}
+	}
+	for _, conditionType := range []string{"Ready", "Available"} {
+		c := findCondition(obj, conditionType)
+		if c == nil {
+			continue
+		}
+		switch c.Status {
+		case metav1.ConditionTrue:
+			return HealthResult{Status: HealthHealthy, Message: conditionMessage(c)}
+		case metav1.ConditionFalse:
+			return HealthResult{Status: HealthProgressing, Message: conditionMessage(c)}
+		default:
+			return HealthResult{Status: HealthUnknown, Message: conditionMessage(c)}
+		}
+	}
+	if status, _, _ := unstructured.NestedMap(obj.Object, "status"); len(status) == 0 && obj.GetGeneration() > 0 {
+		if time.Since(lastChanged(obj)) < statusGracePeriod {
+			return progressing("waiting for the controller to report status")
+		}
+		return HealthResult{Status: HealthHealthy, Message: "no status reported"}
+	}
+	return HealthResult{Status: HealthHealthy}
+}
+
+// lastChanged returns the latest time of the managed fields, or the creation
+// time if none.
+func lastChanged(obj *unstructured.Unstructured) time.Time {
+	changed := obj.GetCreationTimestamp().Time
+	for _, f := range obj.GetManagedFields() {
+		if f.Time != nil && f.Time.After(changed) {
+			changed = f.Time.Time
+		}
+	}
+	return changed
+}
+
+func deploymentHealth(obj *unstructured.Unstructured) HealthResult {
+	if paused, _, _ := unstructured.NestedBool(obj.Object, "spec", "paused"); paused {
+		return HealthResult{Status: HealthHealthy, Message: "rollout paused"}
+	}
+	if c := findCondition(obj, "Progressing"); c != nil && c.Reason == "ProgressDeadlineExceeded" {
+		return HealthResult{Status: HealthDegraded, Message: conditionMessage(c)}
+	}
+	replicas := specReplicas(obj)
+	updated, _, _ := unstructured.NestedInt64(obj.Object, "status", "updatedReplicas")
+	current, _, _ := unstructured.NestedInt64(obj.Object, "status", "replicas")
+	available, _, _ := unstructured.NestedInt64(obj.Object, "status", "availableReplicas")
+	switch {
+	case updated < replicas:
+		return progressing("%d of %d replicas updated", updated, replicas)
+	case current > updated:
+		return progressing("%d old replicas pending termination", current-updated)
+	case available < updated:
+		return progressing("%d of %d updated replicas available", available, updated)
+	}
+	return HealthResult{Status: HealthHealthy}
+}
+
+func statefulSetHealth(obj *unstructured.Unstructured) HealthResult {
+	if strategy, _, _ := unstructured.NestedString(obj.Object, "spec", "updateStrategy", "type"); strategy == "OnDelete" {
+		return HealthResult{Status: HealthHealthy, Message: "updated on delete"}
+	}
+	replicas := specReplicas(obj)
+	ready, _, _ := unstructured.NestedInt64(obj.Object, "status", "readyReplicas")
+	if ready < replicas {
+		return progressing("%d of %d replicas ready", ready, replicas)
+	}
+	partition, found, _ := unstructured.NestedInt64(obj.Object, "spec", "updateStrategy", "rollingUpdate", "partition")
+	if found && partition > 0 {
+		updated, _, _ := unstructured.NestedInt64(obj.Object, "status", "updatedReplicas")
+		if updated < replicas-partition {
+			return progressing("%d of %d replicas updated above partition %d", updated, replicas-partition, partition)
+		}
+		return HealthResult{Status: HealthHealthy}
+	}
+	currentRevision, _, _ := unstructured.NestedString(obj.Object, "status", "currentRevision")
+	updateRevision, _, _ := unstructured.NestedString(obj.Object, "status", "updateRevision")
+	if currentRevision != updateRevision {
+		return progressing("rolling out revision %s", updateRevision)
+	}
+	return HealthResult{Status: HealthHealthy}
+}
+
+func daemonSetHealth(obj *unstructured.Unstructured) HealthResult {
+	if strategy, _, _ := unstructured.NestedString(obj.Object, "spec", "updateStrategy", "type"); strategy == "OnDelete" {
+		return HealthResult{Status: HealthHealthy, Message: "updated on delete"}
+	}
+	desired, _, _ := unstructured.NestedInt64(obj.Object, "status", "desiredNumberScheduled")
+	updated, _, _ := unstructured.NestedInt64(obj.Object, "status", "updatedNumberScheduled")
+	available, _, _ := unstructured.NestedInt64(obj.Object, "status", "numberAvailable")
+	switch {
+	case updated < desired:
+		return progressing("%d of %d pods updated", updated, desired)
+	case available < desired:
+		return progressing("%d of %d updated pods available", available, desired)
+	}
+	return HealthResult{Status: HealthHealthy}
+}
+
+func jobHealth(obj *unstructured.Unstructured) HealthResult {
+	if c := findCondition(obj, "Failed"); c != nil && c.Status == metav1.ConditionTrue {
+		return HealthResult{Status: HealthDegraded, Message: conditionMessage(c)}
+	}
+	if c := findCondition(obj, "Complete"); c != nil && c.Status == metav1.ConditionTrue {
+		return HealthResult{Status: HealthHealthy, Message: "completed"}
+	}
+	if suspended, _, _ := unstructured.NestedBool(obj.Object, "spec", "suspend"); suspended {
+		return HealthResult{Status: HealthHealthy, Message: "suspended"}
+	}
+	active, _, _ := unstructured.NestedInt64(obj.Object, "status", "active")
+	return progressing("%d pods active", active)
+}
+
+func podHealth(obj *unstructured.Unstructured) HealthResult {
+	phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+	switch phase {
+	case "Succeeded":
+		return HealthResult{Status: HealthHealthy, Message: "completed"}
+	case "Failed":
+		message, _, _ := unstructured.NestedString(obj.Object, "status", "message")
+		return HealthResult{Status: HealthDegraded, Message: message}
+	case "Pending", "Running":
+	default:
+		return HealthResult{Status: HealthUnknown, Message: "phase " + phase}
+	}
+
+	for _, field := range []string{"initContainerStatuses", "containerStatuses"} {
+		statuses, _, _ := unstructured.NestedSlice(obj.Object, "status", field)
+		for _, s := range statuses {
+			status, _ := s.(map[string]interface{})
+			reason, _, _ := unstructured.NestedString(status, "state", "waiting", "reason")
+			if podWaitingDegraded[reason] {
+				message, _, _ := unstructured.NestedString(status, "state", "waiting", "message")
+				return HealthResult{Status: HealthDegraded, Message: fmt.Sprintf("container %v: %s %s", status["name"], reason, message)}
+			}
+		}
+	}
+	if c := findCondition(obj, "Ready"); c != nil && c.Status == metav1.ConditionTrue {
+		return HealthResult{Status: HealthHealthy}
+	}
+	if c := findCondition(obj, "PodScheduled"); c != nil && c.Status == metav1.ConditionFalse {
+		return HealthResult{Status: HealthProgressing, Message: conditionMessage(c)}
+	}
+	return progressing("phase %s, not ready", phase)
+}
+
+func pvcHealth(obj *unstructured.Unstructured) HealthResult {
+	phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+	switch phase {
+	case "Bound":
+		return HealthResult{Status: HealthHealthy}
+	case "Lost":
+		return HealthResult{Status: HealthDegraded, Message: "volume lost"}
+	case "Pending", "":
+		return HealthResult{Status: HealthProgressing, Message: "waiting for the volume"}
+	}
+	return HealthResult{Status: HealthUnknown, Message: "phase " + phase}
+}
+
+// loadBalancerHealth is healthy if the load balancer is assigned.
+func loadBalancerHealth(obj *unstructured.Unstructured) HealthResult {
+	ingress, _, _ := unstructured.NestedSlice(obj.Object, "status", "loadBalancer", "ingress")
+	if len(ingress) == 0 {
+		return HealthResult{Status: HealthProgressing, Message: "waiting for the load balancer"}
+	}
+	return HealthResult{Status: HealthHealthy}
+}
+
+func specReplicas(obj *unstructured.Unstructured) int64 {
+	replicas, found, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	if !found {
+		return 1
+	}
+	return replicas
+}
+
+func progressing(format string, args ...interface{}) HealthResult {
+	return HealthResult{Status: HealthProgressing, Message: fmt.Sprintf(format, args...)}
+}
+
+// HealthOptions are the options of CheckHealth.
+type HealthOptions struct {
+	NamespaceOptions
+	DiscoveryOptions
+}
+
+// CheckHealth gets the objects of the manifests and assesses the health of them,
+// Err is set if the object can't be got.
+func CheckHealth(ctx context.Context, base64KubeConfig string, manifests []Manifest, opts HealthOptions) ([]ApplyResult, error) {
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  base64KubeConfig,
+		DiscoveryCacheDir: opts.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.DiscoveryCacheTTL,
+	}
+	result := make([]ApplyResult, 0, len(manifests))
+	for _, m := range manifests {
+		obj, dr, err := buildDynamicResourceClient(kubeClient, m.Data, opts.NamespaceOptions)
+		if err != nil {
+			result = append(result, ApplyResult{Err: err, Source: m.Source})
+			continue
+		}
+		r := newApplyResult(obj, m.Source)
+		live, err := dr.Get(ctx, obj.GetName(), metav1.GetOptions{})
+		if err != nil {
+			r.Err = err
+		} else {
+			health := Health(live)
+			r.Health = &health
+		}
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+// waitHealthy polls the applied objects until all of them are healthy, the
+// degraded ones fail at once and the others fail after timeout.
+func waitHealthy(ctx context.Context, kubeClient *k8s.KubeClient, manifests []Manifest, results []ApplyResult, indexes []int, opts ApplyOptions) {
+	ctx, cancel := context.WithTimeout(ctx, opts.WaitTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(defaultWaitPollInterval)
+	defer ticker.Stop()
+
+	var pending []int
+	for i := range results {
+		if results[i].Err == nil {
+			pending = append(pending, i)
+		}
+	}
+	for {
+		var remain []int
+		for _, i := range pending {
+			r := &results[i]
+			obj, dr, err := buildDynamicResourceClient(kubeClient, manifests[indexes[i]].Data, opts.NamespaceOptions)
+			if err != nil {
+				r.Err = err
+				continue
+			}
+			live, err := dr.Get(ctx, obj.GetName(), metav1.GetOptions{})
+			if err != nil {
+				if ctx.Err() != nil {
+					remain = append(remain, i)
+				} else {
+					r.Err = errors.Wrapf(err, "get %s failed", obj.GetName())
+				}
+				continue
+			}
+			health := Health(live)
+			r.Health = &health
+			switch health.Status {
+			case HealthHealthy:
+			case HealthDegraded:
+				r.Err = errors.Errorf("%s is degraded: %s", obj.GetName(), health.Message)
+			default:
+				remain = append(remain, i)
+			}
+		}
+		pending = remain
+		if len(pending) == 0 {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			for _, i := range pending {
+				r := &results[i]
+				message := "unknown"
+				if r.Health != nil {
+					message = r.Health.String()
+				}
+				r.Err = errors.Errorf("timed out waiting for %s to be healthy, last %s", r.Name, message)
+			}
+			return
+		}
+	}
+}
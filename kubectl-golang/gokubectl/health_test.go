@@ -0,0 +1,398 @@
+package gokubectl
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/k8s"
+)
+
+func TestHealth(t *testing.T) {
+	tests := []struct {
+		name string
+		obj  string
+		want HealthResult
+	}{
+		{
+			name: "being deleted",
+			obj:  "kind: ConfigMap\nmetadata: {name: web, deletionTimestamp: '2020-01-01T00:00:00Z'}\n",
+			want: HealthResult{Status: HealthProgressing, Message: "being deleted"},
+		},
+		{
+			name: "generation not observed",
+			obj:  "kind: Deployment\nmetadata: {name: web, generation: 3}\nstatus: {observedGeneration: 2}\n",
+			want: HealthResult{Status: HealthProgressing, Message: "waiting for the controller to observe generation 3"},
+		},
+		{
+			name: "ConfigMap",
+			obj:  "kind: ConfigMap\nmetadata: {name: web}\ndata: {a: '1'}\n",
+			want: HealthResult{Status: HealthHealthy},
+		},
+
+		{
+			name: "Deployment available",
+			obj:  "kind: Deployment\nspec: {replicas: 2}\nstatus: {replicas: 2, updatedReplicas: 2, availableReplicas: 2}\n",
+			want: HealthResult{Status: HealthHealthy},
+		},
+		{
+			name: "Deployment updating",
+			obj:  "kind: Deployment\nspec: {replicas: 3}\nstatus: {replicas: 3, updatedReplicas: 1}\n",
+			want: HealthResult{Status: HealthProgressing, Message: "1 of 3 replicas updated"},
+		},
+		{
+			name: "Deployment old replicas",
+			obj:  "kind: Deployment\nspec: {replicas: 2}\nstatus: {replicas: 3, updatedReplicas: 2}\n",
+			want: HealthResult{Status: HealthProgressing, Message: "1 old replicas pending termination"},
+		},
+		{
+			name: "Deployment not available",
+			obj:  "kind: Deployment\nstatus: {replicas: 1, updatedReplicas: 1}\n",
+			want: HealthResult{Status: HealthProgressing, Message: "0 of 1 updated replicas available"},
+		},
+		{
+			name: "Deployment deadline exceeded",
+			obj: `
+kind: Deployment
+spec: {replicas: 2}
+status:
+  conditions:
+  - {type: Progressing, status: 'False', reason: ProgressDeadlineExceeded, message: ReplicaSet web-1 has timed out progressing.}
+`,
+			want: HealthResult{Status: HealthDegraded, Message: "ReplicaSet web-1 has timed out progressing."},
+		},
+		{
+			name: "Deployment paused",
+			obj:  "kind: Deployment\nspec: {replicas: 2, paused: true}\n",
+			want: HealthResult{Status: HealthHealthy, Message: "rollout paused"},
+		},
+
+		{
+			name: "StatefulSet not ready",
+			obj:  "kind: StatefulSet\nspec: {replicas: 3}\nstatus: {readyReplicas: 2}\n",
+			want: HealthResult{Status: HealthProgressing, Message: "2 of 3 replicas ready"},
+		},
+		{
+			name: "StatefulSet rolling out",
+			obj:  "kind: StatefulSet\nspec: {replicas: 1}\nstatus: {readyReplicas: 1, currentRevision: db-1, updateRevision: db-2}\n",
+			want: HealthResult{Status: HealthProgressing, Message: "rolling out revision db-2"},
+		},
+		{
+			name: "StatefulSet partition",
+			obj:  "kind: StatefulSet\nspec: {replicas: 3, updateStrategy: {rollingUpdate: {partition: 1}}}\nstatus: {readyReplicas: 3, updatedReplicas: 1, currentRevision: db-1, updateRevision: db-2}\n",
+			want: HealthResult{Status: HealthProgressing, Message: "1 of 2 replicas updated above partition 1"},
+		},
+		{
+			name: "StatefulSet partition updated",
+			obj:  "kind: StatefulSet\nspec: {replicas: 3, updateStrategy: {rollingUpdate: {partition: 1}}}\nstatus: {readyReplicas: 3, updatedReplicas: 2, currentRevision: db-1, updateRevision: db-2}\n",
+			want: HealthResult{Status: HealthHealthy},
+		},
+		{
+			name: "StatefulSet on delete",
+			obj:  "kind: StatefulSet\nspec: {updateStrategy: {type: OnDelete}}\n",
+			want: HealthResult{Status: HealthHealthy, Message: "updated on delete"},
+		},
+		{
+			name: "StatefulSet rolled out",
+			obj:  "kind: StatefulSet\nspec: {replicas: 1}\nstatus: {readyReplicas: 1, currentRevision: db-2, updateRevision: db-2}\n",
+			want: HealthResult{Status: HealthHealthy},
+		},
+
+		{
+			name: "DaemonSet updating",
+			obj:  "kind: DaemonSet\nstatus: {desiredNumberScheduled: 3, updatedNumberScheduled: 2, numberAvailable: 3}\n",
+			want: HealthResult{Status: HealthProgressing, Message: "2 of 3 pods updated"},
+		},
+		{
+			name: "DaemonSet not available",
+			obj:  "kind: DaemonSet\nstatus: {desiredNumberScheduled: 3, updatedNumberScheduled: 3, numberAvailable: 1}\n",
+			want: HealthResult{Status: HealthProgressing, Message: "1 of 3 updated pods available"},
+		},
+		{
+			name: "DaemonSet available",
+			obj:  "kind: DaemonSet\nstatus: {desiredNumberScheduled: 3, updatedNumberScheduled: 3, numberAvailable: 3}\n",
+			want: HealthResult{Status: HealthHealthy},
+		},
+
+		{
+			name: "Job running",
+			obj:  "kind: Job\nstatus: {active: 2}\n",
+			want: HealthResult{Status: HealthProgressing, Message: "2 pods active"},
+		},
+		{
+			name: "Job complete",
+			obj:  "kind: Job\nstatus: {conditions: [{type: Complete, status: 'True'}]}\n",
+			want: HealthResult{Status: HealthHealthy, Message: "completed"},
+		},
+		{
+			name: "Job failed",
+			obj:  "kind: Job\nstatus: {conditions: [{type: Failed, status: 'True', reason: BackoffLimitExceeded}]}\n",
+			want: HealthResult{Status: HealthDegraded, Message: "BackoffLimitExceeded"},
+		},
+		{
+			name: "Job suspended",
+			obj:  "kind: Job\nspec: {suspend: true}\n",
+			want: HealthResult{Status: HealthHealthy, Message: "suspended"},
+		},
+
+		{
+			name: "Pod ready",
+			obj:  "kind: Pod\nstatus: {phase: Running, conditions: [{type: Ready, status: 'True'}]}\n",
+			want: HealthResult{Status: HealthHealthy},
+		},
+		{
+			name: "Pod crash loop",
+			obj: `
+kind: Pod
+status:
+  phase: Running
+  containerStatuses:
+  - name: web
+    state: {waiting: {reason: CrashLoopBackOff, message: back-off 5m0s}}
+`,
+			want: HealthResult{Status: HealthDegraded, Message: "container web: CrashLoopBackOff back-off 5m0s"},
+		},
+		{
+			name: "Pod init container image",
+			obj: `
+kind: Pod
+status:
+  phase: Pending
+  initContainerStatuses:
+  - name: migrate
+    state: {waiting: {reason: ImagePullBackOff}}
+`,
+			want: HealthResult{Status: HealthDegraded, Message: "container migrate: ImagePullBackOff "},
+		},
+		{
+			name: "Pod unschedulable",
+			obj:  "kind: Pod\nstatus: {phase: Pending, conditions: [{type: PodScheduled, status: 'False', reason: Unschedulable, message: 0/3 nodes are available.}]}\n",
+			want: HealthResult{Status: HealthProgressing, Message: "0/3 nodes are available."},
+		},
+		{
+			name: "Pod starting",
+			obj:  "kind: Pod\nstatus: {phase: Running, containerStatuses: [{name: web, state: {waiting: {reason: ContainerCreating}}}]}\n",
+			want: HealthResult{Status: HealthProgressing, Message: "phase Running, not ready"},
+		},
+		{
+			name: "Pod succeeded",
+			obj:  "kind: Pod\nstatus: {phase: Succeeded}\n",
+			want: HealthResult{Status: HealthHealthy, Message: "completed"},
+		},
+		{
+			name: "Pod failed",
+			obj:  "kind: Pod\nstatus: {phase: Failed, message: OOMKilled}\n",
+			want: HealthResult{Status: HealthDegraded, Message: "OOMKilled"},
+		},
+		{
+			name: "Pod unknown",
+			obj:  "kind: Pod\nstatus: {phase: Unknown}\n",
+			want: HealthResult{Status: HealthUnknown, Message: "phase Unknown"},
+		},
+
+		{
+			name: "PersistentVolumeClaim bound",
+			obj:  "kind: PersistentVolumeClaim\nstatus: {phase: Bound}\n",
+			want: HealthResult{Status: HealthHealthy},
+		},
+		{
+			name: "PersistentVolumeClaim pending",
+			obj:  "kind: PersistentVolumeClaim\n",
+			want: HealthResult{Status: HealthProgressing, Message: "waiting for the volume"},
+		},
+		{
+			name: "PersistentVolumeClaim lost",
+			obj:  "kind: PersistentVolumeClaim\nstatus: {phase: Lost}\n",
+			want: HealthResult{Status: HealthDegraded, Message: "volume lost"},
+		},
+
+		{
+			name: "Service",
+			obj:  "kind: Service\nspec: {type: ClusterIP}\n",
+			want: HealthResult{Status: HealthHealthy},
+		},
+		{
+			name: "Service load balancer pending",
+			obj:  "kind: Service\nspec: {type: LoadBalancer}\n",
+			want: HealthResult{Status: HealthProgressing, Message: "waiting for the load balancer"},
+		},
+		{
+			name: "Service load balancer",
+			obj:  "kind: Service\nspec: {type: LoadBalancer}\nstatus: {loadBalancer: {ingress: [{ip: 10.0.0.1}]}}\n",
+			want: HealthResult{Status: HealthHealthy},
+		},
+		{
+			name: "Ingress pending",
+			obj:  "kind: Ingress\n",
+			want: HealthResult{Status: HealthProgressing, Message: "waiting for the load balancer"},
+		},
+
+		{
+			name: "CustomResourceDefinition established",
+			obj:  "kind: CustomResourceDefinition\nstatus: {conditions: [{type: NamesAccepted, status: 'True'}, {type: Established, status: 'True'}]}\n",
+			want: HealthResult{Status: HealthHealthy},
+		},
+		{
+			name: "CustomResourceDefinition names conflict",
+			obj:  "kind: CustomResourceDefinition\nstatus: {conditions: [{type: NamesAccepted, status: 'False', message: widgets is already in use}]}\n",
+			want: HealthResult{Status: HealthDegraded, Message: "widgets is already in use"},
+		},
+		{
+			name: "CustomResourceDefinition not established",
+			obj:  "kind: CustomResourceDefinition\n",
+			want: HealthResult{Status: HealthProgressing, Message: "not established"},
+		},
+
+		{
+			name: "conditions stalled",
+			obj:  "kind: Widget\nstatus: {conditions: [{type: Ready, status: 'False'}, {type: Stalled, status: 'True', message: invalid spec}]}\n",
+			want: HealthResult{Status: HealthDegraded, Message: "invalid spec"},
+		},
+		{
+			name: "conditions reconciling",
+			obj:  "kind: Widget\nstatus: {conditions: [{type: Reconciling, status: 'True', reason: Progressing}]}\n",
+			want: HealthResult{Status: HealthProgressing, Message: "Progressing"},
+		},
+		{
+			name: "conditions ready",
+			obj:  "kind: Widget\nstatus: {conditions: [{type: Ready, status: 'True', message: ok}]}\n",
+			want: HealthResult{Status: HealthHealthy, Message: "ok"},
+		},
+		{
+			name: "conditions not available",
+			obj:  "kind: Widget\nstatus: {conditions: [{type: Available, status: 'False', reason: MinimumReplicasUnavailable}]}\n",
+			want: HealthResult{Status: HealthProgressing, Message: "MinimumReplicasUnavailable"},
+		},
+		{
+			name: "conditions unknown",
+			obj:  "kind: Widget\nstatus: {conditions: [{type: Ready, status: Unknown, reason: Pending}]}\n",
+			want: HealthResult{Status: HealthUnknown, Message: "Pending"},
+		},
+		{
+			name: "no status reported",
+			obj:  "kind: Widget\nmetadata: {generation: 1, creationTimestamp: '2020-01-01T00:00:00Z'}\n",
+			want: HealthResult{Status: HealthHealthy, Message: "no status reported"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Health(decodeTestLive(t, tt.obj)); got != tt.want {
+				t.Errorf("Health() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthStatusGracePeriod(t *testing.T) {
+	obj := decodeTestLive(t, "kind: Widget\nmetadata: {generation: 2, creationTimestamp: '2020-01-01T00:00:00Z'}\n")
+	// The managed fields are stored in seconds
+	changed := metav1.NewTime(time.Now().Add(-time.Second).Truncate(time.Second))
+	obj.SetManagedFields([]metav1.ManagedFieldsEntry{{Manager: "gokubectl", Time: &changed}})
+	if got := Health(obj); got.Status != HealthProgressing {
+		t.Errorf("Health() = %v, want progressing after changed", got)
+	}
+	if got := lastChanged(obj); !got.Equal(changed.Time) {
+		t.Errorf("lastChanged() = %v, want %v", got, changed.Time)
+	}
+}
+
+func TestHealthResultString(t *testing.T) {
+	if got := (HealthResult{Status: HealthHealthy}).String(); got != "Healthy" {
+		t.Errorf("String() = %q, want Healthy", got)
+	}
+	if got := progressing("%d pods active", 2).String(); got != "Progressing: 2 pods active" {
+		t.Errorf("String() = %q, want Progressing: 2 pods active", got)
+	}
+}
+
+func testDeploymentManifest(name string) Manifest {
+	return Manifest{
+		Data:   []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata: {name: " + name + ", namespace: shop}\n"),
+		Source: Location{File: "deploy.yaml", Line: 1, Item: -1},
+	}
+}
+
+func testStoredDeployment(name string, replicas, available int64) map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "shop"},
+		"spec":       map[string]interface{}{"replicas": replicas},
+		"status": map[string]interface{}{
+			"replicas":          replicas,
+			"updatedReplicas":   replicas,
+			"availableReplicas": available,
+		},
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	store := newTestObjectStore()
+	b64 := testAPIServer(t, store.ServeHTTP)
+	store.add("/apis/apps/v1/namespaces/shop/deployments/web", testStoredDeployment("web", 2, 2))
+	store.add("/apis/apps/v1/namespaces/shop/deployments/api", testStoredDeployment("api", 2, 1))
+
+	manifests := []Manifest{
+		testDeploymentManifest("web"),
+		testDeploymentManifest("api"),
+		testDeploymentManifest("absent"),
+		{Data: []byte("apiVersion: example.com/v1\nkind: Widget\nmetadata: {name: w}\n")},
+	}
+	results, err := CheckHealth(context.Background(), b64, manifests, HealthOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(manifests) {
+		t.Fatalf("CheckHealth() = %d results, want %d", len(results), len(manifests))
+	}
+	if r := results[0]; r.Err != nil || r.Health == nil || r.Health.Status != HealthHealthy {
+		t.Errorf("web = %v %v, want healthy", r.Health, r.Err)
+	}
+	if r := results[1]; r.Err != nil || r.Health == nil || r.Health.String() != "Progressing: 1 of 2 updated replicas available" {
+		t.Errorf("api = %v %v, want progressing", r.Health, r.Err)
+	}
+	if r := results[2]; r.Err == nil || r.Health != nil || r.Name != "absent" {
+		t.Errorf("absent = %+v, want the not found error", r)
+	}
+	// Unknown kinds fail without the object name
+	if r := results[3]; r.Err == nil || r.Source != manifests[3].Source {
+		t.Errorf("widget = %+v, want the error at its source", r)
+	}
+}
+
+func TestWaitHealthy(t *testing.T) {
+	store := newTestObjectStore()
+	kubeClient := &k8s.KubeClient{Base64KubeConfig: testAPIServer(t, store.ServeHTTP)}
+	store.add("/apis/apps/v1/namespaces/shop/deployments/web", testStoredDeployment("web", 2, 2))
+	store.add("/apis/apps/v1/namespaces/shop/deployments/api", testStoredDeployment("api", 2, 1))
+	degraded := testStoredDeployment("db", 1, 0)
+	degraded["status"].(map[string]interface{})["conditions"] = []interface{}{
+		map[string]interface{}{"type": "Progressing", "status": "False", "reason": "ProgressDeadlineExceeded"},
+	}
+	store.add("/apis/apps/v1/namespaces/shop/deployments/db", degraded)
+
+	manifests := []Manifest{testDeploymentManifest("web"), testDeploymentManifest("api"), testDeploymentManifest("db")}
+	results := []ApplyResult{{Name: "web"}, {Name: "api"}, {Name: "db"}, {Name: "failed", Err: context.Canceled}}
+	start := time.Now()
+	waitHealthy(context.Background(), kubeClient, manifests, results, []int{0, 1, 2, 0}, ApplyOptions{WaitTimeout: 100 * time.Millisecond})
+	if elapsed := time.Since(start); elapsed > defaultWaitPollInterval {
+		t.Errorf("waitHealthy() took %v, want the timeout", elapsed)
+	}
+
+	if results[0].Err != nil || results[0].Health == nil || results[0].Health.Status != HealthHealthy {
+		t.Errorf("web = %v %v, want healthy", results[0].Health, results[0].Err)
+	}
+	if err := results[1].Err; err == nil || err.Error() != "timed out waiting for api to be healthy, last Progressing: 1 of 2 updated replicas available" {
+		t.Errorf("api error = %v, want timed out", err)
+	}
+	if err := results[2].Err; err == nil || !strings.Contains(err.Error(), "db is degraded: ProgressDeadlineExceeded") {
+		t.Errorf("db error = %v, want degraded", err)
+	}
+	// The failed ones aren't waited
+	if results[3].Err != context.Canceled || results[3].Health != nil {
+		t.Errorf("failed = %+v, want unchanged", results[3])
+	}
+}
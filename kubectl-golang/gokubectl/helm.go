@@ -0,0 +1,736 @@
+package gokubectl
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/k8s"
+)
+
+const (
+	chartFile    = "Chart.yaml"
+	valuesFile   = "values.yaml"
+	templatesDir = "templates/"
+	chartsDir    = "charts/"
+	crdsDir      = "crds/"
+
+	// defaultKubeVersion is used to render without cluster, the same as helm template
+	defaultKubeVersion = "v1.20.0"
+	hookAnnotation     = "helm.sh/hook"
+)
+
+// installOrder is the order of kinds to apply, the same as Helm.
+// The unknown kinds are applied at last.
+var installOrder = []string{
+	"Namespace", "NetworkPolicy", "ResourceQuota", "LimitRange", "PodSecurityPolicy",
+	"PodDisruptionBudget", "ServiceAccount", "Secret", "SecretList", "ConfigMap",
+	"StorageClass", "PersistentVolume", "PersistentVolumeClaim", "CustomResourceDefinition",
+	"ClusterRole", "ClusterRoleList", "ClusterRoleBinding", "ClusterRoleBindingList",
+	"Role", "RoleList", "RoleBinding", "RoleBindingList", "Service", "DaemonSet", "Pod",
+	"ReplicationController", "ReplicaSet", "Deployment", "HorizontalPodAutoscaler",
+	"StatefulSet", "Job", "CronJob", "Ingress", "APIService",
+}
+
+type ChartOptions struct {
+	DiscoveryOptions
+
+	// ReleaseName is .Release.Name, default the chart name
+	ReleaseName string
+	// Namespace is .Release.Namespace and the default namespace of the objects, default "default"
+	Namespace string
+	// Revision is .Release.Revision, default 1
+	Revision int
+	// IsUpgrade sets .Release.IsUpgrade instead of .Release.IsInstall
+	IsUpgrade bool
+
+	// ValuesFiles are merged over the values.yaml of the chart in order
+	ValuesFiles []string
+	// Values are merged at last, like --set of helm
+	Values map[string]interface{}
+
+	// KubeVersion is .Capabilities.KubeVersion to render without cluster, default
+	// the version of cluster. APIVersions are added to the ones of cluster.
+	KubeVersion string
+	APIVersions []string
+
+	// SkipCRDs leaves out the CRDs of crds/, like helm --skip-crds
+	SkipCRDs bool
+}
+
+func (opts *ChartOptions) complete(chartName string) {
+	if opts.ReleaseName == "" {
+		opts.ReleaseName = chartName
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = defaultNamespace
+	}
+	if opts.Revision <= 0 {
+		opts.Revision = 1
+	}
+}
+
+// ChartMetadata is the Chart.yaml, it's .Chart in templates.
+type ChartMetadata struct {
+	APIVersion   string            `json:"apiVersion"`
+	Name         string            `json:"name"`
+	Version      string            `json:"version"`
+	AppVersion   string            `json:"appVersion,omitempty"`
+	KubeVersion  string            `json:"kubeVersion,omitempty"`
+	Description  string            `json:"description,omitempty"`
+	Type         string            `json:"type,omitempty"`
+	Home         string            `json:"home,omitempty"`
+	Keywords     []string          `json:"keywords,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	Dependencies []ChartDependency `json:"dependencies,omitempty"`
+}
+
+type ChartDependency struct {
+	Name      string `json:"name"`
+	Version   string `json:"version,omitempty"`
+	Condition string `json:"condition,omitempty"`
+	Alias     string `json:"alias,omitempty"`
+}
+
+// chart is the loaded chart, the files are relative to the chart directory.
+type chart struct {
+	Metadata  ChartMetadata
+	Values    map[string]interface{}
+	Files     map[string][]byte
+	Subcharts []*chart
+}
+
+// ReleaseInfo is .Release in templates
+type ReleaseInfo struct {
+	Name      string
+	Namespace string
+	Revision  int
+	IsInstall bool
+	IsUpgrade bool
+	Service   string
+}
+
+// KubeVersion is .Capabilities.KubeVersion in templates
+type KubeVersion struct {
+	Version    string
+	Major      string
+	Minor      string
+	GitVersion string
+}
+
+func (v KubeVersion) String() string {
+	return v.Version
+}
+
+// VersionSet is .Capabilities.APIVersions, like "apps/v1" and "apps/v1/Deployment"
+type VersionSet []string
+
+// Has returns true if the version or the version/kind is served.
+func (s VersionSet) Has(version string) bool {
+	for _, v := range s {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// ChartCapabilities is .Capabilities in templates
+type ChartCapabilities struct {
+	KubeVersion KubeVersion
+	APIVersions VersionSet
+}
+
+// TemplateInfo is .Template in templates
+type TemplateInfo struct {
+	Name     string
+	BasePath string
+}
+
+// RenderChart renders the chart directory or .tgz like `helm template`, the
+// capabilities and lookup come from the cluster if base64KubeConfig isn't empty.
+// The manifests are sorted by kind in the install order of Helm, the CRDs of crds/
+// first unless ChartOptions.SkipCRDs, and the test hooks are dropped.
+func RenderChart(ctx context.Context, base64KubeConfig string, chartPath string, opts ChartOptions) ([]Manifest, error) {
+	var kubeClient *k8s.KubeClient
+	if base64KubeConfig != "" {
+		kubeClient = &k8s.KubeClient{
+			Base64KubeConfig:  base64KubeConfig,
+			DiscoveryCacheDir: opts.DiscoveryCacheDir,
+			DiscoveryCacheTTL: opts.DiscoveryCacheTTL,
+		}
+	}
+	return renderChartPath(ctx, kubeClient, chartPath, opts)
+}
+
+// ApplyChart renders the chart with the capabilities of cluster and applies it,
+// the objects without namespace are applied in ChartOptions.Namespace.
+func ApplyChart(ctx context.Context, base64KubeConfig string, chartPath string, chartOpts ChartOptions, opts ApplyOptions) ([]ApplyResult, error) {
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  base64KubeConfig,
+		DiscoveryCacheDir: opts.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.DiscoveryCacheTTL,
+		QPS:               opts.QPS,
+		Burst:             opts.Burst,
+	}
+	manifests, err := renderChartPath(ctx, kubeClient, chartPath, chartOpts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.DefaultNamespace == "" {
+		opts.DefaultNamespace = chartOpts.Namespace
+	}
+	return applyManifests(ctx, kubeClient, manifests, opts)
+}
+
+// DeleteChart renders the chart like ApplyChart and deletes the objects in the
+// reverse install order. The CRDs of crds/ are never deleted like Helm, deleting
+// them removes all the custom resources of the cluster, not only the release's.
+func DeleteChart(ctx context.Context, base64KubeConfig string, chartPath string, chartOpts ChartOptions, opts DeleteOptions) ([]ApplyResult, error) {
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  base64KubeConfig,
+		DiscoveryCacheDir: opts.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.DiscoveryCacheTTL,
+	}
+	chartOpts.SkipCRDs = true
+	manifests, err := renderChartPath(ctx, kubeClient, chartPath, chartOpts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.DefaultNamespace == "" {
+		opts.DefaultNamespace = chartOpts.Namespace
+	}
+	return deleteManifests(ctx, kubeClient, UninstallOrder(manifests), opts)
+}
+
+func renderChartPath(ctx context.Context, kubeClient *k8s.KubeClient, chartPath string, opts ChartOptions) ([]Manifest, error) {
+	c, err := loadChart(chartPath)
+	if err != nil {
+		return nil, err
+	}
+	caps, err := chartCapabilities(ctx, kubeClient, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]interface{}{}
+	for _, filename := range opts.ValuesFiles {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		fileValues := map[string]interface{}{}
+		if err = yaml.Unmarshal(data, &fileValues); err != nil {
+			return nil, errors.Wrapf(err, "decode values %s failed", filename)
+		}
+		values = mergeValues(values, fileValues)
+	}
+	values = mergeValues(values, opts.Values)
+	return renderChart(c, values, caps, chartLookup(ctx, kubeClient), opts)
+}
+
+// chartCapabilities detects the capabilities of cluster, the options are used
+// without cluster. The discovery of client-go takes no context, ctx is checked
+// before it.
+func chartCapabilities(ctx context.Context, kubeClient *k8s.KubeClient, opts ChartOptions) (ChartCapabilities, error) {
+	caps := ChartCapabilities{}
+	gitVersion := opts.KubeVersion
+	if kubeClient != nil {
+		if err := ctx.Err(); err != nil {
+			return caps, err
+		}
+		capabilities, err := kubeClient.GetCapabilities()
+		if err != nil {
+			return caps, err
+		}
+		if gitVersion == "" {
+			gitVersion = capabilities.GitVersion
+		}
+
+		dc, err := kubeClient.GetDiscoveryClient()
+		if err != nil {
+			return caps, err
+		}
+		// The partial result is used if some groups are unavailable
+		_, resources, err := dc.ServerGroupsAndResources()
+		if err != nil && len(resources) == 0 {
+			return caps, errors.Wrap(err, "discover api versions failed")
+		}
+		for _, list := range resources {
+			caps.APIVersions = append(caps.APIVersions, list.GroupVersion)
+			for _, r := range list.APIResources {
+				caps.APIVersions = append(caps.APIVersions, list.GroupVersion+"/"+r.Kind)
+			}
+		}
+	}
+	if gitVersion == "" {
+		gitVersion = defaultKubeVersion
+	}
+	caps.APIVersions = append(caps.APIVersions, opts.APIVersions...)
+
+	v, err := k8s.ParseVersion(&version.Info{GitVersion: gitVersion})
+	if err != nil {
+		return caps, err
+	}
+	caps.KubeVersion = KubeVersion{
+		Version:    v.String(),
+		Major:      strconv.Itoa(v.Major),
+		Minor:      strconv.Itoa(v.Minor),
+		GitVersion: gitVersion,
+	}
+	return caps, nil
+}
+
+// lookupFunc is the lookup of templates, it returns the object, or the list if
+// the name is empty.
+type lookupFunc func(apiVersion, kind, namespace, name string) (map[string]interface{}, error)
+
+// chartLookup gets the objects from the cluster like `helm install`, the objects
+// not found are empty maps. It always returns empty maps without cluster like
+// `helm template`.
+func chartLookup(ctx context.Context, kubeClient *k8s.KubeClient) lookupFunc {
+	return func(apiVersion, kind, namespace, name string) (map[string]interface{}, error) {
+		if kubeClient == nil {
+			return map[string]interface{}{}, nil
+		}
+		gv, err := schema.ParseGroupVersion(apiVersion)
+		if err != nil {
+			return nil, err
+		}
+		mapping, err := kubeClient.RESTMapping(gv.WithKind(kind).GroupKind(), gv.Version)
+		if err != nil {
+			return nil, errors.Wrap(err, "Mapping kind with version failed")
+		}
+		dynamicClient, err := kubeClient.GetDynamicClient()
+		if err != nil {
+			return nil, errors.Wrap(err, "Prepare dynamic client failed.")
+		}
+		var dr dynamic.ResourceInterface = dynamicClient.Resource(mapping.Resource)
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			// The empty namespace lists all the namespaces
+			dr = dynamicClient.Resource(mapping.Resource).Namespace(namespace)
+		}
+
+		var content map[string]interface{}
+		if name == "" {
+			var list *unstructured.UnstructuredList
+			if list, err = dr.List(ctx, metav1.ListOptions{}); err == nil {
+				content = list.UnstructuredContent()
+			}
+		} else {
+			var obj *unstructured.Unstructured
+			if obj, err = dr.Get(ctx, name, metav1.GetOptions{}); err == nil {
+				content = obj.Object
+			}
+		}
+		if k8sErrors.IsNotFound(err) {
+			return map[string]interface{}{}, nil
+		}
+		return content, err
+	}
+}
+
+// loadChart loads the chart directory or the chart archive.
+func loadChart(chartPath string) (*chart, error) {
+	info, err := os.Stat(chartPath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		data, err := ioutil.ReadFile(chartPath)
+		if err != nil {
+			return nil, err
+		}
+		return loadChartArchive(chartPath, data)
+	}
+
+	files := map[string][]byte{}
+	err = filepath.Walk(chartPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(chartPath, p)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = data
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "load chart %s failed", chartPath)
+	}
+	return newChart(chartPath, files)
+}
+
+// loadChartArchive loads the .tgz whose files are in the directory of the chart name.
+func loadChartArchive(name string, data []byte) (*chart, error) {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s failed", name)
+	}
+	defer gzipReader.Close()
+
+	files := map[string][]byte{}
+	reader := tar.NewReader(gzipReader)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s failed", name)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		// Drop the top directory
+		parts := strings.SplitN(path.Clean(header.Name), "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		content, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s failed", name)
+		}
+		files[parts[1]] = content
+	}
+	return newChart(name, files)
+}
+
+func newChart(name string, files map[string][]byte) (*chart, error) {
+	c := &chart{Files: map[string][]byte{}}
+	data, ok := files[chartFile]
+	if !ok {
+		return nil, errors.Errorf("%s is not a chart, %s not found", name, chartFile)
+	}
+	if err := yaml.Unmarshal(data, &c.Metadata); err != nil {
+		return nil, errors.Wrapf(err, "decode %s of %s failed", chartFile, name)
+	}
+	if c.Metadata.Name == "" {
+		return nil, errors.Errorf("name of chart %s is required", name)
+	}
+
+	c.Values = map[string]interface{}{}
+	if data, ok := files[valuesFile]; ok {
+		if err := yaml.Unmarshal(data, &c.Values); err != nil {
+			return nil, errors.Wrapf(err, "decode %s of %s failed", valuesFile, name)
+		}
+	}
+
+	subcharts := map[string]map[string][]byte{}
+	for filename, data := range files {
+		if !strings.HasPrefix(filename, chartsDir) {
+			c.Files[filename] = data
+			continue
+		}
+		rel := strings.TrimPrefix(filename, chartsDir)
+		if !strings.Contains(rel, "/") {
+			if strings.HasSuffix(rel, ".tgz") {
+				sub, err := loadChartArchive(path.Join(name, filename), data)
+				if err != nil {
+					return nil, err
+				}
+				c.Subcharts = append(c.Subcharts, sub)
+			}
+			continue
+		}
+		parts := strings.SplitN(rel, "/", 2)
+		if subcharts[parts[0]] == nil {
+			subcharts[parts[0]] = map[string][]byte{}
+		}
+		subcharts[parts[0]][parts[1]] = data
+	}
+	for dir, subFiles := range subcharts {
+		sub, err := newChart(path.Join(name, chartsDir, dir), subFiles)
+		if err != nil {
+			return nil, err
+		}
+		c.Subcharts = append(c.Subcharts, sub)
+	}
+	sort.Slice(c.Subcharts, func(i, j int) bool {
+		return c.Subcharts[i].Metadata.Name < c.Subcharts[j].Metadata.Name
+	})
+	return c, nil
+}
+
+// chartScope is a chart with its values, subcharts are scoped by the name or alias.
+type chartScope struct {
+	chart  *chart
+	name   string
+	path   string
+	values map[string]interface{}
+}
+
+// scopes returns the chart and the enabled subcharts with their values. The
+// values of the parent override the defaults, global is shared by all.
+func (c *chart) scopes(name, chartPath string, values map[string]interface{}) []chartScope {
+	values = mergeValues(deepCopyValues(c.Values), values)
+	scopes := []chartScope{{chart: c, name: name, path: chartPath, values: values}}
+
+	global, _ := values["global"].(map[string]interface{})
+	for _, sub := range c.Subcharts {
+		subName := sub.Metadata.Name
+		for _, dep := range c.Metadata.Dependencies {
+			if dep.Name != sub.Metadata.Name {
+				continue
+			}
+			if dep.Alias != "" {
+				subName = dep.Alias
+			}
+			if dep.Condition != "" {
+				if enabled, ok := lookupValue(values, dep.Condition).(bool); ok && !enabled {
+					subName = ""
+				}
+			}
+		}
+		if subName == "" {
+			continue
+		}
+
+		subValues, _ := values[subName].(map[string]interface{})
+		subValues = deepCopyValues(subValues)
+		if subValues == nil {
+			subValues = map[string]interface{}{}
+		}
+		subGlobal, _ := subValues["global"].(map[string]interface{})
+		subValues["global"] = mergeValues(deepCopyValues(subGlobal), global)
+		scopes = append(scopes, sub.scopes(subName, path.Join(chartPath, chartsDir, subName), subValues)...)
+	}
+	return scopes
+}
+
+func renderChart(c *chart, values map[string]interface{}, caps ChartCapabilities, lookup lookupFunc, opts ChartOptions) ([]Manifest, error) {
+	opts.complete(c.Metadata.Name)
+	release := ReleaseInfo{
+		Name:      opts.ReleaseName,
+		Namespace: opts.Namespace,
+		Revision:  opts.Revision,
+		IsInstall: !opts.IsUpgrade,
+		IsUpgrade: opts.IsUpgrade,
+		Service:   "Helm",
+	}
+	scopes := c.scopes(c.Metadata.Name, c.Metadata.Name, values)
+
+	// All the templates are in one set, so that include works across the charts
+	root := template.New("gotpl").Option("missingkey=zero")
+	root.Funcs(chartFuncs(root, lookup))
+	var names []string
+	data := map[string]map[string]interface{}{}
+	var crds []Manifest
+	for _, scope := range scopes {
+		for filename, content := range scope.chart.Files {
+			name := path.Join(scope.path, filename)
+			if strings.HasPrefix(filename, crdsDir) {
+				if isYAMLFile(filename) && !opts.SkipCRDs {
+					crds = append(crds, Manifest{Data: content, Source: Location{File: name}})
+				}
+				continue
+			}
+			if !strings.HasPrefix(filename, templatesDir) {
+				continue
+			}
+			if _, err := root.New(name).Parse(string(content)); err != nil {
+				return nil, errors.Wrapf(err, "parse template %s failed", name)
+			}
+			base := path.Base(filename)
+			if strings.HasPrefix(base, "_") || strings.HasSuffix(base, ".txt") {
+				continue
+			}
+			names = append(names, name)
+			data[name] = map[string]interface{}{
+				"Values":       scope.values,
+				"Release":      release,
+				"Chart":        scope.chart.Metadata,
+				"Capabilities": caps,
+				"Files":        newChartFiles(scope.chart.Files),
+				"Template": TemplateInfo{
+					Name:     name,
+					BasePath: path.Join(scope.path, templatesDir),
+				},
+			}
+		}
+	}
+	sort.Strings(names)
+	sort.Slice(crds, func(i, j int) bool {
+		return crds[i].Source.File < crds[j].Source.File
+	})
+
+	var manifests []Manifest
+	for _, name := range names {
+		var buf bytes.Buffer
+		if err := root.ExecuteTemplate(&buf, name, data[name]); err != nil {
+			return nil, errors.Wrapf(err, "render template %s failed", name)
+		}
+		out := bytes.Replace(buf.Bytes(), []byte("<no value>"), nil, -1)
+		rendered, err := ReadBytes(name, out)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, rendered...)
+	}
+
+	var result []Manifest
+	for _, m := range crds {
+		docs, err := ReadBytes(m.Source.File, m.Data)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, docs...)
+	}
+	return append(result, sortByInstallOrder(dropTestHooks(manifests))...), nil
+}
+
+// dropTestHooks drops the objects of `helm test`, the other hooks are applied
+// as normal objects.
+func dropTestHooks(manifests []Manifest) []Manifest {
+	var result []Manifest
+	for _, m := range manifests {
+		var doc struct {
+			Metadata struct {
+				Annotations map[string]string `json:"annotations"`
+			} `json:"metadata"`
+		}
+		_ = yaml.Unmarshal(m.Data, &doc)
+		if strings.HasPrefix(doc.Metadata.Annotations[hookAnnotation], "test") {
+			continue
+		}
+		result = append(result, m)
+	}
+	return result
+}
+
+// installRank returns the index of the kind in installOrder.
+func installRank(kind string) int {
+	for i, k := range installOrder {
+		if k == kind {
+			return i
+		}
+	}
+	return len(installOrder)
+}
+
+func manifestKind(m Manifest) string {
+	var doc struct {
+		Kind string `json:"kind"`
+	}
+	_ = yaml.Unmarshal(m.Data, &doc)
+	return doc.Kind
+}
+
+func sortByInstallOrder(manifests []Manifest) []Manifest {
+	ranks := make([]int, len(manifests))
+	for i, m := range manifests {
+		ranks[i] = installRank(manifestKind(m))
+	}
+	indexes := make([]int, len(manifests))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return ranks[indexes[i]] < ranks[indexes[j]]
+	})
+	result := make([]Manifest, len(manifests))
+	for i, index := range indexes {
+		result[i] = manifests[index]
+	}
+	return result
+}
+
+// UninstallOrder returns the manifests in the reverse install order of Helm,
+// e.g. the workloads before their Services and Namespaces.
+func UninstallOrder(manifests []Manifest) []Manifest {
+	result := sortByInstallOrder(manifests)
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result
+}
+
+func isYAMLFile(filename string) bool {
+	ext := strings.ToLower(path.Ext(filename))
+	return ext == ".yaml" || ext == ".yml" || ext == ".json"
+}
+
+// mergeValues merges src over dst deeply, null in src deletes the key like Helm.
+func mergeValues(dst, src map[string]interface{}) map[string]interface{} {
+	if dst == nil {
+		dst = map[string]interface{}{}
+	}
+	for k, v := range src {
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+		srcMap, ok := v.(map[string]interface{})
+		dstMap, dstOk := dst[k].(map[string]interface{})
+		if ok && dstOk {
+			dst[k] = mergeValues(dstMap, srcMap)
+			continue
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+func deepCopyValues(values map[string]interface{}) map[string]interface{} {
+	if values == nil {
+		return nil
+	}
+	return deepCopyValue(values).(map[string]interface{})
+}
+
+func deepCopyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			out[k] = deepCopyValue(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = deepCopyValue(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// lookupValue returns the value of the dotted path like "redis.enabled".
+func lookupValue(values map[string]interface{}, dotted string) interface{} {
+	var current interface{} = values
+	for _, key := range strings.Split(dotted, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[key]
+	}
+	return current
+}
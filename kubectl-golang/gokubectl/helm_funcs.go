@@ -0,0 +1,921 @@
+package gokubectl
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	alphaChars   = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	numericChars = "0123456789"
+)
+
+// chartFuncs returns the functions of templates, they are a subset of Sprig and
+// the functions of Helm.
+func chartFuncs(root *template.Template, lookup lookupFunc) template.FuncMap {
+	funcs := template.FuncMap{
+		// Strings
+		"trim":       strings.TrimSpace,
+		"trimAll":    func(cutset, s string) string { return strings.Trim(s, cutset) },
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"title":      strings.Title,
+		"untitle":    untitle,
+		"repeat":     func(count int, s string) string { return strings.Repeat(s, count) },
+		"substr":     substr,
+		"nospace":    func(s string) string { return strings.Map(dropSpace, s) },
+		"trunc":      trunc,
+		"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+		"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"quote":      quote,
+		"squote":     squote,
+		"cat":        cat,
+		"indent":     indent,
+		"nindent":    func(spaces int, s string) string { return "\n" + indent(spaces, s) },
+		"replace":    func(old, new, s string) string { return strings.Replace(s, old, new, -1) },
+		"snakecase":  func(s string) string { return joinWords(s, "_", false) },
+		"kebabcase":  func(s string) string { return joinWords(s, "-", false) },
+		"camelcase":  func(s string) string { return joinWords(s, "", true) },
+		"toString":   toString,
+		"toStrings":  toStrings,
+		"split":      split,
+		"splitList":  func(sep, s string) []string { return strings.Split(s, sep) },
+		"join":       join,
+		"sortAlpha":  sortAlpha,
+		"b64enc":     func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+		"b64dec":     b64dec,
+		"sha1sum":    func(s string) string { sum := sha1.Sum([]byte(s)); return hex.EncodeToString(sum[:]) },
+		"sha256sum":  func(s string) string { sum := sha256.Sum256([]byte(s)); return hex.EncodeToString(sum[:]) },
+		"randAlphaNum": func(n int) (string, error) {
+			return randString(n, alphaChars+numericChars)
+		},
+		"randAlpha":   func(n int) (string, error) { return randString(n, alphaChars) },
+		"randNumeric": func(n int) (string, error) { return randString(n, numericChars) },
+		"uuidv4":      uuidv4,
+
+		// Regexp
+		"regexMatch": func(regex, s string) (bool, error) { return regexp.MatchString(regex, s) },
+		"regexFind": func(regex, s string) (string, error) {
+			r, err := regexp.Compile(regex)
+			if err != nil {
+				return "", err
+			}
+			return r.FindString(s), nil
+		},
+		"regexReplaceAll": func(regex, s, repl string) (string, error) {
+			r, err := regexp.Compile(regex)
+			if err != nil {
+				return "", err
+			}
+			return r.ReplaceAllString(s, repl), nil
+		},
+		"regexReplaceAllLiteral": func(regex, s, repl string) (string, error) {
+			r, err := regexp.Compile(regex)
+			if err != nil {
+				return "", err
+			}
+			return r.ReplaceAllLiteralString(s, repl), nil
+		},
+
+		// Defaults and flow
+		"default":  defaultValue,
+		"empty":    empty,
+		"coalesce": coalesce,
+		"ternary": func(vt, vf interface{}, v bool) interface{} {
+			if v {
+				return vt
+			}
+			return vf
+		},
+		"fail":     func(msg string) (string, error) { return "", errors.New(msg) },
+		"required": required,
+
+		// Encoding
+		"toJson":        toJSON,
+		"toPrettyJson":  toPrettyJSON,
+		"fromJson":      fromJSON,
+		"toYaml":        toYAML,
+		"fromYaml":      fromYAML,
+		"fromYamlArray": fromYAMLArray,
+
+		// Types
+		"kindOf": func(v interface{}) string { return reflect.ValueOf(v).Kind().String() },
+		"kindIs": func(kind string, v interface{}) bool { return reflect.ValueOf(v).Kind().String() == kind },
+		"typeOf": func(v interface{}) string { return fmt.Sprintf("%T", v) },
+		"typeIs": func(typ string, v interface{}) bool { return fmt.Sprintf("%T", v) == typ },
+		"int":    func(v interface{}) int { return int(toInt64(v)) },
+		"int64":  toInt64,
+		"float64": func(v interface{}) float64 {
+			f, _ := toFloat64(v)
+			return f
+		},
+		"atoi": func(s string) int { i, _ := strconv.Atoi(s); return i },
+
+		// Math
+		"add":   func(a, b interface{}) int64 { return toInt64(a) + toInt64(b) },
+		"add1":  func(a interface{}) int64 { return toInt64(a) + 1 },
+		"sub":   func(a, b interface{}) int64 { return toInt64(a) - toInt64(b) },
+		"mul":   func(a, b interface{}) int64 { return toInt64(a) * toInt64(b) },
+		"div":   func(a, b interface{}) int64 { return toInt64(a) / toInt64(b) },
+		"mod":   func(a, b interface{}) int64 { return toInt64(a) % toInt64(b) },
+		"max":   maxInt,
+		"min":   minInt,
+		"until": until,
+
+		// Lists
+		"list":    func(items ...interface{}) []interface{} { return items },
+		"tuple":   func(items ...interface{}) []interface{} { return items },
+		"first":   first,
+		"last":    last,
+		"rest":    rest,
+		"initial": initial,
+		"append":  appendList,
+		"push":    appendList,
+		"prepend": prependList,
+		"concat":  concat,
+		"reverse": reverse,
+		"uniq":    uniq,
+		"without": without,
+		"has":     has,
+		"compact": compact,
+
+		// Dicts
+		"dict":           dict,
+		"get":            func(d map[string]interface{}, key string) interface{} { return d[key] },
+		"set":            func(d map[string]interface{}, key string, v interface{}) map[string]interface{} { d[key] = v; return d },
+		"unset":          func(d map[string]interface{}, key string) map[string]interface{} { delete(d, key); return d },
+		"hasKey":         func(d map[string]interface{}, key string) bool { _, ok := d[key]; return ok },
+		"pluck":          pluck,
+		"keys":           keys,
+		"values":         values,
+		"pick":           pick,
+		"omit":           omit,
+		"merge":          merge,
+		"mergeOverwrite": mergeOverwrite,
+		"deepCopy":       deepCopyValue,
+		"dig":            dig,
+
+		// Versions and dates
+		"semverCompare": semverCompare,
+		"now":           time.Now,
+		"date":          func(layout string, t time.Time) string { return t.Format(layout) },
+
+		// Helm
+		"lookup": lookup,
+	}
+
+	funcs["include"] = func(name string, data interface{}) (string, error) {
+		var buf bytes.Buffer
+		if err := root.ExecuteTemplate(&buf, name, data); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+	funcs["tpl"] = func(text string, data interface{}) (string, error) {
+		t, err := root.Clone()
+		if err != nil {
+			return "", err
+		}
+		if t, err = t.New("tpl").Parse(text); err != nil {
+			return "", errors.Wrap(err, "parse tpl failed")
+		}
+		var buf bytes.Buffer
+		if err = t.Execute(&buf, data); err != nil {
+			return "", err
+		}
+		return strings.Replace(buf.String(), "<no value>", "", -1), nil
+	}
+	return funcs
+}
+
+// chartFiles is .Files in templates, the files of the chart except the templates.
+type chartFiles map[string][]byte
+
+func newChartFiles(files map[string][]byte) chartFiles {
+	result := chartFiles{}
+	for name, data := range files {
+		if name == chartFile || name == valuesFile || strings.HasPrefix(name, templatesDir) {
+			continue
+		}
+		result[name] = data
+	}
+	return result
+}
+
+func (f chartFiles) Get(name string) string {
+	return string(f[name])
+}
+
+func (f chartFiles) GetBytes(name string) []byte {
+	return f[name]
+}
+
+func (f chartFiles) Glob(pattern string) chartFiles {
+	result := chartFiles{}
+	for name, data := range f {
+		if ok, _ := path.Match(pattern, name); ok {
+			result[name] = data
+		}
+	}
+	return result
+}
+
+func (f chartFiles) Lines(name string) []string {
+	return strings.Split(strings.TrimSuffix(string(f[name]), "\n"), "\n")
+}
+
+// AsConfig returns the files as the data of ConfigMap in YAML.
+func (f chartFiles) AsConfig() string {
+	data := map[string]string{}
+	for name, content := range f {
+		data[path.Base(name)] = string(content)
+	}
+	return toYAML(data)
+}
+
+// AsSecrets returns the files as the data of Secret in YAML.
+func (f chartFiles) AsSecrets() string {
+	data := map[string]string{}
+	for name, content := range f {
+		data[path.Base(name)] = base64.StdEncoding.EncodeToString(content)
+	}
+	return toYAML(data)
+}
+
+func untitle(s string) string {
+	if s == "" {
+		return s
+	}
+	runes := []rune(s)
+	runes[0] = unicode.ToLower(runes[0])
+	return string(runes)
+}
+
+func dropSpace(r rune) rune {
+	if unicode.IsSpace(r) {
+		return -1
+	}
+	return r
+}
+
+func substr(start, end int, s string) string {
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 || end > len(s) {
+		end = len(s)
+	}
+	if start > end {
+		return ""
+	}
+	return s[start:end]
+}
+
+// trunc keeps the first n characters, or the last -n if n is negative
+func trunc(n int, s string) string {
+	if n < 0 && len(s)+n > 0 {
+		return s[len(s)+n:]
+	}
+	if n >= 0 && len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+func quote(items ...interface{}) string {
+	var out []string
+	for _, item := range items {
+		if item != nil {
+			out = append(out, fmt.Sprintf("%q", toString(item)))
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func squote(items ...interface{}) string {
+	var out []string
+	for _, item := range items {
+		if item != nil {
+			out = append(out, "'"+toString(item)+"'")
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func cat(items ...interface{}) string {
+	var out []string
+	for _, item := range items {
+		if item != nil {
+			out = append(out, toString(item))
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+// joinWords splits the words by the case and separators and joins them.
+func joinWords(s, sep string, title bool) string {
+	var words []string
+	var word []rune
+	flush := func() {
+		if len(word) != 0 {
+			words = append(words, string(word))
+			word = nil
+		}
+	}
+	runes := []rune(s)
+	for i, r := range runes {
+		switch {
+		case r == '_' || r == '-' || unicode.IsSpace(r):
+			flush()
+		case unicode.IsUpper(r) && i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])):
+			flush()
+			word = append(word, r)
+		default:
+			word = append(word, r)
+		}
+	}
+	flush()
+	for i, w := range words {
+		if title {
+			words[i] = strings.Title(strings.ToLower(w))
+		} else {
+			words[i] = strings.ToLower(w)
+		}
+	}
+	return strings.Join(words, sep)
+}
+
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func toStrings(v interface{}) []string {
+	list := toList(v)
+	out := make([]string, 0, len(list))
+	for _, item := range list {
+		out = append(out, toString(item))
+	}
+	return out
+}
+
+// split returns a map of _0, _1 ... like Sprig
+func split(sep, s string) map[string]string {
+	out := map[string]string{}
+	for i, part := range strings.Split(s, sep) {
+		out["_"+strconv.Itoa(i)] = part
+	}
+	return out
+}
+
+func join(sep string, v interface{}) string {
+	return strings.Join(toStrings(v), sep)
+}
+
+func sortAlpha(v interface{}) []string {
+	out := toStrings(v)
+	sort.Strings(out)
+	return out
+}
+
+func b64dec(s string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func randString(n int, chars string) (string, error) {
+	out := make([]byte, n)
+	for i := range out {
+		index, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+		if err != nil {
+			return "", err
+		}
+		out[i] = chars[index.Int64()]
+	}
+	return string(out), nil
+}
+
+func uuidv4() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// empty returns true for nil, zero values and empty collections
+func empty(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return true
+	}
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Bool:
+		return !rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	default:
+		return false
+	}
+}
+
+// defaultValue is `default DEFAULT VALUE`, the value is the piped one
+func defaultValue(d interface{}, given ...interface{}) interface{} {
+	if len(given) == 0 || empty(given[0]) {
+		return d
+	}
+	return given[0]
+}
+
+func coalesce(items ...interface{}) interface{} {
+	for _, item := range items {
+		if !empty(item) {
+			return item
+		}
+	}
+	return nil
+}
+
+func required(msg string, v interface{}) (interface{}, error) {
+	if v == nil {
+		return v, errors.New(msg)
+	}
+	if s, ok := v.(string); ok && s == "" {
+		return v, errors.New(msg)
+	}
+	return v, nil
+}
+
+func toJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func toPrettyJSON(v interface{}) string {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// fromJSON returns the error in the "Error" key like Helm
+func fromJSON(s string) map[string]interface{} {
+	out := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(s), &out); err != nil {
+		out["Error"] = err.Error()
+	}
+	return out
+}
+
+// toYAML returns the YAML without the trailing newline like Helm
+func toYAML(v interface{}) string {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(string(data), "\n")
+}
+
+func fromYAML(s string) map[string]interface{} {
+	out := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(s), &out); err != nil {
+		out["Error"] = err.Error()
+	}
+	return out
+}
+
+func fromYAMLArray(s string) []interface{} {
+	var out []interface{}
+	if err := yaml.Unmarshal([]byte(s), &out); err != nil {
+		return []interface{}{err.Error()}
+	}
+	return out
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.Bool:
+		if rv.Bool() {
+			return 1, true
+		}
+		return 0, true
+	case reflect.String:
+		f, err := strconv.ParseFloat(rv.String(), 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+func toInt64(v interface{}) int64 {
+	f, _ := toFloat64(v)
+	return int64(f)
+}
+
+func maxInt(a interface{}, items ...interface{}) int64 {
+	result := toInt64(a)
+	for _, item := range items {
+		if i := toInt64(item); i > result {
+			result = i
+		}
+	}
+	return result
+}
+
+func minInt(a interface{}, items ...interface{}) int64 {
+	result := toInt64(a)
+	for _, item := range items {
+		if i := toInt64(item); i < result {
+			result = i
+		}
+	}
+	return result
+}
+
+func until(count int) []int {
+	out := make([]int, 0, count)
+	for i := 0; i < count; i++ {
+		out = append(out, i)
+	}
+	return out
+}
+
+// toList converts any slice or array to []interface{}, nil for the others
+func toList(v interface{}) []interface{} {
+	if list, ok := v.([]interface{}); ok {
+		return list
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil
+	}
+	out := make([]interface{}, rv.Len())
+	for i := range out {
+		out[i] = rv.Index(i).Interface()
+	}
+	return out
+}
+
+func first(v interface{}) interface{} {
+	list := toList(v)
+	if len(list) == 0 {
+		return nil
+	}
+	return list[0]
+}
+
+func last(v interface{}) interface{} {
+	list := toList(v)
+	if len(list) == 0 {
+		return nil
+	}
+	return list[len(list)-1]
+}
+
+func rest(v interface{}) []interface{} {
+	list := toList(v)
+	if len(list) == 0 {
+		return nil
+	}
+	return list[1:]
+}
+
+func initial(v interface{}) []interface{} {
+	list := toList(v)
+	if len(list) == 0 {
+		return nil
+	}
+	return list[:len(list)-1]
+}
+
+func appendList(v interface{}, item interface{}) []interface{} {
+	list := toList(v)
+	return append(append([]interface{}{}, list...), item)
+}
+
+func prependList(v interface{}, item interface{}) []interface{} {
+	return append([]interface{}{item}, toList(v)...)
+}
+
+func concat(lists ...interface{}) []interface{} {
+	var out []interface{}
+	for _, list := range lists {
+		out = append(out, toList(list)...)
+	}
+	return out
+}
+
+func reverse(v interface{}) []interface{} {
+	list := toList(v)
+	out := make([]interface{}, len(list))
+	for i, item := range list {
+		out[len(list)-1-i] = item
+	}
+	return out
+}
+
+func uniq(v interface{}) []interface{} {
+	var out []interface{}
+	for _, item := range toList(v) {
+		if !has(item, out) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func without(v interface{}, omitted ...interface{}) []interface{} {
+	var out []interface{}
+	for _, item := range toList(v) {
+		if !has(item, omitted) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func has(needle interface{}, v interface{}) bool {
+	for _, item := range toList(v) {
+		if reflect.DeepEqual(item, needle) {
+			return true
+		}
+	}
+	return false
+}
+
+func compact(v interface{}) []interface{} {
+	var out []interface{}
+	for _, item := range toList(v) {
+		if !empty(item) {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func dict(items ...interface{}) map[string]interface{} {
+	out := map[string]interface{}{}
+	for i := 0; i < len(items); i += 2 {
+		var v interface{}
+		if i+1 < len(items) {
+			v = items[i+1]
+		}
+		out[toString(items[i])] = v
+	}
+	return out
+}
+
+func pluck(key string, dicts ...map[string]interface{}) []interface{} {
+	var out []interface{}
+	for _, d := range dicts {
+		if v, ok := d[key]; ok {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func keys(dicts ...map[string]interface{}) []string {
+	var out []string
+	for _, d := range dicts {
+		for k := range d {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+func values(d map[string]interface{}) []interface{} {
+	out := make([]interface{}, 0, len(d))
+	for _, v := range d {
+		out = append(out, v)
+	}
+	return out
+}
+
+func pick(d map[string]interface{}, keys ...string) map[string]interface{} {
+	out := map[string]interface{}{}
+	for _, k := range keys {
+		if v, ok := d[k]; ok {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func omit(d map[string]interface{}, keys ...string) map[string]interface{} {
+	out := map[string]interface{}{}
+	for k, v := range d {
+		out[k] = v
+	}
+	for _, k := range keys {
+		delete(out, k)
+	}
+	return out
+}
+
+// merge merges the sources into dst, the existing keys of dst are kept
+func merge(dst map[string]interface{}, srcs ...map[string]interface{}) map[string]interface{} {
+	for _, src := range srcs {
+		for k, v := range src {
+			srcMap, ok := v.(map[string]interface{})
+			dstMap, dstOk := dst[k].(map[string]interface{})
+			switch {
+			case ok && dstOk:
+				merge(dstMap, srcMap)
+			case !hasKeyValue(dst, k):
+				dst[k] = v
+			}
+		}
+	}
+	return dst
+}
+
+func hasKeyValue(d map[string]interface{}, k string) bool {
+	v, ok := d[k]
+	return ok && v != nil
+}
+
+// mergeOverwrite merges the sources into dst, the later ones win
+func mergeOverwrite(dst map[string]interface{}, srcs ...map[string]interface{}) map[string]interface{} {
+	for _, src := range srcs {
+		for k, v := range src {
+			srcMap, ok := v.(map[string]interface{})
+			dstMap, dstOk := dst[k].(map[string]interface{})
+			if ok && dstOk {
+				mergeOverwrite(dstMap, srcMap)
+				continue
+			}
+			dst[k] = v
+		}
+	}
+	return dst
+}
+
+// dig is `dig "a" "b" DEFAULT DICT`
+func dig(items ...interface{}) (interface{}, error) {
+	if len(items) < 3 {
+		return nil, errors.New("dig requires keys, a default value and a dict")
+	}
+	d, ok := items[len(items)-1].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("the last argument of dig should be a dict")
+	}
+	def := items[len(items)-2]
+	var current interface{} = d
+	for _, key := range items[:len(items)-2] {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return def, nil
+		}
+		if current, ok = m[toString(key)]; !ok {
+			return def, nil
+		}
+	}
+	return current, nil
+}
+
+var semverRegexp = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?`)
+
+func parseSemver(s string) ([3]int64, bool) {
+	var v [3]int64
+	matches := semverRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if matches == nil {
+		return v, false
+	}
+	for i := 0; i < 3; i++ {
+		if matches[i+1] != "" {
+			v[i], _ = strconv.ParseInt(matches[i+1], 10, 64)
+		}
+	}
+	return v, true
+}
+
+func compareSemver(a, b [3]int64) int {
+	for i := 0; i < 3; i++ {
+		switch {
+		case a[i] < b[i]:
+			return -1
+		case a[i] > b[i]:
+			return 1
+		}
+	}
+	return 0
+}
+
+// semverCompare checks the version by the constraint like ">=1.19-0", "~1.20"
+// or "^1.2 || >=2.1, <3". The pre-release suffixes are ignored.
+func semverCompare(constraint, version string) (bool, error) {
+	v, ok := parseSemver(version)
+	if !ok {
+		return false, errors.Errorf("invalid version %q", version)
+	}
+	for _, or := range strings.Split(constraint, "||") {
+		matched := true
+		for _, cond := range strings.FieldsFunc(or, func(r rune) bool { return r == ',' || r == ' ' }) {
+			ok, err := matchSemver(cond, v)
+			if err != nil {
+				return false, err
+			}
+			matched = matched && ok
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func matchSemver(cond string, v [3]int64) (bool, error) {
+	op := cond[:len(cond)-len(strings.TrimLeft(cond, "=<>!~^"))]
+	want, ok := parseSemver(strings.TrimPrefix(cond, op))
+	if !ok {
+		return false, errors.Errorf("invalid constraint %q", cond)
+	}
+	c := compareSemver(v, want)
+	switch op {
+	case "", "=", "==":
+		return c == 0, nil
+	case "!=":
+		return c != 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=", "=>":
+		return c >= 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=", "=<":
+		return c <= 0, nil
+	case "~":
+		return c >= 0 && v[0] == want[0] && v[1] == want[1], nil
+	case "^":
+		return c >= 0 && v[0] == want[0], nil
+	default:
+		return false, errors.Errorf("invalid constraint %q", cond)
+	}
+}
@@ -0,0 +1,259 @@
+package gokubectl
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+// executeTemplate executes the text with the functions of charts
+func executeTemplate(text string, data interface{}) (string, error) {
+	root := template.New("gotpl").Option("missingkey=zero")
+	root.Funcs(chartFuncs(root, chartLookup(context.Background(), nil)))
+	if _, err := root.New("test").Parse(text); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := root.ExecuteTemplate(&buf, "test", data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func TestChartFuncs(t *testing.T) {
+	data := map[string]interface{}{
+		"name":    "web",
+		"empty":   "",
+		"list":    []interface{}{"b", "a", "c", "a"},
+		"numbers": []interface{}{int64(1), int64(0), int64(3)},
+		"dict":    map[string]interface{}{"a": int64(1), "b": map[string]interface{}{"c": "deep"}},
+		"float":   float64(2.5),
+	}
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		// Strings
+		{"trim", `{{ trim "  a b  " }}`, "a b"},
+		{"trimAll", `{{ trimAll "$" "$5.00$" }}`, "5.00"},
+		{"trimPrefix", `{{ trimPrefix "v" "v1.2" }}`, "1.2"},
+		{"trimSuffix", `{{ trimSuffix ".yaml" "app.yaml" }}`, "app"},
+		{"upper", `{{ upper "web" }}`, "WEB"},
+		{"lower", `{{ lower "WEB" }}`, "web"},
+		{"title", `{{ title "hello world" }}`, "Hello World"},
+		{"untitle", `{{ untitle "Hello World" }}`, "hello World"},
+		{"repeat", `{{ repeat 3 "ab" }}`, "ababab"},
+		{"substr", `{{ substr 1 3 "hello" }}`, "el"},
+		{"substr out of range", `{{ substr -1 10 "hello" }}`, "hello"},
+		{"nospace", `{{ nospace "a b\tc\n" }}`, "abc"},
+		{"trunc", `{{ trunc 3 "hello" }}`, "hel"},
+		{"trunc negative", `{{ trunc -3 "hello" }}`, "llo"},
+		{"trunc short", `{{ trunc 10 "hello" }}`, "hello"},
+		{"contains", `{{ contains "ell" "hello" }}`, "true"},
+		{"hasPrefix", `{{ hasPrefix "he" "hello" }}`, "true"},
+		{"hasSuffix", `{{ hasSuffix "he" "hello" }}`, "false"},
+		{"quote", `{{ quote "a" 1 nil }}`, `"a" "1"`},
+		{"quote escape", `{{ "a\"b" | quote }}`, `"a\"b"`},
+		{"squote", `{{ squote "a" 2 }}`, "'a' '2'"},
+		{"cat", `{{ cat "a" 1 nil "b" }}`, "a 1 b"},
+		{"indent", `{{ indent 2 "a\nb" }}`, "  a\n  b"},
+		{"nindent", `{{ nindent 2 "a" }}`, "\n  a"},
+		{"replace", `{{ replace "-" "_" "a-b-c" }}`, "a_b_c"},
+		{"snakecase", `{{ snakecase "FirstName" }}`, "first_name"},
+		{"kebabcase", `{{ kebabcase "firstName2Last" }}`, "first-name2-last"},
+		{"camelcase", `{{ camelcase "http_server-name" }}`, "HttpServerName"},
+		{"toString", `{{ toString .float }}`, "2.5"},
+		{"toStrings", `{{ toStrings .numbers }}`, "[1 0 3]"},
+		{"split", `{{ $p := split "." "a.b.c" }}{{ $p._1 }}`, "b"},
+		{"splitList", `{{ splitList "," "a,b" }}`, "[a b]"},
+		{"join", `{{ join "," .list }}`, "b,a,c,a"},
+		{"sortAlpha", `{{ sortAlpha .list }}`, "[a a b c]"},
+		{"b64enc", `{{ b64enc "hello" }}`, "aGVsbG8="},
+		{"b64dec", `{{ b64dec "aGVsbG8=" }}`, "hello"},
+		{"sha1sum", `{{ sha1sum "abc" }}`, "a9993e364706816aba3e25717850c26c9cd0d89d"},
+		{"sha256sum", `{{ sha256sum "abc" }}`, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+
+		// Regexp
+		{"regexMatch", `{{ regexMatch "^[a-z]+$" "web" }}`, "true"},
+		{"regexFind", `{{ regexFind "[0-9]+" "web123abc" }}`, "123"},
+		{"regexReplaceAll", `{{ regexReplaceAll "a(x*)b" "-ab-axxb-" "${1}W" }}`, "-W-xxW-"},
+		{"regexReplaceAllLiteral", `{{ regexReplaceAllLiteral "a(x*)b" "-ab-axxb-" "${1}" }}`, "-${1}-${1}-"},
+
+		// Defaults and flow
+		{"default empty", `{{ .empty | default "foo" }}`, "foo"},
+		{"default missing", `{{ .missing | default "foo" }}`, "foo"},
+		{"default given", `{{ .name | default "foo" }}`, "web"},
+		{"default zero", `{{ 0 | default 5 }}`, "5"},
+		{"empty", `{{ empty .empty }} {{ empty .list }} {{ empty false }} {{ empty .missing }}`, "true false true true"},
+		{"coalesce", `{{ coalesce .missing .empty "x" "y" }}`, "x"},
+		{"ternary", `{{ ternary "yes" "no" true }} {{ false | ternary "yes" "no" }}`, "yes no"},
+		{"required", `{{ required "name is required" .name }}`, "web"},
+
+		// Encoding
+		{"toJson", `{{ toJson .dict }}`, `{"a":1,"b":{"c":"deep"}}`},
+		{"toPrettyJson", `{{ toPrettyJson (dict "a" 1) }}`, "{\n  \"a\": 1\n}"},
+		{"fromJson", `{{ (fromJson "{\"a\": \"b\"}").a }}`, "b"},
+		{"fromJson error", `{{ hasKey (fromJson "{") "Error" }}`, "true"},
+		{"toYaml", `{{ toYaml .dict }}`, "a: 1\nb:\n  c: deep"},
+		{"fromYaml", `{{ (fromYaml "a: {b: c}").a.b }}`, "c"},
+		{"fromYamlArray", `{{ index (fromYamlArray "[a, b]") 1 }}`, "b"},
+
+		// Types
+		{"kindOf", `{{ kindOf .list }} {{ kindOf .dict }} {{ kindOf .name }}`, "slice map string"},
+		{"kindIs", `{{ kindIs "map" .dict }}`, "true"},
+		{"typeOf", `{{ typeOf .float }}`, "float64"},
+		{"typeIs", `{{ typeIs "string" .name }}`, "true"},
+		{"int", `{{ int "42" }} {{ int .float }}`, "42 2"},
+		{"int64", `{{ int64 "42" }}`, "42"},
+		{"float64", `{{ float64 "1.5" }}`, "1.5"},
+		{"atoi", `{{ atoi "42" }} {{ atoi "x" }}`, "42 0"},
+
+		// Math
+		{"add", `{{ add 1 "2" }}`, "3"},
+		{"add1", `{{ add1 41 }}`, "42"},
+		{"sub", `{{ sub 5 2 }}`, "3"},
+		{"mul", `{{ mul 3 4 }}`, "12"},
+		{"div", `{{ div 7 2 }}`, "3"},
+		{"mod", `{{ mod 7 2 }}`, "1"},
+		{"max", `{{ max 1 5 3 }}`, "5"},
+		{"min", `{{ min 4 2 3 }}`, "2"},
+		{"until", `{{ until 3 }}`, "[0 1 2]"},
+
+		// Lists
+		{"list", `{{ list 1 "a" }}`, "[1 a]"},
+		{"tuple", `{{ tuple 1 2 }}`, "[1 2]"},
+		{"first", `{{ first .list }}`, "b"},
+		{"last", `{{ last .list }}`, "a"},
+		{"rest", `{{ rest .list }}`, "[a c a]"},
+		{"initial", `{{ initial .list }}`, "[b a c]"},
+		{"append", `{{ append .list "d" }} {{ .list }}`, "[b a c a d] [b a c a]"},
+		{"push", `{{ push (list 1) 2 }}`, "[1 2]"},
+		{"prepend", `{{ prepend .list "z" }}`, "[z b a c a]"},
+		{"concat", `{{ concat (list 1) (list 2 3) }}`, "[1 2 3]"},
+		{"reverse", `{{ reverse .list }}`, "[a c a b]"},
+		{"uniq", `{{ uniq .list }}`, "[b a c]"},
+		{"without", `{{ without .list "a" }}`, "[b c]"},
+		{"has", `{{ has "c" .list }} {{ has "d" .list }}`, "true false"},
+		{"compact", `{{ compact .numbers }}`, "[1 3]"},
+
+		// Dicts
+		{"dict", `{{ $d := dict "a" 1 "b" }}{{ $d.a }} {{ hasKey $d "b" }}`, "1 true"},
+		{"get", `{{ get .dict "a" }}`, "1"},
+		{"set", `{{ $d := dict }}{{ $_ := set $d "a" 1 }}{{ $d.a }}`, "1"},
+		{"unset", `{{ $d := dict "a" 1 }}{{ $_ := unset $d "a" }}{{ hasKey $d "a" }}`, "false"},
+		{"hasKey", `{{ hasKey .dict "b" }} {{ hasKey .dict "z" }}`, "true false"},
+		{"pluck", `{{ pluck "a" (dict "a" 1) (dict "b" 2) (dict "a" 3) }}`, "[1 3]"},
+		{"keys", `{{ keys .dict | sortAlpha }}`, "[a b]"},
+		{"values", `{{ values (dict "a" 1) }}`, "[1]"},
+		{"pick", `{{ pick .dict "a" "z" }}`, "map[a:1]"},
+		{"omit", `{{ omit .dict "b" }}`, "map[a:1]"},
+		{"merge", `{{ merge (dict "a" 1 "n" nil) (dict "a" 2 "b" 3 "n" 4) }}`, "map[a:1 b:3 n:4]"},
+		{"mergeOverwrite", `{{ mergeOverwrite (dict "a" 1) (dict "a" 2 "b" 3) }}`, "map[a:2 b:3]"},
+		{"deepCopy", `{{ $c := deepCopy .dict }}{{ $_ := set $c.b "c" "x" }}{{ .dict.b.c }}`, "deep"},
+		{"dig", `{{ dig "b" "c" "none" .dict }} {{ dig "b" "z" "none" .dict }}`, "deep none"},
+
+		// Versions
+		{"semverCompare", `{{ semverCompare ">=1.19-0" "v1.20.4" }}`, "true"},
+		{"semverCompare less", `{{ semverCompare "<1.19" "1.20.0" }}`, "false"},
+		{"semverCompare tilde", `{{ semverCompare "~1.20" "1.20.9" }} {{ semverCompare "~1.20" "1.21.0" }}`, "true false"},
+		{"semverCompare caret", `{{ semverCompare "^1.2" "1.9.0" }} {{ semverCompare "^1.2" "2.0.0" }}`, "true false"},
+		{"semverCompare or", `{{ semverCompare "<1.0 || >=2.1, <3" "2.5.0" }}`, "true"},
+		{"date", `{{ date "2006" now | len }}`, "4"},
+
+		// Helm
+		{"include", `{{ define "x" }}[{{ . }}]{{ end }}{{ include "x" "a" | upper }}`, "[A]"},
+		{"tpl", `{{ tpl "{{ .name }}-{{ .missing }}" . }}`, "web-"},
+		{"lookup without cluster", `{{ lookup "v1" "Secret" "default" "web" | len }}`, "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := executeTemplate(tt.text, data)
+			if err != nil {
+				t.Fatalf("execute %s failed: %v", tt.text, err)
+			}
+			if got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChartFuncsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"fail", `{{ fail "stop here" }}`, "stop here"},
+		{"required", `{{ required "name is required" .missing }}`, "name is required"},
+		{"required empty", `{{ required "name is required" "" }}`, "name is required"},
+		{"b64dec", `{{ b64dec "!" }}`, "illegal base64"},
+		{"regexMatch", `{{ regexMatch "(" "a" }}`, "missing closing )"},
+		{"dig", `{{ dig "a" "b" }}`, "dig requires"},
+		{"semverCompare", `{{ semverCompare ">=1.19" "latest" }}`, "invalid version"},
+		{"semverCompare constraint", `{{ semverCompare "?1" "1.0.0" }}`, "invalid constraint"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := executeTemplate(tt.text, map[string]interface{}{})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%s err = %v, want %q", tt.text, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestChartFuncsRandom(t *testing.T) {
+	tests := []struct {
+		text    string
+		pattern string
+	}{
+		{`{{ randAlphaNum 16 }}`, `^[a-zA-Z0-9]{16}$`},
+		{`{{ randAlpha 8 }}`, `^[a-zA-Z]{8}$`},
+		{`{{ randNumeric 6 }}`, `^[0-9]{6}$`},
+		{`{{ uuidv4 }}`, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`},
+	}
+	for _, tt := range tests {
+		got, err := executeTemplate(tt.text, nil)
+		if err != nil {
+			t.Fatalf("execute %s failed: %v", tt.text, err)
+		}
+		if !regexp.MustCompile(tt.pattern).MatchString(got) {
+			t.Errorf("%s = %q, want match %s", tt.text, got, tt.pattern)
+		}
+	}
+}
+
+func TestChartFiles(t *testing.T) {
+	files := newChartFiles(map[string][]byte{
+		chartFile:               []byte("name: web"),
+		valuesFile:              []byte("a: 1"),
+		"templates/app.yaml":    []byte("kind: Pod"),
+		"config/app.conf":       []byte("port=80\nhost=web\n"),
+		"config/nginx.conf":     []byte("server {}"),
+		"scripts/entrypoint.sh": []byte("#!/bin/sh"),
+	})
+	if len(files) != 3 {
+		t.Errorf("newChartFiles() = %d files, want 3 without Chart.yaml, values.yaml and templates", len(files))
+	}
+	if got := files.Get("config/app.conf"); got != "port=80\nhost=web\n" {
+		t.Errorf("Get() = %q", got)
+	}
+	if got := files.Lines("config/app.conf"); len(got) != 2 || got[1] != "host=web" {
+		t.Errorf("Lines() = %q, want 2 lines", got)
+	}
+	glob := files.Glob("config/*")
+	if len(glob) != 2 {
+		t.Errorf("Glob() = %d files, want 2", len(glob))
+	}
+	if got, want := glob.AsConfig(), "app.conf: |\n  port=80\n  host=web\nnginx.conf: server {}"; got != want {
+		t.Errorf("AsConfig() = %q, want %q", got, want)
+	}
+	if got, want := files.Glob("scripts/*").AsSecrets(), "entrypoint.sh: IyEvYmluL3No"; got != want {
+		t.Errorf("AsSecrets() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,122 @@
+package gokubectl
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files of testdata")
+
+// renderGolden renders the manifests with their sources like `helm template`
+func renderGolden(manifests []Manifest) []byte {
+	var buf bytes.Buffer
+	for _, m := range manifests {
+		fmt.Fprintf(&buf, "---\n# Source: %s\n", m.Source.File)
+		buf.Write(m.Data)
+		if !bytes.HasSuffix(m.Data, []byte("\n")) {
+			buf.WriteString("\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestRenderChartGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		golden string
+		opts   ChartOptions
+	}{
+		{
+			name:   "defaults",
+			golden: "web.golden",
+			opts:   ChartOptions{ReleaseName: "shop", Namespace: "prod"},
+		},
+		{
+			name:   "values and capabilities",
+			golden: "web-values.golden",
+			opts: ChartOptions{
+				ReleaseName: "shop",
+				Revision:    3,
+				ValuesFiles: []string{filepath.Join("testdata", "charts", "prod-values.yaml")},
+				Values:      map[string]interface{}{"image": map[string]interface{}{"tag": "1.21"}},
+				KubeVersion: "v1.8.15",
+				APIVersions: []string{"monitoring.coreos.com/v1"},
+				SkipCRDs:    true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifests, err := RenderChart(context.Background(), "", filepath.Join("testdata", "charts", "web"), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := renderGolden(manifests)
+
+			golden := filepath.Join("testdata", "charts", tt.golden)
+			if *updateGolden {
+				if err = ioutil.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("RenderChart() differs from %s, run go test -update to update it\ngot:\n%s", golden, got)
+			}
+		})
+	}
+}
+
+func TestRenderChartArchiveMissing(t *testing.T) {
+	if _, err := RenderChart(context.Background(), "", filepath.Join("testdata", "charts", "missing.tgz"), ChartOptions{}); err == nil {
+		t.Error("RenderChart() of a missing chart succeeds")
+	}
+}
+
+func TestMergeValues(t *testing.T) {
+	dst := map[string]interface{}{
+		"image":   map[string]interface{}{"repository": "nginx", "tag": "1.19"},
+		"service": map[string]interface{}{"port": 80},
+		"debug":   true,
+	}
+	src := map[string]interface{}{
+		"image": map[string]interface{}{"tag": "1.21"},
+		// null deletes the key like Helm
+		"debug": nil,
+		"extra": []interface{}{"a"},
+	}
+	want := map[string]interface{}{
+		"image":   map[string]interface{}{"repository": "nginx", "tag": "1.21"},
+		"service": map[string]interface{}{"port": 80},
+		"extra":   []interface{}{"a"},
+	}
+	if got := mergeValues(dst, src); !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeValues() = %v, want %v", got, want)
+	}
+}
+
+func TestUninstallOrder(t *testing.T) {
+	manifests := []Manifest{
+		{Data: []byte("kind: Service")},
+		{Data: []byte("kind: Namespace")},
+		{Data: []byte("kind: Deployment")},
+		{Data: []byte("kind: CronTab")},
+	}
+	var got []string
+	for _, m := range UninstallOrder(manifests) {
+		got = append(got, manifestKind(m))
+	}
+	want := []string{"CronTab", "Deployment", "Service", "Namespace"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("UninstallOrder() = %v, want %v", got, want)
+	}
+}
@@ -1,163 +1,510 @@
 package gokubectl
 
 import (
-	"bufio"
-	"bytes"
 	"context"
-	"fmt"
-	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/serializer/yaml"
 	"k8s.io/apimachinery/pkg/types"
-	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/dynamic"
 
 	"github.com/penglongli/kubernetes-demo/kubectl-golang/k8s"
 )
 
+const (
+	defaultFieldManager = "kubectl-golang"
+)
+
 var (
 	decUnstructured = yaml.NewDecodingSerializer(unstructured.UnstructuredJSONScheme)
 )
 
+// DiscoveryOptions persist the API discovery of the cluster to disk, so that the
+// later processes skip it. It's shared by the options of all the operations.
+type DiscoveryOptions struct {
+	// DiscoveryCacheDir is the dir of the disk cache like ~/.kube/cache/discovery
+	// of kubectl, the discovery is cached in memory only if it's empty.
+	DiscoveryCacheDir string
+	// DiscoveryCacheTTL is the max age of the disk cache, default 10 minutes.
+	DiscoveryCacheTTL time.Duration
+}
+
+type ApplyOptions struct {
+	NamespaceOptions
+	DiscoveryOptions
+
+	// FieldManager is the manager name of server-side apply, default "kubectl-golang".
+	FieldManager string
+	// Force takes the ownership of the fields conflicted with other managers.
+	Force bool
+
+	// Render renders the manifest before apply if not nil
+	Render *RenderOptions
+	// Validation validates the documents against the OpenAPI schema of cluster
+	Validation ValidationMode
+	// AgeIdentities decrypt the manifests encrypted by SOPS or age, see
+	// ReadAgeIdentities. The encrypted manifests are rejected if it's nil.
+	AgeIdentities []*AgeIdentity
+	// ConvertDeprecated converts the deprecated apiVersions which are not served
+	// by the cluster to the served ones, the conversions are reported as warnings.
+	ConvertDeprecated bool
+	// Concurrency is the max number of objects applied at the same time. The objects
+	// are applied tier by tier in the install order of kinds, e.g. Namespaces before
+	// Deployments, and the results are still in the order of the manifests. 1 or less
+	// applies them one by one in order.
+	Concurrency int
+	// QPS and Burst limit the requests to the cluster shared by all the workers,
+	// default 5 and 10 of client-go.
+	QPS   float32
+	Burst int
+	// Policies check every object before apply, the violations are in the results.
+	// Nothing is applied if any object is denied, see DefaultPolicies.
+	Policies []PolicyRule
+	// PolicyExemptions skip the rules for the objects
+	PolicyExemptions PolicyExemptions
+
+	// Wait blocks until the applied objects are healthy, see Health. The degraded
+	// objects fail at once.
+	Wait bool
+	// WaitTimeout is the max duration of waiting, default 5m.
+	WaitTimeout time.Duration
+}
+
+func (opts *ApplyOptions) complete() {
+	if opts.FieldManager == "" {
+		opts.FieldManager = defaultFieldManager
+	}
+	if opts.WaitTimeout <= 0 {
+		opts.WaitTimeout = defaultWaitTimeout
+	}
+}
+
+type ApplyResult struct {
+	Kind      string
+	Namespace string
+	Name      string
+
+	// Message describes what has been done when Err is nil
+	Message  string
+	Err      error
+	Warnings []string
+	// Violations are the policy violations, the denied ones are in Err as well
+	Violations []PolicyViolation
+	// Health is the health of the live object if waited or checked, see
+	// ApplyOptions.Wait and CheckHealth
+	Health *HealthResult
+
+	// Source is where the object is read from
+	Source Location
+}
+
+func (r ApplyResult) String() string {
+	if r.Err != nil {
+		if r.Source.File != "" {
+			return r.Source.String() + ": " + r.Err.Error()
+		}
+		return r.Err.Error()
+	}
+	return r.Message
+}
+
 func Apply(ctx context.Context, base64KubeConfig string, data []byte) (result []string, err error) {
 	kubeClient := &k8s.KubeClient{
 		Base64KubeConfig: base64KubeConfig,
 	}
 
-	// Compare kubernetes version
-	var less116 bool
-	if less116, err = kubeClient.CompareVersion(); err != nil {
+	results, err := applyData(ctx, kubeClient, data, ApplyOptions{})
+	if err != nil {
 		return nil, err
 	}
+	for _, r := range results {
+		result = append(result, r.String())
+	}
+	return result, nil
+}
+
+// ApplyWithOptions applies the manifest and returns the result of every object,
+// the conflicts of server-side apply are returned as *ConflictError.
+func ApplyWithOptions(ctx context.Context, base64KubeConfig string, data []byte, opts ApplyOptions) ([]ApplyResult, error) {
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  base64KubeConfig,
+		DiscoveryCacheDir: opts.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.DiscoveryCacheTTL,
+		QPS:               opts.QPS,
+		Burst:             opts.Burst,
+	}
+	return applyData(ctx, kubeClient, data, opts)
+}
+
+// ApplyManifests applies the manifests read from the sources, see ReadPaths.
+func ApplyManifests(ctx context.Context, base64KubeConfig string, manifests []Manifest, opts ApplyOptions) ([]ApplyResult, error) {
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  base64KubeConfig,
+		DiscoveryCacheDir: opts.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.DiscoveryCacheTTL,
+		QPS:               opts.QPS,
+		Burst:             opts.Burst,
+	}
+	return applyManifests(ctx, kubeClient, manifests, opts)
+}
+
+func applyData(ctx context.Context, kubeClient *k8s.KubeClient, data []byte, opts ApplyOptions) ([]ApplyResult, error) {
+	manifests, err := ReadBytes("", data)
+	if err != nil {
+		return nil, err
+	}
+	return applyManifests(ctx, kubeClient, manifests, opts)
+}
+
+func applyManifests(ctx context.Context, kubeClient *k8s.KubeClient, manifests []Manifest, opts ApplyOptions) ([]ApplyResult, error) {
+	opts.complete()
+	prepared, invalid, err := prepareManifests(kubeClient, manifests, opts)
+	if err != nil || invalid != nil {
+		return invalid, err
+	}
+	result, indexes, err := applyPrepared(ctx, kubeClient, prepared, opts, false)
+	if err == nil && opts.Wait {
+		waitHealthy(ctx, kubeClient, prepared.manifests, result, indexes, opts)
+	}
+	return result, err
+}
+
+// preparedManifests are the manifests ready to apply, with the warnings and
+// policy violations of every one.
+type preparedManifests struct {
+	manifests  []Manifest
+	warnings   [][]string
+	violations [][]PolicyViolation
+}
+
+// prepareManifests decrypts, renders, converts, checks and validates the manifests
+// before apply. The invalid results are returned if any object is denied by the
+// policies or invalid in strict validation mode.
+func prepareManifests(kubeClient *k8s.KubeClient, manifests []Manifest, opts ApplyOptions) (_ *preparedManifests, invalid []ApplyResult, err error) {
+	if manifests, err = decryptManifests(manifests, opts.AgeIdentities); err != nil {
+		return nil, nil, err
+	}
+	if opts.Render != nil {
+		if manifests, err = renderManifests(kubeClient, manifests, *opts.Render); err != nil {
+			return nil, nil, errors.Wrap(err, "render manifest failed")
+		}
+	}
+
+	var converted [][]string
+	if opts.ConvertDeprecated {
+		if manifests, converted, err = convertUnserved(kubeClient, manifests); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	violations, denied := checkPolicies(manifests, opts.Policies, opts.PolicyExemptions)
+	if denied != nil {
+		return nil, denied, nil
+	}
+	warnings, invalid := validateManifests(kubeClient, manifests, opts.Validation)
+	if invalid != nil {
+		return nil, invalid, nil
+	}
+	for i := range converted {
+		warnings[i] = append(converted[i], warnings[i]...)
+	}
+	return &preparedManifests{
+		manifests:  manifests,
+		warnings:   warnings,
+		violations: violations,
+	}, nil, nil
+}
+
+// applyPrepared applies the prepared manifests tier by tier, see applyTiers. The
+// results are in the order of the manifests with their indexes, the rest are not
+// applied after the first failure if stopOnError is true.
+func applyPrepared(ctx context.Context, kubeClient *k8s.KubeClient, prepared *preparedManifests, opts ApplyOptions, stopOnError bool) (result []ApplyResult, indexes []int, err error) {
+	// Choose the apply strategy by the capabilities
+	clientSide, err := useClientSideApply(kubeClient)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	less116 = true
 	var low *lowVersion
-	if less116 {
+	if clientSide {
 		low = &lowVersion{
 			ctx:        ctx,
 			kubeClient: kubeClient,
+			nsOpts:     opts.NamespaceOptions,
 		}
 	}
 
-	chanMes, chanErr := readYaml(data)
-	for {
-		select {
-		case dataBytes, ok := <-chanMes:
-			{
-				if !ok {
-					return result, nil
-				}
-				if less116 {
-					if err = low.apply(dataBytes); err != nil {
-						fmt.Println(err.Error())
-						result = append(result, err.Error())
-					}
-					continue
-				}
-
-				// Get obj and dr
-				obj, dr, err := buildDynamicResourceClient(kubeClient, dataBytes)
-				if err != nil {
-					result = append(result, err.Error())
-					continue
-				}
-
-				// Create or Update
-				_, err = dr.Patch(ctx, obj.GetName(), types.ApplyPatchType, dataBytes, metav1.PatchOptions{
-					FieldManager: "kubectl-golang",
-				})
-				if err != nil {
-					result = append(result, err.Error())
-				} else {
-					result = append(result, obj.GetName()+" patched.")
-				}
-			}
-		case err, ok := <-chanErr:
-			if !ok {
-				return result, nil
+	apply := func(i int) ApplyResult {
+		m := prepared.manifests[i]
+		dataBytes := m.Data
+		if clientSide {
+			obj := &unstructured.Unstructured{}
+			_, _, _ = decUnstructured.Decode(dataBytes, nil, obj)
+			r := newApplyResult(obj, m.Source)
+			r.Warnings = prepared.warnings[i]
+			r.Violations = prepared.violations[i]
+			if _, err := low.apply(dataBytes); err != nil {
+				r.Err = err
+			} else {
+				r.Message = obj.GetName() + " applied."
 			}
-			if err == nil {
-				continue
-			}
-			result = append(result, err.Error())
+			redactResult(obj, &r)
+			return r
+		}
+
+		// Get obj and dr
+		obj, dr, err := buildDynamicResourceClient(kubeClient, dataBytes, opts.NamespaceOptions)
+		if err != nil {
+			return ApplyResult{Err: err, Source: m.Source}
+		}
+
+		// Create or Update
+		r := newApplyResult(obj, m.Source)
+		r.Warnings = prepared.warnings[i]
+		r.Violations = prepared.violations[i]
+		_, err = dr.Patch(ctx, obj.GetName(), types.ApplyPatchType, dataBytes, metav1.PatchOptions{
+			FieldManager: opts.FieldManager,
+			Force:        &opts.Force,
+		})
+		if err != nil {
+			r.Err = newConflictError(obj, err)
+		} else {
+			r.Message = obj.GetName() + " patched."
+		}
+		redactResult(obj, &r)
+		return r
+	}
+
+	results := make([]ApplyResult, len(prepared.manifests))
+	applied := make([]bool, len(prepared.manifests))
+	for _, tier := range applyTiers(prepared.manifests, opts.Concurrency) {
+		failed := applyTier(tier, opts.Concurrency, stopOnError, func(i int) bool {
+			results[i], applied[i] = apply(i), true
+			return results[i].Err != nil
+		})
+		if stopOnError && failed {
+			break
 		}
 	}
+	for i, r := range results {
+		if applied[i] {
+			result = append(result, r)
+			indexes = append(indexes, i)
+		}
+	}
+	return result, indexes, nil
 }
 
-func Delete(ctx context.Context, base64KubeConfig string, data []byte) (result []string, err error) {
-	kubeClient := &k8s.KubeClient{
-		Base64KubeConfig: base64KubeConfig,
+// applyTiers groups the indexes of the manifests by the install order of kinds,
+// e.g. Namespaces and CRDs before the workloads. Every manifest is a tier in the
+// order of the manifests if the objects are applied one by one.
+func applyTiers(manifests []Manifest, concurrency int) [][]int {
+	if concurrency <= 1 {
+		tiers := make([][]int, len(manifests))
+		for i := range manifests {
+			tiers[i] = []int{i}
+		}
+		return tiers
 	}
 
-	chanMes, chanErr := readYaml(data)
-	for {
-		select {
-		case dataBytes, ok := <-chanMes:
-			{
-				if !ok {
-					return result, nil
-				}
-
-				// Get obj and dr
-				obj, dr, err := buildDynamicResourceClient(kubeClient, dataBytes)
-				if err != nil {
-					result = append(result, err.Error())
-				}
-
-				// Delete
-				deletePolicy := metav1.DeletePropagationBackground
-				err = dr.Delete(ctx, obj.GetName(), metav1.DeleteOptions{
-					PropagationPolicy: &deletePolicy,
-				})
-				if err != nil {
-					result = append(result, err.Error())
-				} else {
-					result = append(result, obj.GetName()+" patched.")
-				}
-			}
-		case err, ok := <-chanErr:
-			if !ok {
-				return result, nil
-			}
-			if err == nil {
-				continue
-			}
-			result = append(result, err.Error())
+	byRank := map[int][]int{}
+	var ranks []int
+	for i, m := range manifests {
+		rank := installRank(manifestKind(m))
+		if _, ok := byRank[rank]; !ok {
+			ranks = append(ranks, rank)
 		}
+		byRank[rank] = append(byRank[rank], i)
 	}
+	sort.Ints(ranks)
+	tiers := make([][]int, 0, len(ranks))
+	for _, rank := range ranks {
+		tiers = append(tiers, byRank[rank])
+	}
+	return tiers
 }
 
-func readYaml(data []byte) (<-chan []byte, <-chan error) {
+// applyTier applies the objects of the tier by the workers, it returns true if
+// any of them failed. The rest are not started after the failure if stopOnError
+// is true, the requests are limited by the QPS of the client shared by workers.
+func applyTier(tier []int, workers int, stopOnError bool, apply func(i int) bool) bool {
+	if workers <= 1 {
+		workers = 1
+	}
 	var (
-		chanErr        = make(chan error)
-		chanBytes      = make(chan []byte)
-		multidocReader = utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, workers)
+		failed int32
 	)
+	for _, i := range tier {
+		sem <- struct{}{}
+		if stopOnError && atomic.LoadInt32(&failed) == 1 {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
 
-	go func() {
-		defer close(chanErr)
-		defer close(chanBytes)
-
-		for {
-			buf, err := multidocReader.Read()
-			if err != nil {
-				if err == io.EOF {
-					return
-				}
-				chanErr <- errors.Wrap(err, "failed to read yaml data")
-				return
+			if apply(i) {
+				atomic.StoreInt32(&failed, 1)
 			}
-			chanBytes <- buf
+		}(i)
+	}
+	wg.Wait()
+	return failed == 1
+}
+
+// useClientSideApply returns true if the cluster has no server-side apply.
+func useClientSideApply(kubeClient *k8s.KubeClient) (bool, error) {
+	capabilities, err := kubeClient.GetCapabilities()
+	if err != nil {
+		return false, err
+	}
+	return !capabilities.ServerSideApply, nil
+}
+
+// validateManifests returns the warnings of every manifest. In strict mode,
+// the results of the invalid manifests are returned if any, and nothing
+// should be applied.
+func validateManifests(kubeClient *k8s.KubeClient, manifests []Manifest, mode ValidationMode) ([][]string, []ApplyResult) {
+	warnings := make([][]string, len(manifests))
+	if mode == ValidationNone {
+		return warnings, nil
+	}
+
+	var invalid []ApplyResult
+	for i, m := range manifests {
+		obj := &unstructured.Unstructured{}
+		if _, _, err := decUnstructured.Decode(m.Data, nil, obj); err != nil {
+			// Decode error is reported by apply
+			continue
+		}
+		errs, err := validateObject(kubeClient, m.Source, obj)
+		if err != nil && mode == ValidationStrict {
+			r := newApplyResult(obj, m.Source)
+			r.Err = errors.Wrap(err, "validation failed")
+			invalid = append(invalid, r)
+			continue
 		}
-	}()
-	return chanBytes, chanErr
+		if err != nil {
+			warnings[i] = append(warnings[i], "skip validation: "+err.Error())
+			continue
+		}
+		if len(errs) == 0 {
+			continue
+		}
+		if mode == ValidationStrict {
+			r := newApplyResult(obj, m.Source)
+			r.Err = errs
+			redactResult(obj, &r)
+			invalid = append(invalid, r)
+			continue
+		}
+		for _, e := range errs {
+			warnings[i] = append(warnings[i], e.Error())
+		}
+	}
+	return warnings, invalid
+}
+
+func newApplyResult(obj *unstructured.Unstructured, source Location) ApplyResult {
+	return ApplyResult{
+		Kind:      obj.GetKind(),
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Source:    source,
+	}
 }
 
-func buildDynamicResourceClient(kubeClient *k8s.KubeClient, data []byte) (obj *unstructured.Unstructured, dr dynamic.ResourceInterface, err error) {
+func Delete(ctx context.Context, base64KubeConfig string, data []byte) (result []string, err error) {
+	return DeleteWithOptions(ctx, base64KubeConfig, data, DeleteOptions{})
+}
+
+func DeleteWithOptions(ctx context.Context, base64KubeConfig string, data []byte, opts DeleteOptions) (result []string, err error) {
+	manifests, err := ReadBytes("", data)
+	if err != nil {
+		return nil, err
+	}
+	results, err := DeleteManifests(ctx, base64KubeConfig, manifests, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range results {
+		result = append(result, r.String())
+	}
+	return result, nil
+}
+
+// DeleteManifests deletes the objects of manifests read from the sources, see ReadPaths.
+func DeleteManifests(ctx context.Context, base64KubeConfig string, manifests []Manifest, opts DeleteOptions) ([]ApplyResult, error) {
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  base64KubeConfig,
+		DiscoveryCacheDir: opts.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.DiscoveryCacheTTL,
+	}
+	return deleteManifests(ctx, kubeClient, manifests, opts)
+}
+
+func deleteManifests(ctx context.Context, kubeClient *k8s.KubeClient, manifests []Manifest, opts DeleteOptions) (result []ApplyResult, err error) {
+	opts.complete()
+
+	// The results are in the order of the manifests, the waited ones are set later
+	result = make([]ApplyResult, len(manifests))
+	var deleted []deletedObject
+	for i, m := range manifests {
+		// Get obj and dr
+		obj, dr, err := buildDynamicResourceClient(kubeClient, m.Data, opts.NamespaceOptions)
+		if err != nil {
+			result[i] = ApplyResult{Err: err, Source: m.Source}
+			continue
+		}
+
+		// Record the UID to wait for, the name may be reused after deleted
+		r := newApplyResult(obj, m.Source)
+		var uid types.UID
+		if opts.Wait {
+			var live *unstructured.Unstructured
+			if live, err = dr.Get(ctx, obj.GetName(), metav1.GetOptions{}); err == nil {
+				uid = live.GetUID()
+			}
+		}
+
+		// Delete
+		if err == nil {
+			err = dr.Delete(ctx, obj.GetName(), opts.toDeleteOptions(uid))
+		}
+		if err != nil {
+			if k8sErrors.IsNotFound(err) && opts.IgnoreNotFound {
+				r.Message = obj.GetName() + " not found, ignored."
+			} else {
+				r.Err = err
+			}
+			result[i] = r
+			continue
+		}
+		if !opts.Wait {
+			r.Message = obj.GetName() + " deleted."
+			result[i] = r
+			continue
+		}
+		deleted = append(deleted, deletedObject{obj: obj, uid: uid, dr: dr, source: m.Source, index: i})
+	}
+	waitDeleted(ctx, deleted, result, opts)
+	return result, nil
+}
+
+func buildDynamicResourceClient(kubeClient *k8s.KubeClient, data []byte, nsOpts NamespaceOptions) (obj *unstructured.Unstructured, dr dynamic.ResourceInterface, err error) {
 	// Decode YAML manifest into unstructured.Unstructured
 	obj = &unstructured.Unstructured{}
 	_, gvk, err := decUnstructured.Decode(data, nil, obj)
@@ -165,16 +512,15 @@ func buildDynamicResourceClient(kubeClient *k8s.KubeClient, data []byte) (obj *u
 		return obj, dr, errors.Wrap(err, "Decode yaml failed. ")
 	}
 
-	// Prepare discovery mapper
-	mapper, err := kubeClient.GetDiscoveryMapper()
+	// Find GVR, the mapper is cached by kubeClient
+	mapping, err := kubeClient.RESTMapping(gvk.GroupKind(), gvk.Version)
 	if err != nil {
-		return obj, dr, errors.Wrap(err, "Prepare discovery mapper failed")
+		return obj, dr, errors.Wrap(err, "Mapping kind with version failed")
 	}
 
-	// Find GVR
-	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
-	if err != nil {
-		return obj, dr, errors.Wrap(err, "Mapping kind with version failed")
+	// Default and check the namespace by scope
+	if err = nsOpts.resolveNamespace(obj, mapping); err != nil {
+		return obj, dr, err
 	}
 
 	// Prepare dynamic client
@@ -0,0 +1,121 @@
+package gokubectl
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestApplyTiers(t *testing.T) {
+	manifests := []Manifest{
+		{Data: []byte("kind: Deployment")},
+		{Data: []byte("kind: ConfigMap")},
+		{Data: []byte("kind: Widget")},
+		{Data: []byte("kind: Namespace")},
+		{Data: []byte("kind: Secret")},
+		{Data: []byte("kind: Deployment")},
+		{Data: []byte("kind: ConfigMap")},
+	}
+	tests := []struct {
+		concurrency int
+		want        [][]int
+	}{
+		// One by one in the order of the manifests
+		{concurrency: 0, want: [][]int{{0}, {1}, {2}, {3}, {4}, {5}, {6}}},
+		{concurrency: 1, want: [][]int{{0}, {1}, {2}, {3}, {4}, {5}, {6}}},
+		// The unknown kinds are the last tier
+		{concurrency: 4, want: [][]int{{3}, {4}, {1, 6}, {0, 5}, {2}}},
+	}
+	for _, tt := range tests {
+		if got := applyTiers(manifests, tt.concurrency); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("applyTiers(%d) = %v, want %v", tt.concurrency, got, tt.want)
+		}
+	}
+}
+
+func TestApplyTierWorkers(t *testing.T) {
+	var running, maxRunning int32
+	var applied []int
+	var mu sync.Mutex
+	failed := applyTier([]int{0, 1, 2, 3, 4, 5, 6, 7}, 3, false, func(i int) bool {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		mu.Lock()
+		if n > maxRunning {
+			maxRunning = n
+		}
+		applied = append(applied, i)
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		return i == 2
+	})
+	if !failed {
+		t.Error("applyTier() = false, want failed")
+	}
+	// All are applied without stopOnError
+	if len(applied) != 8 {
+		t.Errorf("applied = %v, want all", applied)
+	}
+	if maxRunning > 3 || maxRunning < 2 {
+		t.Errorf("max running = %d, want at most 3 workers at the same time", maxRunning)
+	}
+}
+
+func TestApplyTierStopOnError(t *testing.T) {
+	var applied []int
+	failed := applyTier([]int{0, 1, 2, 3}, 1, true, func(i int) bool {
+		applied = append(applied, i)
+		return i == 1
+	})
+	if !failed || !reflect.DeepEqual(applied, []int{0, 1}) {
+		t.Errorf("applyTier() = %v with %v applied, want failed at 1", failed, applied)
+	}
+	if failed = applyTier(nil, 2, true, func(int) bool { return true }); failed {
+		t.Error("applyTier() of the empty tier failed")
+	}
+}
+
+func TestApplyConcurrency(t *testing.T) {
+	store := newTestObjectStore()
+	b64 := testAPIServer(t, store.ServeHTTP)
+
+	manifests := []Manifest{
+		{Data: []byte(testConfigMapManifest("a"))},
+		{Data: []byte(testConfigMapManifest("b"))},
+		{Data: []byte("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: shop\n")},
+		{Data: []byte(testConfigMapManifest("c"))},
+	}
+	results, err := ApplyManifests(context.Background(), b64, manifests, ApplyOptions{
+		NamespaceOptions: NamespaceOptions{DefaultNamespace: "shop"},
+		Concurrency:      4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The results are in the order of the manifests
+	var names []string
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("apply %s failed: %v", r.Name, r.Err)
+		}
+		names = append(names, r.Name)
+	}
+	if want := []string{"a", "b", "shop", "c"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("results = %v, want %v", names, want)
+	}
+
+	// The Namespace is applied before the ConfigMaps in it
+	var patches []string
+	for _, request := range store.requests {
+		if strings.HasPrefix(request, "PATCH ") {
+			patches = append(patches, request)
+		}
+	}
+	if len(patches) != 4 || patches[0] != "PATCH /api/v1/namespaces/shop" {
+		t.Errorf("patches = %v, want the Namespace first", patches)
+	}
+}
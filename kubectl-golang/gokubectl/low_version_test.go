@@ -0,0 +1,250 @@
+package gokubectl
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// scriptedResource returns the live objects of Get in order, and fails the
+// first Create and Patch with the errors.
+type scriptedResource struct {
+	dynamic.ResourceInterface
+	lives     []*unstructured.Unstructured
+	createErr error
+	patchErr  error
+
+	calls   []string
+	patches []string
+}
+
+func (r *scriptedResource) Get(ctx context.Context, name string, options metav1.GetOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	r.calls = append(r.calls, "get")
+	live := r.lives[0]
+	if len(r.lives) > 1 {
+		r.lives = r.lives[1:]
+	}
+	if live == nil {
+		return nil, k8sErrors.NewNotFound(schema.GroupResource{Resource: "deployments"}, name)
+	}
+	return live.DeepCopy(), nil
+}
+
+func (r *scriptedResource) Create(ctx context.Context, obj *unstructured.Unstructured, options metav1.CreateOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	r.calls = append(r.calls, "create")
+	if err := r.createErr; err != nil {
+		r.createErr = nil
+		return nil, err
+	}
+	return obj, nil
+}
+
+func (r *scriptedResource) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, options metav1.PatchOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	r.calls = append(r.calls, "patch")
+	r.patches = append(r.patches, string(pt)+" "+string(data))
+	if err := r.patchErr; err != nil {
+		r.patchErr = nil
+		return nil, err
+	}
+	return &unstructured.Unstructured{}, nil
+}
+
+const appliedDeployment = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:2.0
+`
+
+func testApplied(t *testing.T, manifest string) *unstructured.Unstructured {
+	t.Helper()
+	obj, err := setLastAppliedConfiguration(decodeTestObject(t, manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+func TestCreateApplyPatchStrategic(t *testing.T) {
+	original := testApplied(t, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:1.0
+`)
+	// The sidecar is injected by others
+	current := decodeTestObject(t, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:1.0
+      - name: sidecar
+        image: envoy:1.16
+`)
+	current.SetAnnotations(original.GetAnnotations())
+
+	modified := testApplied(t, appliedDeployment)
+	patchType, patch, err := createApplyPatch(modified, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patchType != types.StrategicMergePatchType {
+		t.Errorf("patch type = %s, want %s", patchType, types.StrategicMergePatchType)
+	}
+	var got map[string]interface{}
+	if err = json.Unmarshal(patch, &got); err != nil {
+		t.Fatal(err)
+	}
+	unstructured.RemoveNestedField(got, "metadata")
+	// The container is merged by name, the sidecar is kept
+	want := decodeTestJSON(t, `{"spec":{"replicas":null,"template":{"spec":{"$setElementOrder/containers":[{"name":"web"}],"containers":[{"image":"web:2.0","name":"web"}]}}}}`)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("createApplyPatch() = %s, want %v", patch, want)
+	}
+}
+
+func TestCreateApplyPatchCustomResource(t *testing.T) {
+	original := testApplied(t, `
+apiVersion: example.com/v1
+kind: CronTab
+metadata:
+  name: backup
+spec:
+  schedule: "0 * * * *"
+  hosts: [a, b]
+`)
+	current := original.DeepCopy()
+	unstructured.SetNestedField(current.Object, "ready", "status", "phase")
+
+	modified := testApplied(t, `
+apiVersion: example.com/v1
+kind: CronTab
+metadata:
+  name: backup
+spec:
+  hosts: [a]
+`)
+	patchType, patch, err := createApplyPatch(modified, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patchType != types.MergePatchType {
+		t.Errorf("patch type = %s, want %s", patchType, types.MergePatchType)
+	}
+	var got map[string]interface{}
+	if err = json.Unmarshal(patch, &got); err != nil {
+		t.Fatal(err)
+	}
+	unstructured.RemoveNestedField(got, "metadata")
+	want := decodeTestJSON(t, `{"spec":{"hosts":["a"],"schedule":null}}`)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("createApplyPatch() = %s, want %v", patch, want)
+	}
+
+	current.SetAnnotations(map[string]string{lastAppliedConfigAnnotation: "{"})
+	if _, _, err = createApplyPatch(modified, current); err == nil {
+		t.Error("createApplyPatch() with an invalid last applied configuration succeeds")
+	}
+}
+
+func TestCreateOrPatch(t *testing.T) {
+	live := testApplied(t, appliedDeployment)
+	// changed is applied with the previous image
+	changed := testApplied(t, strings.Replace(appliedDeployment, "web:2.0", "web:1.0", 1))
+	conflict := k8sErrors.NewConflict(schema.GroupResource{Resource: "deployments"}, "web", nil)
+	exists := k8sErrors.NewAlreadyExists(schema.GroupResource{Resource: "deployments"}, "web")
+
+	tests := []struct {
+		name    string
+		dr      *scriptedResource
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "created",
+			dr:   &scriptedResource{lives: []*unstructured.Unstructured{nil}},
+			want: []string{"get", "create"},
+		},
+		{
+			// Created by others between Get and Create
+			name: "create raced",
+			dr:   &scriptedResource{lives: []*unstructured.Unstructured{nil, changed}, createErr: exists},
+			want: []string{"get", "create", "get", "patch"},
+		},
+		{
+			name: "unchanged",
+			dr:   &scriptedResource{lives: []*unstructured.Unstructured{live}},
+			want: []string{"get"},
+		},
+		{
+			name: "patch conflict retried",
+			dr:   &scriptedResource{lives: []*unstructured.Unstructured{changed}, patchErr: conflict},
+			want: []string{"get", "patch", "get", "patch"},
+		},
+		{
+			name:    "patch failed",
+			dr:      &scriptedResource{lives: []*unstructured.Unstructured{changed}, patchErr: k8sErrors.NewBadRequest("invalid")},
+			want:    []string{"get", "patch"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			low := &lowVersion{ctx: context.Background()}
+			_, err := low.createOrPatch(tt.dr, testApplied(t, appliedDeployment))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("createOrPatch() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(tt.dr.calls, tt.want) {
+				t.Errorf("calls = %v, want %v", tt.dr.calls, tt.want)
+			}
+			for _, patch := range tt.dr.patches {
+				if !strings.HasPrefix(patch, string(types.StrategicMergePatchType)) || !strings.Contains(patch, `"image":"web:2.0"`) {
+					t.Errorf("patch = %s, want the strategic merge patch of the image", patch)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateOrPatchCanceled(t *testing.T) {
+	live := decodeTestObject(t, appliedDeployment)
+	conflict := k8sErrors.NewConflict(schema.GroupResource{Resource: "deployments"}, "web", nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	low := &lowVersion{ctx: ctx}
+	dr := &scriptedResource{lives: []*unstructured.Unstructured{live}, patchErr: conflict}
+	if _, err := low.createOrPatch(dr, testApplied(t, appliedDeployment)); err != context.Canceled {
+		t.Errorf("createOrPatch() err = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,81 @@
+package gokubectl
+
+import (
+	"reflect"
+
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// threeWayMergePatch returns the JSON merge patch (RFC 7386) which changes the
+// current object to the modified one, and deletes the fields which are in the
+// original but not in the modified. The fields only in current are kept, they
+// are set by the other managers.
+// Lists are replaced as a whole, which is the semantics of JSON merge patch.
+func threeWayMergePatch(original, modified, current map[string]interface{}) map[string]interface{} {
+	patch := diffAdditions(current, modified)
+	mergeDeletions(patch, diffDeletions(original, modified))
+	return patch
+}
+
+// diffAdditions returns the fields of modified which are added or changed from current.
+func diffAdditions(current, modified map[string]interface{}) map[string]interface{} {
+	patch := make(map[string]interface{})
+	for key, modifiedValue := range modified {
+		currentValue, ok := current[key]
+		if !ok {
+			patch[key] = runtime.DeepCopyJSONValue(modifiedValue)
+			continue
+		}
+
+		currentMap, currentIsMap := currentValue.(map[string]interface{})
+		modifiedMap, modifiedIsMap := modifiedValue.(map[string]interface{})
+		if currentIsMap && modifiedIsMap {
+			if sub := diffAdditions(currentMap, modifiedMap); len(sub) != 0 {
+				patch[key] = sub
+			}
+			continue
+		}
+		if !reflect.DeepEqual(currentValue, modifiedValue) {
+			patch[key] = runtime.DeepCopyJSONValue(modifiedValue)
+		}
+	}
+	return patch
+}
+
+// diffDeletions returns the fields of original which are removed from modified,
+// the removed fields are set to null.
+func diffDeletions(original, modified map[string]interface{}) map[string]interface{} {
+	patch := make(map[string]interface{})
+	for key, originalValue := range original {
+		modifiedValue, ok := modified[key]
+		if !ok {
+			patch[key] = nil
+			continue
+		}
+
+		originalMap, originalIsMap := originalValue.(map[string]interface{})
+		modifiedMap, modifiedIsMap := modifiedValue.(map[string]interface{})
+		if originalIsMap && modifiedIsMap {
+			if sub := diffDeletions(originalMap, modifiedMap); len(sub) != 0 {
+				patch[key] = sub
+			}
+		}
+	}
+	return patch
+}
+
+func mergeDeletions(patch, deletions map[string]interface{}) {
+	for key, deletion := range deletions {
+		value, ok := patch[key]
+		if !ok {
+			patch[key] = deletion
+			continue
+		}
+
+		valueMap, valueIsMap := value.(map[string]interface{})
+		deletionMap, deletionIsMap := deletion.(map[string]interface{})
+		if valueIsMap && deletionIsMap {
+			mergeDeletions(valueMap, deletionMap)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package gokubectl
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func decodeTestJSON(t *testing.T, data string) map[string]interface{} {
+	t.Helper()
+	if data == "" {
+		return nil
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestThreeWayMergePatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+		modified string
+		current  string
+		want     string
+	}{
+		{
+			name:     "unchanged",
+			original: `{"a":1,"spec":{"x":1}}`,
+			modified: `{"a":1,"spec":{"x":1}}`,
+			current:  `{"a":1,"spec":{"x":1}}`,
+			want:     `{}`,
+		},
+		{
+			name:     "field added and changed",
+			original: `{"a":1}`,
+			modified: `{"a":2,"b":"new"}`,
+			current:  `{"a":1}`,
+			want:     `{"a":2,"b":"new"}`,
+		},
+		{
+			name:     "field deleted",
+			original: `{"a":1,"b":2}`,
+			modified: `{"a":1}`,
+			current:  `{"a":1,"b":2}`,
+			want:     `{"b":null}`,
+		},
+		{
+			name:     "nested field deleted",
+			original: `{"spec":{"x":1,"y":2}}`,
+			modified: `{"spec":{"x":1}}`,
+			current:  `{"spec":{"x":1,"y":2}}`,
+			want:     `{"spec":{"y":null}}`,
+		},
+		{
+			name:     "map deleted",
+			original: `{"a":1,"spec":{"x":1}}`,
+			modified: `{"a":1}`,
+			current:  `{"a":1,"spec":{"x":1}}`,
+			want:     `{"spec":null}`,
+		},
+		{
+			name:     "changed and deleted in the same map",
+			original: `{"spec":{"x":1,"y":2}}`,
+			modified: `{"spec":{"x":3}}`,
+			current:  `{"spec":{"x":1,"y":2}}`,
+			want:     `{"spec":{"x":3,"y":null}}`,
+		},
+		{
+			// The fields set by the other managers are not in the original
+			name:     "fields of others kept",
+			original: `{"spec":{"x":1}}`,
+			modified: `{"spec":{"x":1}}`,
+			current:  `{"spec":{"x":1,"z":3},"status":{"ready":true}}`,
+			want:     `{}`,
+		},
+		{
+			name:     "no original",
+			modified: `{"spec":{"x":2}}`,
+			current:  `{"spec":{"x":1,"y":2}}`,
+			want:     `{"spec":{"x":2}}`,
+		},
+		{
+			name:     "deleted by others",
+			original: `{"a":1,"b":2}`,
+			modified: `{"a":1,"b":2}`,
+			current:  `{"a":1}`,
+			want:     `{"b":2}`,
+		},
+		{
+			name:     "list replaced",
+			original: `{"items":["a","b","c"]}`,
+			modified: `{"items":["a","b"]}`,
+			current:  `{"items":["a","b","c"]}`,
+			want:     `{"items":["a","b"]}`,
+		},
+		{
+			// The elements are not merged by their keys
+			name:     "list of maps replaced",
+			original: `{"ports":[{"name":"http","port":80}]}`,
+			modified: `{"ports":[{"name":"http","port":8080}]}`,
+			current:  `{"ports":[{"name":"http","port":80,"protocol":"TCP"}]}`,
+			want:     `{"ports":[{"name":"http","port":8080}]}`,
+		},
+		{
+			name:     "list of others kept",
+			original: `{"a":1}`,
+			modified: `{"a":1}`,
+			current:  `{"a":1,"items":["x"]}`,
+			want:     `{}`,
+		},
+		{
+			name:     "list deleted",
+			original: `{"a":1,"items":["x"]}`,
+			modified: `{"a":1}`,
+			current:  `{"a":1,"items":["x"]}`,
+			want:     `{"items":null}`,
+		},
+		{
+			name:     "null deletes the current field",
+			original: `{"a":1,"b":2}`,
+			modified: `{"a":1,"b":null}`,
+			current:  `{"a":1,"b":2}`,
+			want:     `{"b":null}`,
+		},
+		{
+			name:     "null of a missing field",
+			modified: `{"b":null}`,
+			current:  `{"a":1}`,
+			want:     `{"b":null}`,
+		},
+		{
+			name:     "null replaced",
+			original: `{"b":null}`,
+			modified: `{"b":{"x":1}}`,
+			current:  `{"b":null}`,
+			want:     `{"b":{"x":1}}`,
+		},
+		{
+			name:     "null kept",
+			original: `{"b":null}`,
+			modified: `{"b":null}`,
+			current:  `{"b":null}`,
+			want:     `{}`,
+		},
+		{
+			name:     "map replaced by scalar",
+			original: `{"a":{"x":1,"y":2}}`,
+			modified: `{"a":"s"}`,
+			current:  `{"a":{"x":1,"y":2}}`,
+			want:     `{"a":"s"}`,
+		},
+		{
+			name:     "scalar replaced by map",
+			original: `{"a":"s"}`,
+			modified: `{"a":{"x":1}}`,
+			current:  `{"a":"s"}`,
+			want:     `{"a":{"x":1}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := threeWayMergePatch(decodeTestJSON(t, tt.original), decodeTestJSON(t, tt.modified), decodeTestJSON(t, tt.current))
+			if want := decodeTestJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+				gotJSON, _ := json.Marshal(got)
+				t.Errorf("threeWayMergePatch() = %s, want %s", gotJSON, tt.want)
+			}
+		})
+	}
+}
+
+func TestThreeWayMergePatchCopy(t *testing.T) {
+	modified := decodeTestJSON(t, `{"spec":{"items":["a"],"selector":{"app":"web"}}}`)
+	patch := threeWayMergePatch(nil, modified, map[string]interface{}{})
+	spec := patch["spec"].(map[string]interface{})
+	spec["items"].([]interface{})[0] = "b"
+	spec["selector"].(map[string]interface{})["app"] = "api"
+
+	if want := decodeTestJSON(t, `{"spec":{"items":["a"],"selector":{"app":"web"}}}`); !reflect.DeepEqual(modified, want) {
+		t.Errorf("modified = %v after the patch is changed, want %v", modified, want)
+	}
+}
+
+func TestSetLastAppliedConfiguration(t *testing.T) {
+	obj := decodeTestObject(t, `
+apiVersion: example.com/v1
+kind: CronTab
+metadata:
+  name: backup
+  annotations:
+    kubectl.kubernetes.io/last-applied-configuration: '{"stale":true}'
+spec:
+  schedule: "0 * * * *"
+`)
+	obj, err := setLastAppliedConfiguration(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"apiVersion":"example.com/v1","kind":"CronTab","metadata":{"annotations":{},"name":"backup"},"spec":{"schedule":"0 * * * *"}}`
+	if got := obj.GetAnnotations()[lastAppliedConfigAnnotation]; got != want {
+		t.Errorf("%s = %s, want %s", lastAppliedConfigAnnotation, got, want)
+	}
+}
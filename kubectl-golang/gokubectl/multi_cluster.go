@@ -0,0 +1,191 @@
+package gokubectl
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/k8s"
+)
+
+const (
+	defaultMultiApplyWorkers = 4
+)
+
+type RolloutStrategy string
+
+const (
+	// RolloutParallel applies the manifest to all the clusters at the same time.
+	RolloutParallel RolloutStrategy = "Parallel"
+	// RolloutCanary applies the manifest to the first cluster, the rest clusters
+	// will be applied only if the first one succeeded.
+	RolloutCanary RolloutStrategy = "Canary"
+)
+
+type ClusterConfig struct {
+	// Name is the unique name of the cluster
+	Name             string
+	Base64KubeConfig string
+	Overrides        Overrides
+}
+
+type MultiApplyOptions struct {
+	// Apply is the options of applying to every cluster
+	Apply ApplyOptions
+	// Workers is the max number of clusters applied at the same time, default 4.
+	Workers  int
+	Strategy RolloutStrategy
+	// StopOnFailure stops applying to the clusters which are not started yet
+	// once any cluster failed.
+	StopOnFailure bool
+}
+
+type ClusterResult struct {
+	Cluster string
+	Results []ApplyResult
+	Err     error
+	// Skipped is true if the cluster was not applied because of the failure of others.
+	Skipped bool
+}
+
+// Failed returns true if the cluster or any object of it failed to apply.
+func (r ClusterResult) Failed() bool {
+	if r.Err != nil {
+		return true
+	}
+	for _, result := range r.Results {
+		if result.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// MultiApply applies the manifest to the clusters concurrently, results are
+// returned in the order of clusters.
+func MultiApply(ctx context.Context, clusters []ClusterConfig, data []byte, opts MultiApplyOptions) ([]ClusterResult, error) {
+	manifests, err := ReadBytes("", data)
+	if err != nil {
+		return nil, err
+	}
+	return MultiApplyManifests(ctx, clusters, manifests, opts)
+}
+
+// MultiApplyManifests is MultiApply of the manifests read from the sources, see ReadPaths.
+func MultiApplyManifests(ctx context.Context, clusters []ClusterConfig, manifests []Manifest, opts MultiApplyOptions) ([]ClusterResult, error) {
+	names := make(map[string]bool, len(clusters))
+	for _, cluster := range clusters {
+		if cluster.Name == "" {
+			return nil, errors.New("cluster name is required")
+		}
+		if names[cluster.Name] {
+			return nil, errors.Errorf("duplicate cluster name: %s", cluster.Name)
+		}
+		names[cluster.Name] = true
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = defaultMultiApplyWorkers
+	}
+
+	results := make([]ClusterResult, len(clusters))
+	indexes := make([]int, len(clusters))
+	for i := range clusters {
+		results[i].Cluster = clusters[i].Name
+		indexes[i] = i
+	}
+
+	var waves [][]int
+	switch opts.Strategy {
+	case RolloutCanary:
+		if len(indexes) > 0 {
+			waves = append(waves, indexes[:1], indexes[1:])
+		}
+	case RolloutParallel, "":
+		waves = append(waves, indexes)
+	default:
+		return nil, errors.Errorf("unknown rollout strategy: %s", opts.Strategy)
+	}
+
+	var failed int32
+	for _, wave := range waves {
+		// The canary wave always stops the rollout
+		stop := atomic.LoadInt32(&failed) == 1 && (opts.StopOnFailure || opts.Strategy == RolloutCanary)
+		if stop {
+			for _, i := range wave {
+				results[i].Skipped = true
+			}
+			continue
+		}
+		applyWave(ctx, clusters, manifests, wave, results, opts, &failed)
+	}
+	return results, nil
+}
+
+func applyWave(ctx context.Context, clusters []ClusterConfig, manifests []Manifest, wave []int,
+	results []ClusterResult, opts MultiApplyOptions, failed *int32) {
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, opts.Workers)
+	)
+	for _, i := range wave {
+		sem <- struct{}{}
+		if ctx.Err() != nil || (opts.StopOnFailure && atomic.LoadInt32(failed) == 1) {
+			<-sem
+			results[i].Skipped = true
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result := applyCluster(ctx, clusters[i], manifests, opts.Apply)
+			if result.Failed() {
+				atomic.StoreInt32(failed, 1)
+			}
+			results[i] = result
+		}(i)
+	}
+	wg.Wait()
+}
+
+func applyCluster(ctx context.Context, cluster ClusterConfig, manifests []Manifest, opts ApplyOptions) ClusterResult {
+	result := ClusterResult{
+		Cluster: cluster.Name,
+	}
+
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  cluster.Base64KubeConfig,
+		DiscoveryCacheDir: opts.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.DiscoveryCacheTTL,
+		QPS:               opts.QPS,
+		Burst:             opts.Burst,
+	}
+	// Decrypt before render and overrides, which re-encode the documents and
+	// break the MAC of SOPS and the armor of age
+	manifests, err := decryptManifests(manifests, opts.AgeIdentities)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	// Render before overrides, the variables may be not valid YAML values
+	if opts.Render != nil {
+		rendered, err := renderManifests(kubeClient, manifests, *opts.Render)
+		if err != nil {
+			result.Err = errors.Wrap(err, "render manifest failed")
+			return result
+		}
+		manifests, opts.Render = rendered, nil
+	}
+	manifests, err = applyOverrides(kubeClient, manifests, cluster.Overrides)
+	if err != nil {
+		result.Err = errors.Wrap(err, "apply overrides failed")
+		return result
+	}
+
+	result.Results, result.Err = applyManifests(ctx, kubeClient, manifests, opts)
+	return result
+}
@@ -0,0 +1,110 @@
+package gokubectl
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestClusterResultFailed(t *testing.T) {
+	tests := []struct {
+		name   string
+		result ClusterResult
+		want   bool
+	}{
+		{"succeeded", ClusterResult{Results: []ApplyResult{{}, {}}}, false},
+		{"skipped", ClusterResult{Skipped: true}, false},
+		{"cluster failed", ClusterResult{Err: errors.New("unreachable")}, true},
+		{"object failed", ClusterResult{Results: []ApplyResult{{}, {Err: errors.New("invalid")}}}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.result.Failed(); got != tt.want {
+			t.Errorf("%s: Failed() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMultiApplyManifestsClusters(t *testing.T) {
+	for _, clusters := range [][]ClusterConfig{
+		{{Name: "a"}, {Name: ""}},
+		{{Name: "a"}, {Name: "a"}},
+	} {
+		if _, err := MultiApplyManifests(context.Background(), clusters, nil, MultiApplyOptions{}); err == nil {
+			t.Errorf("MultiApplyManifests(%v) succeeds, want error", clusters)
+		}
+	}
+	if _, err := MultiApplyManifests(context.Background(), nil, nil, MultiApplyOptions{Strategy: "BlueGreen"}); err == nil {
+		t.Error("MultiApplyManifests() with an unknown strategy succeeds")
+	}
+}
+
+func TestMultiApplyManifestsRollout(t *testing.T) {
+	// Every cluster fails before connecting, the manifest is encrypted without the key
+	manifests := []Manifest{{Data: readTestFile(t, "age", "secret.yaml.age")}}
+	clusters := []ClusterConfig{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		opts        MultiApplyOptions
+		wantFailed  []bool
+		wantSkipped []bool
+	}{
+		{
+			name:        "parallel",
+			ctx:         context.Background(),
+			opts:        MultiApplyOptions{Strategy: RolloutParallel},
+			wantFailed:  []bool{true, true, true},
+			wantSkipped: []bool{false, false, false},
+		},
+		{
+			name:        "stop on failure",
+			ctx:         context.Background(),
+			opts:        MultiApplyOptions{Workers: 1, StopOnFailure: true},
+			wantFailed:  []bool{true, false, false},
+			wantSkipped: []bool{false, true, true},
+		},
+		{
+			// The canary stops the rollout without StopOnFailure
+			name:        "canary",
+			ctx:         context.Background(),
+			opts:        MultiApplyOptions{Strategy: RolloutCanary},
+			wantFailed:  []bool{true, false, false},
+			wantSkipped: []bool{false, true, true},
+		},
+		{
+			name:        "canceled",
+			ctx:         canceled,
+			opts:        MultiApplyOptions{},
+			wantFailed:  []bool{false, false, false},
+			wantSkipped: []bool{true, true, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := MultiApplyManifests(tt.ctx, clusters, manifests, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			failed, skipped := make([]bool, len(results)), make([]bool, len(results))
+			for i, r := range results {
+				names = append(names, r.Cluster)
+				failed[i], skipped[i] = r.Failed(), r.Skipped
+			}
+			if !reflect.DeepEqual(names, []string{"a", "b", "c"}) {
+				t.Errorf("clusters = %v, want in the order of clusters", names)
+			}
+			if !reflect.DeepEqual(failed, tt.wantFailed) {
+				t.Errorf("failed = %v, want %v", failed, tt.wantFailed)
+			}
+			if !reflect.DeepEqual(skipped, tt.wantSkipped) {
+				t.Errorf("skipped = %v, want %v", skipped, tt.wantSkipped)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package gokubectl
+
+import (
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	defaultNamespace = "default"
+)
+
+// NamespaceOptions decides the namespace of the objects, it's shared by Apply and Delete.
+type NamespaceOptions struct {
+	// DefaultNamespace is set to the namespaced objects without namespace, default "default".
+	DefaultNamespace string
+	// EnforceNamespace rejects the namespaced objects in other namespaces,
+	// it's also the default namespace if set.
+	EnforceNamespace string
+	// AllowedNamespaces rejects the objects in the namespaces not listed,
+	// Namespace objects are checked by their names. Empty means all allowed.
+	AllowedNamespaces []string
+}
+
+// resolveNamespace defaults and checks the namespace of obj by the scope of its kind.
+func (opts NamespaceOptions) resolveNamespace(obj *unstructured.Unstructured, mapping *meta.RESTMapping) error {
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		if obj.GetNamespace() != "" {
+			return errors.Errorf("%s %s is cluster-scoped, but metadata.namespace is set to %q",
+				obj.GetKind(), obj.GetName(), obj.GetNamespace())
+		}
+		// Namespace is the only cluster-scoped kind owned by tenant
+		if mapping.GroupVersionKind.Group == "" && mapping.GroupVersionKind.Kind == "Namespace" && !opts.allowed(obj.GetName()) {
+			return errors.Errorf("Namespace %s is not in the allowed namespaces %v", obj.GetName(), opts.AllowedNamespaces)
+		}
+		return nil
+	}
+
+	namespace := obj.GetNamespace()
+	if namespace == "" {
+		switch {
+		case opts.EnforceNamespace != "":
+			namespace = opts.EnforceNamespace
+		case opts.DefaultNamespace != "":
+			namespace = opts.DefaultNamespace
+		default:
+			namespace = defaultNamespace
+		}
+		obj.SetNamespace(namespace)
+	}
+
+	if opts.EnforceNamespace != "" && namespace != opts.EnforceNamespace {
+		return errors.Errorf("%s %s is in namespace %q, but the namespace is enforced to %q",
+			obj.GetKind(), obj.GetName(), namespace, opts.EnforceNamespace)
+	}
+	if !opts.allowed(namespace) {
+		return errors.Errorf("%s %s is in namespace %q, which is not in the allowed namespaces %v",
+			obj.GetKind(), obj.GetName(), namespace, opts.AllowedNamespaces)
+	}
+	return nil
+}
+
+func (opts NamespaceOptions) allowed(namespace string) bool {
+	if len(opts.AllowedNamespaces) == 0 {
+		return true
+	}
+	return containsString(opts.AllowedNamespaces, namespace)
+}
@@ -0,0 +1,95 @@
+package gokubectl
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func testMapping(kind string, scope meta.RESTScope) *meta.RESTMapping {
+	return &meta.RESTMapping{GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: kind}, Scope: scope}
+}
+
+func TestResolveNamespace(t *testing.T) {
+	configMap := testMapping("ConfigMap", meta.RESTScopeNamespace)
+	namespace := testMapping("Namespace", meta.RESTScopeRoot)
+	node := testMapping("Node", meta.RESTScopeRoot)
+
+	tests := []struct {
+		name      string
+		opts      NamespaceOptions
+		namespace string
+		objName   string
+		mapping   *meta.RESTMapping
+		want      string
+		wantErr   string
+	}{
+		{name: "default", mapping: configMap, want: "default"},
+		{name: "default namespace", opts: NamespaceOptions{DefaultNamespace: "dev"}, mapping: configMap, want: "dev"},
+		{name: "namespace kept", opts: NamespaceOptions{DefaultNamespace: "dev"}, namespace: "prod", mapping: configMap, want: "prod"},
+		{
+			// The enforced namespace is the default as well
+			name:    "enforced default",
+			opts:    NamespaceOptions{DefaultNamespace: "dev", EnforceNamespace: "prod"},
+			mapping: configMap,
+			want:    "prod",
+		},
+		{
+			name:      "enforced",
+			opts:      NamespaceOptions{EnforceNamespace: "prod"},
+			namespace: "dev",
+			mapping:   configMap,
+			wantErr:   `the namespace is enforced to "prod"`,
+		},
+		{name: "allowed", opts: NamespaceOptions{AllowedNamespaces: []string{"dev", "prod"}}, namespace: "prod", mapping: configMap, want: "prod"},
+		{
+			name:    "default not allowed",
+			opts:    NamespaceOptions{AllowedNamespaces: []string{"dev", "prod"}},
+			mapping: configMap,
+			wantErr: `namespace "default", which is not in the allowed namespaces`,
+		},
+		{name: "cluster-scoped", opts: NamespaceOptions{EnforceNamespace: "prod"}, mapping: node},
+		{
+			name:      "cluster-scoped with namespace",
+			namespace: "prod",
+			mapping:   node,
+			wantErr:   "Node web is cluster-scoped",
+		},
+		{name: "Namespace allowed", opts: NamespaceOptions{AllowedNamespaces: []string{"web"}}, mapping: namespace},
+		{
+			name:    "Namespace not allowed",
+			opts:    NamespaceOptions{AllowedNamespaces: []string{"dev"}},
+			objName: "kube-system",
+			mapping: namespace,
+			wantErr: "Namespace kube-system is not in the allowed namespaces",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &unstructured.Unstructured{}
+			obj.SetKind(tt.mapping.GroupVersionKind.Kind)
+			obj.SetName("web")
+			if tt.objName != "" {
+				obj.SetName(tt.objName)
+			}
+			obj.SetNamespace(tt.namespace)
+
+			err := tt.opts.resolveNamespace(obj, tt.mapping)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("resolveNamespace() err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if obj.GetNamespace() != tt.want {
+				t.Errorf("namespace = %q, want %q", obj.GetNamespace(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package gokubectl
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/k8s"
+)
+
+var (
+	// clusterScopedKinds are the built-in kinds without namespace, the other kinds
+	// are treated as namespaced without cluster, see builtinNamespaced.
+	clusterScopedKinds = map[string]bool{
+		"APIService":                     true,
+		"CertificateSigningRequest":      true,
+		"ClusterRole":                    true,
+		"ClusterRoleBinding":             true,
+		"CSIDriver":                      true,
+		"CSINode":                        true,
+		"CustomResourceDefinition":       true,
+		"IngressClass":                   true,
+		"MutatingWebhookConfiguration":   true,
+		"Namespace":                      true,
+		"Node":                           true,
+		"PersistentVolume":               true,
+		"PodSecurityPolicy":              true,
+		"PriorityClass":                  true,
+		"RuntimeClass":                   true,
+		"StorageClass":                   true,
+		"ValidatingWebhookConfiguration": true,
+		"VolumeAttachment":               true,
+	}
+
+	// podSpecPaths are the paths of pod spec in the workload kinds.
+	podSpecPaths = map[string][]string{
+		"Pod":                   {"spec"},
+		"Deployment":            {"spec", "template", "spec"},
+		"StatefulSet":           {"spec", "template", "spec"},
+		"DaemonSet":             {"spec", "template", "spec"},
+		"ReplicaSet":            {"spec", "template", "spec"},
+		"ReplicationController": {"spec", "template", "spec"},
+		"Job":                   {"spec", "template", "spec"},
+		"CronJob":               {"spec", "jobTemplate", "spec", "template", "spec"},
+	}
+)
+
+// Overrides are the per-cluster changes made on the manifest before apply.
+type Overrides struct {
+	// Namespace replaces the namespace of the namespaced objects.
+	Namespace string
+	// ImageTags replaces the image tag of the containers, key is the container name.
+	ImageTags map[string]string
+	// Replicas replaces spec.replicas of the workloads, key is the object name.
+	Replicas map[string]int64
+}
+
+func (o Overrides) empty() bool {
+	return o.Namespace == "" && len(o.ImageTags) == 0 && len(o.Replicas) == 0
+}
+
+func (o Overrides) apply(obj *unstructured.Unstructured, namespaced namespacedFunc) error {
+	if o.Namespace != "" {
+		setNamespace(obj, o.Namespace, namespaced)
+	}
+	if err := setImageTags(obj, o.ImageTags); err != nil {
+		return err
+	}
+	if replicas, ok := o.Replicas[obj.GetName()]; ok {
+		if _, ok := podSpecPaths[obj.GetKind()]; !ok || obj.GetKind() == "Pod" {
+			return errors.Errorf("%s %s has no replicas", obj.GetKind(), obj.GetName())
+		}
+		if err := unstructured.SetNestedField(obj.Object, replicas, "spec", "replicas"); err != nil {
+			return errors.Wrapf(err, "set replicas of %s failed", obj.GetName())
+		}
+	}
+	return nil
+}
+
+// ApplyOverrides returns the manifests with overrides of the cluster applied.
+// Only the built-in kinds are known cluster-scoped, see ApplyClusterOverrides.
+func ApplyOverrides(manifests []Manifest, overrides Overrides) ([]Manifest, error) {
+	return applyOverrides(nil, manifests, overrides)
+}
+
+// ApplyClusterOverrides is ApplyOverrides with the scopes of the kinds discovered
+// from the cluster, so the namespace isn't set on the cluster-scoped custom resources.
+func ApplyClusterOverrides(base64KubeConfig string, manifests []Manifest, overrides Overrides, opts DiscoveryOptions) ([]Manifest, error) {
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  base64KubeConfig,
+		DiscoveryCacheDir: opts.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.DiscoveryCacheTTL,
+	}
+	return applyOverrides(kubeClient, manifests, overrides)
+}
+
+func applyOverrides(kubeClient *k8s.KubeClient, manifests []Manifest, overrides Overrides) ([]Manifest, error) {
+	if overrides.empty() {
+		return manifests, nil
+	}
+	namespaced := clusterNamespaced(kubeClient)
+	return transformManifests(manifests, func(obj *unstructured.Unstructured) error {
+		return overrides.apply(obj, namespaced)
+	})
+}
+
+// transformManifests decodes every manifest, calls fn with the object
+// and encodes it back to YAML, the sources are kept.
+func transformManifests(manifests []Manifest, fn func(obj *unstructured.Unstructured) error) ([]Manifest, error) {
+	result := make([]Manifest, 0, len(manifests))
+	for _, m := range manifests {
+		obj := &unstructured.Unstructured{}
+		if _, _, err := decUnstructured.Decode(m.Data, nil, obj); err != nil {
+			return nil, errors.Wrapf(err, "%s: Decode yaml failed. ", m.Source)
+		}
+		if err := fn(obj); err != nil {
+			return nil, err
+		}
+		out, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return nil, errors.Wrapf(err, "encode %s failed", obj.GetName())
+		}
+		result = append(result, Manifest{Data: out, Source: m.Source})
+	}
+	return result, nil
+}
+
+// namespacedFunc tells if the kind of the object is namespaced.
+type namespacedFunc func(obj *unstructured.Unstructured) bool
+
+func builtinNamespaced(obj *unstructured.Unstructured) bool {
+	return !clusterScopedKinds[obj.GetKind()]
+}
+
+// clusterNamespaced resolves the scope by the RESTMapper of the cluster, the
+// kinds failed to map fall back to builtinNamespaced, as well as nil kubeClient.
+func clusterNamespaced(kubeClient *k8s.KubeClient) namespacedFunc {
+	if kubeClient == nil {
+		return builtinNamespaced
+	}
+	return func(obj *unstructured.Unstructured) bool {
+		gvk := obj.GroupVersionKind()
+		mapping, err := kubeClient.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return builtinNamespaced(obj)
+		}
+		return mapping.Scope.Name() == meta.RESTScopeNameNamespace
+	}
+}
+
+func setNamespace(obj *unstructured.Unstructured, namespace string, namespaced namespacedFunc) {
+	if namespaced(obj) {
+		obj.SetNamespace(namespace)
+	}
+}
+
+func setImageTags(obj *unstructured.Unstructured, imageTags map[string]string) error {
+	path, ok := podSpecPaths[obj.GetKind()]
+	if !ok || len(imageTags) == 0 {
+		return nil
+	}
+	for _, field := range []string{"initContainers", "containers"} {
+		containers, found, err := unstructured.NestedSlice(obj.Object, append(path, field)...)
+		if err != nil {
+			return errors.Wrapf(err, "read %s of %s failed", field, obj.GetName())
+		}
+		if !found {
+			continue
+		}
+		for i := range containers {
+			container, ok := containers[i].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := container["name"].(string)
+			tag, ok := imageTags[name]
+			if !ok {
+				continue
+			}
+			image, _ := container["image"].(string)
+			container["image"] = replaceImageTag(image, tag)
+		}
+		if err = unstructured.SetNestedSlice(obj.Object, containers, append(path, field)...); err != nil {
+			return errors.Wrapf(err, "set %s of %s failed", field, obj.GetName())
+		}
+	}
+	return nil
+}
+
+// replaceImageTag replaces the tag or digest of the image reference,
+// registry ports like "registry:5000/app" are kept.
+func replaceImageTag(image, tag string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image + ":" + tag
+}
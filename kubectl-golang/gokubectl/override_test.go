@@ -0,0 +1,131 @@
+package gokubectl
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestReplaceImageTag(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{"nginx", "nginx:v2"},
+		{"nginx:1.19", "nginx:v2"},
+		{"library/nginx:1.19", "library/nginx:v2"},
+		{"registry:5000/app", "registry:5000/app:v2"},
+		{"registry:5000/app:v1", "registry:5000/app:v2"},
+		{"nginx@sha256:0123456789abcdef", "nginx:v2"},
+		{"nginx:1.19@sha256:0123456789abcdef", "nginx:v2"},
+	}
+	for _, tt := range tests {
+		if got := replaceImageTag(tt.image, "v2"); got != tt.want {
+			t.Errorf("replaceImageTag(%q) = %q, want %q", tt.image, got, tt.want)
+		}
+	}
+}
+
+const overrideManifests = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: default
+spec:
+  replicas: 1
+  template:
+    spec:
+      initContainers:
+      - name: migrate
+        image: web:1.0
+      containers:
+      - name: web
+        image: web:1.0
+      - name: sidecar
+        image: envoy:1.16
+---
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: backup
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: web
+            image: registry:5000/web
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: reader
+`
+
+func TestApplyOverrides(t *testing.T) {
+	manifests, err := ReadBytes("app.yaml", []byte(overrideManifests))
+	if err != nil {
+		t.Fatal(err)
+	}
+	overridden, err := ApplyOverrides(manifests, Overrides{
+		Namespace: "prod",
+		ImageTags: map[string]string{"web": "2.0", "migrate": "2.0"},
+		Replicas:  map[string]int64{"web": 3},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(overridden) != 3 || overridden[1].Source != manifests[1].Source {
+		t.Fatalf("ApplyOverrides() = %v, want the 3 manifests with their sources", overridden)
+	}
+
+	deploy := decodeTestObject(t, string(overridden[0].Data))
+	if deploy.GetNamespace() != "prod" {
+		t.Errorf("namespace = %q, want prod", deploy.GetNamespace())
+	}
+	if replicas, _, _ := unstructured.NestedFloat64(deploy.Object, "spec", "replicas"); replicas != 3 {
+		t.Errorf("spec.replicas = %v, want 3", replicas)
+	}
+	images := map[string]string{}
+	for _, field := range []string{"initContainers", "containers"} {
+		containers, _, _ := unstructured.NestedSlice(deploy.Object, "spec", "template", "spec", field)
+		for _, c := range containers {
+			c := c.(map[string]interface{})
+			images[c["name"].(string)] = c["image"].(string)
+		}
+	}
+	want := map[string]string{"migrate": "web:2.0", "web": "web:2.0", "sidecar": "envoy:1.16"}
+	for name, image := range want {
+		if images[name] != image {
+			t.Errorf("image of %s = %q, want %q", name, images[name], image)
+		}
+	}
+
+	cronJob := decodeTestObject(t, string(overridden[1].Data))
+	containers, _, _ := unstructured.NestedSlice(cronJob.Object, "spec", "jobTemplate", "spec", "template", "spec", "containers")
+	if image := containers[0].(map[string]interface{})["image"]; image != "registry:5000/web:2.0" {
+		t.Errorf("image of the CronJob = %v, want registry:5000/web:2.0", image)
+	}
+
+	// The cluster-scoped kinds have no namespace
+	if role := decodeTestObject(t, string(overridden[2].Data)); role.GetNamespace() != "" {
+		t.Errorf("namespace of ClusterRole = %q, want empty", role.GetNamespace())
+	}
+}
+
+func TestApplyOverridesEmpty(t *testing.T) {
+	manifests := []Manifest{{Data: []byte("kind: [")}}
+	got, err := ApplyOverrides(manifests, Overrides{})
+	if err != nil || len(got) != 1 || string(got[0].Data) != "kind: [" {
+		t.Errorf("ApplyOverrides() = %v, %v, want the manifests unchanged", got, err)
+	}
+}
+
+func TestApplyOverridesReplicasError(t *testing.T) {
+	manifests := []Manifest{{Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: web\n")}}
+	if _, err := ApplyOverrides(manifests, Overrides{Replicas: map[string]int64{"web": 3}}); err == nil {
+		t.Error("ApplyOverrides() sets replicas of a ConfigMap")
+	}
+}
@@ -0,0 +1,191 @@
+package gokubectl
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/k8s"
+)
+
+type PatchOptions struct {
+	DiscoveryOptions
+
+	// Type is the patch type, default types.StrategicMergePatchType. The strategic
+	// merge patch is supported by the built-in kinds only.
+	Type types.PatchType
+	// Namespace of the namespaced objects, default "default"
+	Namespace string
+	// AllNamespaces patches the objects selected in all the namespaces.
+	AllNamespaces bool
+	// LabelSelector selects the objects to patch if the name is empty
+	LabelSelector string
+	// Subresource patches the subresource instead, e.g. "status" or "scale"
+	Subresource string
+	// DryRun sends the patch without persisting it
+	DryRun bool
+	// FieldManager is the manager name of the patched fields, default "kubectl-golang".
+	FieldManager string
+}
+
+func (opts *PatchOptions) complete() {
+	if opts.Type == "" {
+		opts.Type = types.StrategicMergePatchType
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = defaultNamespace
+	}
+	if opts.FieldManager == "" {
+		opts.FieldManager = defaultFieldManager
+	}
+}
+
+// Patch patches the object of the kind by name, or the objects selected by
+// PatchOptions.LabelSelector if name is empty. The patch is JSON or YAML.
+func Patch(ctx context.Context, base64KubeConfig string, gvk schema.GroupVersionKind, name string, patch []byte, opts PatchOptions) ([]ApplyResult, error) {
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  base64KubeConfig,
+		DiscoveryCacheDir: opts.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.DiscoveryCacheTTL,
+	}
+	mapping, err := kubeClient.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, errors.Wrap(err, "Mapping kind with version failed")
+	}
+	return patchObjects(ctx, kubeClient, mapping, name, patch, opts)
+}
+
+// PatchResource is Patch by the resource argument like kubectl, e.g. "deploy"
+// or "deployments.apps", see Get.
+func PatchResource(ctx context.Context, base64KubeConfig string, resource, name string, patch []byte, opts PatchOptions) ([]ApplyResult, error) {
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  base64KubeConfig,
+		DiscoveryCacheDir: opts.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.DiscoveryCacheTTL,
+	}
+	mapping, err := kubeClient.ResourceMapping(resource)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Mapping resource %s failed", resource)
+	}
+	return patchObjects(ctx, kubeClient, mapping, name, patch, opts)
+}
+
+func patchObjects(ctx context.Context, kubeClient *k8s.KubeClient, mapping *meta.RESTMapping, name string, patch []byte, opts PatchOptions) ([]ApplyResult, error) {
+	opts.complete()
+	if (name == "") == (opts.LabelSelector == "") {
+		return nil, errors.New("patch requires either name or label selector")
+	}
+	patch, err := patchJSON(patch, opts.Type)
+	if err != nil {
+		return nil, err
+	}
+	// The server rejects the strategic merge patch of custom resources
+	if opts.Type == types.StrategicMergePatchType && !scheme.Scheme.Recognizes(mapping.GroupVersionKind) {
+		return nil, errors.Errorf("strategic merge patch is not supported by %s, use merge or json patch",
+			mapping.GroupVersionKind.Kind)
+	}
+	if opts.DryRun {
+		capabilities, err := kubeClient.GetCapabilities()
+		if err != nil {
+			return nil, err
+		}
+		if !capabilities.DryRun {
+			return nil, errors.Errorf("server %s doesn't support dry-run", capabilities.GitVersion)
+		}
+	}
+
+	dynamicClient, err := kubeClient.GetDynamicClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "Prepare dynamic client failed.")
+	}
+	namespaced := mapping.Scope.Name() == meta.RESTScopeNameNamespace
+	resourceClient := func(namespace string) dynamic.ResourceInterface {
+		if !namespaced {
+			return dynamicClient.Resource(mapping.Resource)
+		}
+		return dynamicClient.Resource(mapping.Resource).Namespace(namespace)
+	}
+
+	// The target objects, only the name and namespace are set if patched by name
+	var targets []unstructured.Unstructured
+	if name != "" {
+		obj := unstructured.Unstructured{}
+		obj.SetGroupVersionKind(mapping.GroupVersionKind)
+		obj.SetName(name)
+		if namespaced {
+			obj.SetNamespace(opts.Namespace)
+		}
+		targets = append(targets, obj)
+	} else {
+		namespace := opts.Namespace
+		if opts.AllNamespaces {
+			namespace = metav1.NamespaceAll
+		}
+		list, err := resourceClient(namespace).List(ctx, metav1.ListOptions{LabelSelector: opts.LabelSelector})
+		if err != nil {
+			return nil, errors.Wrapf(err, "list %s failed", mapping.Resource.Resource)
+		}
+		targets = list.Items
+	}
+
+	patchOpts := metav1.PatchOptions{FieldManager: opts.FieldManager}
+	if opts.DryRun {
+		patchOpts.DryRun = []string{metav1.DryRunAll}
+	}
+	var subresources []string
+	if opts.Subresource != "" {
+		subresources = append(subresources, opts.Subresource)
+	}
+
+	result := make([]ApplyResult, 0, len(targets))
+	for i := range targets {
+		obj := &targets[i]
+		obj.SetGroupVersionKind(mapping.GroupVersionKind)
+		r := newApplyResult(obj, Location{})
+		_, err := resourceClient(obj.GetNamespace()).Patch(ctx, obj.GetName(), opts.Type, patch, patchOpts, subresources...)
+		switch {
+		case err != nil:
+			r.Err = errors.Wrapf(err, "patch %s failed", obj.GetName())
+		case opts.DryRun:
+			r.Message = obj.GetName() + " patched (dry run)."
+		default:
+			r.Message = obj.GetName() + " patched."
+		}
+		redactResult(obj, &r)
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+// patchJSON converts the YAML patch to JSON, the JSON patch must be a list of
+// operations and the others must be objects.
+func patchJSON(patch []byte, patchType types.PatchType) ([]byte, error) {
+	data, err := yaml.YAMLToJSON(patch)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Decode patch failed. ")
+	}
+	switch patchType {
+	case types.JSONPatchType:
+		var ops []map[string]interface{}
+		if err = json.Unmarshal(data, &ops); err != nil {
+			return nil, errors.Wrap(err, "json patch should be a list of operations")
+		}
+	case types.MergePatchType, types.StrategicMergePatchType:
+		var obj map[string]interface{}
+		if err = json.Unmarshal(data, &obj); err != nil {
+			return nil, errors.Wrapf(err, "%s should be an object", patchType)
+		}
+	default:
+		return nil, errors.Errorf("unsupported patch type: %s", patchType)
+	}
+	return data, nil
+}
@@ -0,0 +1,225 @@
+package gokubectl
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestPatchJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		patch     string
+		patchType types.PatchType
+		want      string
+		wantErr   string
+	}{
+		{
+			name:      "strategic YAML",
+			patch:     "spec:\n  replicas: 3\n",
+			patchType: types.StrategicMergePatchType,
+			want:      `{"spec":{"replicas":3}}`,
+		},
+		{
+			name:      "merge JSON",
+			patch:     `{"metadata":{"labels":{"app":null}}}`,
+			patchType: types.MergePatchType,
+			want:      `{"metadata":{"labels":{"app":null}}}`,
+		},
+		{
+			name:      "json patch",
+			patch:     "- {op: replace, path: /spec/replicas, value: 3}\n",
+			patchType: types.JSONPatchType,
+			want:      `[{"op":"replace","path":"/spec/replicas","value":3}]`,
+		},
+		{
+			name:      "json patch of an object",
+			patch:     `{"spec":{"replicas":3}}`,
+			patchType: types.JSONPatchType,
+			wantErr:   "json patch should be a list of operations",
+		},
+		{
+			name:      "merge patch of a list",
+			patch:     `[{"op":"remove","path":"/spec"}]`,
+			patchType: types.MergePatchType,
+			wantErr:   "application/merge-patch+json should be an object",
+		},
+		{
+			name:      "apply patch",
+			patch:     `{"spec":{}}`,
+			patchType: types.ApplyPatchType,
+			wantErr:   "unsupported patch type: application/apply-patch+yaml",
+		},
+		{
+			name:      "invalid YAML",
+			patch:     "spec: [",
+			patchType: types.MergePatchType,
+			wantErr:   "Decode patch failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := patchJSON([]byte(tt.patch), tt.patchType)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("patchJSON() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("patchJSON() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatch(t *testing.T) {
+	store := newTestObjectStore()
+	var queries []string
+	b64 := testAPIServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPatch {
+			queries = append(queries, r.URL.RawQuery)
+		}
+		store.ServeHTTP(w, r)
+	})
+	web := testStoredConfigMap("web", map[string]interface{}{"a": "1"})
+	web["metadata"].(map[string]interface{})["labels"] = map[string]interface{}{"app": "shop"}
+	store.add(testConfigMapsPath+"web", web)
+	api := testStoredConfigMap("api", map[string]interface{}{"a": "1"})
+	api["metadata"].(map[string]interface{})["labels"] = map[string]interface{}{"app": "shop"}
+	store.add(testConfigMapsPath+"api", api)
+	store.add(testConfigMapsPath+"db", testStoredConfigMap("db", map[string]interface{}{"a": "1"}))
+	store.add("/apis/apps/v1/namespaces/shop/deployments/web/scale", map[string]interface{}{
+		"apiVersion": "autoscaling/v1",
+		"kind":       "Scale",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "shop"},
+		"spec":       map[string]interface{}{"replicas": int64(1)},
+	})
+	ctx := context.Background()
+
+	messages := func(results []ApplyResult) []string {
+		var messages []string
+		for _, r := range results {
+			if r.Err != nil {
+				messages = append(messages, r.Err.Error())
+			} else {
+				messages = append(messages, r.Message)
+			}
+		}
+		return messages
+	}
+
+	// By name
+	results, err := PatchResource(ctx, b64, "configmaps", "web", []byte("data: {b: '2'}"), PatchOptions{Namespace: "shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messages(results); !reflect.DeepEqual(got, []string{"web patched."}) {
+		t.Errorf("PatchResource() = %q, want web patched", got)
+	}
+	if got := store.get(testConfigMapsPath + "web")["data"]; !reflect.DeepEqual(got, map[string]interface{}{"a": "1", "b": "2"}) {
+		t.Errorf("web data = %v, want merged", got)
+	}
+	if want := "fieldManager=kubectl-golang"; len(queries) != 1 || queries[0] != want {
+		t.Errorf("queries = %q, want %q", queries, want)
+	}
+
+	// By label selector
+	results, err = Patch(ctx, b64, schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, "", []byte(`{"data":{"c":"3"}}`),
+		PatchOptions{Type: types.MergePatchType, Namespace: "shop", LabelSelector: "app=shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messages(results); !reflect.DeepEqual(got, []string{"api patched.", "web patched."}) {
+		t.Errorf("Patch() = %q, want api and web patched", got)
+	}
+	if _, ok := store.get(testConfigMapsPath + "db")["data"].(map[string]interface{})["c"]; ok {
+		t.Error("db is patched, want not selected")
+	}
+
+	// The subresource with dry run
+	queries = nil
+	results, err = PatchResource(ctx, b64, "deployments.apps", "web", []byte("spec: {replicas: 3}"),
+		PatchOptions{Type: types.MergePatchType, Namespace: "shop", Subresource: "scale", DryRun: true, FieldManager: "ci"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := messages(results); !reflect.DeepEqual(got, []string{"web patched (dry run)."}) {
+		t.Errorf("PatchResource() = %q, want web patched (dry run)", got)
+	}
+	if want := "dryRun=All&fieldManager=ci"; len(queries) != 1 || queries[0] != want {
+		t.Errorf("queries = %q, want %q", queries, want)
+	}
+
+	// The patch failed
+	results, err = PatchResource(ctx, b64, "configmaps", "absent", []byte("data: {b: '2'}"), PatchOptions{Namespace: "shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Err == nil || !strings.HasPrefix(results[0].Err.Error(), "patch absent failed") {
+		t.Errorf("PatchResource() = %q, want patch absent failed", messages(results))
+	}
+}
+
+func TestPatchInvalid(t *testing.T) {
+	store := newTestObjectStore()
+	b64 := testAPIServer(t, store.ServeHTTP)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		resource string
+		objName  string
+		patch    string
+		opts     PatchOptions
+		wantErr  string
+	}{
+		{
+			name:     "neither name nor selector",
+			resource: "configmaps",
+			patch:    "data: {}",
+			wantErr:  "patch requires either name or label selector",
+		},
+		{
+			name:     "both name and selector",
+			resource: "configmaps",
+			objName:  "web",
+			patch:    "data: {}",
+			opts:     PatchOptions{LabelSelector: "app=shop"},
+			wantErr:  "patch requires either name or label selector",
+		},
+		{
+			name:     "invalid patch",
+			resource: "configmaps",
+			objName:  "web",
+			patch:    "[]",
+			wantErr:  "should be an object",
+		},
+		{
+			name:     "unknown resource",
+			resource: "widgets",
+			objName:  "web",
+			patch:    "spec: {}",
+			wantErr:  "Mapping resource widgets failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := PatchResource(ctx, b64, tt.resource, tt.objName, []byte(tt.patch), tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("PatchResource() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+	if len(store.requests) != 0 {
+		t.Errorf("requests = %q, want none", store.requests)
+	}
+}
@@ -0,0 +1,561 @@
+package gokubectl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// PolicyObject is the key of the object exempted from the rules, Namespace is
+// the one in the manifest, empty if omitted or cluster-scoped.
+type PolicyObject struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// PolicyExemptions are the names of the rules skipped for the objects, "*" skips
+// all of them. They're set by the caller, the manifests can't exempt themselves.
+type PolicyExemptions map[PolicyObject][]string
+
+func (e PolicyExemptions) rules(obj *unstructured.Unstructured) map[string]bool {
+	exempt := map[string]bool{}
+	for _, name := range e[PolicyObject{Kind: obj.GetKind(), Namespace: obj.GetNamespace(), Name: obj.GetName()}] {
+		exempt[name] = true
+	}
+	return exempt
+}
+
+type PolicySeverity string
+
+const (
+	// PolicyWarn reports the violation in the warnings of the result.
+	PolicyWarn PolicySeverity = "Warn"
+	// PolicyDeny stops the whole apply, like the strict validation.
+	PolicyDeny PolicySeverity = "Deny"
+)
+
+type PolicyViolation struct {
+	Rule     string
+	Severity PolicySeverity
+	// Path is the YAML path of the field, e.g. spec.template.spec.containers[0].image
+	Path    string
+	Message string
+}
+
+func (v PolicyViolation) String() string {
+	if v.Path == "" {
+		return fmt.Sprintf("policy %s: %s", v.Rule, v.Message)
+	}
+	return fmt.Sprintf("policy %s: %s: %s", v.Rule, v.Path, v.Message)
+}
+
+// PolicyError is the error of the object denied by the policies.
+type PolicyError struct {
+	Violations []PolicyViolation
+}
+
+func (e *PolicyError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, v.String())
+	}
+	return "denied: " + strings.Join(messages, "; ")
+}
+
+// PolicyRule checks every decoded object before apply. Custom rules are Go funcs,
+// or the field rules read by ReadPolicyFile. CEL expressions are not supported.
+type PolicyRule struct {
+	Name     string
+	Severity PolicySeverity
+	// Kinds limits the rule to the kinds, all kinds if empty
+	Kinds []string
+	// Check returns the violations of the object, the empty Rule and Severity
+	// are filled by the rule.
+	Check func(obj *unstructured.Unstructured) []PolicyViolation
+}
+
+func (rule PolicyRule) matchKind(kind string) bool {
+	if len(rule.Kinds) == 0 {
+		return true
+	}
+	for _, k := range rule.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultPolicies returns the built-in rules: privileged containers, host namespaces
+// and hostPath volumes are denied, the latest images, missing resources and probes
+// are warned.
+func DefaultPolicies() []PolicyRule {
+	return []PolicyRule{
+		{Name: "privileged", Severity: PolicyDeny, Check: checkPrivileged},
+		{Name: "host-namespaces", Severity: PolicyDeny, Check: checkHostNamespaces},
+		{Name: "host-path", Severity: PolicyDeny, Check: checkHostPath},
+		{Name: "latest-tag", Severity: PolicyWarn, Check: checkLatestTag},
+		{Name: "resources", Severity: PolicyWarn, Check: checkResources},
+		{
+			Name:     "probes",
+			Severity: PolicyWarn,
+			Kinds:    []string{"Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "ReplicationController"},
+			Check:    checkProbes,
+		},
+	}
+}
+
+// CheckPolicies checks the manifests without cluster, the results of the objects
+// with violations are returned, Err is set if any of them is denied.
+func CheckPolicies(manifests []Manifest, rules []PolicyRule, exemptions PolicyExemptions) ([]ApplyResult, error) {
+	var result []ApplyResult
+	for _, m := range manifests {
+		obj := &unstructured.Unstructured{}
+		if _, _, err := decUnstructured.Decode(m.Data, nil, obj); err != nil {
+			return nil, errors.Wrapf(err, "%s: decode failed", m.Source)
+		}
+		violations := evaluatePolicies(obj, rules, exemptions)
+		if len(violations) == 0 {
+			continue
+		}
+		result = append(result, policyResult(obj, m.Source, violations))
+	}
+	return result, nil
+}
+
+// checkPolicies returns the violations of every manifest, and the results of the
+// denied ones if any, nothing should be applied then.
+func checkPolicies(manifests []Manifest, rules []PolicyRule, exemptions PolicyExemptions) ([][]PolicyViolation, []ApplyResult) {
+	violations := make([][]PolicyViolation, len(manifests))
+	if len(rules) == 0 {
+		return violations, nil
+	}
+
+	var denied []ApplyResult
+	for i, m := range manifests {
+		obj := &unstructured.Unstructured{}
+		if _, _, err := decUnstructured.Decode(m.Data, nil, obj); err != nil {
+			// Decode error is reported by apply
+			continue
+		}
+		violations[i] = evaluatePolicies(obj, rules, exemptions)
+		if r := policyResult(obj, m.Source, violations[i]); r.Err != nil {
+			denied = append(denied, r)
+		}
+	}
+	return violations, denied
+}
+
+func policyResult(obj *unstructured.Unstructured, source Location, violations []PolicyViolation) ApplyResult {
+	r := newApplyResult(obj, source)
+	r.Violations = violations
+	var deny []PolicyViolation
+	for _, v := range violations {
+		if v.Severity == PolicyDeny {
+			deny = append(deny, v)
+		}
+	}
+	if deny != nil {
+		r.Err = &PolicyError{Violations: deny}
+	}
+	redactResult(obj, &r)
+	return r
+}
+
+func evaluatePolicies(obj *unstructured.Unstructured, rules []PolicyRule, exemptions PolicyExemptions) (result []PolicyViolation) {
+	exempt := exemptions.rules(obj)
+	if exempt["*"] {
+		return nil
+	}
+	for _, rule := range rules {
+		if exempt[rule.Name] || !rule.matchKind(obj.GetKind()) {
+			continue
+		}
+		for _, v := range rule.Check(obj) {
+			if v.Rule == "" {
+				v.Rule = rule.Name
+			}
+			if v.Severity == "" {
+				v.Severity = rule.Severity
+			}
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// podContainer is a container in the pod spec of the workload
+type podContainer struct {
+	path      string
+	container map[string]interface{}
+}
+
+// podSpec returns the pod spec of the workload kinds and its YAML path.
+func podSpec(obj *unstructured.Unstructured) (map[string]interface{}, string) {
+	path, ok := podSpecPaths[obj.GetKind()]
+	if !ok {
+		return nil, ""
+	}
+	spec, _, _ := unstructured.NestedMap(obj.Object, path...)
+	return spec, strings.Join(path, ".")
+}
+
+// podContainers returns the containers of the fields in the pod spec.
+func podContainers(obj *unstructured.Unstructured, fields ...string) (result []podContainer) {
+	spec, path := podSpec(obj)
+	for _, field := range fields {
+		containers, _ := spec[field].([]interface{})
+		for i, c := range containers {
+			if container, ok := c.(map[string]interface{}); ok {
+				result = append(result, podContainer{
+					path:      fmt.Sprintf("%s.%s[%d]", path, field, i),
+					container: container,
+				})
+			}
+		}
+	}
+	return result
+}
+
+func allContainers(obj *unstructured.Unstructured) []podContainer {
+	return podContainers(obj, "initContainers", "containers", "ephemeralContainers")
+}
+
+func checkPrivileged(obj *unstructured.Unstructured) (result []PolicyViolation) {
+	for _, c := range allContainers(obj) {
+		if privileged, _, _ := unstructured.NestedBool(c.container, "securityContext", "privileged"); privileged {
+			result = append(result, PolicyViolation{
+				Path:    c.path + ".securityContext.privileged",
+				Message: "privileged container is not allowed",
+			})
+		}
+	}
+	return result
+}
+
+func checkHostNamespaces(obj *unstructured.Unstructured) (result []PolicyViolation) {
+	spec, path := podSpec(obj)
+	for _, field := range []string{"hostNetwork", "hostPID", "hostIPC"} {
+		if enabled, _ := spec[field].(bool); enabled {
+			result = append(result, PolicyViolation{
+				Path:    path + "." + field,
+				Message: field + " is not allowed",
+			})
+		}
+	}
+	return result
+}
+
+func checkHostPath(obj *unstructured.Unstructured) (result []PolicyViolation) {
+	spec, path := podSpec(obj)
+	volumes, _ := spec["volumes"].([]interface{})
+	for i, v := range volumes {
+		volume, _ := v.(map[string]interface{})
+		if _, ok := volume["hostPath"]; ok {
+			result = append(result, PolicyViolation{
+				Path:    fmt.Sprintf("%s.volumes[%d].hostPath", path, i),
+				Message: "hostPath volume is not allowed",
+			})
+		}
+	}
+	return result
+}
+
+func checkLatestTag(obj *unstructured.Unstructured) (result []PolicyViolation) {
+	for _, c := range allContainers(obj) {
+		image, _ := c.container["image"].(string)
+		if image == "" || strings.Contains(image, "@") {
+			continue
+		}
+		tag := "latest"
+		if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+			tag = image[i+1:]
+		}
+		if tag == "latest" {
+			result = append(result, PolicyViolation{
+				Path:    c.path + ".image",
+				Message: fmt.Sprintf("image %s uses the latest tag, pin a version or digest", image),
+			})
+		}
+	}
+	return result
+}
+
+func checkResources(obj *unstructured.Unstructured) (result []PolicyViolation) {
+	for _, c := range podContainers(obj, "initContainers", "containers") {
+		for _, field := range []string{"requests", "limits"} {
+			for _, resource := range []string{"cpu", "memory"} {
+				if _, found, _ := unstructured.NestedFieldNoCopy(c.container, "resources", field, resource); !found {
+					result = append(result, PolicyViolation{
+						Path:    fmt.Sprintf("%s.resources.%s.%s", c.path, field, resource),
+						Message: "missing " + resource + " " + field,
+					})
+				}
+			}
+		}
+	}
+	return result
+}
+
+func checkProbes(obj *unstructured.Unstructured) (result []PolicyViolation) {
+	for _, c := range podContainers(obj, "containers") {
+		for _, probe := range []string{"readinessProbe", "livenessProbe"} {
+			if _, ok := c.container[probe]; !ok {
+				result = append(result, PolicyViolation{
+					Path:    c.path + "." + probe,
+					Message: "missing " + probe,
+				})
+			}
+		}
+	}
+	return result
+}
+
+// FieldOperator is how a field rule checks the field.
+type FieldOperator string
+
+const (
+	FieldExists     FieldOperator = "Exists"
+	FieldAbsent     FieldOperator = "Absent"
+	FieldEquals     FieldOperator = "Equals"
+	FieldNotEquals  FieldOperator = "NotEquals"
+	FieldMatches    FieldOperator = "Matches"
+	FieldNotMatches FieldOperator = "NotMatches"
+)
+
+// FieldRule is a declarative rule in the policy file, the field at Path must
+// satisfy the operator, otherwise it's a violation. "[]" in the path matches
+// every item of the list, e.g. spec.template.spec.containers[].image, and the
+// keys with dots are quoted, e.g. metadata.labels["app.kubernetes.io/name"].
+type FieldRule struct {
+	Name     string         `json:"name"`
+	Severity PolicySeverity `json:"severity,omitempty"`
+	Kinds    []string       `json:"kinds,omitempty"`
+	Path     string         `json:"path"`
+	Operator FieldOperator  `json:"operator"`
+	Value    string         `json:"value,omitempty"`
+	Message  string         `json:"message,omitempty"`
+}
+
+// PolicyFile is the file of the field rules:
+//
+//	rules:
+//	- name: team-label
+//	  severity: Deny
+//	  kinds: [Deployment]
+//	  path: metadata.labels.team
+//	  operator: Exists
+type PolicyFile struct {
+	Rules []FieldRule `json:"rules"`
+}
+
+// ReadPolicyFile reads the field rules from the file.
+func ReadPolicyFile(path string) ([]PolicyRule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Read policy file failed.")
+	}
+	file := PolicyFile{}
+	if err = yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, errors.Wrapf(err, "Decode policy file %s failed.", path)
+	}
+	rules := make([]PolicyRule, 0, len(file.Rules))
+	for _, fieldRule := range file.Rules {
+		rule, err := fieldRule.PolicyRule()
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s", path)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// PolicyRule compiles the field rule, the default severity is Warn.
+func (f FieldRule) PolicyRule() (PolicyRule, error) {
+	if f.Name == "" || f.Path == "" {
+		return PolicyRule{}, errors.New("rule requires name and path")
+	}
+	switch f.Severity {
+	case "":
+		f.Severity = PolicyWarn
+	case PolicyWarn, PolicyDeny:
+	default:
+		return PolicyRule{}, errors.Errorf("rule %s: unknown severity %q", f.Name, f.Severity)
+	}
+
+	var check func(value interface{}, found bool) bool
+	switch f.Operator {
+	case FieldExists:
+		check = func(_ interface{}, found bool) bool { return found }
+	case FieldAbsent:
+		check = func(_ interface{}, found bool) bool { return !found }
+	case FieldEquals:
+		check = func(value interface{}, found bool) bool { return found && fieldString(value) == f.Value }
+	case FieldNotEquals:
+		check = func(value interface{}, found bool) bool { return !found || fieldString(value) != f.Value }
+	case FieldMatches, FieldNotMatches:
+		re, err := regexp.Compile(f.Value)
+		if err != nil {
+			return PolicyRule{}, errors.Wrapf(err, "rule %s", f.Name)
+		}
+		if f.Operator == FieldMatches {
+			check = func(value interface{}, found bool) bool { return found && re.MatchString(fieldString(value)) }
+		} else {
+			check = func(value interface{}, found bool) bool { return !found || !re.MatchString(fieldString(value)) }
+		}
+	default:
+		return PolicyRule{}, errors.Errorf("rule %s: unknown operator %q", f.Name, f.Operator)
+	}
+
+	segments, err := parseFieldPath(f.Path)
+	if err != nil {
+		return PolicyRule{}, errors.Wrapf(err, "rule %s", f.Name)
+	}
+	message := f.Message
+	if message == "" {
+		message = strings.TrimSpace(fmt.Sprintf("should satisfy %s %s", f.Operator, f.Value))
+	}
+	return PolicyRule{
+		Name:     f.Name,
+		Severity: f.Severity,
+		Kinds:    f.Kinds,
+		Check: func(obj *unstructured.Unstructured) (result []PolicyViolation) {
+			for _, field := range walkFields(obj.Object, "", segments) {
+				if !check(field.value, field.found) {
+					result = append(result, PolicyViolation{Path: field.path, Message: message})
+				}
+			}
+			return result
+		},
+	}, nil
+}
+
+func fieldString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// fieldValue is a field matched by the path of a field rule
+type fieldValue struct {
+	path  string
+	value interface{}
+	found bool
+}
+
+// fieldSegment is a key in the path of a field rule, list matches every item
+// of the list at the key.
+type fieldSegment struct {
+	name string
+	list bool
+}
+
+// parseFieldPath splits the path by dots, a key in brackets is a Go quoted string
+// which may contain dots, e.g. metadata.annotations["example.com/owner"].
+func parseFieldPath(path string) ([]fieldSegment, error) {
+	var segments []fieldSegment
+	rest := path
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, "[]"):
+			if len(segments) == 0 || segments[len(segments)-1].list {
+				return nil, errors.Errorf("invalid path %q: unexpected []", path)
+			}
+			segments[len(segments)-1].list = true
+			rest = rest[2:]
+		case strings.HasPrefix(rest, `["`):
+			end := 2
+			for ; end < len(rest) && rest[end] != '"'; end++ {
+				if rest[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(rest) || !strings.HasPrefix(rest[end+1:], "]") {
+				return nil, errors.Errorf("invalid path %q: unterminated quoted key", path)
+			}
+			name, err := strconv.Unquote(rest[1 : end+1])
+			if err != nil {
+				return nil, errors.Errorf("invalid path %q: %v", path, err)
+			}
+			segments = append(segments, fieldSegment{name: name})
+			rest = rest[end+2:]
+		default:
+			if len(segments) != 0 {
+				if rest[0] != '.' {
+					return nil, errors.Errorf("invalid path %q: expect . or [ before %q", path, rest)
+				}
+				rest = rest[1:]
+			}
+			n := strings.IndexAny(rest, ".[")
+			if n < 0 {
+				n = len(rest)
+			}
+			if n == 0 {
+				return nil, errors.Errorf("invalid path %q: empty key", path)
+			}
+			segments = append(segments, fieldSegment{name: rest[:n]})
+			rest = rest[n:]
+		}
+	}
+	if len(segments) == 0 {
+		return nil, errors.Errorf("invalid path %q", path)
+	}
+	return segments, nil
+}
+
+// appendFieldPath appends the key to the path of the violation, quoted if it
+// can't be split by dots.
+func appendFieldPath(prefix string, name string) string {
+	if name == "" || strings.ContainsAny(name, `.[]"`) {
+		return prefix + "[" + strconv.Quote(name) + "]"
+	}
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// walkFields returns the fields matched by the path segments, the missing one is
+// returned with the full path and found false. The empty lists match nothing.
+func walkFields(value interface{}, prefix string, segments []fieldSegment) []fieldValue {
+	if len(segments) == 0 {
+		return []fieldValue{{path: prefix, value: value, found: true}}
+	}
+	segment := segments[0]
+	path := appendFieldPath(prefix, segment.name)
+	m, _ := value.(map[string]interface{})
+	child, ok := m[segment.name]
+	if !ok {
+		for i, s := range segments {
+			if i != 0 {
+				path = appendFieldPath(path, s.name)
+			}
+			if s.list {
+				path += "[]"
+			}
+		}
+		return []fieldValue{{path: path}}
+	}
+	if !segment.list {
+		return walkFields(child, path, segments[1:])
+	}
+
+	var result []fieldValue
+	items, _ := child.([]interface{})
+	for i, item := range items {
+		result = append(result, walkFields(item, fmt.Sprintf("%s[%d]", path, i), segments[1:])...)
+	}
+	return result
+}
@@ -0,0 +1,386 @@
+package gokubectl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+func decodeTestObject(t *testing.T, data string) *unstructured.Unstructured {
+	t.Helper()
+	obj := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal([]byte(data), &obj.Object); err != nil {
+		t.Fatal(err)
+	}
+	return obj
+}
+
+const privilegedDaemonSet = `
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: agent
+  namespace: kube-system
+  annotations:
+    gokubectl.io/policy-exempt: "*"
+spec:
+  template:
+    spec:
+      hostNetwork: true
+      containers:
+      - name: agent
+        image: agent:1.0
+        securityContext:
+          privileged: true
+`
+
+func TestEvaluatePoliciesIgnoresAnnotation(t *testing.T) {
+	obj := decodeTestObject(t, privilegedDaemonSet)
+	violations := evaluatePolicies(obj, DefaultPolicies(), nil)
+	var denied []string
+	for _, v := range violations {
+		if v.Severity == PolicyDeny {
+			denied = append(denied, v.Rule)
+		}
+	}
+	if want := []string{"privileged", "host-namespaces"}; !reflect.DeepEqual(denied, want) {
+		t.Errorf("denied rules = %v, want %v", denied, want)
+	}
+}
+
+func TestEvaluatePoliciesExemptions(t *testing.T) {
+	obj := decodeTestObject(t, privilegedDaemonSet)
+	key := PolicyObject{Kind: "DaemonSet", Namespace: "kube-system", Name: "agent"}
+	tests := []struct {
+		name       string
+		exemptions PolicyExemptions
+		want       []string
+	}{
+		{
+			name:       "one rule",
+			exemptions: PolicyExemptions{key: {"privileged"}},
+			want:       []string{"host-namespaces"},
+		},
+		{
+			name:       "all rules",
+			exemptions: PolicyExemptions{key: {"*"}},
+		},
+		{
+			name:       "other object",
+			exemptions: PolicyExemptions{{Kind: "DaemonSet", Namespace: "default", Name: "agent"}: {"*"}},
+			want:       []string{"privileged", "host-namespaces"},
+		},
+	}
+	for _, tt := range tests {
+		var denied []string
+		for _, v := range evaluatePolicies(obj, DefaultPolicies(), tt.exemptions) {
+			if v.Severity == PolicyDeny {
+				denied = append(denied, v.Rule)
+			}
+		}
+		if !reflect.DeepEqual(denied, tt.want) {
+			t.Errorf("%s: denied rules = %v, want %v", tt.name, denied, tt.want)
+		}
+	}
+}
+
+func TestParseFieldPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    []fieldSegment
+		wantErr bool
+	}{
+		{path: "metadata.labels.team", want: []fieldSegment{{name: "metadata"}, {name: "labels"}, {name: "team"}}},
+		{path: `metadata.labels["app.kubernetes.io/name"]`, want: []fieldSegment{{name: "metadata"}, {name: "labels"}, {name: "app.kubernetes.io/name"}}},
+		{path: "spec.containers[].image", want: []fieldSegment{{name: "spec"}, {name: "containers", list: true}, {name: "image"}}},
+		{path: `a["x\"y"][].b`, want: []fieldSegment{{name: "a"}, {name: `x"y`, list: true}, {name: "b"}}},
+		{path: "", wantErr: true},
+		{path: "a..b", wantErr: true},
+		{path: "a.", wantErr: true},
+		{path: "[]", wantErr: true},
+		{path: "a[][]", wantErr: true},
+		{path: `a["x`, wantErr: true},
+		{path: `a["x"]b`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseFieldPath(tt.path)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseFieldPath(%q) = %v, want error", tt.path, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseFieldPath(%q) failed: %v", tt.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseFieldPath(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFieldRule(t *testing.T) {
+	obj := decodeTestObject(t, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    app.kubernetes.io/name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: registry.example.com/web:1.0
+      - name: sidecar
+        image: docker.io/proxy:1.0
+`)
+	tests := []struct {
+		rule FieldRule
+		want []string
+	}{
+		{
+			rule: FieldRule{Path: `metadata.labels["app.kubernetes.io/name"]`, Operator: FieldEquals, Value: "web"},
+		},
+		{
+			rule: FieldRule{Path: `metadata.labels["app.kubernetes.io/part-of"]`, Operator: FieldExists},
+			want: []string{`metadata.labels["app.kubernetes.io/part-of"]`},
+		},
+		{
+			rule: FieldRule{Path: "spec.template.spec.containers[].image", Operator: FieldMatches, Value: "^registry.example.com/"},
+			want: []string{"spec.template.spec.containers[1].image"},
+		},
+		{
+			rule: FieldRule{Path: "spec.template.spec.volumes[].hostPath", Operator: FieldAbsent},
+		},
+		{
+			rule: FieldRule{Path: "spec.template.spec.missing[].x", Operator: FieldExists},
+			want: []string{"spec.template.spec.missing[].x"},
+		},
+	}
+	for _, tt := range tests {
+		tt.rule.Name = "test"
+		rule, err := tt.rule.PolicyRule()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.rule.Path, err)
+		}
+		var paths []string
+		for _, v := range rule.Check(obj) {
+			paths = append(paths, v.Path)
+		}
+		if !reflect.DeepEqual(paths, tt.want) {
+			t.Errorf("%s: violations = %v, want %v", tt.rule.Path, paths, tt.want)
+		}
+	}
+}
+
+func TestDefaultPolicies(t *testing.T) {
+	tests := []struct {
+		name string
+		obj  string
+		want []string
+	}{
+		{
+			name: "compliant",
+			obj: `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: web}
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web@sha256:0123
+        resources:
+          requests: {cpu: 100m, memory: 64Mi}
+          limits: {cpu: 200m, memory: 128Mi}
+        readinessProbe: {httpGet: {path: /, port: 80}}
+        livenessProbe: {httpGet: {path: /, port: 80}}
+`,
+		},
+		{
+			name: "host and privileged init container",
+			obj: `
+apiVersion: v1
+kind: Pod
+metadata: {name: web}
+spec:
+  hostPID: true
+  hostIPC: false
+  volumes:
+  - {name: data, emptyDir: {}}
+  - {name: root, hostPath: {path: /}}
+  initContainers:
+  - name: init
+    image: busybox:1.32
+    securityContext: {privileged: true}
+    resources:
+      requests: {cpu: 100m, memory: 64Mi}
+      limits: {cpu: 100m, memory: 64Mi}
+`,
+			want: []string{
+				"privileged: spec.initContainers[0].securityContext.privileged",
+				"host-namespaces: spec.hostPID",
+				"host-path: spec.volumes[1].hostPath",
+			},
+		},
+		{
+			// The port of the registry isn't the tag, and the probes aren't checked in CronJobs
+			name: "latest tags and resources",
+			obj: `
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata: {name: backup}
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: a
+            image: registry.example.com:5000/backup
+            resources:
+              requests: {cpu: 100m, memory: 64Mi}
+              limits: {cpu: 100m}
+          - name: b
+            image: backup:latest
+            resources:
+              requests: {cpu: 100m, memory: 64Mi}
+              limits: {cpu: 100m, memory: 64Mi}
+`,
+			want: []string{
+				"latest-tag: spec.jobTemplate.spec.template.spec.containers[0].image",
+				"latest-tag: spec.jobTemplate.spec.template.spec.containers[1].image",
+				"resources: spec.jobTemplate.spec.template.spec.containers[0].resources.limits.memory",
+			},
+		},
+		{
+			name: "probes",
+			obj: `
+apiVersion: apps/v1
+kind: StatefulSet
+metadata: {name: db}
+spec:
+  template:
+    spec:
+      containers:
+      - name: db
+        image: db:1.0
+        resources:
+          requests: {cpu: 100m, memory: 64Mi}
+          limits: {cpu: 100m, memory: 64Mi}
+        livenessProbe: {tcpSocket: {port: 5432}}
+`,
+			want: []string{"probes: spec.template.spec.containers[0].readinessProbe"},
+		},
+		{
+			name: "not a workload",
+			obj:  "apiVersion: v1\nkind: ConfigMap\nmetadata: {name: web}\ndata: {image: web}\n",
+		},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, v := range evaluatePolicies(decodeTestObject(t, tt.obj), DefaultPolicies(), nil) {
+			got = append(got, v.Rule+": "+v.Path)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: violations = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPolicies(t *testing.T) {
+	manifests := []Manifest{
+		{Data: []byte(testConfigMapManifest("web")), Source: Location{File: "cm.yaml", Line: 1, Item: -1}},
+		{Data: []byte(privilegedDaemonSet), Source: Location{File: "ds.yaml", Line: 1, Item: -1}},
+		{Data: []byte("apiVersion: v1\nkind: Pod\nmetadata: {name: web}\nspec:\n  containers:\n  - {name: web, image: web}\n"), Source: Location{File: "pod.yaml", Line: 1, Item: -1}},
+	}
+	results, err := CheckPolicies(manifests, DefaultPolicies(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The objects without violations are omitted
+	if len(results) != 2 || results[0].Name != "agent" || results[1].Name != "web" {
+		t.Fatalf("CheckPolicies() = %v, want the DaemonSet and the Pod", results)
+	}
+	if policyErr, ok := results[0].Err.(*PolicyError); !ok || len(policyErr.Violations) != 2 ||
+		!strings.HasPrefix(policyErr.Error(), "denied: policy privileged: spec.template.spec.containers[0].securityContext.privileged") {
+		t.Errorf("Err of DaemonSet = %v, want denied by privileged and host-namespaces", results[0].Err)
+	}
+	// The warnings don't fail
+	if results[1].Err != nil || len(results[1].Violations) != 5 || results[1].Source != manifests[2].Source {
+		t.Errorf("Pod = %+v, want the latest tag and resources warned", results[1])
+	}
+
+	if _, err = CheckPolicies([]Manifest{{Data: []byte("{"), Source: Location{File: "bad.yaml"}}}, DefaultPolicies(), nil); err == nil {
+		t.Error("CheckPolicies() of the invalid manifest succeeds")
+	}
+}
+
+func TestReadPolicyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			data: `
+rules:
+- name: team-label
+  severity: Deny
+  kinds: [Deployment]
+  path: metadata.labels.team
+  operator: Exists
+- name: registry
+  path: spec.template.spec.containers[].image
+  operator: Matches
+  value: ^registry.example.com/
+`,
+		},
+		{name: "unknown field", data: "rules:\n- name: a\n  path: a\n  operator: Exists\n  level: Deny\n", wantErr: "Decode policy file"},
+		{name: "unknown operator", data: "rules:\n- name: a\n  path: a\n  operator: Contains\n", wantErr: `rule a: unknown operator "Contains"`},
+		{name: "unknown severity", data: "rules:\n- name: a\n  path: a\n  operator: Exists\n  severity: Error\n", wantErr: `rule a: unknown severity "Error"`},
+		{name: "invalid regexp", data: "rules:\n- name: a\n  path: a\n  operator: Matches\n  value: '('\n", wantErr: "rule a: error parsing regexp"},
+		{name: "invalid path", data: "rules:\n- name: a\n  path: a..b\n  operator: Exists\n", wantErr: "rule a"},
+		{name: "no name", data: "rules:\n- path: a\n  operator: Exists\n", wantErr: "rule requires name and path"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, "policy.yaml")
+		if err = ioutil.WriteFile(path, []byte(tt.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		rules, err := ReadPolicyFile(path)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: ReadPolicyFile() err = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(rules) != 2 || rules[0].Severity != PolicyDeny || rules[1].Severity != PolicyWarn ||
+			!rules[0].matchKind("Deployment") || rules[0].matchKind("DaemonSet") || !rules[1].matchKind("DaemonSet") {
+			t.Errorf("%s: ReadPolicyFile() = %+v, want the team-label denied in Deployments and registry warned", tt.name, rules)
+		}
+	}
+	if _, err = ReadPolicyFile(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("ReadPolicyFile() of the missing file succeeds")
+	}
+}
@@ -0,0 +1,361 @@
+package gokubectl
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/k8s"
+)
+
+const (
+	defaultResyncPeriod  = 10 * time.Minute
+	watchRetryInterval   = 5 * time.Second
+	defaultLeaseDuration = 15 * time.Second
+	defaultRenewDeadline = 10 * time.Second
+	defaultRetryPeriod   = 2 * time.Second
+)
+
+type ReconcileOptions struct {
+	// Apply is the options of every apply
+	Apply ApplyOptions
+	// ResyncPeriod re-applies all the manifests periodically, default 10 minutes.
+	ResyncPeriod time.Duration
+	// LeaderElection runs the reconciler only on the leader if not nil
+	LeaderElection *LeaderElectionOptions
+	// Logf logs the drift and apply events, default log.Printf
+	Logf func(format string, args ...interface{})
+}
+
+// LeaderElectionOptions elects the leader by a Lease, so that the replicas
+// of the reconciler don't fight.
+type LeaderElectionOptions struct {
+	// Namespace and Name of the Lease
+	Namespace string
+	Name      string
+	// Identity is the unique identity of the replica, default the hostname
+	Identity string
+
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// Reconcile applies the manifests and watches the applied objects, the objects
+// are re-applied once the fields of manifest are changed or the objects are
+// deleted by others. It blocks until ctx is done.
+func Reconcile(ctx context.Context, base64KubeConfig string, manifests []Manifest, opts ReconcileOptions) error {
+	if opts.ResyncPeriod <= 0 {
+		opts.ResyncPeriod = defaultResyncPeriod
+	}
+	if opts.Logf == nil {
+		opts.Logf = log.Printf
+	}
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  base64KubeConfig,
+		DiscoveryCacheDir: opts.Apply.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.Apply.DiscoveryCacheTTL,
+		QPS:               opts.Apply.QPS,
+		Burst:             opts.Apply.Burst,
+	}
+	// Decrypt before render, which re-encodes the documents and breaks the MAC
+	// of SOPS and the armor of age
+	manifests, err := decryptManifests(manifests, opts.Apply.AgeIdentities)
+	if err != nil {
+		return err
+	}
+	if opts.Apply.Render != nil {
+		rendered, err := renderManifests(kubeClient, manifests, *opts.Apply.Render)
+		if err != nil {
+			return errors.Wrap(err, "render manifest failed")
+		}
+		manifests, opts.Apply.Render = rendered, nil
+	}
+
+	r := &reconciler{
+		kubeClient: kubeClient,
+		manifests:  manifests,
+		opts:       opts,
+	}
+	if opts.LeaderElection == nil {
+		r.run(ctx)
+		return nil
+	}
+	return r.runWithLeaderElection(ctx, *opts.LeaderElection)
+}
+
+type reconciler struct {
+	kubeClient *k8s.KubeClient
+	manifests  []Manifest
+	opts       ReconcileOptions
+}
+
+func (r *reconciler) run(ctx context.Context) {
+	r.applyAll(ctx)
+
+	var wg sync.WaitGroup
+	for _, m := range r.desiredManifests() {
+		desired, dr, err := buildDynamicResourceClient(r.kubeClient, m.Data, r.opts.Apply.NamespaceOptions)
+		if err != nil {
+			r.opts.Logf("[Error] %s: %v, not watched", m.Source, err)
+			continue
+		}
+		wg.Add(1)
+		go func(m Manifest) {
+			defer wg.Done()
+			r.watchObject(ctx, m, desired, dr)
+		}(m)
+	}
+
+	ticker := time.NewTicker(r.opts.ResyncPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.applyAll(ctx)
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+	}
+}
+
+// desiredManifests are the manifests prepared like apply, i.e. decrypted, rendered
+// and converted, so they are compared with the live objects as applied.
+func (r *reconciler) desiredManifests() []Manifest {
+	prepared, invalid, err := prepareManifests(r.kubeClient, r.manifests, r.opts.Apply)
+	if err != nil {
+		r.opts.Logf("[Error] prepare manifests failed: %v, not watched", err)
+		return nil
+	}
+	for _, result := range invalid {
+		r.opts.Logf("[Error] %s, not watched", result)
+	}
+	if invalid != nil {
+		return nil
+	}
+	return prepared.manifests
+}
+
+func (r *reconciler) applyAll(ctx context.Context) {
+	results, err := applyManifests(ctx, r.kubeClient, r.manifests, r.opts.Apply)
+	if err != nil {
+		r.opts.Logf("[Error] apply failed: %v", err)
+		return
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			r.opts.Logf("[Error] apply %s %s failed: %s", result.Kind, result.Name, result)
+		}
+	}
+}
+
+// watchObject watches the object by name until ctx is done, the watch is
+// restarted once closed by the server.
+func (r *reconciler) watchObject(ctx context.Context, m Manifest, desired *unstructured.Unstructured, dr dynamic.ResourceInterface) {
+	var resourceVersion string
+	for ctx.Err() == nil {
+		w, err := dr.Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", desired.GetName()).String(),
+			ResourceVersion: resourceVersion,
+		})
+		if err != nil {
+			r.opts.Logf("[Warn] watch %s %s failed: %v", desired.GetKind(), desired.GetName(), err)
+			select {
+			case <-time.After(watchRetryInterval):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		resourceVersion = r.handleEvents(ctx, w, m, desired)
+		w.Stop()
+	}
+}
+
+// handleEvents handles the events until the watch is closed, the last seen
+// resource version is returned to resume the watch.
+func (r *reconciler) handleEvents(ctx context.Context, w watch.Interface, m Manifest, desired *unstructured.Unstructured) string {
+	var resourceVersion string
+	for {
+		select {
+		case <-ctx.Done():
+			return resourceVersion
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return resourceVersion
+			}
+			if event.Type == watch.Error {
+				// The resource version is too old, watch from the latest one
+				if status, ok := event.Object.(*metav1.Status); ok && status.Code == 410 {
+					return ""
+				}
+				err := k8sErrors.FromObject(event.Object)
+				r.opts.Logf("[Warn] watch %s %s: %v", desired.GetKind(), desired.GetName(), err)
+				return ""
+			}
+			live, ok := event.Object.(*unstructured.Unstructured)
+			if !ok {
+				continue
+			}
+			resourceVersion = live.GetResourceVersion()
+
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				paths := driftPaths(desired.Object, live.Object, "")
+				if len(paths) == 0 {
+					continue
+				}
+				r.opts.Logf("[Info] %s %s/%s drifted: %v", desired.GetKind(), desired.GetNamespace(), desired.GetName(), paths)
+			case watch.Deleted:
+				r.opts.Logf("[Info] %s %s/%s was deleted", desired.GetKind(), desired.GetNamespace(), desired.GetName())
+			default:
+				continue
+			}
+			r.reapply(ctx, m)
+		}
+	}
+}
+
+func (r *reconciler) reapply(ctx context.Context, m Manifest) {
+	results, err := applyManifests(ctx, r.kubeClient, []Manifest{m}, r.opts.Apply)
+	if err != nil {
+		r.opts.Logf("[Error] re-apply %s failed: %v", m.Source, err)
+		return
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			r.opts.Logf("[Error] re-apply %s %s failed: %s", result.Kind, result.Name, result)
+		} else {
+			r.opts.Logf("[Info] %s %s re-applied", result.Kind, result.Name)
+		}
+	}
+}
+
+func (r *reconciler) runWithLeaderElection(ctx context.Context, le LeaderElectionOptions) error {
+	if le.Namespace == "" || le.Name == "" {
+		return errors.New("namespace and name of the leader election lease are required")
+	}
+	if le.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		le.Identity = hostname
+	}
+	if le.LeaseDuration <= 0 {
+		le.LeaseDuration = defaultLeaseDuration
+	}
+	if le.RenewDeadline <= 0 {
+		le.RenewDeadline = defaultRenewDeadline
+	}
+	if le.RetryPeriod <= 0 {
+		le.RetryPeriod = defaultRetryPeriod
+	}
+
+	clientSet, err := r.kubeClient.GetClientSet()
+	if err != nil {
+		return err
+	}
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, le.Namespace, le.Name,
+		clientSet.CoreV1(), clientSet.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: le.Identity})
+	if err != nil {
+		return err
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   le.LeaseDuration,
+		RenewDeadline:   le.RenewDeadline,
+		RetryPeriod:     le.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            le.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				r.opts.Logf("[Info] %s became the leader, reconciling", le.Identity)
+				r.run(ctx)
+			},
+			OnStoppedLeading: func() {
+				r.opts.Logf("[Info] %s stopped leading", le.Identity)
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Run returns once the leadership is lost, become a candidate again
+	for ctx.Err() == nil {
+		elector.Run(ctx)
+	}
+	return nil
+}
+
+// driftPaths returns the paths of the fields in desired which are different
+// in live. The fields only in live are set by the server or others, and
+// status is owned by the controllers, they are not drift.
+func driftPaths(desired, live interface{}, path string) []string {
+	switch d := desired.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return []string{pathOrRoot(path)}
+		}
+		keys := make([]string, 0, len(d))
+		for key := range d {
+			if path == "" && key == "status" {
+				continue
+			}
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		var paths []string
+		for _, key := range keys {
+			liveValue, ok := l[key]
+			if !ok {
+				if d[key] != nil {
+					paths = append(paths, joinPath(path, key))
+				}
+				continue
+			}
+			paths = append(paths, driftPaths(d[key], liveValue, joinPath(path, key))...)
+		}
+		return paths
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok || len(l) != len(d) {
+			return []string{pathOrRoot(path)}
+		}
+		var paths []string
+		for i := range d {
+			paths = append(paths, driftPaths(d[i], l[i], fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return paths
+	default:
+		if !reflect.DeepEqual(desired, live) && fmt.Sprint(desired) != fmt.Sprint(live) {
+			return []string{pathOrRoot(path)}
+		}
+		return nil
+	}
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "<root>"
+	}
+	return path
+}
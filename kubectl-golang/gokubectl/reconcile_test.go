@@ -0,0 +1,175 @@
+package gokubectl
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/k8s"
+)
+
+func TestDriftPaths(t *testing.T) {
+	tests := []struct {
+		name          string
+		desired, live string
+		want          []string
+	}{
+		{
+			name:    "same",
+			desired: `{"spec":{"replicas":1,"ports":[{"port":80}]}}`,
+			live:    `{"spec":{"replicas":1,"ports":[{"port":80}]}}`,
+		},
+		{
+			// The fields set by the server aren't drift
+			name:    "fields only in live",
+			desired: `{"metadata":{"name":"web"},"spec":{"replicas":1}}`,
+			live:    `{"metadata":{"name":"web","uid":"1"},"spec":{"replicas":1,"paused":false}}`,
+		},
+		{
+			name:    "status ignored",
+			desired: `{"status":{"ready":true}}`,
+			live:    `{"status":{"ready":false}}`,
+		},
+		{
+			name:    "status below the root",
+			desired: `{"spec":{"status":"on"}}`,
+			live:    `{"spec":{"status":"off"}}`,
+			want:    []string{"spec.status"},
+		},
+		{
+			name:    "changed and removed",
+			desired: `{"spec":{"replicas":3,"paused":true,"strategy":null},"data":{"b":"2","a":"1"}}`,
+			live:    `{"spec":{"replicas":1},"data":{"a":"x","b":"2"}}`,
+			want:    []string{"data.a", "spec.paused", "spec.replicas"},
+		},
+		{
+			name:    "list items",
+			desired: `{"ports":[{"port":80},{"port":443,"name":"https"}]}`,
+			live:    `{"ports":[{"port":80},{"port":8443,"name":"https"}]}`,
+			want:    []string{"ports[1].port"},
+		},
+		{
+			name:    "list length",
+			desired: `{"args":["a","b"]}`,
+			live:    `{"args":["a"]}`,
+			want:    []string{"args"},
+		},
+		{
+			name:    "type changed",
+			desired: `{"spec":{"selector":{"app":"web"}}}`,
+			live:    `{"spec":{"selector":"app=web"}}`,
+			want:    []string{"spec.selector"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := driftPaths(decodeTestJSON(t, tt.desired), decodeTestJSON(t, tt.live), "")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("driftPaths(%s, %s) = %v, want %v", tt.desired, tt.live, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDriftPathsNumbers(t *testing.T) {
+	// The numbers of manifest and the watch are decoded into different types
+	desired := map[string]interface{}{"replicas": float64(3), "port": int64(80)}
+	live := map[string]interface{}{"replicas": int64(3), "port": float64(80)}
+	if got := driftPaths(desired, live, ""); got != nil {
+		t.Errorf("driftPaths() = %v, want no drift", got)
+	}
+	if got := driftPaths(desired, []interface{}{"a"}, ""); !reflect.DeepEqual(got, []string{"<root>"}) {
+		t.Errorf("driftPaths() = %v, want [<root>]", got)
+	}
+}
+
+func TestHandleEvents(t *testing.T) {
+	var logs []string
+	r := &reconciler{
+		kubeClient: &k8s.KubeClient{},
+		opts: ReconcileOptions{Logf: func(format string, args ...interface{}) {
+			logs = append(logs, fmt.Sprintf(format, args...))
+		}},
+	}
+	desired := testConfigMap("")
+	desired.SetNamespace("shop")
+	desired.Object["data"] = map[string]interface{}{"a": "1"}
+	m := Manifest{Data: []byte("{}"), Source: Location{File: "cm.yaml", Line: 1, Item: -1}}
+
+	live := func(resourceVersion, value string) *unstructured.Unstructured {
+		obj := desired.DeepCopy()
+		obj.SetResourceVersion(resourceVersion)
+		obj.SetUID("1")
+		obj.Object["data"] = map[string]interface{}{"a": value}
+		return obj
+	}
+
+	w := watch.NewFakeWithChanSize(4, false)
+	w.Add(live("1", "1"))
+	w.Modify(live("2", "1"))
+	w.Modify(live("3", "2"))
+	w.Stop()
+	if got := r.handleEvents(context.Background(), w, m, desired); got != "3" {
+		t.Errorf("handleEvents() = %q, want the last resource version 3", got)
+	}
+	joined := strings.Join(logs, "\n")
+	if !strings.Contains(joined, "ConfigMap shop/web drifted: [data.a]") || strings.Count(joined, "drifted") != 1 {
+		t.Errorf("logs = %q, want the drift of data.a once", logs)
+	}
+	// The apply fails without the cluster, it's logged
+	if !strings.Contains(joined, "re-apply") {
+		t.Errorf("logs = %q, want the re-apply", logs)
+	}
+
+	logs = nil
+	w = watch.NewFakeWithChanSize(2, false)
+	w.Delete(live("4", "1"))
+	w.Stop()
+	r.handleEvents(context.Background(), w, m, desired)
+	if len(logs) == 0 || logs[0] != "[Info] ConfigMap shop/web was deleted" {
+		t.Errorf("logs = %q, want the deletion", logs)
+	}
+}
+
+func TestHandleEventsError(t *testing.T) {
+	var logs []string
+	r := &reconciler{opts: ReconcileOptions{Logf: func(format string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}}}
+	desired := testConfigMap("")
+
+	tests := []struct {
+		name    string
+		status  *metav1.Status
+		wantLog bool
+	}{
+		// The resource version is too old, resumed from the latest quietly
+		{name: "gone", status: &metav1.Status{Status: metav1.StatusFailure, Code: 410, Reason: metav1.StatusReasonExpired}},
+		{name: "internal error", status: &metav1.Status{Status: metav1.StatusFailure, Code: 500, Message: "etcd timeout"}, wantLog: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs = nil
+			w := watch.NewFakeWithChanSize(2, false)
+			w.Add(desired.DeepCopy())
+			w.Error(tt.status)
+			if got := r.handleEvents(context.Background(), w, Manifest{}, desired); got != "" {
+				t.Errorf("handleEvents() = %q, want the latest", got)
+			}
+			if gotLog := len(logs) == 1 && strings.Contains(logs[0], "etcd timeout"); gotLog != tt.wantLog {
+				t.Errorf("logs = %q, want logged %v", logs, tt.wantLog)
+			}
+		})
+	}
+
+	// ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.handleEvents(ctx, watch.NewFake(), Manifest{}, desired)
+}
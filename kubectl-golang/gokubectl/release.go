@@ -0,0 +1,506 @@
+package gokubectl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/k8s"
+)
+
+const (
+	defaultReleaseNamespace  = "default"
+	defaultReleaseMaxHistory = 10
+	// maxReleaseNameLength keeps the storage name and the label value valid
+	maxReleaseNameLength = 53
+
+	releaseDataKey    = "release"
+	releaseSecretType = "gokubectl.io/release.v1"
+
+	releaseOwnerLabel    = "owner"
+	releaseOwner         = "gokubectl"
+	releaseNameLabel     = "gokubectl.io/release"
+	releaseRevisionLabel = "gokubectl.io/revision"
+	releaseStatusLabel   = "gokubectl.io/status"
+)
+
+type ReleaseStorage string
+
+const (
+	// ReleaseStorageSecret stores the releases in Secrets, the manifests may contain Secrets
+	ReleaseStorageSecret ReleaseStorage = "Secret"
+	// ReleaseStorageConfigMap stores the releases in ConfigMaps, the releases with
+	// Secrets not encrypted by SOPS or age are rejected.
+	ReleaseStorageConfigMap ReleaseStorage = "ConfigMap"
+)
+
+type ReleaseStatus string
+
+const (
+	// ReleaseDeployed is the latest revision applied successfully
+	ReleaseDeployed ReleaseStatus = "deployed"
+	// ReleaseSuperseded was deployed before the latest one
+	ReleaseSuperseded ReleaseStatus = "superseded"
+	// ReleaseFailed is the revision which any object failed to apply
+	ReleaseFailed ReleaseStatus = "failed"
+)
+
+type ReleaseOptions struct {
+	// Namespace is where the revisions are stored, default "default"
+	Namespace string
+	// Storage is the kind of the revisions, default Secret
+	Storage ReleaseStorage
+	// MaxHistory is the max number of revisions kept, default 10. The oldest ones
+	// are deleted, the latest deployed one is always kept.
+	MaxHistory int
+
+	// User is who applies the release, default the login user and the hostname
+	User string
+	// Description is recorded with the revision
+	Description string
+
+	// Apply is the options of applying the manifests
+	Apply ApplyOptions
+}
+
+func (opts *ReleaseOptions) complete() {
+	if opts.Namespace == "" {
+		opts.Namespace = defaultReleaseNamespace
+	}
+	if opts.Storage == "" {
+		opts.Storage = ReleaseStorageSecret
+	}
+	if opts.MaxHistory <= 0 {
+		opts.MaxHistory = defaultReleaseMaxHistory
+	}
+	if opts.User == "" {
+		opts.User = currentUser()
+	}
+}
+
+// ReleaseResult is the result of one object in the release
+type ReleaseResult struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	Message   string `json:"message,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Release is one revision of the named manifest set. The manifests are recorded
+// as they are given, e.g. the ones encrypted by SOPS are kept encrypted.
+type Release struct {
+	Name        string          `json:"name"`
+	Revision    int             `json:"revision"`
+	Status      ReleaseStatus   `json:"status"`
+	Time        time.Time       `json:"time"`
+	User        string          `json:"user"`
+	Description string          `json:"description,omitempty"`
+	Manifests   []Manifest      `json:"manifests"`
+	Results     []ReleaseResult `json:"results"`
+}
+
+// ApplyRelease applies the manifests and records them as the next revision of the
+// release. The objects removed from the manifests are not deleted.
+func ApplyRelease(ctx context.Context, base64KubeConfig string, name string, manifests []Manifest, opts ReleaseOptions) (*Release, []ApplyResult, error) {
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  base64KubeConfig,
+		DiscoveryCacheDir: opts.Apply.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.Apply.DiscoveryCacheTTL,
+		QPS:               opts.Apply.QPS,
+		Burst:             opts.Apply.Burst,
+	}
+	opts.complete()
+	return applyRelease(ctx, kubeClient, name, manifests, opts)
+}
+
+// ReleaseHistory returns the revisions of the release in order, the oldest first.
+func ReleaseHistory(ctx context.Context, base64KubeConfig string, name string, opts ReleaseOptions) ([]*Release, error) {
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  base64KubeConfig,
+		DiscoveryCacheDir: opts.Apply.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.Apply.DiscoveryCacheTTL,
+	}
+	opts.complete()
+	if err := validateReleaseName(name); err != nil {
+		return nil, err
+	}
+	return (&releaseStore{kubeClient: kubeClient, opts: opts}).list(ctx, name)
+}
+
+// GetReleaseStatus returns the latest revision of the release.
+func GetReleaseStatus(ctx context.Context, base64KubeConfig string, name string, opts ReleaseOptions) (*Release, error) {
+	history, err := ReleaseHistory(ctx, base64KubeConfig, name, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		return nil, errors.Errorf("release %s not found", name)
+	}
+	return history[len(history)-1], nil
+}
+
+// RollbackRelease applies the manifests of the revision again as a new revision,
+// revision 0 is the one deployed before the latest deployed one.
+func RollbackRelease(ctx context.Context, base64KubeConfig string, name string, revision int, opts ReleaseOptions) (*Release, []ApplyResult, error) {
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  base64KubeConfig,
+		DiscoveryCacheDir: opts.Apply.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.Apply.DiscoveryCacheTTL,
+		QPS:               opts.Apply.QPS,
+		Burst:             opts.Apply.Burst,
+	}
+	opts.complete()
+	if err := validateReleaseName(name); err != nil {
+		return nil, nil, err
+	}
+	history, err := (&releaseStore{kubeClient: kubeClient, opts: opts}).list(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	target, err := rollbackTarget(history, revision)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "release %s", name)
+	}
+	if opts.Description == "" {
+		opts.Description = fmt.Sprintf("Rollback to %d", target.Revision)
+	}
+	return applyRelease(ctx, kubeClient, name, target.Manifests, opts)
+}
+
+// ReleaseAt returns the revision which was deployed at the time, nil if none.
+func ReleaseAt(history []*Release, t time.Time) *Release {
+	var deployed *Release
+	for _, r := range history {
+		if r.Time.After(t) {
+			break
+		}
+		if r.Status != ReleaseFailed {
+			deployed = r
+		}
+	}
+	return deployed
+}
+
+func rollbackTarget(history []*Release, revision int) (*Release, error) {
+	if revision > 0 {
+		for _, r := range history {
+			if r.Revision == revision {
+				return r, nil
+			}
+		}
+		return nil, errors.Errorf("revision %d not found", revision)
+	}
+
+	// The one before the latest deployed
+	var deployed []*Release
+	for _, r := range history {
+		if r.Status != ReleaseFailed {
+			deployed = append(deployed, r)
+		}
+	}
+	if len(deployed) < 2 {
+		return nil, errors.New("no previous revision to roll back to")
+	}
+	return deployed[len(deployed)-2], nil
+}
+
+func applyRelease(ctx context.Context, kubeClient *k8s.KubeClient, name string, manifests []Manifest, opts ReleaseOptions) (*Release, []ApplyResult, error) {
+	if err := validateReleaseName(name); err != nil {
+		return nil, nil, err
+	}
+	if opts.Storage == ReleaseStorageConfigMap {
+		if source, ok := findPlaintextSecret(manifests); ok {
+			return nil, nil, errors.Errorf("%s: Secret isn't encrypted, it can't be stored in ConfigMap, encrypt it by SOPS or use the Secret storage", source)
+		}
+	}
+	store := &releaseStore{kubeClient: kubeClient, opts: opts}
+	history, err := store.list(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	release := &Release{
+		Name:        name,
+		Revision:    1,
+		Status:      ReleaseDeployed,
+		Time:        time.Now().UTC(),
+		User:        opts.User,
+		Description: opts.Description,
+		Manifests:   manifests,
+	}
+	if len(history) != 0 {
+		release.Revision = history[len(history)-1].Revision + 1
+	}
+
+	results, applyErr := applyManifests(ctx, kubeClient, manifests, opts.Apply)
+	for _, r := range results {
+		result := ReleaseResult{
+			Kind:      r.Kind,
+			Namespace: r.Namespace,
+			Name:      r.Name,
+			Message:   r.Message,
+		}
+		if r.Err != nil {
+			result.Error = r.String()
+			release.Status = ReleaseFailed
+		}
+		release.Results = append(release.Results, result)
+	}
+	if applyErr != nil {
+		release.Status = ReleaseFailed
+		release.Results = append(release.Results, ReleaseResult{Error: applyErr.Error()})
+	}
+
+	// The revision is recorded even if failed, so that the history tells what happened
+	if err = store.create(ctx, release); err != nil {
+		return nil, results, err
+	}
+	if release.Status == ReleaseDeployed {
+		for _, r := range history {
+			if r.Status != ReleaseDeployed {
+				continue
+			}
+			r.Status = ReleaseSuperseded
+			if err = store.update(ctx, r); err != nil {
+				return release, results, err
+			}
+		}
+	}
+	if err = store.prune(ctx, append(history, release)); err != nil {
+		return release, results, err
+	}
+	return release, results, applyErr
+}
+
+func validateReleaseName(name string) error {
+	if len(name) > maxReleaseNameLength {
+		return errors.Errorf("invalid release name %q: longer than %d", name, maxReleaseNameLength)
+	}
+	if errs := validation.IsDNS1123Label(name); len(errs) != 0 {
+		return errors.Errorf("invalid release name %q: %s", name, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// findPlaintextSecret returns the source of the first Secret which isn't encrypted
+// by SOPS or age.
+func findPlaintextSecret(manifests []Manifest) (Location, bool) {
+	for _, m := range manifests {
+		if isAgeEncrypted(m.Data) {
+			continue
+		}
+		var obj map[string]interface{}
+		if err := yaml.Unmarshal(m.Data, &obj); err != nil {
+			continue
+		}
+		if _, encrypted := obj[sopsField]; !encrypted && obj["kind"] == "Secret" {
+			return m.Source, true
+		}
+	}
+	return Location{}, false
+}
+
+// currentUser returns user@host, the identity of kubeconfig is not known by the client.
+func currentUser() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		name = u.Username
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		name += "@" + host
+	}
+	return name
+}
+
+// releaseStore keeps every revision in a Secret or ConfigMap named like
+// gokubectl.release.NAME.v3, the data is the release in gzipped JSON.
+type releaseStore struct {
+	kubeClient *k8s.KubeClient
+	opts       ReleaseOptions
+}
+
+func releaseObjectName(name string, revision int) string {
+	return fmt.Sprintf("gokubectl.release.%s.v%d", name, revision)
+}
+
+func releaseLabels(r *Release) map[string]string {
+	return map[string]string{
+		releaseOwnerLabel:    releaseOwner,
+		releaseNameLabel:     r.Name,
+		releaseRevisionLabel: strconv.Itoa(r.Revision),
+		releaseStatusLabel:   string(r.Status),
+	}
+}
+
+func (s *releaseStore) list(ctx context.Context, name string) ([]*Release, error) {
+	clientSet, err := s.kubeClient.GetClientSet()
+	if err != nil {
+		return nil, err
+	}
+	listOpts := metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s,%s=%s", releaseOwnerLabel, releaseOwner, releaseNameLabel, name),
+	}
+
+	var data [][]byte
+	switch s.opts.Storage {
+	case ReleaseStorageConfigMap:
+		list, err := clientSet.CoreV1().ConfigMaps(s.opts.Namespace).List(ctx, listOpts)
+		if err != nil {
+			return nil, errors.Wrap(err, "list releases failed")
+		}
+		for _, item := range list.Items {
+			data = append(data, item.BinaryData[releaseDataKey])
+		}
+	default:
+		list, err := clientSet.CoreV1().Secrets(s.opts.Namespace).List(ctx, listOpts)
+		if err != nil {
+			return nil, errors.Wrap(err, "list releases failed")
+		}
+		for _, item := range list.Items {
+			data = append(data, item.Data[releaseDataKey])
+		}
+	}
+
+	releases := make([]*Release, 0, len(data))
+	for _, d := range data {
+		r, err := decodeRelease(d)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decode release %s failed", name)
+		}
+		releases = append(releases, r)
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].Revision < releases[j].Revision
+	})
+	return releases, nil
+}
+
+func (s *releaseStore) create(ctx context.Context, r *Release) error {
+	return s.write(ctx, r, true)
+}
+
+func (s *releaseStore) update(ctx context.Context, r *Release) error {
+	return s.write(ctx, r, false)
+}
+
+func (s *releaseStore) write(ctx context.Context, r *Release, create bool) error {
+	clientSet, err := s.kubeClient.GetClientSet()
+	if err != nil {
+		return err
+	}
+	data, err := encodeRelease(r)
+	if err != nil {
+		return err
+	}
+	objectMeta := metav1.ObjectMeta{
+		Name:      releaseObjectName(r.Name, r.Revision),
+		Namespace: s.opts.Namespace,
+		Labels:    releaseLabels(r),
+	}
+
+	switch s.opts.Storage {
+	case ReleaseStorageConfigMap:
+		cm := &corev1.ConfigMap{
+			ObjectMeta: objectMeta,
+			BinaryData: map[string][]byte{releaseDataKey: data},
+		}
+		if create {
+			_, err = clientSet.CoreV1().ConfigMaps(s.opts.Namespace).Create(ctx, cm, metav1.CreateOptions{})
+		} else {
+			_, err = clientSet.CoreV1().ConfigMaps(s.opts.Namespace).Update(ctx, cm, metav1.UpdateOptions{})
+		}
+	default:
+		secret := &corev1.Secret{
+			ObjectMeta: objectMeta,
+			Type:       releaseSecretType,
+			Data:       map[string][]byte{releaseDataKey: data},
+		}
+		if create {
+			_, err = clientSet.CoreV1().Secrets(s.opts.Namespace).Create(ctx, secret, metav1.CreateOptions{})
+		} else {
+			_, err = clientSet.CoreV1().Secrets(s.opts.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+		}
+	}
+	if k8sErrors.IsAlreadyExists(err) {
+		return errors.Errorf("revision %d of release %s already exists, another apply may be in progress", r.Revision, r.Name)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "save revision %d of release %s failed", r.Revision, r.Name)
+	}
+	return nil
+}
+
+// prune deletes the oldest revisions beyond MaxHistory, except the deployed one.
+func (s *releaseStore) prune(ctx context.Context, history []*Release) error {
+	clientSet, err := s.kubeClient.GetClientSet()
+	if err != nil {
+		return err
+	}
+	for i := 0; len(history)-i > s.opts.MaxHistory; i++ {
+		r := history[i]
+		if r.Status == ReleaseDeployed {
+			continue
+		}
+		name := releaseObjectName(r.Name, r.Revision)
+		if s.opts.Storage == ReleaseStorageConfigMap {
+			err = clientSet.CoreV1().ConfigMaps(s.opts.Namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		} else {
+			err = clientSet.CoreV1().Secrets(s.opts.Namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		}
+		if err != nil && !k8sErrors.IsNotFound(err) {
+			return errors.Wrapf(err, "prune revision %d of release %s failed", r.Revision, r.Name)
+		}
+	}
+	return nil
+}
+
+func encodeRelease(r *Release) ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "encode release failed")
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeRelease(data []byte) (*Release, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err = ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	release := &Release{}
+	if err = json.Unmarshal(data, release); err != nil {
+		return nil, err
+	}
+	return release, nil
+}
@@ -0,0 +1,239 @@
+package gokubectl
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateReleaseName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{name: "web"},
+		{name: "web-2"},
+		{name: strings.Repeat("a", 53)},
+		{name: strings.Repeat("a", 54), wantErr: true},
+		{name: "", wantErr: true},
+		{name: "Web", wantErr: true},
+		{name: "web.prod", wantErr: true},
+		{name: "-web", wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := validateReleaseName(tt.name); (err != nil) != tt.wantErr {
+			t.Errorf("validateReleaseName(%q) = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestFindPlaintextSecret(t *testing.T) {
+	configMap := Manifest{Data: []byte(testConfigMapManifest("web")), Source: Location{File: "cm.yaml", Line: 1, Item: -1}}
+	secret := Manifest{Data: []byte("apiVersion: v1\nkind: Secret\nmetadata:\n  name: db\n"), Source: Location{File: "db.yaml", Line: 1, Item: -1}}
+	sops := Manifest{Data: []byte("apiVersion: v1\nkind: Secret\nmetadata:\n  name: db\nsops: {}\n"), Source: Location{File: "sops.yaml", Line: 1, Item: -1}}
+
+	tests := []struct {
+		name      string
+		manifests []Manifest
+		want      Location
+		wantFound bool
+	}{
+		{name: "no Secret", manifests: []Manifest{configMap}},
+		{name: "SOPS", manifests: []Manifest{configMap, sops}},
+		{name: "plaintext", manifests: []Manifest{configMap, sops, secret}, want: secret.Source, wantFound: true},
+	}
+	for _, tt := range tests {
+		got, found := findPlaintextSecret(tt.manifests)
+		if got != tt.want || found != tt.wantFound {
+			t.Errorf("%s: findPlaintextSecret() = %v, %v, want %v, %v", tt.name, got, found, tt.want, tt.wantFound)
+		}
+	}
+}
+
+func TestEncodeRelease(t *testing.T) {
+	r := &Release{
+		Name:      "web",
+		Revision:  3,
+		Status:    ReleaseDeployed,
+		Time:      time.Date(2021, 6, 1, 15, 0, 0, 0, time.UTC),
+		User:      "ops@host",
+		Manifests: []Manifest{{Data: []byte(testConfigMapManifest("web")), Source: Location{File: "cm.yaml", Line: 1, Item: -1}}},
+		Results:   []ReleaseResult{{Kind: "ConfigMap", Namespace: "shop", Name: "web", Message: "web patched."}},
+	}
+	data, err := encodeRelease(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeRelease(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, r) {
+		t.Errorf("decodeRelease(encodeRelease()) = %+v, want %+v", got, r)
+	}
+	if _, err = decodeRelease([]byte(`{"name":"web"}`)); err == nil {
+		t.Error("decodeRelease() of the data not gzipped succeeds")
+	}
+}
+
+func testHistory(statuses ...ReleaseStatus) []*Release {
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	history := make([]*Release, len(statuses))
+	for i, status := range statuses {
+		history[i] = &Release{Name: "web", Revision: i + 1, Status: status, Time: start.AddDate(0, 0, i)}
+	}
+	return history
+}
+
+func TestReleaseAt(t *testing.T) {
+	history := testHistory(ReleaseSuperseded, ReleaseFailed, ReleaseDeployed)
+	tests := []struct {
+		t    time.Time
+		want int
+	}{
+		{history[0].Time.Add(-time.Second), 0},
+		{history[0].Time, 1},
+		// The failed revision isn't deployed
+		{history[1].Time.Add(time.Hour), 1},
+		{history[2].Time.AddDate(1, 0, 0), 3},
+	}
+	for _, tt := range tests {
+		got := ReleaseAt(history, tt.t)
+		if (got == nil && tt.want != 0) || (got != nil && got.Revision != tt.want) {
+			t.Errorf("ReleaseAt(%v) = %v, want revision %d", tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestRollbackTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		history  []*Release
+		revision int
+		want     int
+		wantErr  string
+	}{
+		{name: "previous", history: testHistory(ReleaseSuperseded, ReleaseSuperseded, ReleaseDeployed), want: 2},
+		{name: "failed skipped", history: testHistory(ReleaseSuperseded, ReleaseFailed, ReleaseDeployed, ReleaseFailed), want: 1},
+		{name: "revision", history: testHistory(ReleaseSuperseded, ReleaseFailed, ReleaseDeployed), revision: 2, want: 2},
+		{name: "revision not found", history: testHistory(ReleaseDeployed), revision: 5, wantErr: "revision 5 not found"},
+		{name: "no previous", history: testHistory(ReleaseFailed, ReleaseDeployed), wantErr: "no previous revision"},
+	}
+	for _, tt := range tests {
+		got, err := rollbackTarget(tt.history, tt.revision)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: rollbackTarget() err = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got.Revision != tt.want {
+			t.Errorf("%s: rollbackTarget() = %v, %v, want revision %d", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestApplyRelease(t *testing.T) {
+	store := newTestObjectStore()
+	b64 := testAPIServer(t, store.ServeHTTP)
+	ctx := context.Background()
+
+	const releasesPath = "/api/v1/namespaces/default/secrets/"
+	manifest := func(name, value string) Manifest {
+		return Manifest{Data: []byte(testConfigMapManifest(name) + "data: {a: \"" + value + "\"}\n")}
+	}
+	opts := ReleaseOptions{
+		MaxHistory: 3,
+		User:       "ops@host",
+		Apply:      ApplyOptions{NamespaceOptions: NamespaceOptions{DefaultNamespace: "shop"}},
+	}
+	statuses := func() []string {
+		history, err := ReleaseHistory(ctx, b64, "web", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range history {
+			got = append(got, string(r.Status))
+		}
+		return got
+	}
+
+	r, _, err := ApplyRelease(ctx, b64, "web", []Manifest{manifest("web", "1")}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Revision != 1 || r.Status != ReleaseDeployed || r.User != "ops@host" || len(r.Results) != 1 {
+		t.Errorf("ApplyRelease() = %+v, want revision 1 deployed", r)
+	}
+	secret := store.get(releasesPath + "gokubectl.release.web.v1")
+	if secret == nil || secret["type"] != releaseSecretType {
+		t.Fatalf("revision 1 = %v, want the Secret of the release", secret)
+	}
+
+	if _, _, err = ApplyRelease(ctx, b64, "web", []Manifest{manifest("web", "2")}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := statuses(), []string{"superseded", "deployed"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+
+	// The failed revision is recorded, the deployed one is kept
+	store.failures["PATCH "+testConfigMapsPath+"db"] = 500
+	r, _, err = ApplyRelease(ctx, b64, "web", []Manifest{manifest("web", "3"), manifest("db", "1")}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Revision != 3 || r.Status != ReleaseFailed || r.Results[1].Error == "" {
+		t.Errorf("ApplyRelease() = %+v, want revision 3 failed by db", r)
+	}
+	if got, want := statuses(), []string{"superseded", "deployed", "failed"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+	if status, err := GetReleaseStatus(ctx, b64, "web", opts); err != nil || status.Revision != 3 {
+		t.Errorf("GetReleaseStatus() = %v, %v, want revision 3", status, err)
+	}
+
+	// Roll back to the one before the deployed, the oldest is pruned
+	r, _, err = RollbackRelease(ctx, b64, "web", 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Revision != 4 || r.Status != ReleaseDeployed || r.Description != "Rollback to 1" {
+		t.Errorf("RollbackRelease() = %+v, want revision 4 rolled back to 1", r)
+	}
+	if data := store.get(testConfigMapsPath + "web")["data"]; !reflect.DeepEqual(data, map[string]interface{}{"a": "1"}) {
+		t.Errorf("data of web = %v, want the one of revision 1", data)
+	}
+	if store.get(releasesPath+"gokubectl.release.web.v1") != nil {
+		t.Error("revision 1 isn't pruned")
+	}
+	if got, want := statuses(), []string{"superseded", "failed", "deployed"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("statuses = %v, want %v", got, want)
+	}
+
+	if _, err = GetReleaseStatus(ctx, b64, "api", opts); err == nil || err.Error() != "release api not found" {
+		t.Errorf("GetReleaseStatus() err = %v, want not found", err)
+	}
+}
+
+func TestApplyReleaseConfigMapStorage(t *testing.T) {
+	store := newTestObjectStore()
+	b64 := testAPIServer(t, store.ServeHTTP)
+	opts := ReleaseOptions{Storage: ReleaseStorageConfigMap}
+
+	secret := Manifest{Data: []byte("apiVersion: v1\nkind: Secret\nmetadata:\n  name: db\n"), Source: Location{File: "db.yaml", Line: 1, Item: -1}}
+	_, _, err := ApplyRelease(context.Background(), b64, "web", []Manifest{secret}, opts)
+	if err == nil || !strings.HasPrefix(err.Error(), secret.Source.String()+": Secret isn't encrypted") {
+		t.Errorf("ApplyRelease() err = %v, want the plaintext Secret rejected", err)
+	}
+	if len(store.requests) != 0 {
+		t.Errorf("requests = %v, want nothing applied", store.requests)
+	}
+
+	if _, _, err = ApplyRelease(context.Background(), b64, "Web", nil, opts); err == nil || !strings.Contains(err.Error(), "invalid release name") {
+		t.Errorf("ApplyRelease() err = %v, want the invalid name", err)
+	}
+}
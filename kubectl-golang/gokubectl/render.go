@@ -0,0 +1,240 @@
+package gokubectl
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/k8s"
+)
+
+var (
+	// ${VAR} is replaced by the variable, $${VAR} is escaped to ${VAR}
+	varRegexp = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+// RenderOptions are the changes made on the manifest before apply, so that
+// one manifest can be shared by all the environments.
+type RenderOptions struct {
+	// Vars replaces ${VAR} in the manifest
+	Vars map[string]string
+	// UseEnv looks up the environment variables if not found in Vars
+	UseEnv bool
+
+	// CommonLabels are added to all the objects and pod templates,
+	// the selectors are not changed since they are immutable.
+	CommonLabels map[string]string
+	// CommonAnnotations are added to all the objects and pod templates
+	CommonAnnotations map[string]string
+	// Namespace replaces the namespace of the namespaced objects
+	Namespace string
+	// ImageTags replaces the image tag of the containers, key is the container name
+	ImageTags map[string]string
+
+	// Patches are the strategic merge patches in YAML or JSON, every patch
+	// should contain the apiVersion, kind and metadata.name of the target,
+	// like the patchesStrategicMerge of kustomize.
+	Patches [][]byte
+}
+
+// Render returns the manifest rendered by the options.
+func Render(data []byte, opts RenderOptions) ([]byte, error) {
+	data, err := substituteVars(data, opts.Vars, opts.UseEnv)
+	if err != nil {
+		return nil, err
+	}
+	manifests, err := ReadBytes("", data)
+	if err != nil {
+		return nil, err
+	}
+	if manifests, err = renderObjects(manifests, opts, builtinNamespaced); err != nil {
+		return nil, err
+	}
+	return joinManifests(manifests), nil
+}
+
+// renderManifests renders the manifests which are already read, the variables
+// are replaced in every manifest. The scopes of the kinds are resolved by the
+// cluster if kubeClient isn't nil.
+func renderManifests(kubeClient *k8s.KubeClient, manifests []Manifest, opts RenderOptions) ([]Manifest, error) {
+	substituted := make([]Manifest, 0, len(manifests))
+	for _, m := range manifests {
+		data, err := substituteVars(m.Data, opts.Vars, opts.UseEnv)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s", m.Source)
+		}
+		substituted = append(substituted, Manifest{Data: data, Source: m.Source})
+	}
+	return renderObjects(substituted, opts, clusterNamespaced(kubeClient))
+}
+
+func renderObjects(manifests []Manifest, opts RenderOptions, namespaced namespacedFunc) ([]Manifest, error) {
+	patches, err := decodePatches(opts.Patches)
+	if err != nil {
+		return nil, err
+	}
+	manifests, err = transformManifests(manifests, func(obj *unstructured.Unstructured) error {
+		if opts.Namespace != "" {
+			setNamespace(obj, opts.Namespace, namespaced)
+		}
+		addCommonMetadata(obj, "labels", opts.CommonLabels)
+		addCommonMetadata(obj, "annotations", opts.CommonAnnotations)
+		if err := setImageTags(obj, opts.ImageTags); err != nil {
+			return err
+		}
+		for _, patch := range patches {
+			if !patch.matches(obj) {
+				continue
+			}
+			if err := patch.apply(obj); err != nil {
+				return err
+			}
+			patch.matched = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, patch := range patches {
+		if !patch.matched {
+			return nil, errors.Errorf("patch target %s %s not found", patch.GetKind(), patch.GetName())
+		}
+	}
+	return manifests, nil
+}
+
+// substituteVars replaces the variables, all the missing variables are
+// returned in the error.
+func substituteVars(data []byte, vars map[string]string, useEnv bool) ([]byte, error) {
+	missing := make(map[string]bool)
+	data = varRegexp.ReplaceAllFunc(data, func(match []byte) []byte {
+		if strings.HasPrefix(string(match), "$$") {
+			return match[1:]
+		}
+		name := string(varRegexp.FindSubmatch(match)[1])
+		if value, ok := vars[name]; ok {
+			return []byte(value)
+		}
+		if useEnv {
+			if value, ok := os.LookupEnv(name); ok {
+				return []byte(value)
+			}
+		}
+		missing[name] = true
+		return match
+	})
+	if len(missing) != 0 {
+		names := make([]string, 0, len(missing))
+		for name := range missing {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, errors.Errorf("variables not defined: %s", strings.Join(names, ", "))
+	}
+	return data, nil
+}
+
+func addCommonMetadata(obj *unstructured.Unstructured, field string, values map[string]string) {
+	if len(values) == 0 {
+		return
+	}
+	paths := [][]string{{"metadata", field}}
+	if path, ok := podSpecPaths[obj.GetKind()]; ok && obj.GetKind() != "Pod" {
+		// pod template is the parent of pod spec
+		template := append([]string{}, path[:len(path)-1]...)
+		paths = append(paths, append(template, "metadata", field))
+	}
+
+	for _, path := range paths {
+		existing, _, _ := unstructured.NestedStringMap(obj.Object, path...)
+		if existing == nil {
+			existing = make(map[string]string)
+		}
+		for k, v := range values {
+			existing[k] = v
+		}
+		_ = unstructured.SetNestedStringMap(obj.Object, existing, path...)
+	}
+}
+
+type overlayPatch struct {
+	*unstructured.Unstructured
+	matched bool
+}
+
+func decodePatches(patches [][]byte) ([]*overlayPatch, error) {
+	var result []*overlayPatch
+	for i, data := range patches {
+		obj := &unstructured.Unstructured{}
+		if _, _, err := decUnstructured.Decode(data, nil, obj); err != nil {
+			return nil, errors.Wrapf(err, "decode patch %d failed", i)
+		}
+		if obj.GetName() == "" {
+			return nil, errors.Errorf("patch %d has no metadata.name", i)
+		}
+		result = append(result, &overlayPatch{Unstructured: obj})
+	}
+	return result, nil
+}
+
+func (patch *overlayPatch) matches(obj *unstructured.Unstructured) bool {
+	return patch.GroupVersionKind() == obj.GroupVersionKind() && patch.GetName() == obj.GetName()
+}
+
+// apply merges the patch into obj, built-in kinds use strategic merge patch,
+// custom resources use JSON merge patch.
+func (patch *overlayPatch) apply(obj *unstructured.Unstructured) error {
+	patchMap := runtime.DeepCopyJSON(patch.Object)
+	// The patch must not move the object to other namespace
+	unstructured.RemoveNestedField(patchMap, "metadata", "namespace")
+
+	versionedObj, err := scheme.Scheme.New(obj.GroupVersionKind())
+	if err != nil {
+		if !runtime.IsNotRegisteredError(err) {
+			return err
+		}
+		obj.Object = mergePatch(obj.Object, patchMap)
+		return nil
+	}
+
+	lookupPatchMeta, err := strategicpatch.NewPatchMetaFromStruct(versionedObj)
+	if err != nil {
+		return err
+	}
+	merged, err := strategicpatch.StrategicMergeMapPatchUsingLookupPatchMeta(obj.Object, patchMap, lookupPatchMeta)
+	if err != nil {
+		return errors.Wrapf(err, "patch %s %s failed", obj.GetKind(), obj.GetName())
+	}
+	obj.Object = merged
+	return nil
+}
+
+// mergePatch applies the JSON merge patch (RFC 7386) to the object.
+func mergePatch(obj, patch map[string]interface{}) map[string]interface{} {
+	if obj == nil {
+		obj = make(map[string]interface{})
+	}
+	for key, patchValue := range patch {
+		if patchValue == nil {
+			delete(obj, key)
+			continue
+		}
+		patchMap, patchIsMap := patchValue.(map[string]interface{})
+		if !patchIsMap {
+			obj[key] = patchValue
+			continue
+		}
+		objMap, _ := obj[key].(map[string]interface{})
+		obj[key] = mergePatch(objMap, patchMap)
+	}
+	return obj
+}
@@ -0,0 +1,240 @@
+package gokubectl
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestSubstituteVars(t *testing.T) {
+	os.Setenv("GOKUBECTL_TEST_ENV", "from-env")
+	defer os.Unsetenv("GOKUBECTL_TEST_ENV")
+	vars := map[string]string{"NAME": "web", "TAG": "1.0", "EMPTY": ""}
+
+	tests := []struct {
+		data    string
+		useEnv  bool
+		want    string
+		wantErr string
+	}{
+		{data: "name: ${NAME}", want: "name: web"},
+		{data: "image: nginx:${TAG}-${NAME}", want: "image: nginx:1.0-web"},
+		{data: "value: '${EMPTY}'", want: "value: ''"},
+		{data: "value: $${NAME}", want: "value: ${NAME}"},
+		{data: "value: $NAME {NAME}", want: "value: $NAME {NAME}"},
+		{data: "value: ${GOKUBECTL_TEST_ENV}", useEnv: true, want: "value: from-env"},
+		{data: "value: ${GOKUBECTL_TEST_ENV}", wantErr: "variables not defined: GOKUBECTL_TEST_ENV"},
+		// Vars are looked up before the environment
+		{data: "value: ${NAME}", useEnv: true, want: "value: web"},
+		{data: "${B} ${A} ${B}", wantErr: "variables not defined: A, B"},
+	}
+	for _, tt := range tests {
+		got, err := substituteVars([]byte(tt.data), vars, tt.useEnv)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("substituteVars(%q) err = %v, want %q", tt.data, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("substituteVars(%q) failed: %v", tt.data, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("substituteVars(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+}
+
+const renderManifest = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: ${NAME}
+  labels: {app: web}
+spec:
+  selector:
+    matchLabels: {app: web}
+  template:
+    metadata:
+      labels: {app: web}
+    spec:
+      containers:
+      - name: web
+        image: web:1.0
+        env:
+        - {name: MODE, value: dev}
+      - name: sidecar
+        image: envoy:1.16
+---
+apiVersion: example.com/v1
+kind: CronTab
+metadata:
+  name: backup
+spec:
+  schedule: "0 * * * *"
+  hosts: [a, b]
+  retries: 3
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: prod
+`
+
+func TestRender(t *testing.T) {
+	out, err := Render([]byte(renderManifest), RenderOptions{
+		Vars:              map[string]string{"NAME": "web"},
+		CommonLabels:      map[string]string{"team": "shop"},
+		CommonAnnotations: map[string]string{"owner": "ops"},
+		Namespace:         "prod",
+		ImageTags:         map[string]string{"web": "2.0"},
+		Patches: [][]byte{
+			[]byte(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: other
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: web
+        env:
+        - {name: MODE, value: prod}
+`),
+			[]byte(`{"apiVersion":"example.com/v1","kind":"CronTab","metadata":{"name":"backup"},"spec":{"hosts":["c"],"retries":null}}`),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := ReadBytes("", out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 3 {
+		t.Fatalf("Render() = %d documents, want 3", len(manifests))
+	}
+
+	deploy := decodeTestObject(t, string(manifests[0].Data))
+	if deploy.GetName() != "web" || deploy.GetNamespace() != "prod" {
+		t.Errorf("Deployment %s/%s, want prod/web", deploy.GetNamespace(), deploy.GetName())
+	}
+	wantLabels := map[string]string{"app": "web", "team": "shop"}
+	for _, path := range [][]string{{"metadata", "labels"}, {"spec", "template", "metadata", "labels"}} {
+		if labels, _, _ := unstructured.NestedStringMap(deploy.Object, path...); !reflect.DeepEqual(labels, wantLabels) {
+			t.Errorf("%s = %v, want %v", strings.Join(path, "."), labels, wantLabels)
+		}
+	}
+	if annotations, _, _ := unstructured.NestedStringMap(deploy.Object, "spec", "template", "metadata", "annotations"); annotations["owner"] != "ops" {
+		t.Errorf("annotations of the pod template = %v, want the common annotations", annotations)
+	}
+	// The selector is immutable
+	if selector, _, _ := unstructured.NestedStringMap(deploy.Object, "spec", "selector", "matchLabels"); !reflect.DeepEqual(selector, map[string]string{"app": "web"}) {
+		t.Errorf("spec.selector.matchLabels = %v, want it unchanged", selector)
+	}
+	if replicas, _, _ := unstructured.NestedFloat64(deploy.Object, "spec", "replicas"); replicas != 3 {
+		t.Errorf("spec.replicas = %v, want 3 of the patch", replicas)
+	}
+	// The containers and env are merged by name
+	containers, _, _ := unstructured.NestedSlice(deploy.Object, "spec", "template", "spec", "containers")
+	want := decodeTestJSON(t, `{"containers":[
+		{"name":"web","image":"web:2.0","env":[{"name":"MODE","value":"prod"}]},
+		{"name":"sidecar","image":"envoy:1.16"}]}`)["containers"]
+	if !reflect.DeepEqual(containers, want) {
+		t.Errorf("containers = %v, want %v", containers, want)
+	}
+
+	// The lists of custom resources are replaced
+	cronTab := decodeTestObject(t, string(manifests[1].Data))
+	wantSpec := map[string]interface{}{"schedule": "0 * * * *", "hosts": []interface{}{"c"}}
+	if !reflect.DeepEqual(cronTab.Object["spec"], wantSpec) {
+		t.Errorf("spec of CronTab = %v, want %v", cronTab.Object["spec"], wantSpec)
+	}
+	if namespace := decodeTestObject(t, string(manifests[2].Data)); namespace.GetNamespace() != "" {
+		t.Errorf("namespace of Namespace = %q, want empty", namespace.GetNamespace())
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts RenderOptions
+		want string
+	}{
+		{
+			name: "missing variable",
+			opts: RenderOptions{},
+			want: "variables not defined: NAME",
+		},
+		{
+			name: "patch target not found",
+			opts: RenderOptions{
+				Vars:    map[string]string{"NAME": "web"},
+				Patches: [][]byte{[]byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: api\n")},
+			},
+			want: "patch target Deployment api not found",
+		},
+		{
+			// The version is a part of the target
+			name: "patch of other version",
+			opts: RenderOptions{
+				Vars:    map[string]string{"NAME": "web"},
+				Patches: [][]byte{[]byte("apiVersion: example.com/v2\nkind: CronTab\nmetadata:\n  name: backup\n")},
+			},
+			want: "patch target CronTab backup not found",
+		},
+		{
+			name: "patch without name",
+			opts: RenderOptions{
+				Vars:    map[string]string{"NAME": "web"},
+				Patches: [][]byte{[]byte("apiVersion: apps/v1\nkind: Deployment\n")},
+			},
+			want: "patch 0 has no metadata.name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Render([]byte(renderManifest), tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Render() err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderManifestsSources(t *testing.T) {
+	manifests := []Manifest{
+		{Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: ${NAME}\n"), Source: Location{File: "a.yaml", Line: 1, Item: -1}},
+		{Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: ${OTHER}\n"), Source: Location{File: "b.yaml", Line: 1, Item: -1}},
+	}
+	_, err := renderManifests(nil, manifests, RenderOptions{Vars: map[string]string{"NAME": "web"}})
+	if err == nil || !strings.HasPrefix(err.Error(), manifests[1].Source.String()) {
+		t.Errorf("renderManifests() err = %v, want the source of b.yaml", err)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	// The three-way patches applied to the current object
+	for _, tt := range []struct {
+		original, modified, current, want string
+	}{
+		{`{"a":1,"b":2}`, `{"a":1}`, `{"a":1,"b":2,"c":3}`, `{"a":1,"c":3}`},
+		{`{"spec":{"x":1,"y":2}}`, `{"spec":{"x":3}}`, `{"spec":{"x":1,"y":2,"z":4}}`, `{"spec":{"x":3,"z":4}}`},
+		{`{"items":["a","b"]}`, `{"items":["c"]}`, `{"items":["a","b"]}`, `{"items":["c"]}`},
+		{`{"a":{"x":1}}`, `{"a":"s"}`, `{"a":{"x":1}}`, `{"a":"s"}`},
+		{``, `{"spec":{"x":1}}`, `{"status":{}}`, `{"spec":{"x":1},"status":{}}`},
+	} {
+		current := decodeTestJSON(t, tt.current)
+		patch := threeWayMergePatch(decodeTestJSON(t, tt.original), decodeTestJSON(t, tt.modified), current)
+		if got := mergePatch(current, patch); !reflect.DeepEqual(got, decodeTestJSON(t, tt.want)) {
+			t.Errorf("mergePatch(%s, threeWayMergePatch()) = %v, want %s", tt.current, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,579 @@
+package gokubectl
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	yamlv2 "gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// sopsField is the metadata of SOPS, the document is encrypted if it exists
+	sopsField   = "sops"
+	sopsVersion = "3.7.1"
+	// sopsNonceSize is the IV size used by SOPS, not the standard 12 bytes of GCM
+	sopsNonceSize = 32
+	dataKeySize   = 32
+
+	redacted = "***"
+)
+
+var (
+	// e.g. ENC[AES256_GCM,data:xxx,iv:xxx,tag:xxx,type:str]
+	sopsValueRegexp = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.+),tag:(.+),type:(.+)\]$`)
+	secretFields    = []string{"data", "stringData"}
+)
+
+// sopsMetadata is the sops field of the encrypted document. Only the data key
+// encrypted by age is supported.
+type sopsMetadata struct {
+	Age              []sopsAgeKey `yaml:"age"`
+	LastModified     string       `yaml:"lastmodified"`
+	MAC              string       `yaml:"mac"`
+	MACOnlyEncrypted bool         `yaml:"mac_only_encrypted,omitempty"`
+	EncryptedRegex   string       `yaml:"encrypted_regex,omitempty"`
+	Version          string       `yaml:"version"`
+
+	KMS       []interface{} `yaml:"kms,omitempty"`
+	GCPKMS    []interface{} `yaml:"gcp_kms,omitempty"`
+	AzureKV   []interface{} `yaml:"azure_kv,omitempty"`
+	HCVault   []interface{} `yaml:"hc_vault,omitempty"`
+	PGP       []interface{} `yaml:"pgp,omitempty"`
+	KeyGroups []interface{} `yaml:"key_groups,omitempty"`
+}
+
+type sopsAgeKey struct {
+	Recipient string `yaml:"recipient"`
+	Enc       string `yaml:"enc"`
+}
+
+// sopsTree encrypts or decrypts the values of a SOPS file, the plaintext values
+// are hashed in the document order for the MAC.
+type sopsTree struct {
+	key              []byte
+	hash             hash.Hash
+	macOnlyEncrypted bool
+}
+
+func newSOPSTree(key []byte) *sopsTree {
+	return &sopsTree{key: key, hash: sha512.New()}
+}
+
+// EncryptSecret encrypts the values of data and stringData of the Secret manifest
+// like `sops --encrypt --age <recipients> --encrypted-regex '^(data|stringData)$'`,
+// the other fields are kept in plain text so the manifest is still reviewable.
+// The output can be decrypted by `sops -d` with any identity of the recipients.
+func EncryptSecret(data []byte, recipients []string) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("at least one age recipient is required")
+	}
+	obj := &unstructured.Unstructured{}
+	if _, _, err := decUnstructured.Decode(data, nil, obj); err != nil {
+		return nil, errors.Wrap(err, "Decode yaml failed. ")
+	}
+	if obj.GetKind() != "Secret" {
+		return nil, errors.Errorf("%s %s is not a Secret", obj.GetKind(), obj.GetName())
+	}
+	if _, ok := obj.Object[sopsField]; ok {
+		return nil, errors.Errorf("Secret %s is already encrypted", obj.GetName())
+	}
+
+	// Keeps the order of the document, which is the order of the MAC
+	var doc yamlv2.MapSlice
+	if err := yamlv2.Unmarshal(data, &doc); err != nil {
+		return nil, errors.Wrap(err, "Decode yaml failed. ")
+	}
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	tree := newSOPSTree(key)
+	for i, item := range doc {
+		name := fmt.Sprint(item.Key)
+		encrypted, err := tree.encrypt(item.Value, []string{name}, isSecretField(name))
+		if err != nil {
+			return nil, err
+		}
+		doc[i].Value = encrypted
+	}
+
+	metadata := sopsMetadata{
+		LastModified:   time.Now().UTC().Format(time.RFC3339),
+		EncryptedRegex: "^(" + strings.Join(secretFields, "|") + ")$",
+		Version:        sopsVersion,
+	}
+	var err error
+	if metadata.MAC, err = encryptValue(tree.mac(), key, metadata.LastModified); err != nil {
+		return nil, err
+	}
+	for _, recipient := range recipients {
+		enc, err := ageEncrypt(key, []string{recipient})
+		if err != nil {
+			return nil, err
+		}
+		metadata.Age = append(metadata.Age, sopsAgeKey{Recipient: recipient, Enc: string(enc)})
+	}
+	doc = append(doc, yamlv2.MapItem{Key: sopsField, Value: metadata})
+	return yamlv2.Marshal(doc)
+}
+
+func isSecretField(name string) bool {
+	for _, field := range secretFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// decryptManifests decrypts the manifests encrypted by SOPS or age with the age
+// identities, the encrypted ones without identities are rejected, so the
+// ciphertext is never applied. The MAC of SOPS is verified for every file. The
+// documents are read as YAML 1.1 like Kubernetes, and the comments in them are
+// not supported by the MAC.
+func decryptManifests(manifests []Manifest, identities []*AgeIdentity) ([]Manifest, error) {
+	type sopsFile struct {
+		source   Location
+		metadata *sopsMetadata
+		tree     *sopsTree
+	}
+	var (
+		result []Manifest
+		// The documents of a file share the metadata and the MAC
+		files     = map[string]*sopsFile{}
+		fileOrder []*sopsFile
+	)
+	for _, m := range manifests {
+		if isAgeEncrypted(m.Data) {
+			if identities == nil {
+				return nil, errors.Errorf("%s: manifest is encrypted by age, the age key file is required", m.Source)
+			}
+			plaintext, err := ageDecrypt(m.Data, identities)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s", m.Source)
+			}
+			decrypted, err := ReadBytes(m.Source.File, plaintext)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s", m.Source)
+			}
+			result = append(result, decrypted...)
+			continue
+		}
+
+		var doc yamlv2.MapSlice
+		if err := yamlv2.Unmarshal(m.Data, &doc); err != nil {
+			// Decode error is reported when applying
+			result = append(result, m)
+			continue
+		}
+		metadata, err := popSOPSMetadata(&doc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s", m.Source)
+		}
+		if metadata == nil {
+			result = append(result, m)
+			continue
+		}
+		if identities == nil {
+			return nil, errors.Errorf("%s: manifest is encrypted by SOPS, the age key file is required", m.Source)
+		}
+
+		fileKey := m.Source.File + "\x00" + metadata.MAC
+		file := files[fileKey]
+		if file == nil {
+			key, err := sopsDataKey(metadata, identities)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s", m.Source)
+			}
+			file = &sopsFile{source: m.Source, metadata: metadata, tree: newSOPSTree(key)}
+			file.tree.macOnlyEncrypted = metadata.MACOnlyEncrypted
+			files[fileKey] = file
+			fileOrder = append(fileOrder, file)
+		}
+		decrypted, err := file.tree.decrypt(doc, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s", m.Source)
+		}
+		data, err := yamlv2.Marshal(decrypted)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: encode failed", m.Source)
+		}
+		result = append(result, Manifest{Data: data, Source: m.Source})
+	}
+
+	for _, file := range fileOrder {
+		if err := file.tree.verify(file.metadata); err != nil {
+			return nil, errors.Wrapf(err, "%s", file.source)
+		}
+	}
+	return result, nil
+}
+
+// popSOPSMetadata removes the sops field from the document and returns it, nil
+// if the document isn't encrypted by SOPS.
+func popSOPSMetadata(doc *yamlv2.MapSlice) (*sopsMetadata, error) {
+	for i, item := range *doc {
+		if item.Key != sopsField {
+			continue
+		}
+		*doc = append((*doc)[:i:i], (*doc)[i+1:]...)
+		data, err := yamlv2.Marshal(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		metadata := &sopsMetadata{}
+		if err = yamlv2.Unmarshal(data, metadata); err != nil {
+			return nil, errors.Wrap(err, "invalid SOPS metadata")
+		}
+		return metadata, nil
+	}
+	return nil, nil
+}
+
+// sopsDataKey decrypts the data key by the age identities.
+func sopsDataKey(metadata *sopsMetadata, identities []*AgeIdentity) ([]byte, error) {
+	if len(metadata.KeyGroups) != 0 {
+		return nil, errors.New("SOPS key groups are not supported, decrypt it by `sops -d` first")
+	}
+	if len(metadata.Age) == 0 {
+		return nil, errors.New("the SOPS data key is not encrypted by age, decrypt it by `sops -d` first")
+	}
+	recipients := make([]string, 0, len(metadata.Age))
+	for _, k := range metadata.Age {
+		key, err := ageDecrypt([]byte(k.Enc), identities)
+		if err == nil {
+			if len(key) != dataKeySize {
+				return nil, errors.Errorf("invalid SOPS data key size %d", len(key))
+			}
+			return key, nil
+		}
+		recipients = append(recipients, k.Recipient)
+	}
+	return nil, errors.Errorf("no age identity matches the SOPS recipients %s", strings.Join(recipients, ", "))
+}
+
+// encrypt encrypts the values if encrypted is true, the path is the additional
+// data of GCM.
+func (t *sopsTree) encrypt(in interface{}, path []string, encrypted bool) (interface{}, error) {
+	switch v := in.(type) {
+	case yamlv2.MapSlice:
+		for i, item := range v {
+			out, err := t.encrypt(item.Value, append(path[:len(path):len(path)], fmt.Sprint(item.Key)), encrypted)
+			if err != nil {
+				return nil, err
+			}
+			v[i].Value = out
+		}
+		return v, nil
+	case []interface{}:
+		for i, item := range v {
+			out, err := t.encrypt(item, path, encrypted)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = out
+		}
+		return v, nil
+	case nil:
+		return nil, nil
+	default:
+		if encrypted || !t.macOnlyEncrypted {
+			t.hash.Write(sopsBytes(v))
+		}
+		if !encrypted {
+			return v, nil
+		}
+		return encryptValue(v, t.key, sopsAdditionalData(path))
+	}
+}
+
+// decrypt decrypts the ENC values recursively, see encrypt.
+func (t *sopsTree) decrypt(in interface{}, path []string) (interface{}, error) {
+	switch v := in.(type) {
+	case yamlv2.MapSlice:
+		for i, item := range v {
+			out, err := t.decrypt(item.Value, append(path[:len(path):len(path)], fmt.Sprint(item.Key)))
+			if err != nil {
+				return nil, err
+			}
+			v[i].Value = out
+		}
+		return v, nil
+	case []interface{}:
+		for i, item := range v {
+			out, err := t.decrypt(item, path)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = out
+		}
+		return v, nil
+	case nil:
+		return nil, nil
+	default:
+		s, encrypted := v.(string)
+		encrypted = encrypted && strings.HasPrefix(s, "ENC[")
+		out := v
+		if encrypted {
+			var err error
+			if out, err = decryptValue(s, t.key, sopsAdditionalData(path)); err != nil {
+				return nil, errors.Wrapf(err, "%s", strings.Join(path, "."))
+			}
+		}
+		if encrypted || !t.macOnlyEncrypted {
+			t.hash.Write(sopsBytes(out))
+		}
+		return out, nil
+	}
+}
+
+// mac is the hash of the plaintext values in upper case hex.
+func (t *sopsTree) mac() string {
+	return fmt.Sprintf("%X", t.hash.Sum(nil))
+}
+
+// verify checks the MAC of the metadata with the values walked, so the values
+// can't be removed, reordered or replaced by the ones of other files.
+func (t *sopsTree) verify(metadata *sopsMetadata) error {
+	if metadata.MAC == "" {
+		return errors.New("SOPS MAC is missing")
+	}
+	lastModified := metadata.LastModified
+	if parsed, err := time.Parse(time.RFC3339, lastModified); err == nil {
+		lastModified = parsed.Format(time.RFC3339)
+	}
+	mac, err := decryptValue(metadata.MAC, t.key, lastModified)
+	if err != nil {
+		return errors.Wrap(err, "decrypt SOPS MAC failed")
+	}
+	if mac != t.mac() {
+		return errors.New("SOPS MAC mismatch, the file may be modified")
+	}
+	return nil
+}
+
+// sopsBytes is the plaintext of the value hashed by SOPS, the booleans are
+// capitalized like Python.
+func sopsBytes(v interface{}) []byte {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return []byte("True")
+		}
+		return []byte("False")
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		return []byte(fmt.Sprint(v))
+	}
+}
+
+func decryptValue(value string, key []byte, additionalData string) (interface{}, error) {
+	matches := sopsValueRegexp.FindStringSubmatch(value)
+	if matches == nil {
+		return nil, errors.New("invalid encrypted value")
+	}
+	var parts [3][]byte
+	for i := range parts {
+		decoded, err := base64.StdEncoding.DecodeString(matches[i+1])
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid encrypted value")
+		}
+		parts[i] = decoded
+	}
+	ciphertext, iv, tag := parts[0], parts[1], parts[2]
+
+	gcm, err := newSOPSCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != sopsNonceSize {
+		return nil, errors.Errorf("invalid iv size %d", len(iv))
+	}
+	plaintext, err := gcm.Open(nil, iv, append(ciphertext, tag...), []byte(additionalData))
+	if err != nil {
+		return nil, errors.New("decrypt failed, the value may be modified")
+	}
+
+	s := string(plaintext)
+	switch matches[4] {
+	case "str", "bytes":
+		return s, nil
+	case "int":
+		return strconv.Atoi(s)
+	case "float":
+		return strconv.ParseFloat(s, 64)
+	case "bool":
+		return strconv.ParseBool(s)
+	default:
+		return nil, errors.Errorf("unknown value type %q", matches[4])
+	}
+}
+
+func encryptValue(value interface{}, key []byte, additionalData string) (string, error) {
+	var valueType string
+	switch value.(type) {
+	case string:
+		valueType = "str"
+	case int, int64, uint64:
+		valueType = "int"
+	case float64:
+		valueType = "float"
+	case bool:
+		valueType = "bool"
+	default:
+		return "", errors.Errorf("can't encrypt value of type %T", value)
+	}
+	gcm, err := newSOPSCipher(key)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, sopsNonceSize)
+	if _, err = rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nil, iv, sopsBytes(value), []byte(additionalData))
+	ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]",
+		base64.StdEncoding.EncodeToString(ciphertext),
+		base64.StdEncoding.EncodeToString(iv),
+		base64.StdEncoding.EncodeToString(tag),
+		valueType), nil
+}
+
+func newSOPSCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCMWithNonceSize(block, sopsNonceSize)
+}
+
+// sopsAdditionalData is the path of the value like "data:password:"
+func sopsAdditionalData(path []string) string {
+	return strings.Join(path, ":") + ":"
+}
+
+// secretValues returns the values of the Secret in plain text and base64,
+// the longer ones first so that they are redacted before their substrings.
+func secretValues(obj *unstructured.Unstructured) []string {
+	if obj == nil || obj.GetKind() != "Secret" {
+		return nil
+	}
+	var values []string
+	for _, field := range secretFields {
+		m, _, _ := unstructured.NestedMap(obj.Object, field)
+		for _, v := range m {
+			s, ok := v.(string)
+			if !ok || s == "" {
+				continue
+			}
+			values = append(values, s)
+			if field == "data" {
+				if decoded, err := base64.StdEncoding.DecodeString(s); err == nil && len(decoded) != 0 {
+					values = append(values, string(decoded))
+				}
+			} else {
+				values = append(values, base64.StdEncoding.EncodeToString([]byte(s)))
+			}
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+	return values
+}
+
+// redactText replaces the values in the text.
+func redactText(text string, values []string) string {
+	for _, v := range values {
+		text = strings.Replace(text, v, redacted, -1)
+	}
+	return text
+}
+
+// redactedError hides the Secret values in the message of the error, the cause
+// is kept for errors.Cause.
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Cause() error {
+	return e.err
+}
+
+// redactResult removes the Secret values from the error and the warnings, the
+// results may be printed or logged.
+func redactResult(obj *unstructured.Unstructured, r *ApplyResult) {
+	values := secretValues(obj)
+	if len(values) == 0 {
+		return
+	}
+	if r.Err != nil {
+		if msg := redactText(r.Err.Error(), values); msg != r.Err.Error() {
+			r.Err = &redactedError{err: r.Err, msg: msg}
+		}
+	}
+	for i, w := range r.Warnings {
+		r.Warnings[i] = redactText(w, values)
+	}
+	r.Message = redactText(r.Message, values)
+}
+
+// redactSecretDiff replaces the values of Secrets with *** in place, the changed
+// values are marked like kubectl diff so the diff still shows which keys changed.
+func redactSecretDiff(live, merged *unstructured.Unstructured) {
+	if (live == nil || live.GetKind() != "Secret") && (merged == nil || merged.GetKind() != "Secret") {
+		return
+	}
+	for _, field := range secretFields {
+		var liveValues, mergedValues map[string]interface{}
+		if live != nil {
+			liveValues, _, _ = unstructured.NestedMap(live.Object, field)
+		}
+		if merged != nil {
+			mergedValues, _, _ = unstructured.NestedMap(merged.Object, field)
+		}
+		for k, lv := range liveValues {
+			mv, ok := mergedValues[k]
+			switch {
+			case !ok:
+				liveValues[k] = redacted
+			case fmt.Sprint(lv) == fmt.Sprint(mv):
+				liveValues[k] = redacted
+				mergedValues[k] = redacted
+			default:
+				liveValues[k] = redacted + " (before)"
+				mergedValues[k] = redacted + " (after)"
+			}
+		}
+		for k := range mergedValues {
+			if _, ok := liveValues[k]; !ok {
+				mergedValues[k] = redacted
+			}
+		}
+		if liveValues != nil {
+			_ = unstructured.SetNestedMap(live.Object, liveValues, field)
+		}
+		if mergedValues != nil {
+			_ = unstructured.SetNestedMap(merged.Object, mergedValues, field)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package gokubectl
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	yamlv2 "gopkg.in/yaml.v2"
+)
+
+// The vectors of testdata/sops are encrypted by SOPS 3.7.3 to the recipient of
+// testdata/age/key.txt, secret.enc.yaml with --encrypted-regex '^(data|stringData)$'.
+
+func readTestManifests(t *testing.T, elem ...string) []Manifest {
+	t.Helper()
+	manifests, err := ReadBytes(elem[len(elem)-1], readTestFile(t, elem...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifests
+}
+
+func decodeTestManifest(t *testing.T, m Manifest) map[string]interface{} {
+	t.Helper()
+	var doc map[string]interface{}
+	if err := yamlv2.Unmarshal(m.Data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestDecryptManifestsSOPSVectors(t *testing.T) {
+	identities := readTestIdentities(t)
+
+	manifests, err := decryptManifests(readTestManifests(t, "sops", "secret.enc.yaml"), identities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 1 {
+		t.Fatalf("decryptManifests() = %d manifests, want 1", len(manifests))
+	}
+	doc := decodeTestManifest(t, manifests[0])
+	if _, ok := doc[sopsField]; ok {
+		t.Error("the sops metadata is not removed")
+	}
+	want := map[interface{}]interface{}{
+		"username": "admin",
+		"password": "s3cr3t",
+		"port":     5432,
+		"ratio":    0.5,
+		"enabled":  true,
+	}
+	if !reflect.DeepEqual(doc["stringData"], want) {
+		t.Errorf("stringData = %v, want %v", doc["stringData"], want)
+	}
+	metadata := map[interface{}]interface{}{"name": "db", "namespace": "shop"}
+	if !reflect.DeepEqual(doc["metadata"], metadata) {
+		t.Errorf("metadata = %v, want %v", doc["metadata"], metadata)
+	}
+
+	// Every field is encrypted, the documents share the MAC
+	manifests, err = decryptManifests(readTestManifests(t, "sops", "multi.enc.yaml"), identities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []interface{}
+	for _, m := range manifests {
+		doc := decodeTestManifest(t, m)
+		kinds = append(kinds, doc["kind"])
+		if m.Source.File != "multi.enc.yaml" {
+			t.Errorf("Source.File = %q, want multi.enc.yaml", m.Source.File)
+		}
+	}
+	if !reflect.DeepEqual(kinds, []interface{}{"Secret", "ConfigMap"}) {
+		t.Errorf("kinds = %v, want Secret and ConfigMap", kinds)
+	}
+	secret := decodeTestManifest(t, manifests[0])
+	if token := secret["data"].(map[interface{}]interface{})["token"]; token != "dG9rZW4=" {
+		t.Errorf("data.token = %v, want dG9rZW4=", token)
+	}
+	config := decodeTestManifest(t, manifests[1])
+	if replicas := config["data"].(map[interface{}]interface{})["replicas"]; replicas != 3 {
+		t.Errorf("data.replicas = %v, want 3", replicas)
+	}
+}
+
+func TestDecryptManifestsAgeVector(t *testing.T) {
+	manifests, err := decryptManifests(readTestManifests(t, "age", "secret.yaml.age"), readTestIdentities(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 1 || manifests[0].Source.File != "secret.yaml.age" {
+		t.Fatalf("decryptManifests() = %v, want the Secret of secret.yaml.age", manifests)
+	}
+	doc := decodeTestManifest(t, manifests[0])
+	if password := doc["stringData"].(map[interface{}]interface{})["password"]; password != "s3cr3t" {
+		t.Errorf("stringData.password = %v, want s3cr3t", password)
+	}
+}
+
+func TestDecryptManifestsTampered(t *testing.T) {
+	identities := readTestIdentities(t)
+	secret := string(readTestFile(t, "sops", "secret.enc.yaml"))
+	multi := string(readTestFile(t, "sops", "multi.enc.yaml"))
+
+	// field returns the line of the key in the file
+	field := func(data, key string) string {
+		for _, line := range strings.Split(data, "\n") {
+			if strings.HasPrefix(strings.TrimSpace(line), key+":") {
+				return line
+			}
+		}
+		t.Fatalf("%s is not found", key)
+		return ""
+	}
+	value := func(data, key string) string {
+		line := field(data, key)
+		return line[strings.Index(line, ":")+2:]
+	}
+	other, err := GenerateAgeIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		data       string
+		identities []*AgeIdentity
+		want       string
+	}{
+		{
+			name:       "wrong identity",
+			data:       secret,
+			identities: []*AgeIdentity{other},
+			want:       "no age identity matches the SOPS recipients " + testAgeRecipient,
+		},
+		{
+			name: "no identity",
+			data: secret,
+			want: "the age key file is required",
+		},
+		{
+			name:       "value modified",
+			data:       strings.Replace(secret, "data:zBwgX3as", "data:zBwgX3at", 1),
+			identities: identities,
+			want:       "decrypt failed, the value may be modified",
+		},
+		{
+			// The path is the additional data of the value
+			name:       "values swapped",
+			data:       strings.Replace(secret, value(secret, "username"), value(secret, "password"), 1),
+			identities: identities,
+			want:       "decrypt failed, the value may be modified",
+		},
+		{
+			name:       "value removed",
+			data:       strings.Replace(secret, field(secret, "enabled")+"\n", "", 1),
+			identities: identities,
+			want:       "SOPS MAC mismatch",
+		},
+		{
+			// The MAC covers the values in plain text as well
+			name:       "plaintext modified",
+			data:       strings.Replace(secret, "namespace: shop", "namespace: prod", 1),
+			identities: identities,
+			want:       "SOPS MAC mismatch",
+		},
+		{
+			name:       "lastmodified modified",
+			data:       strings.Replace(secret, "2026-10-19T13:02:06Z", "2026-10-19T13:02:07Z", 1),
+			identities: identities,
+			want:       "decrypt SOPS MAC failed",
+		},
+		{
+			name:       "mac removed",
+			data:       strings.Replace(secret, field(secret, "mac")+"\n", "", 1),
+			identities: identities,
+			want:       "SOPS MAC is missing",
+		},
+		{
+			name:       "document removed",
+			data:       multi[:strings.Index(multi, "\n---\n")+1],
+			identities: identities,
+			want:       "SOPS MAC mismatch",
+		},
+		{
+			name:       "key groups",
+			data:       strings.Replace(secret, "    pgp: []", "    pgp: []\n    key_groups:\n        - age: []", 1),
+			identities: identities,
+			want:       "key groups are not supported",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifests, err := ReadBytes("secret.enc.yaml", []byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = decryptManifests(manifests, tt.identities)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("decryptManifests() err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecryptManifestsPlaintext(t *testing.T) {
+	manifests := []Manifest{
+		{Data: []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: web\n")},
+		// Decode error is reported when applying
+		{Data: []byte("kind: [")},
+	}
+	got, err := decryptManifests(manifests, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, manifests) {
+		t.Errorf("decryptManifests() = %v, want the manifests unchanged", got)
+	}
+}
+
+func TestDecryptValue(t *testing.T) {
+	identities := readTestIdentities(t)
+	manifests := readTestManifests(t, "sops", "secret.enc.yaml")
+	var doc yamlv2.MapSlice
+	if err := yamlv2.Unmarshal(manifests[0].Data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := popSOPSMetadata(&doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := sopsDataKey(metadata, identities)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		value string
+		ad    string
+		want  interface{}
+	}{
+		{"ENC[AES256_GCM,data:zBwgX3as,iv:HjCSHGNat5rZDJIbdPGyvsKeOQhzDiRm46nYx98Q3Y4=,tag:rRp2KBf/IK1CVDKXhlDFag==,type:str]", "stringData:password:", "s3cr3t"},
+		{"ENC[AES256_GCM,data:u9OwOg==,iv:OulhgrsbIQzZYDdUehaqG0pRkl4XP16erk3TpNKNFD8=,tag:K6tViliLyFMpvRPY0jsCOw==,type:int]", "stringData:port:", 5432},
+		{"ENC[AES256_GCM,data:3mk4,iv:fTRIPA9YDLvrkB6C75QwzC93nXffKq2zhuDQhIDVwKk=,tag:vcm+rXohORZXfUpyZLFxmw==,type:float]", "stringData:ratio:", 0.5},
+		{"ENC[AES256_GCM,data:iaoBGw==,iv:cw+ZQhpbqdVvGDPxa9HM6AE1xrLrQgIEPyfxaJUQBIc=,tag:XshkUEB7Up/ECyOGruejlw==,type:bool]", "stringData:enabled:", true},
+	}
+	for _, tt := range tests {
+		got, err := decryptValue(tt.value, key, tt.ad)
+		if err != nil {
+			t.Errorf("decryptValue(%q) failed: %v", tt.ad, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("decryptValue(%q) = %v, want %v", tt.ad, got, tt.want)
+		}
+
+		// The value encrypted again is decrypted to the same one
+		encrypted, err := encryptValue(got, key, tt.ad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again, err := decryptValue(encrypted, key, tt.ad); err != nil || again != tt.want {
+			t.Errorf("decryptValue(encryptValue(%v)) = %v, %v", tt.want, again, err)
+		}
+	}
+
+	for _, value := range []string{
+		"s3cr3t",
+		"ENC[AES256_GCM,data:zBwgX3as,iv:AAAA,tag:rRp2KBf/IK1CVDKXhlDFag==,type:str]",
+		"ENC[AES256_GCM,data:zBwgX3as,iv:HjCSHGNat5rZDJIbdPGyvsKeOQhzDiRm46nYx98Q3Y4=,tag:rRp2KBf/IK1CVDKXhlDFag==,type:str]",
+	} {
+		if _, err := decryptValue(value, key, "stringData:username:"); err == nil {
+			t.Errorf("decryptValue(%q) succeeds, want error", value)
+		}
+	}
+}
+
+func TestSOPSBytes(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{true, "True"},
+		{false, "False"},
+		{0.5, "0.5"},
+		{float64(100000000), "100000000"},
+		{5432, "5432"},
+		{"s3cr3t", "s3cr3t"},
+	}
+	for _, tt := range tests {
+		if got := string(sopsBytes(tt.value)); got != tt.want {
+			t.Errorf("sopsBytes(%v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestEncryptSecretRoundTrip(t *testing.T) {
+	identities := readTestIdentities(t)
+	plaintext := []byte(`apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+type: Opaque
+data:
+  password: czNjcjN0
+stringData:
+  port: 5432
+`)
+	encrypted, err := EncryptSecret(plaintext, []string{testAgeRecipient})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encrypted, []byte("czNjcjN0")) || !bytes.Contains(encrypted, []byte("name: db")) {
+		t.Errorf("EncryptSecret() = %s, want the data encrypted and the metadata in plain text", encrypted)
+	}
+
+	manifests, err := decryptManifests([]Manifest{{Data: encrypted}}, identities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want, got map[string]interface{}
+	if err = yamlv2.Unmarshal(plaintext, &want); err != nil {
+		t.Fatal(err)
+	}
+	got = decodeTestManifest(t, manifests[0])
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decryptManifests(EncryptSecret()) = %v, want %v", got, want)
+	}
+
+	for _, data := range [][]byte{
+		[]byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: web\n"),
+		encrypted,
+	} {
+		if _, err := EncryptSecret(data, []string{testAgeRecipient}); err == nil {
+			t.Errorf("EncryptSecret(%q) succeeds, want error", data)
+		}
+	}
+	if _, err := EncryptSecret(plaintext, nil); err == nil {
+		t.Error("EncryptSecret() without recipients succeeds")
+	}
+}
+
+func TestRedactResult(t *testing.T) {
+	obj := decodeTestObject(t, `
+apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+data:
+  password: czNjcjN0
+stringData:
+  username: admin
+`)
+	r := &ApplyResult{
+		Err:      &redactedError{msg: "invalid value s3cr3t"},
+		Warnings: []string{"username admin (YWRtaW4=) is deprecated"},
+		Message:  "password czNjcjN0",
+	}
+	redactResult(obj, r)
+	if r.Err.Error() != "invalid value ***" {
+		t.Errorf("Err = %q, want the password redacted", r.Err.Error())
+	}
+	if r.Warnings[0] != "username *** (***) is deprecated" {
+		t.Errorf("Warnings = %q, want the username redacted", r.Warnings)
+	}
+	if r.Message != "password ***" {
+		t.Errorf("Message = %q, want the password redacted", r.Message)
+	}
+
+	// The values of other kinds are kept
+	obj.SetKind("ConfigMap")
+	r = &ApplyResult{Message: "admin"}
+	redactResult(obj, r)
+	if r.Message != "admin" {
+		t.Errorf("Message = %q of a ConfigMap, want admin", r.Message)
+	}
+}
+
+func TestRedactSecretDiff(t *testing.T) {
+	live := decodeTestObject(t, `
+kind: Secret
+data: {same: YQ==, changed: Yg==, removed: Yw==}
+`)
+	merged := decodeTestObject(t, `
+kind: Secret
+data: {same: YQ==, changed: ZA==, added: ZQ==}
+`)
+	redactSecretDiff(live, merged)
+	wantLive := map[string]interface{}{"same": "***", "changed": "*** (before)", "removed": "***"}
+	wantMerged := map[string]interface{}{"same": "***", "changed": "*** (after)", "added": "***"}
+	if !reflect.DeepEqual(live.Object["data"], wantLive) {
+		t.Errorf("live data = %v, want %v", live.Object["data"], wantLive)
+	}
+	if !reflect.DeepEqual(merged.Object["data"], wantMerged) {
+		t.Errorf("merged data = %v, want %v", merged.Object["data"], wantMerged)
+	}
+}
@@ -0,0 +1,222 @@
+package gokubectl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/k8s"
+)
+
+const (
+	// kubectlClientSideApplyManager is the manager name of `kubectl apply`
+	kubectlClientSideApplyManager = "kubectl-client-side-apply"
+)
+
+var (
+	// The message of conflict cause is like: conflict with "kubectl" using apps/v1
+	conflictManagerRegexp = regexp.MustCompile(`conflict with "([^"]*)"`)
+)
+
+type FieldConflict struct {
+	// Field is the path of the conflicted field, e.g. .spec.replicas
+	Field string
+	// Manager is the manager which owns the field
+	Manager string
+	Message string
+}
+
+// ConflictError is returned if server-side apply conflicts with other managers,
+// apply with ApplyOptions.Force to take the ownership of the fields.
+type ConflictError struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Conflicts []FieldConflict
+
+	err error
+}
+
+func (e *ConflictError) Error() string {
+	conflicts := make([]string, 0, len(e.Conflicts))
+	for _, conflict := range e.Conflicts {
+		conflicts = append(conflicts, fmt.Sprintf("%s owned by %q", conflict.Field, conflict.Manager))
+	}
+	return fmt.Sprintf("%s %s apply conflicts with %d field(s): %s", e.Kind, e.Name, len(e.Conflicts),
+		strings.Join(conflicts, ", "))
+}
+
+// Cause returns the original status error
+func (e *ConflictError) Cause() error {
+	return e.err
+}
+
+// Unwrap returns the original status error for errors.Is and errors.As
+func (e *ConflictError) Unwrap() error {
+	return e.err
+}
+
+// AsConflictError returns the *ConflictError if err is the conflict of server-side apply.
+func AsConflictError(err error) (*ConflictError, bool) {
+	conflictErr, ok := err.(*ConflictError)
+	return conflictErr, ok
+}
+
+// newConflictError converts the conflict status error to *ConflictError,
+// the other errors are returned directly.
+func newConflictError(obj *unstructured.Unstructured, err error) error {
+	if !k8sErrors.IsConflict(err) {
+		return err
+	}
+	statusErr, ok := err.(k8sErrors.APIStatus)
+	if !ok || statusErr.Status().Details == nil {
+		return err
+	}
+
+	conflictErr := &ConflictError{
+		Kind:      obj.GetKind(),
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		err:       err,
+	}
+	for _, cause := range statusErr.Status().Details.Causes {
+		if cause.Type != metav1.CauseTypeFieldManagerConflict {
+			continue
+		}
+		conflict := FieldConflict{
+			Field:   cause.Field,
+			Message: cause.Message,
+		}
+		if matches := conflictManagerRegexp.FindStringSubmatch(cause.Message); len(matches) == 2 {
+			conflict.Manager = matches[1]
+		}
+		conflictErr.Conflicts = append(conflictErr.Conflicts, conflict)
+	}
+	if len(conflictErr.Conflicts) == 0 {
+		return err
+	}
+	return conflictErr
+}
+
+type MigrateOptions struct {
+	NamespaceOptions
+	DiscoveryOptions
+
+	// FieldManager is the manager which takes the ownership, default "kubectl-golang".
+	FieldManager string
+	// ClientSideManagers are the managers of client-side apply to be replaced,
+	// default "kubectl-client-side-apply".
+	ClientSideManagers []string
+}
+
+// MigrateToServerSideApply moves the ownership of the fields applied by
+// client-side apply to the server-side apply manager. Without it, the fields
+// removed from manifest will never be deleted by server-side apply, since they
+// are still owned by the client-side manager.
+func MigrateToServerSideApply(ctx context.Context, base64KubeConfig string, data []byte, opts MigrateOptions) ([]ApplyResult, error) {
+	if opts.FieldManager == "" {
+		opts.FieldManager = defaultFieldManager
+	}
+	if len(opts.ClientSideManagers) == 0 {
+		opts.ClientSideManagers = []string{kubectlClientSideApplyManager}
+	}
+
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  base64KubeConfig,
+		DiscoveryCacheDir: opts.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.DiscoveryCacheTTL,
+	}
+	manifests, err := ReadBytes("", data)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]ApplyResult, 0, len(manifests))
+	for _, m := range manifests {
+		result = append(result, migrateObject(ctx, kubeClient, m, opts))
+	}
+	return result, nil
+}
+
+func migrateObject(ctx context.Context, kubeClient *k8s.KubeClient, m Manifest, opts MigrateOptions) ApplyResult {
+	obj, dr, err := buildDynamicResourceClient(kubeClient, m.Data, opts.NamespaceOptions)
+	if err != nil {
+		return ApplyResult{Err: err, Source: m.Source}
+	}
+	r := newApplyResult(obj, m.Source)
+	r.Message, r.Err = migrateResource(ctx, dr, obj.GetName(), opts)
+	return r
+}
+
+// migrateResource migrates the live object of the name, the message is returned
+// if it succeeded.
+func migrateResource(ctx context.Context, dr dynamic.ResourceInterface, name string, opts MigrateOptions) (string, error) {
+	live, err := dr.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	// Take over the fields of last applied configuration, they are the fields
+	// owned by client-side apply.
+	if last := live.GetAnnotations()[lastAppliedConfigAnnotation]; last != "" {
+		force := true
+		_, err = dr.Patch(ctx, name, types.ApplyPatchType, []byte(last), metav1.PatchOptions{
+			FieldManager: opts.FieldManager,
+			Force:        &force,
+		})
+		if err != nil {
+			return "", errors.Wrapf(err, "take over fields of %s failed", name)
+		}
+		if live, err = dr.Get(ctx, name, metav1.GetOptions{}); err != nil {
+			return "", err
+		}
+	}
+
+	// Drop the client-side managers, the shared fields are owned by
+	// the server-side manager only.
+	var (
+		managedFields []metav1.ManagedFieldsEntry
+		removed       bool
+	)
+	for _, entry := range live.GetManagedFields() {
+		if entry.Operation == metav1.ManagedFieldsOperationUpdate && containsString(opts.ClientSideManagers, entry.Manager) {
+			removed = true
+			continue
+		}
+		managedFields = append(managedFields, entry)
+	}
+	if !removed || len(managedFields) == 0 {
+		return name + " has nothing to migrate.", nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"managedFields":   managedFields,
+			"resourceVersion": live.GetResourceVersion(),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if _, err = dr.Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return "", errors.Wrapf(err, "update managedFields of %s failed", name)
+	}
+	return name + " migrated to server-side apply.", nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
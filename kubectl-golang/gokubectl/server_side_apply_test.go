@@ -0,0 +1,164 @@
+package gokubectl
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestNewConflictError(t *testing.T) {
+	obj := testConfigMap("")
+	statusErr := k8sErrors.NewApplyConflict([]metav1.StatusCause{
+		{Type: metav1.CauseTypeFieldManagerConflict, Field: ".data.mode", Message: `conflict with "kubectl-client-side-apply" using v1`},
+		{Type: metav1.CauseTypeFieldManagerConflict, Field: ".data.size", Message: `conflict with "helm" using v1`},
+		{Type: metav1.CauseTypeFieldValueInvalid, Field: ".data.other", Message: "invalid"},
+	}, "Apply failed with 2 conflicts")
+
+	err := newConflictError(obj, statusErr)
+	conflictErr, ok := AsConflictError(err)
+	if !ok {
+		t.Fatalf("newConflictError() = %T, want *ConflictError", err)
+	}
+	want := []FieldConflict{
+		{Field: ".data.mode", Manager: "kubectl-client-side-apply", Message: `conflict with "kubectl-client-side-apply" using v1`},
+		{Field: ".data.size", Manager: "helm", Message: `conflict with "helm" using v1`},
+	}
+	if !reflect.DeepEqual(conflictErr.Conflicts, want) {
+		t.Errorf("Conflicts = %v, want %v", conflictErr.Conflicts, want)
+	}
+	if conflictErr.Kind != "ConfigMap" || conflictErr.Namespace != "default" || conflictErr.Name != "web" {
+		t.Errorf("ConflictError of %s %s/%s, want ConfigMap default/web", conflictErr.Kind, conflictErr.Namespace, conflictErr.Name)
+	}
+	wantMsg := `ConfigMap web apply conflicts with 2 field(s): .data.mode owned by "kubectl-client-side-apply", .data.size owned by "helm"`
+	if err.Error() != wantMsg {
+		t.Errorf("Error() = %q, want %q", err.Error(), wantMsg)
+	}
+	if errors.Cause(err) != statusErr || !k8sErrors.IsConflict(err) {
+		t.Errorf("cause of %v isn't the status error", err)
+	}
+}
+
+func TestNewConflictErrorOthers(t *testing.T) {
+	obj := testConfigMap("")
+	for _, err := range []error{
+		errors.New("connection refused"),
+		k8sErrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, "web"),
+		// The conflict of resourceVersion has no field causes
+		k8sErrors.NewConflict(schema.GroupResource{Resource: "configmaps"}, "web", errors.New("modified")),
+	} {
+		if got := newConflictError(obj, err); got != err {
+			t.Errorf("newConflictError(%v) = %v, want the error unchanged", err, got)
+		}
+		if _, ok := AsConflictError(err); ok {
+			t.Errorf("AsConflictError(%v) = true", err)
+		}
+	}
+}
+
+func testManagedObject(annotated bool, managers ...string) *unstructured.Unstructured {
+	obj := testConfigMap("uid")
+	obj.SetResourceVersion("10")
+	if annotated {
+		obj.SetAnnotations(map[string]string{lastAppliedConfigAnnotation: `{"apiVersion":"v1","kind":"ConfigMap"}`})
+	}
+	var entries []metav1.ManagedFieldsEntry
+	for _, manager := range managers {
+		operation := metav1.ManagedFieldsOperationUpdate
+		if manager == defaultFieldManager {
+			operation = metav1.ManagedFieldsOperationApply
+		}
+		entries = append(entries, metav1.ManagedFieldsEntry{Manager: manager, Operation: operation})
+	}
+	obj.SetManagedFields(entries)
+	return obj
+}
+
+func TestMigrateResource(t *testing.T) {
+	opts := MigrateOptions{FieldManager: defaultFieldManager, ClientSideManagers: []string{kubectlClientSideApplyManager}}
+	tests := []struct {
+		name        string
+		lives       []*unstructured.Unstructured
+		want        string
+		wantCalls   []string
+		wantManaged []string
+	}{
+		{
+			name: "migrated",
+			lives: []*unstructured.Unstructured{
+				testManagedObject(true, kubectlClientSideApplyManager, "kube-controller-manager"),
+				testManagedObject(true, kubectlClientSideApplyManager, defaultFieldManager, "kube-controller-manager"),
+			},
+			want:        "web migrated to server-side apply.",
+			wantCalls:   []string{"get", "patch", "get", "patch"},
+			wantManaged: []string{defaultFieldManager, "kube-controller-manager"},
+		},
+		{
+			name:      "server-side applied",
+			lives:     []*unstructured.Unstructured{testManagedObject(false, defaultFieldManager)},
+			want:      "web has nothing to migrate.",
+			wantCalls: []string{"get"},
+		},
+		{
+			// The fields can't be owned by no manager
+			name:      "client-side manager only",
+			lives:     []*unstructured.Unstructured{testManagedObject(false, kubectlClientSideApplyManager)},
+			want:      "web has nothing to migrate.",
+			wantCalls: []string{"get"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dr := &scriptedResource{lives: tt.lives}
+			got, err := migrateResource(context.Background(), dr, "web", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("migrateResource() = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(dr.calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", dr.calls, tt.wantCalls)
+			}
+			if len(dr.patches) == 0 {
+				return
+			}
+			if !strings.HasPrefix(dr.patches[0], string(types.ApplyPatchType)+" ") {
+				t.Errorf("first patch = %s, want the last applied configuration", dr.patches[0])
+			}
+
+			var patch struct {
+				Metadata struct {
+					ManagedFields   []metav1.ManagedFieldsEntry `json:"managedFields"`
+					ResourceVersion string                      `json:"resourceVersion"`
+				} `json:"metadata"`
+			}
+			last := dr.patches[len(dr.patches)-1]
+			if err = json.Unmarshal([]byte(strings.TrimPrefix(last, string(types.MergePatchType)+" ")), &patch); err != nil {
+				t.Fatalf("last patch = %s, want the merge patch of managedFields: %v", last, err)
+			}
+			var managers []string
+			for _, entry := range patch.Metadata.ManagedFields {
+				managers = append(managers, entry.Manager)
+			}
+			if !reflect.DeepEqual(managers, tt.wantManaged) || patch.Metadata.ResourceVersion != "10" {
+				t.Errorf("managers = %v at %q, want %v at 10", managers, patch.Metadata.ResourceVersion, tt.wantManaged)
+			}
+		})
+	}
+}
+
+func TestMigrateResourceNotFound(t *testing.T) {
+	dr := &scriptedResource{lives: []*unstructured.Unstructured{nil}}
+	if _, err := migrateResource(context.Background(), dr, "web", MigrateOptions{}); !k8sErrors.IsNotFound(err) {
+		t.Errorf("migrateResource() err = %v, want not found", err)
+	}
+}
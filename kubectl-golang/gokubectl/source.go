@@ -0,0 +1,372 @@
+package gokubectl
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	yamlSeparator = "---"
+	// Stdin is the file name of ReadPaths for reading from stdin
+	Stdin = "-"
+)
+
+var (
+	manifestExtensions = map[string]bool{
+		".yaml": true,
+		".yml":  true,
+		".json": true,
+	}
+)
+
+// Location is where the object is read from.
+type Location struct {
+	// File is the file name, or the name given to the reader
+	File string
+	// Doc is the index of document in the file, from 0
+	Doc int
+	// Line is the line of the document start, from 1
+	Line int
+	// Item is the index in the List document, -1 if the document is not a List
+	Item int
+}
+
+func (l Location) String() string {
+	file := l.File
+	if file == "" {
+		file = "<manifest>"
+	}
+	s := fmt.Sprintf("%s:%d:%d", file, l.Doc, l.Line)
+	if l.Item >= 0 {
+		s += fmt.Sprintf("[%d]", l.Item)
+	}
+	return s
+}
+
+// Manifest is one object in YAML or JSON.
+type Manifest struct {
+	Data   []byte
+	Source Location
+}
+
+// ReadBytes splits the multi-document YAML or JSON into manifests,
+// the List documents are expanded to their items.
+func ReadBytes(name string, data []byte) ([]Manifest, error) {
+	var (
+		manifests []Manifest
+		doc       bytes.Buffer
+		docLine   = 1
+		docIndex  = 0
+	)
+	flush := func() error {
+		if !hasContent(doc.Bytes()) {
+			doc.Reset()
+			return nil
+		}
+		items, err := expandList(Manifest{
+			Data: append([]byte{}, doc.Bytes()...),
+			Source: Location{
+				File: name,
+				Doc:  docIndex,
+				Line: docLine,
+				Item: -1,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		manifests = append(manifests, items...)
+		docIndex++
+		doc.Reset()
+		return nil
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, errors.Wrap(err, "failed to read yaml data")
+		}
+		if isSeparator(line) {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			docLine = lineNo + 1
+		} else {
+			if !hasContent(doc.Bytes()) && !hasContent(line) {
+				// Points to the first line with content
+				docLine = lineNo + 1
+			}
+			doc.Write(line)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return manifests, nil
+}
+
+// ReadReader reads the manifests from reader, name is used in the locations.
+func ReadReader(name string, reader io.Reader) ([]Manifest, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s failed", name)
+	}
+	return ReadBytes(name, data)
+}
+
+// ReadFile reads the manifest file, the tar (.tar, .tar.gz, .tgz) and zip archives
+// are read by ReadArchive.
+func ReadFile(filename string) ([]Manifest, error) {
+	if isArchive(filename) {
+		return ReadArchive(filename)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ReadBytes(filename, data)
+}
+
+// ReadDir reads the .yaml, .yml and .json files in the dir by lexical order,
+// the sub dirs are read only if recursive.
+func ReadDir(dir string, recursive bool) ([]Manifest, error) {
+	var manifests []Manifest
+	err := filepath.Walk(dir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if filename != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !manifestExtensions[strings.ToLower(filepath.Ext(filename))] {
+			return nil
+		}
+		items, err := ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		manifests = append(manifests, items...)
+		return nil
+	})
+	return manifests, err
+}
+
+// ReadArchive reads the manifest files in the tar or zip archive, the files
+// are located as "<archive>!<path in archive>".
+func ReadArchive(filename string) ([]Manifest, error) {
+	name := strings.ToLower(filename)
+	if strings.HasSuffix(name, ".zip") {
+		return readZip(filename)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var reader io.Reader = f
+	if strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz") {
+		gzipReader, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s failed", filename)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+	return readTar(filename, reader)
+}
+
+// ReadMirroredURL reads the manifest of url from the local mirror dir, the
+// file of "https://host/path/app.yaml" is "<mirrorDir>/host/path/app.yaml".
+// The url is kept in the locations.
+func ReadMirroredURL(rawURL, mirrorDir string) ([]Manifest, error) {
+	filename, err := mirroredFile(rawURL, mirrorDir)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s not found in mirror", rawURL)
+	}
+	return ReadBytes(rawURL, data)
+}
+
+// mirroredFile returns the file of url in the mirror dir, the urls escaping the
+// mirror dir by the host or the path are rejected.
+func mirroredFile(rawURL, mirrorDir string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "parse url %s failed", rawURL)
+	}
+	if u.Host == "" || u.Host == "." || u.Host == ".." || strings.ContainsAny(u.Host, `/\`) {
+		return "", errors.Errorf("invalid host of url %s", rawURL)
+	}
+	dir := filepath.Clean(mirrorDir)
+	filename := filepath.Join(dir, u.Host, filepath.FromSlash(path.Clean("/"+u.Path)))
+	if !strings.HasPrefix(filename, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator)) {
+		return "", errors.Errorf("url %s escapes the mirror dir", rawURL)
+	}
+	return filename, nil
+}
+
+// ReadPaths reads the files, dirs and stdin ("-") in order.
+func ReadPaths(paths []string, recursive bool) ([]Manifest, error) {
+	var manifests []Manifest
+	for _, p := range paths {
+		var (
+			items []Manifest
+			err   error
+		)
+		if p == Stdin {
+			items, err = ReadReader("stdin", os.Stdin)
+		} else if info, statErr := os.Stat(p); statErr != nil {
+			err = statErr
+		} else if info.IsDir() {
+			items, err = ReadDir(p, recursive)
+		} else {
+			items, err = ReadFile(p)
+		}
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, items...)
+	}
+	return manifests, nil
+}
+
+// joinManifests returns the multi-document YAML of the manifests.
+func joinManifests(manifests []Manifest) []byte {
+	var buf bytes.Buffer
+	for _, m := range manifests {
+		buf.WriteString(yamlSeparator + "\n")
+		buf.Write(m.Data)
+		if !bytes.HasSuffix(m.Data, []byte("\n")) {
+			buf.WriteString("\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+func readTar(filename string, reader io.Reader) ([]Manifest, error) {
+	var manifests []Manifest
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return manifests, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s failed", filename)
+		}
+		if header.Typeflag != tar.TypeReg || !manifestExtensions[strings.ToLower(path.Ext(header.Name))] {
+			continue
+		}
+		items, err := ReadReader(filename+"!"+header.Name, tarReader)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, items...)
+	}
+}
+
+func readZip(filename string) ([]Manifest, error) {
+	zipReader, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s failed", filename)
+	}
+	defer zipReader.Close()
+
+	var manifests []Manifest
+	for _, f := range zipReader.File {
+		if f.FileInfo().IsDir() || !manifestExtensions[strings.ToLower(path.Ext(f.Name))] {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s!%s failed", filename, f.Name)
+		}
+		items, err := ReadReader(filename+"!"+f.Name, rc)
+		_ = rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, items...)
+	}
+	return manifests, nil
+}
+
+// expandList returns the items of List document, e.g. the output of `kubectl get -o yaml`.
+// The List is told by the items array, not the kind, e.g. IPAllowList is a kind.
+func expandList(m Manifest) ([]Manifest, error) {
+	obj := &unstructured.Unstructured{}
+	if err := yaml.Unmarshal(m.Data, &obj.Object); err != nil || !obj.IsList() {
+		// Decode error is reported when applying
+		return []Manifest{m}, nil
+	}
+
+	list := &unstructured.UnstructuredList{}
+	if _, _, err := decUnstructured.Decode(m.Data, nil, list); err != nil {
+		return nil, errors.Wrapf(err, "%s: decode %s failed", m.Source, obj.GetKind())
+	}
+	var manifests []Manifest
+	for i, item := range list.Items {
+		data, err := json.Marshal(item.Object)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: encode item %d failed", m.Source, i)
+		}
+		source := m.Source
+		source.Item = i
+		manifests = append(manifests, Manifest{Data: data, Source: source})
+	}
+	return manifests, nil
+}
+
+func isSeparator(line []byte) bool {
+	return bytes.HasPrefix(line, []byte(yamlSeparator)) &&
+		len(bytes.TrimSpace(line[len(yamlSeparator):])) == 0
+}
+
+// hasContent returns false if the data has only blank lines and comments.
+func hasContent(data []byte) bool {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) != 0 && line[0] != '#' {
+			return true
+		}
+	}
+	return false
+}
+
+func isArchive(filename string) bool {
+	name := strings.ToLower(filename)
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,347 @@
+package gokubectl
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMirroredFile(t *testing.T) {
+	mirror := filepath.Join("testdata", "mirror")
+	tests := []struct {
+		url     string
+		want    string
+		wantErr bool
+	}{
+		{url: "https://example.com/app/deploy.yaml", want: filepath.Join(mirror, "example.com", "app", "deploy.yaml")},
+		{url: "https://example.com:8443/app.yaml", want: filepath.Join(mirror, "example.com:8443", "app.yaml")},
+		// The path is cleaned as a rooted path
+		{url: "https://example.com/../../etc/passwd", want: filepath.Join(mirror, "example.com", "etc", "passwd")},
+		{url: "https://example.com/a/%2e%2e/%2e%2e/%2e%2e/etc/passwd", want: filepath.Join(mirror, "example.com", "etc", "passwd")},
+		{url: "https://../../etc/passwd", wantErr: true},
+		{url: "https://./etc/passwd", wantErr: true},
+		{url: "https://..", wantErr: true},
+		{url: "file:///etc/passwd", wantErr: true},
+		{url: "app.yaml", wantErr: true},
+		{url: "https://exa%5cmple.com/app.yaml", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := mirroredFile(tt.url, mirror+"/")
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("mirroredFile(%q) = %q, want error", tt.url, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("mirroredFile(%q) failed: %v", tt.url, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("mirroredFile(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestMirroredFileRootDir(t *testing.T) {
+	got, err := mirroredFile("https://example.com/app.yaml", "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("/", "example.com", "app.yaml"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestReadMirroredURL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mirror := filepath.Join(dir, "mirror")
+	if err = os.MkdirAll(filepath.Join(mirror, "example.com"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\n")
+	if err = ioutil.WriteFile(filepath.Join(mirror, "example.com", "app.yaml"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A file beside the mirror dir must not be reachable
+	if err = ioutil.WriteFile(filepath.Join(dir, "secret.yaml"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manifests, err := ReadMirroredURL("https://example.com/app.yaml", mirror)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) != 1 || manifests[0].Source.File != "https://example.com/app.yaml" {
+		t.Errorf("unexpected manifests %+v", manifests)
+	}
+	if _, err = ReadMirroredURL("https://../secret.yaml", mirror); err == nil {
+		t.Error("url escaping the mirror dir is read")
+	}
+}
+
+func TestLocationString(t *testing.T) {
+	tests := []struct {
+		location Location
+		want     string
+	}{
+		{Location{File: "app.yaml", Doc: 1, Line: 5, Item: -1}, "app.yaml:1:5"},
+		{Location{File: "list.yaml", Doc: 0, Line: 1, Item: 2}, "list.yaml:0:1[2]"},
+		{Location{Line: 1, Item: -1}, "<manifest>:0:1"},
+	}
+	for _, tt := range tests {
+		if got := tt.location.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestReadBytes(t *testing.T) {
+	data := `# leading comment
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a
+---
+
+# only comments
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: b
+data:
+  banner: |
+    ----
+    --- not a separator
+--- 
+{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "c"}}
+---
+`
+	manifests, err := ReadBytes("app.yaml", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The line is the first one with content, the empty documents are skipped
+	want := []Location{
+		{File: "app.yaml", Doc: 0, Line: 2, Item: -1},
+		{File: "app.yaml", Doc: 1, Line: 10, Item: -1},
+		{File: "app.yaml", Doc: 2, Line: 19, Item: -1},
+	}
+	var got []Location
+	for _, m := range manifests {
+		got = append(got, m.Source)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ReadBytes() locations = %v, want %v", got, want)
+	}
+	if banner := decodeTestObject(t, string(manifests[1].Data)).Object["data"].(map[string]interface{})["banner"]; banner != "----\n--- not a separator\n" {
+		t.Errorf("banner = %q, want the lines kept", banner)
+	}
+	if name := decodeTestObject(t, string(manifests[2].Data)).GetName(); name != "c" {
+		t.Errorf("name of the JSON document = %q, want c", name)
+	}
+}
+
+func TestReadBytesList(t *testing.T) {
+	data := `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: ConfigMap
+  metadata: {name: a}
+- apiVersion: v1
+  kind: Secret
+  metadata: {name: b}
+---
+apiVersion: example.com/v1
+kind: IPAllowList
+metadata:
+  name: office
+spec:
+  cidrs: [10.0.0.0/8]
+`
+	manifests, err := ReadBytes("list.yaml", []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, m := range manifests {
+		got = append(got, manifestKind(m)+" "+m.Source.String())
+	}
+	// The kind named *List without items isn't a List
+	want := []string{"ConfigMap list.yaml:0:1[0]", "Secret list.yaml:0:1[1]", "IPAllowList list.yaml:1:11"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadBytes() = %v, want %v", got, want)
+	}
+}
+
+// writeTestFiles writes the files under dir, the names are slash separated.
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testConfigMapManifest(name string) string {
+	return "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: " + name + "\n"
+}
+
+func manifestNames(t *testing.T, manifests []Manifest) []string {
+	t.Helper()
+	var names []string
+	for _, m := range manifests {
+		names = append(names, decodeTestObject(t, string(m.Data)).GetName())
+	}
+	return names
+}
+
+func TestReadDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFiles(t, dir, map[string]string{
+		"b.yaml":     testConfigMapManifest("b"),
+		"a.YML":      testConfigMapManifest("a"),
+		"c.json":     `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "c"}}`,
+		"README.md":  "# not a manifest",
+		"sub/d.yaml": testConfigMapManifest("d"),
+	})
+
+	tests := []struct {
+		recursive bool
+		want      []string
+	}{
+		{false, []string{"a", "b", "c"}},
+		{true, []string{"a", "b", "c", "d"}},
+	}
+	for _, tt := range tests {
+		manifests, err := ReadDir(dir, tt.recursive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := manifestNames(t, manifests); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ReadDir(recursive %v) = %v, want %v", tt.recursive, got, tt.want)
+		}
+	}
+}
+
+func TestReadArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := []struct{ name, data string }{
+		{"app/a.yaml", testConfigMapManifest("a") + "---\n" + testConfigMapManifest("b")},
+		{"app/notes.txt", "not a manifest"},
+		{"app/c.json", `{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "c"}}`},
+	}
+
+	var tarBuf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&tarBuf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	if err = tarWriter.WriteHeader(&tar.Header{Name: "app/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+		t.Fatal(err)
+	}
+	var zipBuf bytes.Buffer
+	zipWriter := zip.NewWriter(&zipBuf)
+	for _, f := range files {
+		if err = tarWriter.WriteHeader(&tar.Header{Name: f.name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(f.data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tarWriter.Write([]byte(f.data)); err != nil {
+			t.Fatal(err)
+		}
+		w, err := zipWriter.Create(f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = w.Write([]byte(f.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []io.Closer{tarWriter, gzipWriter, zipWriter} {
+		if err = c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFiles(t, dir, map[string]string{"app.tgz": tarBuf.String(), "app.zip": zipBuf.String()})
+
+	for _, name := range []string{"app.tgz", "app.zip"} {
+		filename := filepath.Join(dir, name)
+		manifests, err := ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := manifestNames(t, manifests); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+			t.Errorf("ReadFile(%s) = %v, want a, b, c", name, got)
+		}
+		want := Location{File: filename + "!app/a.yaml", Doc: 1, Line: 6, Item: -1}
+		if manifests[1].Source != want {
+			t.Errorf("ReadFile(%s) source = %v, want %v", name, manifests[1].Source, want)
+		}
+	}
+
+	writeTestFiles(t, dir, map[string]string{"broken.tar.gz": "not gzip"})
+	if _, err = ReadFile(filepath.Join(dir, "broken.tar.gz")); err == nil {
+		t.Error("ReadFile() of a broken archive succeeds")
+	}
+}
+
+func TestReadPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "paths")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFiles(t, dir, map[string]string{
+		"z.yaml":       testConfigMapManifest("z"),
+		"app/a.yaml":   testConfigMapManifest("a"),
+		"app/b/c.yaml": testConfigMapManifest("c"),
+	})
+
+	// The paths are read in the given order
+	manifests, err := ReadPaths([]string{filepath.Join(dir, "z.yaml"), filepath.Join(dir, "app")}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := manifestNames(t, manifests); !reflect.DeepEqual(got, []string{"z", "a", "c"}) {
+		t.Errorf("ReadPaths() = %v, want z, a, c", got)
+	}
+	if _, err = ReadPaths([]string{filepath.Join(dir, "missing.yaml")}, false); err == nil {
+		t.Error("ReadPaths() of a missing file succeeds")
+	}
+}
+
+func TestJoinManifests(t *testing.T) {
+	manifests := []Manifest{{Data: []byte("kind: A")}, {Data: []byte("kind: B\n")}}
+	want := "---\nkind: A\n---\nkind: B\n"
+	if got := string(joinManifests(manifests)); got != want {
+		t.Errorf("joinManifests() = %q, want %q", got, want)
+	}
+	joined, err := ReadBytes("", joinManifests(manifests))
+	if err != nil || len(joined) != 2 {
+		t.Errorf("ReadBytes(joinManifests()) = %v, %v, want 2 manifests", joined, err)
+	}
+}
@@ -0,0 +1,206 @@
+package gokubectl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/k8s"
+)
+
+const (
+	// tableAccept asks for the Table of v1, or v1beta1 on the clusters < 1.15
+	tableAccept = "application/json;as=Table;v=v1;g=meta.k8s.io," +
+		"application/json;as=Table;v=v1beta1;g=meta.k8s.io," +
+		"application/json"
+)
+
+type TableFormat string
+
+const (
+	// TableText prints the columns of priority 0, like `kubectl get`.
+	TableText TableFormat = ""
+	// TableWide prints all the columns, like `kubectl get -o wide`.
+	TableWide TableFormat = "wide"
+	// TableJSON prints the rows as a JSON array of objects keyed by the column names.
+	TableJSON TableFormat = "json"
+)
+
+type TablePrintOptions struct {
+	Format TableFormat
+	// WithNamespace adds the NAMESPACE column, it's used with GetOptions.AllNamespaces.
+	WithNamespace bool
+	NoHeaders     bool
+}
+
+// GetTable returns the objects of resource as the Table defined by the server,
+// the columns are the same as `kubectl get`, the additionalPrinterColumns of CRDs
+// included. The objects are listed if name is empty.
+func GetTable(ctx context.Context, base64KubeConfig string, resource, name string, opts GetOptions) (*metav1.Table, error) {
+	kubeClient := &k8s.KubeClient{
+		Base64KubeConfig:  base64KubeConfig,
+		DiscoveryCacheDir: opts.DiscoveryCacheDir,
+		DiscoveryCacheTTL: opts.DiscoveryCacheTTL,
+	}
+	mapping, err := kubeClient.ResourceMapping(resource)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Mapping resource %s failed", resource)
+	}
+	restClient, err := kubeClient.GetRESTClientFor(mapping.GroupVersionKind.GroupVersion())
+	if err != nil {
+		return nil, errors.Wrap(err, "Prepare REST client failed.")
+	}
+
+	namespace := opts.namespace(mapping, name)
+	req := restClient.Get().
+		NamespaceIfScoped(namespace, namespace != "").
+		Resource(mapping.Resource.Resource).
+		SetHeader("Accept", tableAccept)
+	if name != "" {
+		req = req.Name(name)
+	}
+	if name == "" && opts.LabelSelector != "" {
+		req = req.Param("labelSelector", opts.LabelSelector)
+	}
+	if name == "" && opts.FieldSelector != "" {
+		req = req.Param("fieldSelector", opts.FieldSelector)
+	}
+	data, err := req.DoRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	table := &metav1.Table{}
+	if err = json.Unmarshal(data, table); err != nil {
+		return nil, errors.Wrap(err, "decode table failed")
+	}
+	if table.Kind != "Table" {
+		return nil, errors.Errorf("%s is returned instead of Table, the server doesn't support Table", table.Kind)
+	}
+	return table, nil
+}
+
+// PrintTable prints the table in text or JSON.
+func PrintTable(w io.Writer, table *metav1.Table, opts TablePrintOptions) error {
+	var columns []int
+	for i, column := range table.ColumnDefinitions {
+		if column.Priority == 0 || opts.Format != TableText {
+			columns = append(columns, i)
+		}
+	}
+
+	if opts.Format == TableJSON {
+		rows := make([]map[string]interface{}, 0, len(table.Rows))
+		for _, row := range table.Rows {
+			item := make(map[string]interface{}, len(columns)+1)
+			if opts.WithNamespace {
+				item["Namespace"] = rowNamespace(row)
+			}
+			for _, i := range columns {
+				if i < len(row.Cells) {
+					item[table.ColumnDefinitions[i].Name] = row.Cells[i]
+				}
+			}
+			rows = append(rows, item)
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "    ")
+		return encoder.Encode(rows)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 3, ' ', 0)
+	if !opts.NoHeaders {
+		var header []string
+		if opts.WithNamespace {
+			header = append(header, "NAMESPACE")
+		}
+		for _, i := range columns {
+			header = append(header, strings.ToUpper(table.ColumnDefinitions[i].Name))
+		}
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+	}
+	for _, row := range table.Rows {
+		var cells []string
+		if opts.WithNamespace {
+			cells = append(cells, rowNamespace(row))
+		}
+		for _, i := range columns {
+			var cell interface{}
+			if i < len(row.Cells) {
+				cell = row.Cells[i]
+			}
+			cells = append(cells, formatCell(table.ColumnDefinitions[i], cell))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+// rowNamespace returns the namespace of the object metadata included in the row.
+func rowNamespace(row metav1.TableRow) string {
+	if len(row.Object.Raw) == 0 {
+		return ""
+	}
+	var obj metav1.PartialObjectMetadata
+	if err := json.Unmarshal(row.Object.Raw, &obj); err != nil {
+		return ""
+	}
+	return obj.Namespace
+}
+
+func formatCell(column metav1.TableColumnDefinition, cell interface{}) string {
+	switch v := cell.(type) {
+	case nil:
+		return "<none>"
+	case string:
+		// The date columns of CRDs are timestamps, they are printed as age
+		if column.Type == "date" || column.Format == "date" {
+			if t, err := time.Parse(time.RFC3339, v); err == nil {
+				return HumanAge(time.Since(t))
+			}
+		}
+		if v == "" {
+			return "<none>"
+		}
+		return v
+	case float64:
+		// Numbers are decoded as float64 from JSON
+		if v == float64(int64(v)) {
+			return fmt.Sprintf("%d", int64(v))
+		}
+		return fmt.Sprintf("%v", v)
+	case []interface{}, map[string]interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		return string(data)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// HumanAge formats the age like kubectl, e.g. 45s, 10m, 5h, 3d.
+func HumanAge(d time.Duration) string {
+	switch {
+	case d < 0:
+		return "<invalid>"
+	case d < 2*time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < 2*time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	case d < 2*365*24*time.Hour:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	default:
+		return fmt.Sprintf("%dy", int(d.Hours()/24/365))
+	}
+}
@@ -0,0 +1,175 @@
+package gokubectl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestHumanAge(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{-time.Second, "<invalid>"},
+		{45 * time.Second, "45s"},
+		{119 * time.Second, "119s"},
+		{10 * time.Minute, "10m"},
+		{5 * time.Hour, "5h"},
+		{47 * time.Hour, "47h"},
+		{3 * 24 * time.Hour, "3d"},
+		{800 * 24 * time.Hour, "2y"},
+	}
+	for _, tt := range tests {
+		if got := HumanAge(tt.d); got != tt.want {
+			t.Errorf("HumanAge(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestFormatCell(t *testing.T) {
+	date := metav1.TableColumnDefinition{Name: "Age", Type: "date"}
+	str := metav1.TableColumnDefinition{Name: "Name", Type: "string"}
+	tests := []struct {
+		column metav1.TableColumnDefinition
+		cell   interface{}
+		want   string
+	}{
+		{str, nil, "<none>"},
+		{str, "", "<none>"},
+		{str, "web", "web"},
+		{str, float64(3), "3"},
+		{str, 0.5, "0.5"},
+		{str, true, "true"},
+		{str, []interface{}{"a", "b"}, `["a","b"]`},
+		{str, map[string]interface{}{"k": "v"}, `{"k":"v"}`},
+		{date, time.Now().Add(-5 * time.Minute).UTC().Format(time.RFC3339), "5m"},
+		{date, "unknown", "unknown"},
+	}
+	for _, tt := range tests {
+		if got := formatCell(tt.column, tt.cell); got != tt.want {
+			t.Errorf("formatCell(%v) = %q, want %q", tt.cell, got, tt.want)
+		}
+	}
+}
+
+func testTable() *metav1.Table {
+	return &metav1.Table{
+		ColumnDefinitions: []metav1.TableColumnDefinition{
+			{Name: "Name", Type: "string"},
+			{Name: "Ready", Type: "string"},
+			{Name: "IP", Type: "string", Priority: 1},
+		},
+		Rows: []metav1.TableRow{
+			{
+				Cells:  []interface{}{"web-1", "1/1", "10.0.0.1"},
+				Object: runtime.RawExtension{Raw: []byte(`{"metadata":{"name":"web-1","namespace":"shop"}}`)},
+			},
+			// The cells may be less than the columns
+			{Cells: []interface{}{"web-2", "0/1"}},
+		},
+	}
+}
+
+func TestPrintTable(t *testing.T) {
+	tests := []struct {
+		name string
+		opts TablePrintOptions
+		want string
+	}{
+		{
+			name: "text",
+			want: "NAME    READY\nweb-1   1/1\nweb-2   0/1\n",
+		},
+		{
+			name: "wide with namespace",
+			opts: TablePrintOptions{Format: TableWide, WithNamespace: true},
+			want: "NAMESPACE   NAME    READY   IP\nshop        web-1   1/1     10.0.0.1\n            web-2   0/1     <none>\n",
+		},
+		{
+			name: "no headers",
+			opts: TablePrintOptions{NoHeaders: true},
+			want: "web-1   1/1\nweb-2   0/1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := PrintTable(&buf, testTable(), tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("PrintTable() printed\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintTableJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := PrintTable(&buf, testTable(), TablePrintOptions{Format: TableJSON, WithNamespace: true}); err != nil {
+		t.Fatal(err)
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0]["Namespace"] != "shop" || rows[0]["IP"] != "10.0.0.1" || rows[1]["Name"] != "web-2" {
+		t.Errorf("PrintTable() = %v, want the rows keyed by the column names", rows)
+	}
+}
+
+func TestGetTable(t *testing.T) {
+	var (
+		gotPath, gotAccept, gotSelector string
+	)
+	b64 := testAPIServer(t, func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAccept, gotSelector = r.URL.Path, r.Header.Get("Accept"), r.URL.Query().Get("labelSelector")
+		table := testTable()
+		table.Kind, table.APIVersion = "Table", "meta.k8s.io/v1"
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(table)
+	})
+
+	table, err := GetTable(context.Background(), b64, "po", "", GetOptions{Namespace: "shop", LabelSelector: "app=web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(table.Rows) != 2 || len(table.ColumnDefinitions) != 3 {
+		t.Errorf("GetTable() = %d rows and %d columns, want 2 and 3", len(table.Rows), len(table.ColumnDefinitions))
+	}
+	if gotPath != "/api/v1/namespaces/shop/pods" || gotSelector != "app=web" {
+		t.Errorf("request = %s?labelSelector=%s, want the pods of shop with the selector", gotPath, gotSelector)
+	}
+	if !strings.HasPrefix(gotAccept, "application/json;as=Table;v=v1;g=meta.k8s.io") {
+		t.Errorf("Accept = %q, want the Table", gotAccept)
+	}
+
+	// The name of the cluster-scoped object
+	if _, err = GetTable(context.Background(), b64, "namespaces", "shop", GetOptions{Namespace: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if gotPath != "/api/v1/namespaces/shop" {
+		t.Errorf("request = %s, want /api/v1/namespaces/shop", gotPath)
+	}
+}
+
+func TestGetTableUnsupported(t *testing.T) {
+	b64 := testAPIServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"kind":"PodList","apiVersion":"v1","items":[]}`))
+	})
+	if _, err := GetTable(context.Background(), b64, "pods", "", GetOptions{}); err == nil || !strings.Contains(err.Error(), "PodList is returned instead of Table") {
+		t.Errorf("GetTable() err = %v, want the Table unsupported", err)
+	}
+	if _, err := GetTable(context.Background(), b64, "widgets", "", GetOptions{}); err == nil {
+		t.Error("GetTable() of an unknown resource succeeds")
+	}
+}
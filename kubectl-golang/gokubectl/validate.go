@@ -0,0 +1,209 @@
+package gokubectl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/kube-openapi/pkg/util/proto"
+
+	"github.com/penglongli/kubernetes-demo/kubectl-golang/k8s"
+)
+
+const (
+	preserveUnknownFieldsExtension = "x-kubernetes-preserve-unknown-fields"
+)
+
+// ValidationMode is how the documents are validated against the OpenAPI v2 schema
+// of the cluster. OpenAPI v3 is not used, it isn't served by the clusters before
+// 1.23 while v2 is served by all of them.
+type ValidationMode string
+
+const (
+	// ValidationNone skips the validation, it's the default.
+	ValidationNone ValidationMode = ""
+	// ValidationWarn reports the invalid fields as warnings and applies anyway.
+	ValidationWarn ValidationMode = "Warn"
+	// ValidationStrict stops the whole apply if any document is invalid, or the
+	// schema can't be fetched.
+	ValidationStrict ValidationMode = "Strict"
+)
+
+type ValidationError struct {
+	// Source is where the object is read from
+	Source Location
+	// Path is the YAML path of the field, e.g. spec.template.spec.containers[0].image
+	Path    string
+	Message string
+}
+
+// Error doesn't include the Source, it's printed by the ApplyResult of the object.
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return "validation failed: " + strings.Join(messages, "; ")
+}
+
+// validateObject validates the object against the OpenAPI schema of the cluster,
+// the kinds without schema are skipped.
+func validateObject(kubeClient *k8s.KubeClient, source Location, obj *unstructured.Unstructured) (ValidationErrors, error) {
+	openAPISchema, err := kubeClient.GetOpenAPISchema()
+	if err != nil {
+		return nil, err
+	}
+	s := openAPISchema.LookupResource(obj.GroupVersionKind())
+	if s == nil {
+		return nil, nil
+	}
+
+	v := &validator{source: source}
+	v.validate(s, obj.Object, "")
+	return v.errs, nil
+}
+
+type validator struct {
+	source Location
+	errs   ValidationErrors
+}
+
+func (v *validator) errorf(path, format string, args ...interface{}) {
+	if path == "" {
+		path = "<root>"
+	}
+	v.errs = append(v.errs, ValidationError{
+		Source:  v.source,
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) validate(s proto.Schema, value interface{}, path string) {
+	// null is allowed for all the fields, it means the default
+	if value == nil {
+		return
+	}
+
+	switch s := s.(type) {
+	case proto.Reference:
+		v.validate(s.SubSchema(), value, path)
+	case *proto.Kind:
+		v.validateKind(s, value, path)
+	case *proto.Map:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			v.errorf(path, "expected object, got %s", typeName(value))
+			return
+		}
+		for key, item := range m {
+			v.validate(s.SubType, item, joinPath(path, key))
+		}
+	case *proto.Array:
+		list, ok := value.([]interface{})
+		if !ok {
+			v.errorf(path, "expected array, got %s", typeName(value))
+			return
+		}
+		for i, item := range list {
+			v.validate(s.SubType, item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case *proto.Primitive:
+		v.validatePrimitive(s, value, path)
+	}
+}
+
+func (v *validator) validateKind(s *proto.Kind, value interface{}, path string) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		v.errorf(path, "expected object, got %s", typeName(value))
+		return
+	}
+	preserveUnknownFields, _ := s.GetExtensions()[preserveUnknownFieldsExtension].(bool)
+
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		field, ok := s.Fields[key]
+		if !ok {
+			if !preserveUnknownFields {
+				v.errorf(joinPath(path, key), "unknown field %q", key)
+			}
+			continue
+		}
+		v.validate(field, m[key], joinPath(path, key))
+	}
+
+	for _, required := range s.RequiredFields {
+		if _, ok := m[required]; !ok {
+			v.errorf(joinPath(path, required), "missing required field %q", required)
+		}
+	}
+}
+
+func (v *validator) validatePrimitive(s *proto.Primitive, value interface{}, path string) {
+	var valid bool
+	switch s.Type {
+	case "integer":
+		switch n := value.(type) {
+		case int64:
+			valid = true
+		case float64:
+			valid = n == float64(int64(n))
+		}
+	case "number":
+		switch value.(type) {
+		case int64, float64:
+			valid = true
+		}
+	case "string":
+		// The numbers are accepted like kube-openapi, e.g. the quantity cpu: 1
+		switch value.(type) {
+		case string, int64, float64:
+			valid = true
+		}
+	case "boolean":
+		_, valid = value.(bool)
+	default:
+		valid = true
+	}
+	if !valid {
+		v.errorf(path, "expected %s, got %s", s.Type, typeName(value))
+	}
+}
+
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case int64:
+		return "integer"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
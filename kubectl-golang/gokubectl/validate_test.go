@@ -0,0 +1,95 @@
+package gokubectl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/kube-openapi/pkg/util/proto"
+)
+
+// testDeploymentSchema is a part of the schema of Deployment
+var testDeploymentSchema = &proto.Kind{
+	Fields: map[string]proto.Schema{
+		"apiVersion": &proto.Primitive{Type: "string"},
+		"kind":       &proto.Primitive{Type: "string"},
+		"metadata": &proto.Kind{
+			Fields: map[string]proto.Schema{
+				"name":   &proto.Primitive{Type: "string"},
+				"labels": &proto.Map{SubType: &proto.Primitive{Type: "string"}},
+			},
+		},
+		"spec": &proto.Kind{
+			Fields: map[string]proto.Schema{
+				"replicas": &proto.Primitive{Type: "integer"},
+				"paused":   &proto.Primitive{Type: "boolean"},
+				"containers": &proto.Array{SubType: &proto.Kind{
+					Fields:         map[string]proto.Schema{"name": &proto.Primitive{Type: "string"}},
+					RequiredFields: []string{"name"},
+				}},
+			},
+		},
+	},
+}
+
+func TestValidator(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		want     []string
+	}{
+		{
+			name: "valid",
+			manifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: web, labels: {app: web}}
+spec: {replicas: 3, paused: null, containers: [{name: web}]}
+`,
+		},
+		{
+			name: "invalid",
+			manifest: `
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: web}
+spec: {replicas: "3", paused: "no", image: nginx, containers: [{}]}
+`,
+			want: []string{
+				`spec.containers[0].name: missing required field "name"`,
+				`spec.image: unknown field "image"`,
+				"spec.paused: expected boolean, got string",
+				"spec.replicas: expected integer, got string",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := decodeTestObject(t, tt.manifest)
+			v := &validator{source: Location{File: "app.yaml", Line: 1, Item: -1}}
+			v.validate(testDeploymentSchema, obj.Object, "")
+			var got []string
+			for _, err := range v.errs {
+				got = append(got, err.Error())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidationResultSource(t *testing.T) {
+	source := Location{File: "app.yaml", Line: 1, Item: -1}
+	r := ApplyResult{
+		Source: source,
+		Err:    ValidationErrors{{Source: source, Path: "spec.replicas", Message: "expected integer, got string"}},
+	}
+	want := "app.yaml:0:1: validation failed: spec.replicas: expected integer, got string"
+	if got := r.String(); got != want {
+		t.Errorf("ApplyResult.String() = %q, want %q", got, want)
+	}
+	if strings.Count(r.String(), source.String()) != 1 {
+		t.Errorf("ApplyResult.String() = %q, want the source once", r.String())
+	}
+}
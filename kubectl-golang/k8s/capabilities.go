@@ -0,0 +1,201 @@
+package k8s
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	openapi_v2 "github.com/googleapis/gnostic/openapiv2"
+	"github.com/pkg/errors"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery/cached/memory"
+)
+
+var (
+	// GitVersion is like v1.18.9, v1.18.9-eks-d1db3c or v1.21.3-gke.2001
+	gitVersionRegexp = regexp.MustCompile(`^v?(\d+)\.(\d+)(?:\.(\d+))?`)
+	leadingDigits    = regexp.MustCompile(`^\d+`)
+)
+
+// Version is the semantic version of the server, the vendor suffixes are dropped.
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("v%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// AtLeast returns true if the version >= major.minor.
+func (v Version) AtLeast(major, minor int) bool {
+	return v.Major > major || (v.Major == major && v.Minor >= minor)
+}
+
+// Capabilities are the features served by the cluster.
+type Capabilities struct {
+	Version    Version
+	GitVersion string
+
+	// ServerSideApply is true if the server accepts application/apply-patch+yaml
+	ServerSideApply bool
+	// DryRun is true if the server supports dryRun=All
+	DryRun bool
+
+	AppsV1              bool
+	BatchV1CronJob      bool
+	NetworkingV1Ingress bool
+}
+
+// ParseVersion parses the version info of the server. GitVersion is preferred,
+// Major and Minor like "1" and "18+" are used if GitVersion is not semver.
+func ParseVersion(info *version.Info) (Version, error) {
+	if matches := gitVersionRegexp.FindStringSubmatch(info.GitVersion); matches != nil {
+		v := Version{}
+		v.Major, _ = strconv.Atoi(matches[1])
+		v.Minor, _ = strconv.Atoi(matches[2])
+		if matches[3] != "" {
+			v.Patch, _ = strconv.Atoi(matches[3])
+		}
+		return v, nil
+	}
+
+	major, err := strconv.Atoi(leadingDigits.FindString(info.Major))
+	if err != nil {
+		return Version{}, errors.Errorf("invalid server version: major %q, minor %q, gitVersion %q",
+			info.Major, info.Minor, info.GitVersion)
+	}
+	minor, err := strconv.Atoi(leadingDigits.FindString(info.Minor))
+	if err != nil {
+		return Version{}, errors.Errorf("invalid server version: major %q, minor %q, gitVersion %q",
+			info.Major, info.Minor, info.GitVersion)
+	}
+	return Version{Major: major, Minor: minor}, nil
+}
+
+// GetCapabilities detects the capabilities once, the result is shared by all the callers.
+func (kube *KubeClient) GetCapabilities() (*Capabilities, error) {
+	kube.mu.Lock()
+	capabilities := kube.capabilities
+	kube.mu.Unlock()
+	if capabilities != nil {
+		return capabilities, nil
+	}
+
+	capabilities, err := kube.detectCapabilities()
+	if err != nil {
+		return nil, err
+	}
+	kube.mu.Lock()
+	kube.capabilities = capabilities
+	kube.mu.Unlock()
+	return capabilities, nil
+}
+
+func (kube *KubeClient) detectCapabilities() (*Capabilities, error) {
+	dc, err := kube.GetDiscoveryClient()
+	if err != nil {
+		return nil, err
+	}
+	info, err := dc.ServerVersion()
+	if err != nil {
+		return nil, errors.Wrap(err, "get server version failed")
+	}
+	v, err := ParseVersion(info)
+	if err != nil {
+		return nil, err
+	}
+
+	capabilities := &Capabilities{
+		Version:    v,
+		GitVersion: info.GitVersion,
+	}
+	if capabilities.AppsV1, err = kube.hasResource("apps/v1", "deployments"); err != nil {
+		return nil, err
+	}
+	if capabilities.BatchV1CronJob, err = kube.hasResource("batch/v1", "cronjobs"); err != nil {
+		return nil, err
+	}
+	if capabilities.NetworkingV1Ingress, err = kube.hasResource("networking.k8s.io/v1", "ingresses"); err != nil {
+		return nil, err
+	}
+
+	// The feature gates may be disabled, the OpenAPI document tells if they're
+	// served. dryRun is beta and enabled by default since 1.13, and server-side
+	// apply since 1.16.
+	var found bool
+	if doc, err := kube.getOpenAPIDocument(); err == nil {
+		capabilities.DryRun, capabilities.ServerSideApply, found = openAPIFeatures(doc)
+	}
+	if !found {
+		capabilities.DryRun = v.AtLeast(1, 13)
+		capabilities.ServerSideApply = v.AtLeast(1, 16)
+	}
+	return capabilities, nil
+}
+
+// hasResource returns true if the resource is served in the group version.
+func (kube *KubeClient) hasResource(groupVersion, resource string) (bool, error) {
+	dc, err := kube.GetDiscoveryClient()
+	if err != nil {
+		return false, err
+	}
+	resources, err := dc.ServerResourcesForGroupVersion(groupVersion)
+	// The memory cache doesn't ask the server for the groups not discovered
+	if k8sErrors.IsNotFound(err) || err == memory.ErrCacheNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "discover %s failed", groupVersion)
+	}
+	for _, r := range resources.APIResources {
+		if r.Name == resource {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// configMapPath is the path of the ConfigMap operations in the OpenAPI document,
+// the core API is served by every cluster.
+const configMapPath = "/api/v1/namespaces/{namespace}/configmaps/{name}"
+
+// openAPIFeatures reads the features from the PATCH operation of ConfigMaps, the
+// apply patch content type is listed if server-side apply is enabled, and the
+// dryRun parameter if dry-run is enabled. found is false if the operation is missing.
+func openAPIFeatures(doc *openapi_v2.Document) (dryRun, serverSideApply, found bool) {
+	var patch *openapi_v2.Operation
+	for _, item := range doc.GetPaths().GetPath() {
+		if item.GetName() == configMapPath {
+			patch = item.GetValue().GetPatch()
+			break
+		}
+	}
+	if patch == nil {
+		return false, false, false
+	}
+	for _, contentType := range patch.GetConsumes() {
+		if contentType == string(types.ApplyPatchType) {
+			serverSideApply = true
+		}
+	}
+
+	// The parameters are inlined or referenced to the shared ones
+	shared := map[string]*openapi_v2.Parameter{}
+	for _, p := range doc.GetParameters().GetAdditionalProperties() {
+		shared["#/parameters/"+p.GetName()] = p.GetValue()
+	}
+	for _, item := range patch.GetParameters() {
+		parameter := item.GetParameter()
+		if ref := item.GetJsonReference(); ref != nil {
+			parameter = shared[ref.GetXRef()]
+		}
+		if parameter.GetNonBodyParameter().GetQueryParameterSubSchema().GetName() == "dryRun" {
+			dryRun = true
+		}
+	}
+	return dryRun, serverSideApply, true
+}
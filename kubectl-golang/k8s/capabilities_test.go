@@ -0,0 +1,186 @@
+package k8s
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/googleapis/gnostic/compiler"
+	openapi_v2 "github.com/googleapis/gnostic/openapiv2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		info    version.Info
+		want    Version
+		wantErr bool
+	}{
+		{info: version.Info{GitVersion: "v1.18.9"}, want: Version{1, 18, 9}},
+		{info: version.Info{GitVersion: "v1.18.9-eks-d1db3c"}, want: Version{1, 18, 9}},
+		{info: version.Info{GitVersion: "v1.21.3-gke.2001"}, want: Version{1, 21, 3}},
+		{info: version.Info{GitVersion: "v1.20"}, want: Version{1, 20, 0}},
+		{info: version.Info{Major: "1", Minor: "18+", GitVersion: "custom"}, want: Version{1, 18, 0}},
+		{info: version.Info{Major: "x", Minor: "18", GitVersion: "custom"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseVersion(&tt.info)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseVersion(%+v) = %v, want error", tt.info, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseVersion(%+v) = %v, %v, want %v", tt.info, got, err, tt.want)
+		}
+	}
+}
+
+func parseTestDocument(t *testing.T, name, data string) *openapi_v2.Document {
+	t.Helper()
+	// The info is cached by the name
+	info, err := compiler.ReadInfoFromBytes(name, []byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := openapi_v2.NewDocument(info, compiler.NewContext("$root", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestOpenAPIFeatures(t *testing.T) {
+	tests := []struct {
+		name                           string
+		doc                            string
+		dryRun, serverSideApply, found bool
+	}{
+		{
+			name: "inline parameters",
+			doc: `{"swagger": "2.0", "info": {"title": "Kubernetes", "version": "v1.16.0"}, "paths": {
+  "/api/v1/namespaces/{namespace}/configmaps/{name}": {"patch": {
+    "consumes": ["application/json-patch+json", "application/merge-patch+json", "application/strategic-merge-patch+json", "application/apply-patch+yaml"],
+    "parameters": [{"name": "dryRun", "in": "query", "type": "string", "uniqueItems": true}],
+    "responses": {"200": {"description": "OK"}}}}}}`,
+			dryRun: true, serverSideApply: true, found: true,
+		},
+		{
+			name: "referenced parameters",
+			doc: `{"swagger": "2.0", "info": {"title": "Kubernetes", "version": "v1.19.0"},
+  "parameters": {"dryRun-abc": {"name": "dryRun", "in": "query", "type": "string", "uniqueItems": true}},
+  "paths": {"/api/v1/namespaces/{namespace}/configmaps/{name}": {"patch": {
+    "consumes": ["application/merge-patch+json", "application/apply-patch+yaml"],
+    "parameters": [{"$ref": "#/parameters/dryRun-abc"}],
+    "responses": {"200": {"description": "OK"}}}}}}`,
+			dryRun: true, serverSideApply: true, found: true,
+		},
+		{
+			name: "features disabled",
+			doc: `{"swagger": "2.0", "info": {"title": "Kubernetes", "version": "v1.16.0"}, "paths": {
+  "/api/v1/namespaces/{namespace}/configmaps/{name}": {"patch": {
+    "consumes": ["application/merge-patch+json", "application/strategic-merge-patch+json"],
+    "parameters": [{"name": "pretty", "in": "query", "type": "string"}],
+    "responses": {"200": {"description": "OK"}}}}}}`,
+			found: true,
+		},
+		{
+			name: "operation missing",
+			doc:  `{"swagger": "2.0", "info": {"title": "Kubernetes", "version": "v1.16.0"}, "paths": {}}`,
+		},
+	}
+	for _, tt := range tests {
+		dryRun, serverSideApply, found := openAPIFeatures(parseTestDocument(t, tt.name, tt.doc))
+		if dryRun != tt.dryRun || serverSideApply != tt.serverSideApply || found != tt.found {
+			t.Errorf("%s: openAPIFeatures() = %v, %v, %v, want %v, %v, %v", tt.name,
+				dryRun, serverSideApply, found, tt.dryRun, tt.serverSideApply, tt.found)
+		}
+	}
+}
+
+// testCapabilitiesServer serves the version and the discovery of apps/v1, the
+// other groups and the OpenAPI document aren't served.
+func testCapabilitiesServer(t *testing.T, gitVersion string) *KubeClient {
+	t.Helper()
+	writeJSON := func(w http.ResponseWriter, obj interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(obj)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/version":
+			writeJSON(w, &version.Info{Major: "1", Minor: "15", GitVersion: gitVersion})
+		case "/api":
+			writeJSON(w, &metav1.APIVersions{Versions: []string{"v1"}})
+		case "/api/v1":
+			writeJSON(w, &metav1.APIResourceList{GroupVersion: "v1", APIResources: []metav1.APIResource{
+				{Name: "configmaps", Kind: "ConfigMap", Namespaced: true},
+			}})
+		case "/apis":
+			writeJSON(w, &metav1.APIGroupList{Groups: []metav1.APIGroup{{
+				Name:             "apps",
+				Versions:         []metav1.GroupVersionForDiscovery{{GroupVersion: "apps/v1", Version: "v1"}},
+				PreferredVersion: metav1.GroupVersionForDiscovery{GroupVersion: "apps/v1", Version: "v1"},
+			}}})
+		case "/apis/apps/v1":
+			writeJSON(w, &metav1.APIResourceList{GroupVersion: "apps/v1", APIResources: []metav1.APIResource{
+				{Name: "deployments", Kind: "Deployment", Namespaced: true},
+			}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	kubeConfig := strings.Replace(testKubeConfig, "https://10.0.0.1:6443", server.URL, 1)
+	return &KubeClient{Base64KubeConfig: base64.StdEncoding.EncodeToString([]byte(kubeConfig))}
+}
+
+func TestGetCapabilities(t *testing.T) {
+	for _, cacheDir := range []string{"", "disk"} {
+		t.Run("cache "+cacheDir, func(t *testing.T) {
+			kube := testCapabilitiesServer(t, "v1.15.3-eks-1")
+			if cacheDir != "" {
+				dir, err := ioutil.TempDir("", "discovery")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(dir)
+				kube.DiscoveryCacheDir = dir
+			}
+
+			capabilities, err := kube.GetCapabilities()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The features are told by the version without the OpenAPI document
+			want := Capabilities{
+				Version:    Version{1, 15, 3},
+				GitVersion: "v1.15.3-eks-1",
+				DryRun:     true,
+				AppsV1:     true,
+			}
+			if *capabilities != want {
+				t.Errorf("GetCapabilities() = %+v, want %+v", *capabilities, want)
+			}
+			if again, _ := kube.GetCapabilities(); again != capabilities {
+				t.Error("GetCapabilities() detects the capabilities again")
+			}
+		})
+	}
+}
+
+func TestGetCapabilitiesCustomVersion(t *testing.T) {
+	// Major and Minor are used if GitVersion isn't semver
+	kube := testCapabilitiesServer(t, "custom")
+	if capabilities, err := kube.GetCapabilities(); err != nil || capabilities.Version != (Version{1, 15, 0}) {
+		t.Errorf("GetCapabilities() = %v, %v, want v1.15.0 of Major and Minor", capabilities, err)
+	}
+}
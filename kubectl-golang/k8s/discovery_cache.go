@@ -0,0 +1,163 @@
+package k8s
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+)
+
+var (
+	unsafeHostChars = regexp.MustCompile(`[^(\w/.)]`)
+)
+
+// diskCachedDiscovery caches the server groups and resources as JSON files,
+// the layout is the same as the one of kubectl:
+//   <dir>/servergroups.json
+//   <dir>/<group>/<version>/serverresources.json
+type diskCachedDiscovery struct {
+	discovery.DiscoveryInterface
+
+	dir string
+	ttl time.Duration
+
+	mu sync.Mutex
+	// fresh is true if all the returned data were fetched from the server
+	fresh bool
+	// invalidated forces to skip the files written before it
+	invalidated time.Time
+}
+
+var _ discovery.CachedDiscoveryInterface = &diskCachedDiscovery{}
+
+func newDiskCachedDiscovery(delegate discovery.DiscoveryInterface, dir string, ttl time.Duration) *diskCachedDiscovery {
+	return &diskCachedDiscovery{
+		DiscoveryInterface: delegate,
+		dir:                dir,
+		ttl:                ttl,
+		fresh:              true,
+	}
+}
+
+// diskCacheDir returns the cache dir of the host under the parent dir.
+func diskCacheDir(parent, host string) string {
+	// strip the scheme and replace the special characters of host
+	host = strings.TrimPrefix(host, "https://")
+	host = strings.TrimPrefix(host, "http://")
+	return filepath.Join(parent, unsafeHostChars.ReplaceAllString(host, "_"))
+}
+
+func (d *diskCachedDiscovery) ServerGroups() (*metav1.APIGroupList, error) {
+	filename := filepath.Join(d.dir, "servergroups.json")
+	groups := new(metav1.APIGroupList)
+	if d.readCache(filename, groups) {
+		return groups, nil
+	}
+
+	groups, err := d.DiscoveryInterface.ServerGroups()
+	if err != nil {
+		return groups, err
+	}
+	d.writeCache(filename, groups)
+	return groups, nil
+}
+
+func (d *diskCachedDiscovery) ServerResourcesForGroupVersion(groupVersion string) (*metav1.APIResourceList, error) {
+	filename := filepath.Join(d.dir, filepath.FromSlash(groupVersion), "serverresources.json")
+	resources := new(metav1.APIResourceList)
+	if d.readCache(filename, resources) {
+		return resources, nil
+	}
+
+	resources, err := d.DiscoveryInterface.ServerResourcesForGroupVersion(groupVersion)
+	if err != nil {
+		return resources, err
+	}
+	d.writeCache(filename, resources)
+	return resources, nil
+}
+
+// ServerResources is deprecated, use ServerGroupsAndResources instead.
+func (d *diskCachedDiscovery) ServerResources() ([]*metav1.APIResourceList, error) {
+	return discovery.ServerResources(d)
+}
+
+func (d *diskCachedDiscovery) ServerGroupsAndResources() ([]*metav1.APIGroup, []*metav1.APIResourceList, error) {
+	return discovery.ServerGroupsAndResources(d)
+}
+
+func (d *diskCachedDiscovery) ServerPreferredResources() ([]*metav1.APIResourceList, error) {
+	return discovery.ServerPreferredResources(d)
+}
+
+func (d *diskCachedDiscovery) ServerPreferredNamespacedResources() ([]*metav1.APIResourceList, error) {
+	return discovery.ServerPreferredNamespacedResources(d)
+}
+
+func (d *diskCachedDiscovery) Fresh() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.fresh
+}
+
+func (d *diskCachedDiscovery) Invalidate() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.invalidated = time.Now()
+	d.fresh = true
+}
+
+func (d *diskCachedDiscovery) readCache(filename string, obj interface{}) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return false
+	}
+	if time.Since(info.ModTime()) > d.ttl || !info.ModTime().After(d.invalidated) {
+		return false
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return false
+	}
+	if err = json.Unmarshal(data, obj); err != nil {
+		return false
+	}
+	d.fresh = false
+	return true
+}
+
+// writeCache ignores the errors, the cache is only a speedup
+func (d *diskCachedDiscovery) writeCache(filename string, obj interface{}) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(filename), 0750); err != nil {
+		return
+	}
+
+	// Write to a temp file first to avoid the partial file read by others
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".")
+	if err != nil {
+		return
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return
+	}
+	if err = f.Close(); err != nil {
+		return
+	}
+	_ = os.Rename(f.Name(), filename)
+}
@@ -0,0 +1,114 @@
+package k8s
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+)
+
+// countingDiscovery serves a fixed group and counts the requests to the server
+type countingDiscovery struct {
+	discovery.DiscoveryInterface
+	requests int
+}
+
+func (d *countingDiscovery) ServerGroups() (*metav1.APIGroupList, error) {
+	d.requests++
+	return &metav1.APIGroupList{Groups: []metav1.APIGroup{{Name: "apps"}}}, nil
+}
+
+func (d *countingDiscovery) ServerResourcesForGroupVersion(groupVersion string) (*metav1.APIResourceList, error) {
+	d.requests++
+	return &metav1.APIResourceList{
+		GroupVersion: groupVersion,
+		APIResources: []metav1.APIResource{{Name: "deployments", Kind: "Deployment", Namespaced: true}},
+	}, nil
+}
+
+func TestDiskCacheDir(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"https://10.0.0.1:6443", filepath.Join("cache", "10.0.0.1_6443")},
+		{"http://localhost:8080", filepath.Join("cache", "localhost_8080")},
+		{"https://api.example.com/prefix", filepath.Join("cache", "api.example.com", "prefix")},
+	}
+	for _, tt := range tests {
+		if got := diskCacheDir("cache", tt.host); got != tt.want {
+			t.Errorf("diskCacheDir(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestDiskCachedDiscovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := &countingDiscovery{}
+	first := newDiskCachedDiscovery(server, dir, time.Minute)
+	if _, err = first.ServerGroups(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = first.ServerResourcesForGroupVersion("apps/v1"); err != nil {
+		t.Fatal(err)
+	}
+	if server.requests != 2 || !first.Fresh() {
+		t.Fatalf("first process: requests = %d, fresh = %v, want 2 and true", server.requests, first.Fresh())
+	}
+	if _, err = os.Stat(filepath.Join(dir, "apps", "v1", "serverresources.json")); err != nil {
+		t.Fatalf("cache file not written: %v", err)
+	}
+
+	// The later process reads the files instead of the server
+	second := newDiskCachedDiscovery(server, dir, time.Minute)
+	resources, err := second.ServerResourcesForGroupVersion("apps/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.requests != 2 || second.Fresh() {
+		t.Errorf("second process: requests = %d, fresh = %v, want 2 and false", server.requests, second.Fresh())
+	}
+	if len(resources.APIResources) != 1 || resources.APIResources[0].Kind != "Deployment" {
+		t.Errorf("cached resources = %+v, want the Deployment", resources.APIResources)
+	}
+
+	second.Invalidate()
+	if _, err = second.ServerResourcesForGroupVersion("apps/v1"); err != nil {
+		t.Fatal(err)
+	}
+	if server.requests != 3 {
+		t.Errorf("after Invalidate: requests = %d, want 3", server.requests)
+	}
+}
+
+func TestDiskCachedDiscoveryExpired(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := &countingDiscovery{}
+	if _, err = newDiskCachedDiscovery(server, dir, time.Minute).ServerGroups(); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Minute)
+	if err = os.Chtimes(filepath.Join(dir, "servergroups.json"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = newDiskCachedDiscovery(server, dir, time.Minute).ServerGroups(); err != nil {
+		t.Fatal(err)
+	}
+	if server.requests != 2 {
+		t.Errorf("requests = %d, want 2 since the cache is older than the ttl", server.requests)
+	}
+}
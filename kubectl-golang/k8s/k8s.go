@@ -2,10 +2,14 @@ package k8s
 
 import (
 	"encoding/base64"
-	"strconv"
+	"sync"
+	"time"
 
+	openapi_v2 "github.com/googleapis/gnostic/openapiv2"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/dynamic"
@@ -17,74 +21,228 @@ import (
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
+const (
+	defaultDiscoveryCacheTTL = 10 * time.Minute
+)
+
+// KubeClient builds the clients of one cluster lazily, the clients are
+// built once and shared by all the callers.
 type KubeClient struct {
 	Base64KubeConfig string
+
+	// DiscoveryCacheDir persists the discovery cache to disk if not empty,
+	// so that the later processes can skip the API discovery.
+	DiscoveryCacheDir string
+	// DiscoveryCacheTTL is the max age of the disk cache, default 10 minutes.
+	DiscoveryCacheTTL time.Duration
+
+	// QPS and Burst limit the requests of the clients, default 5 and 10 of client-go.
+	QPS   float32
+	Burst int
+
+	mu              sync.Mutex
+	restConfig      *rest.Config
+	clientSet       *kubernetes.Clientset
+	dynamicClient   dynamic.Interface
+	discoveryClient discovery.CachedDiscoveryInterface
+	discoveryMapper *restmapper.DeferredDiscoveryRESTMapper
+	openAPIDocument *openapi_v2.Document
+	openAPISchema   *OpenAPISchema
+	capabilities    *Capabilities
+}
+
+func (kube *KubeClient) GetRestConfig() (*rest.Config, error) {
+	kube.mu.Lock()
+	defer kube.mu.Unlock()
+	return kube.getRestConfigLocked()
 }
 
 func (kube *KubeClient) GetClientSet() (*kubernetes.Clientset, error) {
-	restConfig, err := kube.buildRestConfig()
+	kube.mu.Lock()
+	defer kube.mu.Unlock()
+
+	if kube.clientSet != nil {
+		return kube.clientSet, nil
+	}
+	restConfig, err := kube.getRestConfigLocked()
 	if err != nil {
-		return nil, errors.Wrap(err, "build restConfig failed")
+		return nil, err
 	}
-
-	return kubernetes.NewForConfig(restConfig)
+	clientSet, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	kube.clientSet = clientSet
+	return clientSet, nil
 }
 
 func (kube *KubeClient) GetRESTClient() (*rest.RESTClient, error) {
-	restConfig, err := kube.buildRestConfig()
+	restConfig, err := kube.GetRestConfig()
 	if err != nil {
-		return nil, errors.Wrap(err, "build restConfig failed")
+		return nil, err
 	}
 
+	restConfig = rest.CopyConfig(restConfig)
 	restConfig.GroupVersion = &corev1.SchemeGroupVersion
 	restConfig.NegotiatedSerializer = scheme.Codecs
 	return rest.RESTClientFor(restConfig)
 }
 
+// GetRESTClientFor returns the REST client of the group version, e.g. apps/v1.
+func (kube *KubeClient) GetRESTClientFor(gv schema.GroupVersion) (*rest.RESTClient, error) {
+	restConfig, err := kube.GetRestConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	restConfig = rest.CopyConfig(restConfig)
+	restConfig.GroupVersion = &gv
+	restConfig.APIPath = "/apis"
+	if gv.Group == "" {
+		restConfig.APIPath = "/api"
+	}
+	restConfig.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
+	return rest.RESTClientFor(restConfig)
+}
+
 func (kube *KubeClient) GetDynamicClient() (dynamic.Interface, error) {
-	restConfig, err := kube.buildRestConfig()
+	kube.mu.Lock()
+	defer kube.mu.Unlock()
+
+	if kube.dynamicClient != nil {
+		return kube.dynamicClient, nil
+	}
+	restConfig, err := kube.getRestConfigLocked()
 	if err != nil {
-		return nil, errors.Wrap(err, "build restConfig failed")
+		return nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
 	}
+	kube.dynamicClient = dynamicClient
+	return dynamicClient, nil
+}
 
-	return dynamic.NewForConfig(restConfig)
+func (kube *KubeClient) GetDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
+	kube.mu.Lock()
+	defer kube.mu.Unlock()
+	return kube.getDiscoveryClientLocked()
 }
 
 func (kube *KubeClient) GetDiscoveryMapper() (*restmapper.DeferredDiscoveryRESTMapper, error) {
-	restConfig, err := kube.buildRestConfig()
+	kube.mu.Lock()
+	defer kube.mu.Unlock()
+
+	if kube.discoveryMapper != nil {
+		return kube.discoveryMapper, nil
+	}
+	dc, err := kube.getDiscoveryClientLocked()
 	if err != nil {
-		return nil, errors.Wrap(err, "build restConfig failed")
+		return nil, err
 	}
 
 	// Prepare a RESTMapper to find GVR
-	dc, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	kube.discoveryMapper = restmapper.NewDeferredDiscoveryRESTMapper(dc)
+	return kube.discoveryMapper, nil
+}
+
+// RESTMapping finds the GVR of the kind, the discovery cache will be reset
+// and retried once if the kind is not found, the kind may be a CRD which is
+// created after the cache built.
+func (kube *KubeClient) RESTMapping(gk schema.GroupKind, versions ...string) (*meta.RESTMapping, error) {
+	mapper, err := kube.GetDiscoveryMapper()
 	if err != nil {
-		return nil, errors.Wrap(err, "new dc failed")
+		return nil, errors.Wrap(err, "Prepare discovery mapper failed")
 	}
 
-	discoveryMapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(dc))
-	return discoveryMapper, nil
+	mapping, err := mapper.RESTMapping(gk, versions...)
+	if meta.IsNoMatchError(err) {
+		mapper.Reset()
+		mapping, err = mapper.RESTMapping(gk, versions...)
+	}
+	return mapping, err
+}
+
+// ResourceMapping finds the mapping of the resource argument like kubectl,
+// e.g. "deploy", "deployments", "deployments.apps" or "deployments.v1.apps".
+func (kube *KubeClient) ResourceMapping(resource string) (*meta.RESTMapping, error) {
+	mapper, err := kube.GetDiscoveryMapper()
+	if err != nil {
+		return nil, errors.Wrap(err, "Prepare discovery mapper failed")
+	}
+	dc, err := kube.GetDiscoveryClient()
+	if err != nil {
+		return nil, err
+	}
+
+	gvr, gr := schema.ParseResourceArg(resource)
+	kindFor := func() (schema.GroupVersionKind, error) {
+		expander := restmapper.NewShortcutExpander(mapper, dc)
+		if gvr != nil {
+			if gvk, err := expander.KindFor(*gvr); err == nil {
+				return gvk, nil
+			}
+		}
+		return expander.KindFor(gr.WithVersion(""))
+	}
+
+	gvk, err := kindFor()
+	if meta.IsNoMatchError(err) {
+		mapper.Reset()
+		gvk, err = kindFor()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
 }
 
+// CompareVersion returns true if the server version < 1.16, see GetCapabilities
+// for the features.
 func (kube *KubeClient) CompareVersion() (bool, error) {
-	client, err := kube.GetClientSet()
+	capabilities, err := kube.GetCapabilities()
 	if err != nil {
 		return false, err
 	}
-	serverInfo, err := client.ServerVersion()
+	return !capabilities.Version.AtLeast(1, 16), nil
+}
+
+func (kube *KubeClient) getRestConfigLocked() (*rest.Config, error) {
+	if kube.restConfig != nil {
+		return kube.restConfig, nil
+	}
+	restConfig, err := kube.buildRestConfig()
 	if err != nil {
-		return false, err
+		return nil, errors.Wrap(err, "build restConfig failed")
 	}
+	kube.restConfig = restConfig
+	return restConfig, nil
+}
 
-	minor, err := strconv.Atoi(serverInfo.Minor)
+func (kube *KubeClient) getDiscoveryClientLocked() (discovery.CachedDiscoveryInterface, error) {
+	if kube.discoveryClient != nil {
+		return kube.discoveryClient, nil
+	}
+	restConfig, err := kube.getRestConfigLocked()
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	// version < 1.16
-	if serverInfo.Major == "1" && minor < 16 {
-		return true, nil
+	dc, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "new dc failed")
 	}
-	return false, nil
+
+	if kube.DiscoveryCacheDir == "" {
+		kube.discoveryClient = memory.NewMemCacheClient(dc)
+	} else {
+		ttl := kube.DiscoveryCacheTTL
+		if ttl <= 0 {
+			ttl = defaultDiscoveryCacheTTL
+		}
+		kube.discoveryClient = newDiskCachedDiscovery(dc, diskCacheDir(kube.DiscoveryCacheDir, restConfig.Host), ttl)
+	}
+	return kube.discoveryClient, nil
 }
 
 func (kube *KubeClient) buildRestConfig() (resetConfig *rest.Config, err error) {
@@ -100,5 +258,11 @@ func (kube *KubeClient) buildRestConfig() (resetConfig *rest.Config, err error)
 	if err != nil {
 		return nil, err
 	}
+	if kube.QPS > 0 {
+		conf.QPS = kube.QPS
+	}
+	if kube.Burst > 0 {
+		conf.Burst = kube.Burst
+	}
 	return conf, nil
 }
@@ -0,0 +1,117 @@
+package k8s
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"k8s.io/client-go/discovery/cached/memory"
+)
+
+const testKubeConfig = `
+apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://10.0.0.1:6443
+users:
+- name: test
+  user:
+    token: test-token
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+`
+
+func testKubeClient() *KubeClient {
+	return &KubeClient{Base64KubeConfig: base64.StdEncoding.EncodeToString([]byte(testKubeConfig))}
+}
+
+func TestKubeClientCache(t *testing.T) {
+	kube := testKubeClient()
+	kube.QPS, kube.Burst = 50, 100
+
+	restConfig, err := kube.GetRestConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restConfig.Host != "https://10.0.0.1:6443" || restConfig.BearerToken != "test-token" {
+		t.Errorf("GetRestConfig() = %s with token %q, want the kubeconfig", restConfig.Host, restConfig.BearerToken)
+	}
+	if restConfig.QPS != 50 || restConfig.Burst != 100 {
+		t.Errorf("QPS, Burst = %v, %d, want 50, 100", restConfig.QPS, restConfig.Burst)
+	}
+	if again, _ := kube.GetRestConfig(); again != restConfig {
+		t.Error("GetRestConfig() builds the config again")
+	}
+
+	clientSet, err := kube.GetClientSet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := kube.GetClientSet(); again != clientSet {
+		t.Error("GetClientSet() builds the client again")
+	}
+	dynamicClient, err := kube.GetDynamicClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := kube.GetDynamicClient(); again != dynamicClient {
+		t.Error("GetDynamicClient() builds the client again")
+	}
+	mapper, err := kube.GetDiscoveryMapper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := kube.GetDiscoveryMapper(); again != mapper {
+		t.Error("GetDiscoveryMapper() builds the mapper again")
+	}
+}
+
+func TestKubeClientDiscovery(t *testing.T) {
+	kube := testKubeClient()
+	dc, err := kube.GetDiscoveryClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := memory.NewMemCacheClient(nil); fmt.Sprintf("%T", dc) != fmt.Sprintf("%T", want) {
+		t.Errorf("GetDiscoveryClient() = %T without the cache dir, want %T", dc, want)
+	}
+	if again, _ := kube.GetDiscoveryClient(); again != dc {
+		t.Error("GetDiscoveryClient() builds the client again")
+	}
+
+	kube = testKubeClient()
+	kube.DiscoveryCacheDir = "cache"
+	dc, err = kube.GetDiscoveryClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk, ok := dc.(*diskCachedDiscovery)
+	if !ok {
+		t.Fatalf("GetDiscoveryClient() = %T with the cache dir, want the disk cache", dc)
+	}
+	if disk.dir != diskCacheDir("cache", "https://10.0.0.1:6443") || disk.ttl != defaultDiscoveryCacheTTL {
+		t.Errorf("disk cache = %s with ttl %v, want the dir of the host and %v", disk.dir, disk.ttl, defaultDiscoveryCacheTTL)
+	}
+}
+
+func TestKubeClientInvalidConfig(t *testing.T) {
+	for _, config := range []string{
+		"not base64",
+		base64.StdEncoding.EncodeToString([]byte("kind: [")),
+		"",
+	} {
+		kube := &KubeClient{Base64KubeConfig: config}
+		if _, err := kube.GetRestConfig(); err == nil {
+			t.Errorf("GetRestConfig() of %q succeeds, want error", config)
+		}
+		if _, err := kube.GetDynamicClient(); err == nil {
+			t.Errorf("GetDynamicClient() of %q succeeds, want error", config)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package k8s
+
+import (
+	"fmt"
+
+	openapi_v2 "github.com/googleapis/gnostic/openapiv2"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kube-openapi/pkg/util/proto"
+)
+
+const (
+	gvkExtensionKey = "x-kubernetes-group-version-kind"
+)
+
+// OpenAPISchema is the OpenAPI v2 schema of the cluster, indexed by GVK.
+type OpenAPISchema struct {
+	models   proto.Models
+	gvkIndex map[schema.GroupVersionKind]string
+}
+
+// LookupResource returns the schema of the kind, nil if not found.
+func (s *OpenAPISchema) LookupResource(gvk schema.GroupVersionKind) proto.Schema {
+	name, ok := s.gvkIndex[gvk]
+	if !ok {
+		return nil
+	}
+	return s.models.LookupModel(name)
+}
+
+// GetOpenAPISchema fetches the OpenAPI v2 schema once by the discovery client,
+// the later calls return the cached one.
+func (kube *KubeClient) GetOpenAPISchema() (*OpenAPISchema, error) {
+	kube.mu.Lock()
+	defer kube.mu.Unlock()
+
+	if kube.openAPISchema != nil {
+		return kube.openAPISchema, nil
+	}
+	doc, err := kube.getOpenAPIDocumentLocked()
+	if err != nil {
+		return nil, err
+	}
+	models, err := proto.NewOpenAPIData(doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse openapi schema failed")
+	}
+
+	openAPISchema := &OpenAPISchema{
+		models:   models,
+		gvkIndex: make(map[schema.GroupVersionKind]string),
+	}
+	for _, name := range models.ListModels() {
+		model := models.LookupModel(name)
+		if model == nil {
+			continue
+		}
+		for _, gvk := range parseGroupVersionKinds(model.GetExtensions()[gvkExtensionKey]) {
+			openAPISchema.gvkIndex[gvk] = name
+		}
+	}
+	kube.openAPISchema = openAPISchema
+	return openAPISchema, nil
+}
+
+// getOpenAPIDocument fetches the OpenAPI v2 document once, it's shared by the
+// schema and the capabilities.
+func (kube *KubeClient) getOpenAPIDocument() (*openapi_v2.Document, error) {
+	kube.mu.Lock()
+	defer kube.mu.Unlock()
+	return kube.getOpenAPIDocumentLocked()
+}
+
+func (kube *KubeClient) getOpenAPIDocumentLocked() (*openapi_v2.Document, error) {
+	if kube.openAPIDocument != nil {
+		return kube.openAPIDocument, nil
+	}
+	dc, err := kube.getDiscoveryClientLocked()
+	if err != nil {
+		return nil, err
+	}
+	doc, err := dc.OpenAPISchema()
+	if err != nil {
+		return nil, errors.Wrap(err, "fetch openapi schema failed")
+	}
+	kube.openAPIDocument = doc
+	return doc, nil
+}
+
+// parseGroupVersionKinds parses the value of x-kubernetes-group-version-kind,
+// the value is decoded by yaml.v2, so the maps may be map[interface{}]interface{}.
+func parseGroupVersionKinds(extension interface{}) []schema.GroupVersionKind {
+	list, ok := extension.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var gvks []schema.GroupVersionKind
+	for _, item := range list {
+		values := make(map[string]string)
+		switch m := item.(type) {
+		case map[interface{}]interface{}:
+			for k, v := range m {
+				values[fmt.Sprint(k)] = fmt.Sprint(v)
+			}
+		case map[string]interface{}:
+			for k, v := range m {
+				values[k] = fmt.Sprint(v)
+			}
+		default:
+			continue
+		}
+		gvks = append(gvks, schema.GroupVersionKind{
+			Group:   values["group"],
+			Version: values["version"],
+			Kind:    values["kind"],
+		})
+	}
+	return gvks
+}
@@ -0,0 +1,94 @@
+package k8s
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/kube-openapi/pkg/util/proto"
+)
+
+func TestParseGroupVersionKinds(t *testing.T) {
+	tests := []struct {
+		name      string
+		extension interface{}
+		want      []schema.GroupVersionKind
+	}{
+		{
+			name: "yaml.v2 maps",
+			extension: []interface{}{
+				map[interface{}]interface{}{"group": "", "version": "v1", "kind": "ConfigMap"},
+				map[interface{}]interface{}{"group": "apps", "version": "v1", "kind": "Deployment"},
+			},
+			want: []schema.GroupVersionKind{{Version: "v1", Kind: "ConfigMap"}, {Group: "apps", Version: "v1", Kind: "Deployment"}},
+		},
+		{
+			name:      "json maps",
+			extension: []interface{}{map[string]interface{}{"group": "apps", "version": "v1", "kind": "Deployment"}},
+			want:      []schema.GroupVersionKind{{Group: "apps", Version: "v1", Kind: "Deployment"}},
+		},
+		{
+			name:      "invalid items skipped",
+			extension: []interface{}{"apps/v1", map[string]interface{}{"version": "v1", "kind": "Pod"}},
+			want:      []schema.GroupVersionKind{{Version: "v1", Kind: "Pod"}},
+		},
+		{
+			name:      "not a list",
+			extension: map[string]interface{}{"group": "apps"},
+		},
+		{
+			name: "missing",
+		},
+	}
+	for _, tt := range tests {
+		if got := parseGroupVersionKinds(tt.extension); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseGroupVersionKinds() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGetOpenAPISchema(t *testing.T) {
+	doc := parseTestDocument(t, "schema", `{"swagger": "2.0", "info": {"title": "Kubernetes", "version": "v1.18.0"},
+  "paths": {},
+  "definitions": {
+    "io.k8s.api.apps.v1.Deployment": {
+      "type": "object",
+      "properties": {"kind": {"type": "string"}, "spec": {"$ref": "#/definitions/io.k8s.api.apps.v1.DeploymentSpec"}},
+      "x-kubernetes-group-version-kind": [{"group": "apps", "kind": "Deployment", "version": "v1"}]
+    },
+    "io.k8s.api.apps.v1.DeploymentSpec": {
+      "type": "object",
+      "required": ["selector"],
+      "properties": {"replicas": {"type": "integer", "format": "int32"}, "selector": {"type": "object"}}
+    }
+  }}`)
+	kube := &KubeClient{openAPIDocument: doc}
+	openAPISchema, err := kube.GetOpenAPISchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := kube.GetOpenAPISchema(); again != openAPISchema {
+		t.Error("GetOpenAPISchema() parses the schema again")
+	}
+
+	kind, ok := openAPISchema.LookupResource(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}).(*proto.Kind)
+	if !ok {
+		t.Fatal("LookupResource(apps/v1 Deployment) isn't a kind")
+	}
+	spec, ok := kind.Fields["spec"].(*proto.Ref)
+	if !ok {
+		t.Fatalf("spec = %T, want the reference", kind.Fields["spec"])
+	}
+	if sub, ok := spec.SubSchema().(*proto.Kind); !ok || !reflect.DeepEqual(sub.RequiredFields, []string{"selector"}) {
+		t.Errorf("spec = %v, want the required selector", spec.SubSchema())
+	}
+	// The definitions without the extension aren't resources
+	for _, gvk := range []schema.GroupVersionKind{
+		{Group: "apps", Version: "v1", Kind: "DeploymentSpec"},
+		{Group: "apps", Version: "v1beta1", Kind: "Deployment"},
+	} {
+		if got := openAPISchema.LookupResource(gvk); got != nil {
+			t.Errorf("LookupResource(%v) = %v, want nil", gvk, got)
+		}
+	}
+}